@@ -0,0 +1,97 @@
+// manifest-sign writes the detached ed25519 signature
+// (MANIFEST.json.sig) that fetch-model, super-resolution and serve
+// require before trusting a model manifest. See
+// internal/modelfetch/signature.go for the format and key rotation.
+//
+//	manifest-sign -keygen -key release.key          # new key pair
+//	manifest-sign -key release.key -id <key-id> models/MANIFEST.json
+//
+// The private key is the base64 ed25519 seed, read from -key or, when
+// that's empty, from $MANIFEST_SIGNING_KEY (how the release workflow
+// passes its secret). It never belongs in the repo.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+)
+
+func main() {
+	keygen := flag.Bool("keygen", false, "Generate a key pair: seed to -key, public key to stdout")
+	keyPath := flag.String("key", "", "Private key file (default: $MANIFEST_SIGNING_KEY)")
+	keyID := flag.String("id", "real-esrgan-serve-2026", "Key ID recorded in the .sig; must match a trusted key in the binary")
+	flag.Parse()
+
+	var err error
+	if *keygen {
+		err = generate(*keyPath)
+	} else {
+		err = sign(*keyPath, *keyID, flag.Args())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "manifest-sign:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(path string) error {
+	if path == "" {
+		return errors.New("-keygen needs -key <path> for the private key")
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	seed := base64.StdEncoding.EncodeToString(priv.Seed())
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, seed); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
+
+func sign(keyPath, keyID string, files []string) error {
+	if len(files) == 0 {
+		return errors.New("usage: manifest-sign -key <file> [-id <key-id>] MANIFEST.json...")
+	}
+	seed := os.Getenv("MANIFEST_SIGNING_KEY")
+	if keyPath != "" {
+		b, err := os.ReadFile(keyPath)
+		if err != nil {
+			return err
+		}
+		seed = string(b)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return errors.New("no usable private key (pass -key or set MANIFEST_SIGNING_KEY to the base64 seed)")
+	}
+	priv := ed25519.NewKeyFromSeed(raw)
+	for _, path := range files {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path+".sig", modelfetch.Sign(body, keyID, priv), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "signed %s with %s\n", path, keyID)
+	}
+	return nil
+}
@@ -1,11 +1,16 @@
 // real-esrgan-serve — GPU-side Real-ESRGAN serving CLI for the iosuite
 // ecosystem. See ARCHITECTURE.md for the full design rationale.
 //
-// Three subcommands:
+// Subcommands:
 //
 //	upscale     — one-shot inference; subprocesses to runtime/upscaler.py
 //	serve       — long-lived HTTP daemon for hot-path / batch workloads
 //	fetch-model — pull verified model artefacts from GitHub Releases
+//	models      — list / inspect / verify / prune the model cache
+//	manifest    — validate / diff / add entries in models/MANIFEST.json
+//	doctor      — check python, onnxruntime, GPU, cache and manifest
+//	runtime     — set up / inspect / remove the managed Python venv
+//	config      — show the merged settings and where each came from
 //
 // The Go binary is a thin orchestrator. The actual ONNX inference
 // is delegated to the Python runtime helper (subprocess boundary
@@ -14,15 +19,30 @@
 // carried). The binary's only runtime requirement is "a working
 // Python install with onnxruntime"; we check for it and fail loudly
 // if missing.
+//
+// Every flag can also be set in a config file or a REAL_ESRGAN_* env
+// var; see internal/config for the layering.
+//
+// Exit codes are the contract in internal/errs: 1 user, 2 runtime,
+// 3 environment, 4 integrity, 5 network, 130 interrupted.
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
-	"github.com/ls-ads/real-esrgan-serve/internal/upscale"
+	"github.com/ls-ads/real-esrgan-serve/internal/config"
+	"github.com/ls-ads/real-esrgan-serve/internal/doctor"
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/manifest"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelcache"
 	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/runtime"
 	"github.com/ls-ads/real-esrgan-serve/internal/server"
+	"github.com/ls-ads/real-esrgan-serve/internal/upscale"
 	"github.com/spf13/cobra"
 )
 
@@ -43,12 +63,77 @@ this tool. See ARCHITECTURE.md for the full design.`,
 		SilenceErrors: true,
 	}
 
+	var cfgPath, profile string
+	pf := root.PersistentFlags()
+	pf.StringVar(&cfgPath, "config", "", "Config file (default: $REAL_ESRGAN_CONFIG, else $XDG_CONFIG_HOME/real-esrgan-serve/config.toml if it exists)")
+	pf.StringVar(&profile, "profile", "", "Config file profile to apply, [profiles.<name>] (default: $REAL_ESRGAN_PROFILE, else the file's profile key)")
+	// Settings layer under the flags the subcommand was given: the
+	// config file, then the environment. See internal/config.
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if cfgPath == "" {
+			cfgPath = os.Getenv(config.EnvName("config"))
+		}
+		if profile == "" {
+			profile = os.Getenv(config.EnvName("profile"))
+		}
+		cfg, err := config.Load(cfgPath, profile)
+		if err != nil {
+			return err
+		}
+		if err := cfg.Apply(cmd); err != nil {
+			return err
+		}
+		cmd.SetContext(config.NewContext(cmd.Context(), cfg))
+		return nil
+	}
+
 	root.AddCommand(upscale.Command())
 	root.AddCommand(server.Command())
 	root.AddCommand(modelfetch.Command())
+	root.AddCommand(modelcache.Command())
+	root.AddCommand(manifest.Command())
+	root.AddCommand(doctor.Command())
+	root.AddCommand(runtime.Command())
+	root.AddCommand(config.Command())
+
+	cmd, err := root.ExecuteC()
+	if err == nil {
+		return
+	}
+	// Every RunE classifies its own errors, so anything still bare
+	// came from cobra itself: unknown flag, missing --input, bad
+	// subcommand. Those are all the user's to fix.
+	var e *errs.Error
+	if !errors.As(err, &e) {
+		err = errs.Wrap(errs.User, err)
+	}
+	category, code := errs.Classify(err)
+	fmt.Fprintln(os.Stderr, "error:", err)
+	if wantsJSON(cmd) {
+		// Final event of the stream: the one line a wrapper needs to
+		// decide what happened, whatever the helper said before it.
+		b, _ := json.Marshal(map[string]any{
+			"event":    "error",
+			"category": category,
+			"code":     code,
+			"msg":      err.Error(),
+		})
+		fmt.Fprintln(eventSink(cmd), string(b))
+	}
+	os.Exit(code)
+}
+
+// wantsJSON reports whether the failed subcommand ran with --json-events.
+func wantsJSON(cmd *cobra.Command) bool {
+	f := cmd.Flags().Lookup("json-events")
+	return f != nil && f.Value.String() == "true"
+}
 
-	if err := root.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+// eventSink mirrors the subcommand's own event routing: stdout, unless
+// `--output -` reserved it for image bytes.
+func eventSink(cmd *cobra.Command) io.Writer {
+	if f := cmd.Flags().Lookup("output"); f != nil && f.Value.String() == "-" {
+		return os.Stderr
 	}
+	return os.Stdout
 }
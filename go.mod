@@ -2,9 +2,11 @@ module github.com/ls-ads/real-esrgan-serve
 
 go 1.25.5
 
-require github.com/spf13/cobra v1.10.2
-
 require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.9 // indirect
+	github.com/BurntSushi/toml v1.6.0
+	github.com/spf13/cobra v1.10.2
+	github.com/spf13/pflag v1.0.9
+	golang.org/x/image v0.36.0
 )
+
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
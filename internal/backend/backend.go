@@ -0,0 +1,154 @@
+// Package backend is the seam between the commands and whatever runs
+// the model. `super-resolution` and `serve` plan a job in Go, hand it
+// to a Backend, and check the output it leaves behind; neither knows
+// whether a Python helper, another server or plain Go did the work.
+//
+// Implementations:
+//
+//	Helper      — the warm `upscaler.py --serve` process (serve's python backend)
+//	Subprocess  — one `upscaler.py` run per job (super-resolution's python backend)
+//	Remote      — forwards to another serve, or a RunPod endpoint, over /runsync
+//	Fake        — pure-Go nearest-neighbour resize; no Python, no model
+//
+// Jobs are path-based: the input is a file and the output is written
+// to a file. Backends that don't share the caller's filesystem (Remote)
+// move the bytes themselves.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+)
+
+// Backend names, as given to --backend.
+const (
+	NamePython = "python"
+	NameRemote = "remote"
+	NameFake   = "fake"
+)
+
+// Names lists every --backend value.
+var Names = []string{NamePython, NameRemote, NameFake}
+
+// Validate refuses a --backend value this build doesn't have.
+func Validate(name string) error {
+	if !slices.Contains(Names, name) {
+		return errs.New(errs.User, "--backend %q: want %s", name, strings.Join(Names, " | "))
+	}
+	return nil
+}
+
+// Backend runs upscale jobs. Implementations are safe for concurrent
+// use; how much actually runs in parallel is theirs to decide.
+type Backend interface {
+	// Upscale runs one job. When ctx ends first the job is abandoned:
+	// stopped if the backend can stop it, otherwise left to finish
+	// with its result discarded.
+	Upscale(ctx context.Context, job Job) (Result, error)
+	// UpscaleBatch runs jobs together where the backend can (one
+	// batched inference, one request), one by one otherwise. Results
+	// are in job order; the first failure fails the batch.
+	UpscaleBatch(ctx context.Context, jobs []Job) ([]Result, error)
+	// Capabilities describes what the backend runs and which optional
+	// features it implements.
+	Capabilities() Capabilities
+	// Close releases the backend: stops the helper, idles connections.
+	Close() error
+}
+
+// Job is one image: read Input, write Output as Plan says. The plan
+// is sizing's, already checked against the model's limits; a
+// passthrough plan never reaches a backend.
+type Job struct {
+	// ID names the job in logs and on the helper's wire; a backend
+	// that needs one makes one up when it is empty.
+	ID       string
+	Input    string
+	Output   string // its extension picks the encoding
+	Plan     sizing.Plan
+	Resample string // filter for non-native scales; "" = helper default
+}
+
+// Result is a finished job.
+type Result struct {
+	Output string
+	// ElapsedMS is the backend's time on the job, as it reports it;
+	// wall time where it reports nothing.
+	ElapsedMS int64
+}
+
+// Capabilities is what a backend reports about itself.
+type Capabilities struct {
+	Backend string // one of Names
+	// Features are the protocol.Cap* values the backend implements.
+	Features []string
+	// Providers are the onnxruntime execution providers the model was
+	// loaded with, when the backend knows them.
+	Providers []string
+	// Model is what the backend runs: the model file for the local
+	// backends, the endpoint URL for Remote.
+	Model string
+	// Events is set when the backend writes its own progress events
+	// (the one-shot helper's --json-events stream), so the caller
+	// doesn't add a second done event.
+	Events bool
+}
+
+// Has reports whether the backend implements feature f.
+func (c Capabilities) Has(f string) bool {
+	return slices.Contains(c.Features, f)
+}
+
+// String is a one-line summary for logs and error messages.
+func (c Capabilities) String() string {
+	features := "none"
+	if len(c.Features) > 0 {
+		features = strings.Join(c.Features, ",")
+	}
+	s := fmt.Sprintf("%s backend, features %s", c.Backend, features)
+	if len(c.Providers) > 0 {
+		s += ", providers " + strings.Join(c.Providers, ",")
+	}
+	return s
+}
+
+// Checker is implemented by backends that can die between jobs, the
+// helper process: Alive is false once it has, and every job after
+// that fails.
+type Checker interface {
+	Alive() bool
+}
+
+// Process is implemented by backends that run a child process (the
+// helper), for health reports.
+type Process interface {
+	PID() int
+	Started() time.Time
+}
+
+// Batcher is implemented by backends whose UpscaleBatch sends jobs in
+// groups (Remote: one request each). Batches returns those groups,
+// in order, so a caller can report progress group by group.
+type Batcher interface {
+	Batches(jobs []Job) [][]Job
+}
+
+// each runs jobs one at a time through b.Upscale: the UpscaleBatch of
+// backends with nothing to gain from batching.
+func each(ctx context.Context, b Backend, jobs []Job) ([]Result, error) {
+	results := make([]Result, 0, len(jobs))
+	for _, j := range jobs {
+		r, err := b.Upscale(ctx, j)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+)
+
+// Fake upscales by nearest neighbour in pure Go: no Python, no
+// onnxruntime, no model. Output is deterministic and lands on exactly
+// the dimensions the real backends produce, so tests and UI work can
+// run the whole pipeline on any machine. It is not super-resolution
+// and says so in its capabilities.
+type Fake struct {
+	stats *Stats
+}
+
+// NewFake returns the fake backend. stats may be nil.
+func NewFake(stats *Stats) *Fake {
+	return &Fake{stats: stats}
+}
+
+// Capabilities: tiling and batches are accepted (and mean nothing to
+// a resize); a job stops between images when its ctx ends.
+func (f *Fake) Capabilities() Capabilities {
+	return Capabilities{
+		Backend:   NameFake,
+		Features:  []string{protocol.CapTile, protocol.CapBatched, protocol.CapCancel},
+		Providers: []string{"go-nearest"},
+		Model:     "nearest-neighbour",
+	}
+}
+
+// Upscale resizes job.Input to the plan's output size.
+func (f *Fake) Upscale(ctx context.Context, job Job) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	t0 := time.Now()
+	err := nearestFile(job.Input, job.Output, job.Plan.Scale)
+	ms := time.Since(t0).Milliseconds()
+	f.stats.recordResult(ms, err)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Output: job.Output, ElapsedMS: ms}, nil
+}
+
+// UpscaleBatch resizes the jobs one by one.
+func (f *Fake) UpscaleBatch(ctx context.Context, jobs []Job) ([]Result, error) {
+	return each(ctx, f, jobs)
+}
+
+// Close is a no-op.
+func (f *Fake) Close() error { return nil }
+
+func nearestFile(in, out string, scale float64) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var buf bytes.Buffer
+	if err := imageinfo.Nearest(src, &buf, scale, filepath.Ext(out)); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(in), err)
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(out, buf.Bytes(), 0o644)
+}
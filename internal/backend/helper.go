@@ -0,0 +1,407 @@
+package backend
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+	rrt "github.com/ls-ads/real-esrgan-serve/internal/runtime"
+)
+
+// Helper wraps the long-running `upscaler.py --serve` subprocess.
+// One stdin lock + one stdout reader goroutine routes results back
+// to per-job-ID channels. The wire format is package protocol's.
+type Helper struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	stdLock sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   map[string]chan protocol.Event
+
+	// ready is the helper's handshake: protocol version, capabilities
+	// and the providers it loaded the model with.
+	ready   protocol.Event
+	stats   *Stats
+	started time.Time
+
+	closed atomic.Bool
+}
+
+// StartHelper starts the helper on model and waits for its ready
+// event, so the first job never pays for the model load. provider is
+// the --provider value; the ready event must show the helper running
+// on it. stats may be nil.
+func StartHelper(r *rrt.Resolved, model string, gpuID int, provider string, stats *Stats) (*Helper, error) {
+	sent, err := models.HelperProvider(model, provider)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		r.Script,
+		"--serve",
+		"--model", model,
+		"--gpu-id", strconv.Itoa(gpuID),
+	}
+	if sent != "" {
+		args = append(args, "--provider", sent)
+	}
+	cmd := exec.Command(r.Python, args...)
+	cmd.Stderr = os.Stderr // helper logs to our stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errs.New(errs.Environment, "start helper: %w", err)
+	}
+
+	hp := &Helper{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  stdout,
+		pending: make(map[string]chan protocol.Event),
+		stats:   stats,
+		started: time.Now(),
+	}
+
+	// Reader: dispatches every JSONL frame to the matching pending channel
+	go hp.readLoop()
+
+	// Wait for the helper's "ready" event before declaring success.
+	// This is what makes `serve` startup feel synchronous from the
+	// outside — caller can rely on first request having warm session.
+	readyCh := make(chan protocol.Event, 1)
+	hp.subscribe("__ready__", readyCh)
+	select {
+	case ev, ok := <-readyCh:
+		if !ok {
+			hp.Close()
+			return nil, errors.New("helper exited before signalling ready")
+		}
+		if ev.Event != protocol.EventReady {
+			hp.Close()
+			return nil, fmt.Errorf("helper sent %s before ready: %s", ev.Event, ev.Msg)
+		}
+		// A helper from another release would misread our frames
+		// or we its events; refuse it up front rather than fail
+		// per request.
+		if err := protocol.CheckReady(&ev); err != nil {
+			hp.Close()
+			return nil, errs.New(errs.Environment,
+				"%s: %v. The helper and this binary come from different releases: reinstall "+
+					"real-esrgan-serve, or point --runtime / $REAL_ESRGAN_RUNTIME at the upscaler.py shipped with it",
+				r.Script, err)
+		}
+		warning, err := checkProviders(&ev, provider, sent, gpuID)
+		if err != nil {
+			hp.Close()
+			return nil, err
+		}
+		if warning != "" {
+			fmt.Fprintf(os.Stderr, "warn: %s\n", warning)
+		}
+		hp.ready = ev
+	case <-time.After(120 * time.Second):
+		_ = cmd.Process.Kill()
+		return nil, errors.New("helper did not signal ready within 120s")
+	}
+	hp.unsubscribe("__ready__")
+	fmt.Fprintf(os.Stderr, "helper ready: %s\n", protocol.Describe(&hp.ready))
+
+	return hp, nil
+}
+
+// Capabilities reports what the helper's ready event advertised.
+func (h *Helper) Capabilities() Capabilities {
+	return Capabilities{
+		Backend:   NamePython,
+		Features:  h.ready.Capabilities,
+		Providers: h.ready.Providers,
+		Model:     h.ready.Model,
+	}
+}
+
+// PID is the helper's process id.
+func (h *Helper) PID() int { return h.cmd.Process.Pid }
+
+// Started is when the helper was started.
+func (h *Helper) Started() time.Time { return h.started }
+
+// Alive reports whether the helper process is still answering.
+func (h *Helper) Alive() bool {
+	return !h.closed.Load()
+}
+
+func (h *Helper) readLoop() {
+	scanner := bufio.NewScanner(h.stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var ev protocol.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			fmt.Fprintf(os.Stderr, "[helper] non-json line: %s\n", scanner.Text())
+			continue
+		}
+		// Bootstrap: route the one-time "ready" event to a synthetic ID
+		if ev.Event == protocol.EventReady {
+			h.dispatch("__ready__", ev)
+			continue
+		}
+		if ev.ID != "" {
+			h.dispatch(ev.ID, ev)
+		}
+	}
+	// EOF or scan error — helper died. Close all pending channels so
+	// in-flight requests fail fast rather than hang forever.
+	h.closed.Store(true)
+	h.pendingMu.Lock()
+	for _, ch := range h.pending {
+		close(ch)
+	}
+	h.pending = nil
+	h.pendingMu.Unlock()
+}
+
+func (h *Helper) subscribe(id string, ch chan protocol.Event) {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	h.pending[id] = ch
+}
+
+func (h *Helper) unsubscribe(id string) {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	delete(h.pending, id)
+}
+
+func (h *Helper) dispatch(id string, ev protocol.Event) {
+	h.pendingMu.Lock()
+	ch, ok := h.pending[id]
+	h.pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- ev:
+	default:
+		// channel full — caller already got their answer
+	}
+}
+
+// Close ends the helper's stdin and waits for it to exit, killing it
+// after 5s.
+func (h *Helper) Close() error {
+	if h.closed.Swap(true) {
+		return nil
+	}
+	_ = h.stdin.Close()
+	if h.cmd.Process != nil {
+		// Give it a chance to exit cleanly, then kill.
+		done := make(chan error, 1)
+		go func() { done <- h.cmd.Wait() }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			_ = h.cmd.Process.Kill()
+			<-done
+		}
+	}
+	return nil
+}
+
+var jobSeq uint64
+
+// frame is job on the helper's wire. Defaults are left off so an
+// older helper sees the frames it always did.
+func frame(job Job) protocol.Frame {
+	plan := job.Plan
+	fr := protocol.Frame{ID: job.ID, Input: job.Input, Output: job.Output, Resample: job.Resample, Tile: plan.Tile}
+	if fr.ID == "" {
+		fr.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&jobSeq, 1))
+	}
+	if plan.Scale != imageinfo.NativeScale {
+		fr.OutScale = plan.Scale
+	}
+	if plan.Passes != 1 {
+		fr.Passes = &plan.Passes
+	}
+	return fr
+}
+
+// Upscale sends one job to the helper and waits for its answer.
+func (h *Helper) Upscale(ctx context.Context, job Job) (Result, error) {
+	ev, err := h.run(ctx, frame(job))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Output: ev.Output, ElapsedMS: ev.ElapsedMS}, nil
+}
+
+// UpscaleBatch sends jobs as one batched frame when the helper takes
+// them and they fit one inference: same input size, same single-pass
+// plan, no tiling. Anything else goes one frame per job. A batched
+// result's ElapsedMS is its share of the batch's time.
+func (h *Helper) UpscaleBatch(ctx context.Context, jobs []Job) ([]Result, error) {
+	if !h.ready.Has(protocol.CapBatched) || !batchable(jobs) {
+		return each(ctx, h, jobs)
+	}
+	fr := frame(jobs[0])
+	fr.Input, fr.Output = "", ""
+	for _, j := range jobs {
+		fr.Inputs = append(fr.Inputs, j.Input)
+		fr.Outputs = append(fr.Outputs, j.Output)
+	}
+	ev, err := h.run(ctx, fr)
+	if err != nil {
+		return nil, err
+	}
+	if len(ev.Results) != len(jobs) {
+		return nil, fmt.Errorf("helper answered a batch of %d with %d results", len(jobs), len(ev.Results))
+	}
+	results := make([]Result, len(jobs))
+	for i, r := range ev.Results {
+		results[i] = Result{Output: r.Output, ElapsedMS: ev.ElapsedMS / int64(len(jobs))}
+	}
+	return results, nil
+}
+
+// batchable reports whether jobs can share one batched frame: the
+// helper stacks them into one tensor and applies one outscale.
+func batchable(jobs []Job) bool {
+	if len(jobs) < 2 {
+		return false
+	}
+	first := jobs[0]
+	for _, j := range jobs {
+		p := j.Plan
+		if p.Tile || p.Passes != 1 || p.InputWidth == 0 ||
+			p.InputWidth != first.Plan.InputWidth || p.InputHeight != first.Plan.InputHeight ||
+			p.Scale != first.Plan.Scale || j.Resample != first.Resample {
+			return false
+		}
+	}
+	return true
+}
+
+// run sends one frame to the helper and waits for the result. A job
+// whose ctx ends first (client gone, timeout) is abandoned.
+func (h *Helper) run(ctx context.Context, job protocol.Frame) (protocol.Event, error) {
+	if h.closed.Load() {
+		return protocol.Event{}, errors.New("helper is dead — restart the server")
+	}
+
+	ch := make(chan protocol.Event, 4)
+	h.subscribe(job.ID, ch)
+	if err := h.send(job); err != nil {
+		h.unsubscribe(job.ID)
+		return protocol.Event{}, err
+	}
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return protocol.Event{}, errors.New("helper died mid-job")
+			}
+			switch ev.Event {
+			case protocol.EventDone:
+				h.unsubscribe(job.ID)
+				h.stats.record(ev, false)
+				return ev, nil
+			case protocol.EventError:
+				h.unsubscribe(job.ID)
+				h.stats.record(ev, false)
+				return ev, fmt.Errorf("helper error: %s", ev.Msg)
+			case protocol.EventCancelled:
+				// Only ever asked for by abandon; seeing it here means
+				// another sender reused the id.
+				h.unsubscribe(job.ID)
+				h.stats.record(ev, false)
+				return ev, errors.New("helper cancelled the job")
+			default:
+				// progress / preprocessing / inferring — keep listening
+			}
+		case <-ctx.Done():
+			h.abandon(job.ID, ch)
+			return protocol.Event{}, ctx.Err()
+		}
+	}
+}
+
+// send writes one frame to the helper's stdin.
+func (h *Helper) send(frame any) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	h.stdLock.Lock()
+	defer h.stdLock.Unlock()
+	if _, err := h.stdin.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("helper stdin: %w", err)
+	}
+	return nil
+}
+
+const (
+	// cancelGrace is how long an abandoned job's caller waits for the
+	// helper to stop: about one tile, so the helper doesn't go on
+	// writing into a temp dir that is about to be removed.
+	cancelGrace = 5 * time.Second
+	// abandonTimeout bounds the background wait after that.
+	abandonTimeout = 10 * time.Minute
+)
+
+// abandon tells the helper to stop job id, when it can be told, and
+// collects its final event for the wasted-time stats: for up to
+// cancelGrace here, then in the background. Without the cancel
+// capability the job runs to completion and is only accounted for.
+func (h *Helper) abandon(id string, ch chan protocol.Event) {
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return true // helper died; nothing left to count
+				}
+				if ev.Final() {
+					h.stats.record(ev, true)
+					return true
+				}
+			case <-timer.C:
+				return false
+			}
+		}
+	}
+	if h.ready.Has(protocol.CapCancel) {
+		if err := h.send(protocol.Cancel{ID: id}); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cancel %s: %v\n", id, err)
+		} else if wait(cancelGrace) {
+			h.unsubscribe(id)
+			return
+		}
+	}
+	go func() {
+		wait(abandonTimeout)
+		h.unsubscribe(id)
+	}()
+}
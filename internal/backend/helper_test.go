@@ -0,0 +1,306 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol/protocoltest"
+	rrt "github.com/ls-ads/real-esrgan-serve/internal/runtime"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+)
+
+// The test binary doubles as the helper: with BACKEND_TEST_HELPER set
+// to "<version>;<cap,cap>;<delay>[;<provider,provider>]" it serves
+// protocoltest.Fake on stdio instead of running tests, so StartHelper
+// execs it like upscaler.py. The Fake echoes the --provider it was
+// started with and reports the providers given as active.
+func TestMain(m *testing.M) {
+	if spec, ok := os.LookupEnv("BACKEND_TEST_HELPER"); ok {
+		parts := strings.Split(spec, ";")
+		f := &protocoltest.Fake{}
+		f.Version, _ = strconv.Atoi(parts[0])
+		if parts[1] != "" {
+			f.Caps = strings.Split(parts[1], ",")
+		}
+		f.Delay, _ = time.ParseDuration(parts[2])
+		if len(parts) > 3 && parts[3] != "" {
+			f.Providers = strings.Split(parts[3], ",")
+		}
+		if i := slices.Index(os.Args, "--provider"); i > 0 && i+1 < len(os.Args) {
+			f.Provider = os.Args[i+1]
+		}
+		if err := f.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeHelper returns what Locate would for a helper that is the test
+// binary serving Fake with this version and capabilities.
+func fakeHelper(t *testing.T, version int, caps ...string) *rrt.Resolved {
+	return slowHelper(t, 0, version, caps...)
+}
+
+// slowHelper is fakeHelper taking delay per image.
+func slowHelper(t *testing.T, delay time.Duration, version int, caps ...string) *rrt.Resolved {
+	t.Setenv("BACKEND_TEST_HELPER", fmt.Sprintf("%d;%s;%s", version, strings.Join(caps, ","), delay))
+	return &rrt.Resolved{Python: os.Args[0], Script: "/opt/old/upscaler.py"}
+}
+
+// pngJob writes a blank w×h PNG and returns a native-scale job for it.
+func pngJob(t *testing.T, dir, name string, w, h int) Job {
+	t.Helper()
+	in := filepath.Join(dir, name+".png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := sizing.Compute(w, h, sizing.Request{Scale: 4, Limits: sizing.Limits{MinDim: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Job{Input: in, Output: filepath.Join(dir, "out", name+".png"), Plan: plan}
+}
+
+func TestStartHelper(t *testing.T) {
+	cases := []struct {
+		name    string
+		version int
+		want    string // substring of the error; "" = starts
+	}{
+		{"current", 0, ""},
+		{"pre-handshake", -1, "predates the handshake"},
+		{"newer", protocol.Version + 1, "newer"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hp, err := StartHelper(fakeHelper(t, tc.version, protocol.CapTile), "x4.onnx", -1, "", nil)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer hp.Close()
+				if caps := hp.Capabilities(); hp.ready.ProtocolVersion != protocol.Version ||
+					!caps.Has(protocol.CapTile) || caps.Backend != NamePython {
+					t.Errorf("ready: %+v", hp.ready)
+				}
+				return
+			}
+			if err == nil {
+				hp.Close()
+				t.Fatal("incompatible helper accepted")
+			}
+			// The message names the script and how to point at another.
+			msg := err.Error()
+			if !errs.Is(err, errs.Environment) || !strings.Contains(msg, tc.want) ||
+				!strings.Contains(msg, "/opt/old/upscaler.py") || !strings.Contains(msg, "--runtime") {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+// TestStartHelper_provider: the helper gets --provider in its own
+// terms, and a ready event showing it on something else is refused
+// when the provider was named.
+func TestStartHelper_provider(t *testing.T) {
+	cases := []struct {
+		name     string
+		model    string
+		provider string
+		gpuID    int
+		active   string // the helper's providers
+		want     string // Capabilities().Provider(), or "error: <substring>"
+	}{
+		{"auto on the CPU", "x4.onnx", "auto", -1, "CPUExecutionProvider", "cpu"},
+		{"auto falls back", "x4.onnx", "", 0, "CPUExecutionProvider", "cpu"},
+		{"cuda", "x4.onnx", "cuda", 0, "CUDAExecutionProvider,CPUExecutionProvider", "cuda"},
+		{"cuda fell back", "x4.onnx", "cuda", 0, "CPUExecutionProvider", "error: running on cpu"},
+		{"tensorrt", "x4.engine", "tensorrt", 0, "TensorrtDirect", "tensorrt"},
+		{"trt alias", "x4.engine", "trt", 0, "TensorrtDirect", "tensorrt"},
+		{"tensorrt on onnx", "x4.onnx", "tensorrt", 0, "", "error: loads .engine artefacts"},
+		{"cpu on an engine", "x4.engine", "cpu", 0, "", "error: is a TensorRT engine"},
+		{"engine under auto", "x4.engine", "auto", 0, "TensorrtDirect", "tensorrt"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := fakeHelper(t, 0)
+			t.Setenv("BACKEND_TEST_HELPER", os.Getenv("BACKEND_TEST_HELPER")+";"+tc.active)
+			hp, err := StartHelper(r, tc.model, tc.gpuID, tc.provider, nil)
+			if want, ok := strings.CutPrefix(tc.want, "error: "); ok {
+				if err == nil {
+					hp.Close()
+					t.Fatalf("started on %s", hp.Capabilities().Provider())
+				}
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("got %v, want %q", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hp.Close()
+			if got := hp.Capabilities().Provider(); got != tc.want {
+				t.Errorf("provider %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckProviders(t *testing.T) {
+	cases := []struct {
+		name      string
+		requested string // the ready event's requested_provider
+		active    []string
+		provider  string
+		sent      string
+		gpuID     int
+		want      string // "warn" | "error" | ""
+	}{
+		{"auto on GPU", "auto", []string{"CUDAExecutionProvider"}, "auto", "", 0, ""},
+		{"auto fell back", "auto", []string{"CPUExecutionProvider"}, "auto", "", 0, "warn"},
+		{"auto on CPU run", "auto", []string{"CPUExecutionProvider"}, "auto", "", -1, ""},
+		{"request ignored", "auto", []string{"CUDAExecutionProvider"}, "cuda", "cuda", 0, "error"},
+		{"no providers", "cpu", nil, "cpu", "cpu", -1, "error"},
+		{"cuda via tensorrt", "cuda", []string{"TensorrtExecutionProvider"}, "cuda", "cuda", 0, "error"},
+	}
+	for _, tc := range cases {
+		ready := protocol.Event{Event: protocol.EventReady, RequestedProvider: tc.requested, Providers: tc.active}
+		warning, err := checkProviders(&ready, tc.provider, tc.sent, tc.gpuID)
+		got := ""
+		switch {
+		case err != nil:
+			got = "error"
+			if !errs.Is(err, errs.Environment) {
+				t.Errorf("%s: %v is not an environment error", tc.name, err)
+			}
+		case warning != "":
+			got = "warn"
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q (%v %q), want %q", tc.name, got, err, warning, tc.want)
+		}
+	}
+}
+
+// TestHelper_batch: same-size single-pass jobs go as one batched frame
+// when the helper takes them, one frame each otherwise; the results
+// are in job order either way.
+func TestHelper_batch(t *testing.T) {
+	for _, caps := range [][]string{{protocol.CapBatched}, nil} {
+		t.Run(fmt.Sprint(caps), func(t *testing.T) {
+			stats := &Stats{}
+			hp, err := StartHelper(fakeHelper(t, 0, caps...), "x4.onnx", -1, "", stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hp.Close()
+			dir := t.TempDir()
+			jobs := []Job{pngJob(t, dir, "a", 16, 8), pngJob(t, dir, "b", 16, 8), pngJob(t, dir, "c", 16, 8)}
+			results, err := hp.UpscaleBatch(context.Background(), jobs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range results {
+				if r.Output != jobs[i].Output {
+					t.Errorf("result %d: %q, want %q", i, r.Output, jobs[i].Output)
+				}
+			}
+			// One done event for the batch, three without batching.
+			want := int64(3)
+			if caps != nil {
+				want = 1
+			}
+			if got := stats.Done.Load(); got != want {
+				t.Errorf("done events: %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestBatchable(t *testing.T) {
+	job := func(w, h, passes int, tile bool) Job {
+		return Job{Plan: sizing.Plan{InputWidth: w, InputHeight: h, Scale: 4, Passes: passes, Tile: tile}}
+	}
+	cases := []struct {
+		name string
+		jobs []Job
+		want bool
+	}{
+		{"one job", []Job{job(64, 64, 1, false)}, false},
+		{"same size", []Job{job(64, 64, 1, false), job(64, 64, 1, false)}, true},
+		{"mixed size", []Job{job(64, 64, 1, false), job(64, 80, 1, false)}, false},
+		{"two passes", []Job{job(64, 64, 2, false), job(64, 64, 2, false)}, false},
+		{"tiled", []Job{job(64, 64, 1, true), job(64, 64, 1, true)}, false},
+		{"unknown size", []Job{job(0, 0, 1, false), job(0, 0, 1, false)}, false},
+	}
+	for _, tc := range cases {
+		if got := batchable(tc.jobs); got != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestHelper_abandoned: a job whose caller goes away is cancelled in
+// the helper when it advertises cancel, and left to finish otherwise;
+// either way the helper time it cost is counted as wasted.
+func TestHelper_abandoned(t *testing.T) {
+	cases := []struct {
+		name   string
+		caps   []string
+		output bool // the helper still writes the output
+	}{
+		{"cancel advertised", []string{protocol.CapCancel}, false},
+		{"no cancel", nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := &Stats{}
+			hp, err := StartHelper(slowHelper(t, 300*time.Millisecond, 0, tc.caps...), "x4.onnx", -1, "", stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hp.Close()
+
+			job := pngJob(t, t.TempDir(), "in", 8, 8)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = hp.Upscale(ctx, job)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got %v", err)
+			}
+
+			// Without cancel the accounting happens in the background.
+			deadline := time.Now().Add(5 * time.Second)
+			for stats.Abandoned.Load() == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if stats.Abandoned.Load() != 1 || stats.WastedMS.Load() < 250 {
+				t.Fatalf("abandoned %d, wasted %dms", stats.Abandoned.Load(), stats.WastedMS.Load())
+			}
+			if _, err := os.Stat(job.Output); (err == nil) != tc.output {
+				t.Errorf("output written: %v, want %v", err == nil, tc.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+)
+
+// Provider is the --provider name of what runs the model, read off
+// the helper's active execution providers: tensorrt (the TRT-direct
+// session, or onnxruntime's TensorRT EP), cuda or cpu. "" when the
+// backend reports none of those (Remote, Fake).
+func (c Capabilities) Provider() string {
+	switch {
+	case slices.Contains(c.Providers, "TensorrtDirect"), slices.Contains(c.Providers, "TensorrtExecutionProvider"):
+		return models.ProviderTensorRT
+	case slices.Contains(c.Providers, "CUDAExecutionProvider"):
+		return models.ProviderCUDA
+	case slices.Contains(c.Providers, "CPUExecutionProvider"):
+		return models.ProviderCPU
+	}
+	return ""
+}
+
+// checkProviders holds a helper's ready event to the --provider it
+// was started with (sent is the helper's name for it, "" for auto).
+// The helper must echo the request and run on something; a GPU
+// provider it was asked for by name must be the one running. auto
+// landing on the CPU of a GPU run is the silent fallback worth a
+// warning, not a failure: it is what auto allows.
+func checkProviders(ready *protocol.Event, provider, sent string, gpuID int) (warning string, err error) {
+	if sent == "" {
+		sent = "auto"
+	}
+	if ready.RequestedProvider != sent {
+		return "", errs.New(errs.Environment, "helper loaded the model for provider %q, asked for %q", ready.RequestedProvider, sent)
+	}
+	active := Capabilities{Providers: ready.Providers}.Provider()
+	if active == "" {
+		return "", errs.New(errs.Environment, "helper reported no execution provider this build knows (got %q)", ready.Providers)
+	}
+	switch provider = models.CanonicalProvider(provider); provider {
+	case models.ProviderCUDA, models.ProviderTensorRT:
+		if active != provider {
+			return "", errs.New(errs.Environment,
+				"--provider %s: the helper is running on %s (active: %s). Check the CUDA / TensorRT libraries with 'real-esrgan-serve doctor'",
+				provider, active, strings.Join(ready.Providers, ", "))
+		}
+	case "", models.ProviderAuto:
+		if active == models.ProviderCPU && gpuID >= 0 {
+			return fmt.Sprintf("--provider auto fell back to the CPU on GPU %d (active: %s); "+
+				"--provider cuda fails instead, --provider cpu makes it deliberate",
+				gpuID, strings.Join(ready.Providers, ", ")), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,305 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+	"github.com/ls-ads/real-esrgan-serve/pkg/client"
+)
+
+// Remote forwards jobs to a /runsync endpoint: another `serve`, or a
+// RunPod worker, which answer the same JSON envelope (pkg/client).
+// Input bytes travel base64-encoded in the request and outputs come
+// back the same way, so the far end shares nothing with us but the
+// wire.
+type Remote struct {
+	endpoint string // as given: a URL or runpod://<id>
+	client   *client.Client
+	opts     RemoteOptions
+}
+
+// RemoteOptions tune a Remote. Zero values take the defaults.
+type RemoteOptions struct {
+	// APIKey goes out as a bearer token; see APIKey.
+	APIKey string
+	// Timeout bounds one request, including the status polling a
+	// RunPod job still queued after the runsync wait needs. Default 5m.
+	Timeout time.Duration
+	// Retries is how many more times a request is tried after a
+	// network error, a 429 or a 5xx. Refusals (other 4xx, failed jobs)
+	// are never retried.
+	Retries int
+	// MaxBody caps one request body; a batch is split to fit.
+	// Default DefaultMaxBody.
+	MaxBody int64
+	// Stats may be nil.
+	Stats *Stats
+}
+
+const (
+	// DefaultMaxBody is RunPod's ~20 MB request-body cap, which
+	// docs/IMAGE-IO.md sizes batches against.
+	DefaultMaxBody = client.DefaultMaxBody
+	defaultTimeout = 5 * time.Minute
+)
+
+// Variables so tests can point runpod:// at an httptest server and
+// not sit out real delays.
+var (
+	runpodAPI    = client.RunPodAPI
+	pollInterval = 2 * time.Second
+	retryBackoff = time.Second
+)
+
+// NewRemote returns a backend posting to endpoint: a full /runsync URL,
+// a server root to which /runsync is added, or runpod://<endpoint-id>.
+func NewRemote(endpoint string, opts RemoteOptions) (*Remote, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = DefaultMaxBody
+	}
+	if opts.Retries < 0 {
+		return nil, errs.New(errs.User, "--retries must be >= 0, got %d", opts.Retries)
+	}
+	c, err := client.New(endpoint, client.Options{
+		APIKey:       opts.APIKey,
+		Retries:      opts.Retries,
+		Backoff:      retryBackoff,
+		MaxBody:      opts.MaxBody,
+		PollInterval: pollInterval,
+		RunPodAPI:    runpodAPI,
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			fmt.Fprintf(os.Stderr, "warn: %v; retry %d/%d in %s\n", err, attempt, opts.Retries, wait)
+		},
+	})
+	if err != nil {
+		return nil, errs.New(errs.User, "--%w", err)
+	}
+	return &Remote{endpoint: endpoint, client: c, opts: opts}, nil
+}
+
+// APIKey is the key for endpoint: the api-key setting
+// ($REAL_ESRGAN_API_KEY or the config file's api-key), else for
+// runpod:// endpoints $RUNPOD_API_KEY, which the RunPod tooling
+// already uses.
+func APIKey(configured, endpoint string) string {
+	if configured != "" {
+		return configured
+	}
+	if strings.HasPrefix(endpoint, "runpod://") {
+		return os.Getenv("RUNPOD_API_KEY")
+	}
+	return ""
+}
+
+// SetAPIKey swaps in a new api-key setting for requests from now on,
+// as serve does when a reload changes it.
+func (r *Remote) SetAPIKey(configured string) {
+	r.client.SetAPIKey(APIKey(configured, r.endpoint))
+}
+
+// Capabilities: the far end plans and tiles for itself, and a batch
+// is one envelope. Cancelling drops the request, which the far end
+// may or may not notice.
+func (r *Remote) Capabilities() Capabilities {
+	return Capabilities{
+		Backend:  NameRemote,
+		Features: []string{protocol.CapTile, protocol.CapBatched},
+		Model:    r.client.Endpoint(),
+	}
+}
+
+// Upscale posts one image.
+func (r *Remote) Upscale(ctx context.Context, job Job) (Result, error) {
+	results, err := r.UpscaleBatch(ctx, []Job{job})
+	if err != nil {
+		return Result{}, err
+	}
+	return results[0], nil
+}
+
+// UpscaleBatch posts the jobs in as few requests as Batches allows,
+// once every job has been checked against what the far end can do.
+func (r *Remote) UpscaleBatch(ctx context.Context, jobs []Job) ([]Result, error) {
+	for _, j := range jobs {
+		if err := r.supports(j); err != nil {
+			return nil, err
+		}
+	}
+	results := make([]Result, 0, len(jobs))
+	for _, batch := range r.Batches(jobs) {
+		rs, err := r.post(ctx, batch)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, rs...)
+	}
+	return results, nil
+}
+
+// Batches splits jobs into the requests UpscaleBatch sends: runs of
+// consecutive jobs sharing one envelope's settings (scale, resample,
+// tiling, output format), each body under MaxBody. An input too big
+// for a body of its own gets a batch of its own, and is refused when
+// it is sent.
+func (r *Remote) Batches(jobs []Job) [][]Job {
+	var batches [][]Job
+	var size int64
+	for i, j := range jobs {
+		n := encodedSize(j.Input)
+		if i > 0 {
+			last := batches[len(batches)-1]
+			if sameEnvelope(last[0], j) && size+n <= r.opts.MaxBody {
+				batches[len(batches)-1] = append(last, j)
+				size += n
+				continue
+			}
+		}
+		batches = append(batches, []Job{j})
+		size = client.EnvelopeOverhead + n
+	}
+	return batches
+}
+
+// encodedSize is what path adds to a request body. An unreadable
+// file counts as empty and fails when the batch is read.
+func encodedSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return client.ImageSize(info.Size())
+}
+
+func sameEnvelope(a, b Job) bool {
+	return a.Plan.Scale == b.Plan.Scale && a.Plan.Tile == b.Plan.Tile &&
+		a.Resample == b.Resample && outputFormat(a.Output) == outputFormat(b.Output)
+}
+
+// outputFormat is the envelope's output_format for an output path.
+func outputFormat(path string) string {
+	f := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if f == "jpeg" {
+		return "jpg"
+	}
+	return f
+}
+
+// supports refuses a job the far end can't do before any bytes move.
+// The RunPod worker has no sizing fields: it upscales at the native
+// 4× and encodes jpg or png, nothing else.
+func (r *Remote) supports(j Job) error {
+	if !r.client.RunPod() {
+		return nil
+	}
+	if j.Plan.Scale != imageinfo.NativeScale || j.Plan.Passes != 1 {
+		return errs.New(errs.User, "%s: the RunPod worker only upscales at the native %gx; this job needs %gx "+
+			"(use --scale 4, or a serve endpoint, which resamples)", filepath.Base(j.Input), imageinfo.NativeScale, j.Plan.Scale)
+	}
+	if f := outputFormat(j.Output); f != "jpg" && f != "png" {
+		return errs.New(errs.User, "%s: the RunPod worker writes jpg or png, not %s", filepath.Base(j.Input), f)
+	}
+	return nil
+}
+
+func (r *Remote) post(ctx context.Context, jobs []Job) ([]Result, error) {
+	in := client.Input{
+		OutputFormat: outputFormat(jobs[0].Output),
+		Tile:         jobs[0].Plan.Tile,
+	}
+	if !r.client.RunPod() {
+		in.Scale, in.Resample = jobs[0].Plan.Scale, jobs[0].Resample
+	}
+	for _, j := range jobs {
+		raw, err := os.ReadFile(j.Input)
+		if err != nil {
+			return nil, errs.New(errs.User, "read %s: %w", j.Input, err)
+		}
+		in.Images = append(in.Images, client.Image{ImageBase64: base64.StdEncoding.EncodeToString(raw)})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+	t0 := time.Now()
+	resp, err := r.client.RunSync(ctx, in)
+	if err != nil {
+		err = r.explain(ctx, err, jobs)
+		r.opts.Stats.recordResult(time.Since(t0).Milliseconds(), err)
+		return nil, err
+	}
+
+	results := make([]Result, len(jobs))
+	for i, o := range resp.Output.ByIndex(len(jobs)) {
+		if o == nil {
+			return nil, errs.New(errs.Runtime, "%s: %d output(s) for %d image(s)", r.client.Endpoint(), len(resp.Output.Outputs), len(jobs))
+		}
+		raw, err := base64.StdEncoding.DecodeString(o.ImageBase64)
+		if err != nil {
+			return nil, errs.New(errs.Integrity, "%s: output %d: %w", r.client.Endpoint(), i, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(jobs[i].Output), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(jobs[i].Output, raw, 0o644); err != nil {
+			return nil, err
+		}
+		results[i] = Result{Output: jobs[i].Output, ElapsedMS: o.ExecMS}
+		r.opts.Stats.recordResult(o.ExecMS, nil)
+	}
+	return results, nil
+}
+
+// explain puts a client error in the terms of the jobs and flags that
+// caused it, with the exit-code category it deserves.
+func (r *Remote) explain(ctx context.Context, err error, jobs []Job) error {
+	endpoint := r.client.Endpoint()
+	var (
+		he       *client.HTTPError
+		je       *client.JobError
+		tooLarge *client.TooLargeError
+		items    client.ItemErrors
+	)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return errs.New(errs.Network, "%s: no answer within %s (raise --timeout)", endpoint, r.opts.Timeout)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &he) && he.Unauthorized():
+		return errs.New(errs.Environment, "%w (set $REAL_ESRGAN_API_KEY, or api-key in the config file)", err)
+	case errors.As(err, &he) && !he.Temporary():
+		// The far end refused the job itself; sending it again
+		// won't change its mind.
+		return errs.Wrap(errs.User, err)
+	case errors.As(err, &he):
+		return errs.Wrap(errs.Network, err)
+	case errors.As(err, &je):
+		return errs.New(errs.Runtime, "%s: %w", endpoint, err)
+	case errors.As(err, &tooLarge):
+		return errs.New(errs.User, "%s: %.1f MB encoded, past the %.1f MB request cap (raise --max-payload-mb, or downscale it first)",
+			filepath.Base(jobs[tooLarge.Index].Input), float64(tooLarge.Size)/(1<<20), float64(tooLarge.MaxBody)/(1<<20))
+	case errors.As(err, &items):
+		msg := fmt.Sprintf("%s: %s", filepath.Base(jobs[items[0].Index].Input), items[0].Error)
+		if len(items) > 1 {
+			msg += fmt.Sprintf(" (and %d more)", len(items)-1)
+		}
+		return errs.New(errs.Runtime, "%s: %s", endpoint, msg)
+	}
+	return errs.New(errs.Network, "%s: %w", endpoint, err)
+}
+
+// Close idles the connection pool.
+func (r *Remote) Close() error {
+	r.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/pkg/client"
+)
+
+// envelope is a /runsync endpoint in the RunPod worker's shape: it
+// upscales each image by nearest neighbour and answers with the
+// outputs, unless told to fail first.
+type envelope struct {
+	requests atomic.Int32 // posts to /runsync
+	images   atomic.Int32 // images across them
+	failures int32        // first posts answered 503
+	queued   bool         // answer IN_QUEUE and make the caller poll
+	badItem  int          // index reported in per_item_errors; -1 for none
+	auth     atomic.Value // Authorization header of the last post
+}
+
+func newEnvelope(t *testing.T, e *envelope) *httptest.Server {
+	t.Helper()
+	saved := []time.Duration{pollInterval, retryBackoff}
+	pollInterval, retryBackoff = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { pollInterval, retryBackoff = saved[0], saved[1] })
+
+	var done atomic.Value // the finished output of a queued job
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v2/ep/runsync", func(w http.ResponseWriter, r *http.Request) {
+		n := e.requests.Add(1)
+		e.auth.Store(r.Header.Get("Authorization"))
+		if n <= e.failures {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Input struct {
+				Images []struct {
+					ImageBase64 string `json:"image_base64"`
+				} `json:"images"`
+				OutputFormat string  `json:"output_format"`
+				Scale        float64 `json:"scale"`
+			} `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Input.Images) == 0 {
+			http.Error(w, "bad envelope", http.StatusBadRequest)
+			return
+		}
+		e.images.Add(int32(len(req.Input.Images)))
+		outputs := []map[string]any{}
+		var itemErrors []map[string]any
+		for i, img := range req.Input.Images {
+			if i == e.badItem {
+				itemErrors = append(itemErrors, map[string]any{"index": i, "error": "cannot identify image file"})
+				continue
+			}
+			raw, _ := base64.StdEncoding.DecodeString(img.ImageBase64)
+			var buf bytes.Buffer
+			if err := imageinfo.Nearest(bytes.NewReader(raw), &buf, req.Input.Scale, "."+req.Input.OutputFormat); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			outputs = append(outputs, map[string]any{"image_base64": base64.StdEncoding.EncodeToString(buf.Bytes()), "exec_ms": 7})
+		}
+		output := map[string]any{"outputs": outputs, "_diagnostics": map[string]any{"per_item_errors": itemErrors}}
+		if e.queued {
+			done.Store(output)
+			json.NewEncoder(w).Encode(map[string]any{"id": "job-1", "status": "IN_QUEUE"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": "job-1", "status": "COMPLETED", "output": output})
+	})
+	var polls atomic.Int32
+	mux.HandleFunc("GET /v2/ep/status/job-1", func(w http.ResponseWriter, r *http.Request) {
+		if polls.Add(1) < 3 {
+			json.NewEncoder(w).Encode(map[string]any{"id": "job-1", "status": "IN_PROGRESS"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": "job-1", "status": "COMPLETED", "output": done.Load()})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// remoteTo returns a Remote posting to ts as runpod://ep.
+func remoteTo(t *testing.T, ts *httptest.Server, opts RemoteOptions) *Remote {
+	t.Helper()
+	saved := runpodAPI
+	runpodAPI = ts.URL + "/v2"
+	t.Cleanup(func() { runpodAPI = saved })
+	r, err := NewRemote("runpod://ep", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestNewRemote(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		want     string // the /runsync URL; "" = refused
+	}{
+		{"http://gpu-box:8311", "http://gpu-box:8311/runsync"},
+		{"https://gpu-box/api/runsync", "https://gpu-box/api/runsync"},
+		{"runpod://abc123", "https://api.runpod.ai/v2/abc123/runsync"},
+		{"runpod://", ""},
+		{"runpod://a/b", ""},
+		{"gpu-box:8311", ""},
+	}
+	for _, tc := range cases {
+		r, err := NewRemote(tc.endpoint, RemoteOptions{})
+		switch {
+		case tc.want == "" && !errs.Is(err, errs.User):
+			t.Errorf("%s: got %v, want a user error", tc.endpoint, err)
+		case tc.want != "" && (err != nil || r.client.Endpoint() != tc.want):
+			t.Errorf("%s: got %v, %v; want %s", tc.endpoint, r, err, tc.want)
+		}
+	}
+}
+
+// TestRemote_batch: a directory's worth of jobs goes out in as few
+// envelopes as the body cap allows, and every output lands at 4×
+// with the API key on each request.
+func TestRemote_batch(t *testing.T) {
+	e := &envelope{badItem: -1}
+	ts := newEnvelope(t, e)
+	dir := t.TempDir()
+	var jobs []Job
+	for i := range 5 {
+		jobs = append(jobs, pngJob(t, dir, fmt.Sprint(i), 16, 8))
+	}
+	one := encodedSize(jobs[0].Input)
+
+	stats := &Stats{}
+	r := remoteTo(t, ts, RemoteOptions{APIKey: "k", MaxBody: client.EnvelopeOverhead + 2*one, Stats: stats})
+	if got := len(r.Batches(jobs)); got != 3 {
+		t.Errorf("batches: %d, want 3", got)
+	}
+	results, err := r.UpscaleBatch(context.Background(), jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.requests.Load() != 3 || e.images.Load() != 5 || e.auth.Load() != "Bearer k" {
+		t.Errorf("requests %d, images %d, auth %q", e.requests.Load(), e.images.Load(), e.auth.Load())
+	}
+	for i, res := range results {
+		f, err := os.Open(res.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != 64 || cfg.Height != 32 || res.ElapsedMS != 7 {
+			t.Errorf("result %d: %dx%d in %dms, %v", i, cfg.Width, cfg.Height, res.ElapsedMS, err)
+		}
+	}
+	if stats.Done.Load() != 5 {
+		t.Errorf("done: %d", stats.Done.Load())
+	}
+}
+
+// TestRemote_failures: what is retried, what is polled and what is
+// given up on, and how each surfaces.
+func TestRemote_failures(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      *envelope
+		retries  int
+		scale    float64
+		want     string // substring of the error; "" = succeeds
+		requests int32
+	}{
+		{"503 then ok", &envelope{failures: 2, badItem: -1}, 3, 4, "", 3},
+		{"503 past retries", &envelope{failures: 5, badItem: -1}, 1, 4, "503", 2},
+		{"queued, polled", &envelope{queued: true, badItem: -1}, 0, 4, "", 1},
+		{"item error", &envelope{badItem: 0}, 3, 4, "cannot identify image file", 1},
+		{"scale refused", &envelope{badItem: -1}, 3, 2, "native 4x", 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newEnvelope(t, tc.env)
+			r := remoteTo(t, ts, RemoteOptions{Retries: tc.retries})
+			job := pngJob(t, t.TempDir(), "in", 16, 8)
+			job.Plan.Scale = tc.scale
+			_, err := r.Upscale(context.Background(), job)
+			if tc.want == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+				t.Fatalf("got %v, want %q", err, tc.want)
+			}
+			if got := tc.env.requests.Load(); got != tc.requests {
+				t.Errorf("requests: %d, want %d", got, tc.requests)
+			}
+		})
+	}
+}
+
+// TestRemote_refused: a 4xx other than 429 is the far end refusing
+// the job, and is not retried.
+func TestRemote_refused(t *testing.T) {
+	var n atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		http.Error(w, "scale must be at most 16", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	r, err := NewRemote(ts.URL, RemoteOptions{Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Upscale(context.Background(), pngJob(t, t.TempDir(), "in", 16, 8))
+	if !errs.Is(err, errs.User) || !strings.Contains(err.Error(), "at most 16") || n.Load() != 1 {
+		t.Fatalf("got %v after %d request(s)", err, n.Load())
+	}
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"sync/atomic"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+)
+
+// Stats counts what a backend did with the jobs it was sent.
+// Abandoned jobs — the caller went away before the answer, by
+// disconnecting or hitting serve's 2-minute job timeout — are counted
+// apart, with the backend time they cost: the elapsed_ms of their
+// cancelled event, or of the done event when the helper finished
+// first. A nil *Stats records nothing.
+type Stats struct {
+	Done, Failed     atomic.Int64
+	Abandoned        atomic.Int64
+	BusyMS, WastedMS atomic.Int64
+}
+
+// record accounts for a final event; abandoned says the caller had
+// already gone.
+func (s *Stats) record(ev protocol.Event, abandoned bool) {
+	if s == nil {
+		return
+	}
+	s.BusyMS.Add(ev.ElapsedMS)
+	switch {
+	case abandoned:
+		s.Abandoned.Add(1)
+		s.WastedMS.Add(ev.ElapsedMS)
+	case ev.Event == protocol.EventDone:
+		s.Done.Add(1)
+	default:
+		s.Failed.Add(1)
+	}
+}
+
+// recordResult is record for backends that don't speak in events.
+func (s *Stats) recordResult(elapsedMS int64, err error) {
+	ev := protocol.Event{Event: protocol.EventDone, ElapsedMS: elapsedMS}
+	if err != nil {
+		ev.Event = protocol.EventError
+	}
+	s.record(ev, false)
+}
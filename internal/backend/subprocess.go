@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+	rrt "github.com/ls-ads/real-esrgan-serve/internal/runtime"
+)
+
+// Subprocess runs `upscaler.py --input ... --output ...` once per job:
+// the one-shot path of `super-resolution`, which loads the model each
+// time but leaves nothing running. The helper's stdout — progress
+// text, or JSONL with JSONEvents — is passed through to Events as it
+// arrives; its stderr goes straight to ours.
+type Subprocess struct {
+	Runtime    *rrt.Resolved
+	Model      string
+	GPUID      int
+	Provider   string // --provider; "" = auto
+	Events     io.Writer
+	JSONEvents bool
+}
+
+// Capabilities: the one-shot helper tiles; it has no batched frames
+// and is stopped by killing it.
+func (s *Subprocess) Capabilities() Capabilities {
+	return Capabilities{
+		Backend:  NamePython,
+		Features: []string{protocol.CapTile},
+		Model:    s.Model,
+		Events:   true,
+	}
+}
+
+// Upscale runs the helper on one job and waits for it to exit.
+func (s *Subprocess) Upscale(ctx context.Context, job Job) (Result, error) {
+	plan := job.Plan
+	args := []string{
+		s.Runtime.Script,
+		"--input", job.Input,
+		"--output", job.Output,
+		"--model", s.Model,
+		"--gpu-id", fmt.Sprintf("%d", s.GPUID),
+		"--outscale", imageinfo.FormatScale(plan.Scale),
+		"--resample", job.Resample,
+		"--passes", strconv.Itoa(plan.Passes),
+	}
+	p, err := models.HelperProvider(s.Model, s.Provider)
+	if err != nil {
+		return Result{}, err
+	}
+	if p != "" {
+		args = append(args, "--provider", p)
+	}
+	if plan.Tile {
+		args = append(args, "--tile")
+	}
+	if s.JSONEvents {
+		args = append(args, "--json-events")
+	}
+
+	t0 := time.Now()
+	cmd := exec.CommandContext(ctx, s.Runtime.Python, args...)
+	// Stderr goes straight to ours so users see Python tracebacks
+	// in real time.
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return Result{}, errs.New(errs.Environment, "start upscaler: %w", err)
+	}
+
+	// Stream the helper's stdout. In JSON-events mode this is JSONL
+	// the caller (iosuite CLI / web UI) parses; in human mode it's
+	// already-friendly progress text we just pass through.
+	stream := bufio.NewScanner(stdout)
+	stream.Buffer(make([]byte, 0, 64*1024), 1<<20) // helper events stay small
+	for stream.Scan() {
+		fmt.Fprintln(s.Events, stream.Text())
+	}
+	if err := stream.Err(); err != nil && !errors.Is(err, io.EOF) {
+		// non-fatal: we still wait for the process and let its exit
+		// status be the truth
+		fmt.Fprintf(os.Stderr, "warn: stdout scan: %v\n", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return Result{}, rrt.HelperExit(ctx, err)
+	}
+	return Result{Output: job.Output, ElapsedMS: time.Since(t0).Milliseconds()}, nil
+}
+
+// UpscaleBatch runs the helper once per job.
+func (s *Subprocess) UpscaleBatch(ctx context.Context, jobs []Job) ([]Result, error) {
+	return each(ctx, s, jobs)
+}
+
+// Close is a no-op: nothing outlives a job.
+func (s *Subprocess) Close() error { return nil }
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/spf13/cobra"
+)
+
+// Command returns the Cobra command tree for `config`.
+func Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show the effective settings and where each came from",
+		Long: `Settings layer, lowest first: flag defaults, the config file
+($XDG_CONFIG_HOME/real-esrgan-serve/config.toml, or --config), then
+$REAL_ESRGAN_<FLAG> variables, then flags on the command line.
+
+The file is TOML; its keys are flag names. Top-level keys apply to
+every command with the flag, [serve] / [super-resolution] / … to one
+command, and [profiles.<name>] (--profile, $REAL_ESRGAN_PROFILE or a
+top-level profile key) overrides both:
+
+  gpu-id = 0
+  api-key = "…"            # for --endpoint; no flag, so not in ps
+
+  [serve]
+  concurrency = 2
+
+  [profiles.cpu]
+  gpu-id = -1
+
+  [profiles.cpu.serve]
+  concurrency = 4
+
+serve re-reads the file on SIGHUP and applies concurrency and api-key;
+other changes are logged and wait for a restart.`,
+	}
+	cmd.AddCommand(showCommand())
+	return cmd
+}
+
+func showCommand() *cobra.Command {
+	var asJSON, all bool
+	cmd := &cobra.Command{
+		Use:   "show [command]",
+		Short: "Print the merged settings of a command, or of every command",
+		Long: `Print each setting's effective value and its source: default, file
+(with the table it came from), env (the variable) or flag.
+
+With a command (e.g. 'config show serve') every setting of it is
+listed. Without one, every command's settings that aren't defaults
+are. --all lists defaults too.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := FromContext(cmd.Context())
+			root := cmd.Root()
+			targets := root.Commands()
+			if len(args) == 1 {
+				sub := subcommand(root, args[0])
+				if sub == nil {
+					for _, s := range root.Commands() {
+						if s.HasAlias(args[0]) {
+							sub = s
+						}
+					}
+				}
+				if sub == nil {
+					return errs.New(errs.User, "config show: no command %q", args[0])
+				}
+				targets, all = []*cobra.Command{sub}, true
+			}
+
+			type section struct {
+				Command  string    `json:"command"`
+				Settings []Setting `json:"settings"`
+			}
+			doc := struct {
+				File     string    `json:"file,omitempty"`
+				Profile  string    `json:"profile,omitempty"`
+				Commands []section `json:"commands"`
+			}{File: c.Path, Profile: c.Profile}
+			for _, t := range targets {
+				if t.Hidden || t.Name() == "help" || t.Name() == "completion" || t.Name() == cmd.Parent().Name() {
+					continue
+				}
+				var settings []Setting
+				for _, s := range c.Settings(t) {
+					if all || s.Source.Kind != FromDefault {
+						settings = append(settings, s)
+					}
+				}
+				if len(settings) > 0 {
+					doc.Commands = append(doc.Commands, section{Command: t.Name(), Settings: settings})
+				}
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(doc)
+			}
+			file := doc.File
+			if file == "" {
+				file = "none"
+				if p, err := DefaultPath(); err == nil {
+					file += " (" + p + " not found)"
+				}
+			}
+			fmt.Printf("config file: %s\n", file)
+			if doc.Profile != "" {
+				fmt.Printf("profile:     %s\n", doc.Profile)
+			}
+			if len(doc.Commands) == 0 {
+				fmt.Println("every setting is at its default")
+			}
+			for _, s := range doc.Commands {
+				fmt.Printf("\n%s\n", s.Command)
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				for _, st := range s.Settings {
+					fmt.Fprintf(tw, "  %s\t%s\t%s\n", st.Key, printable(st.Value), st.Source)
+				}
+				tw.Flush()
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print one JSON document on stdout")
+	cmd.Flags().BoolVar(&all, "all", false, "Also list settings at their defaults")
+	return cmd
+}
+
+// printable shows an empty value as such rather than as a gap.
+func printable(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return `""`
+	}
+	return v
+}
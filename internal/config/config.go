@@ -0,0 +1,551 @@
+// Package config layers the settings of every subcommand. Lowest
+// first:
+//
+//  1. the flag's default;
+//  2. the config file: --config or $REAL_ESRGAN_CONFIG, else
+//     $XDG_CONFIG_HOME/real-esrgan-serve/config.toml (~/.config when
+//     XDG_CONFIG_HOME is unset), if it exists;
+//  3. $REAL_ESRGAN_<FLAG>: the flag name upper-cased, - as _
+//     ($REAL_ESRGAN_MODEL holding a file path sets --model-path, as
+//     the images and the RunPod handler use it);
+//  4. the flag on the command line.
+//
+// The file is TOML and its keys are flag names. Top-level keys apply
+// to every command with that flag and a [<command>] table to one
+// command. [profiles.<name>], picked with --profile,
+// $REAL_ESRGAN_PROFILE or a top-level `profile` key, overrides both
+// the same way:
+//
+//	model = "realesrgan-x4plus"
+//
+//	[serve]
+//	concurrency = 2
+//
+//	[profiles.cpu]
+//	gpu-id = -1
+//
+//	[profiles.cpu.serve]
+//	concurrency = 4
+//
+// A key that is no flag of its command is an error, so a typo can't
+// go unnoticed. api-key is the one setting without a flag: a key on
+// the command line would land in shell history and ps.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// EnvPrefix starts every setting's environment variable.
+	EnvPrefix = "REAL_ESRGAN_"
+	// APIKey is the bearer token for --endpoint.
+	APIKey = "api-key"
+)
+
+// Source kinds, lowest precedence first.
+const (
+	FromDefault = "default"
+	FromFile    = "file"
+	FromEnv     = "env"
+	FromFlag    = "flag"
+)
+
+// layering are the flags that pick the layers, and so can't sit in
+// one, and the ones that only make sense typed.
+var layering = map[string]bool{"config": true, "profile": true, "help": true, "version": true}
+
+// Source is where a setting's value came from.
+type Source struct {
+	Kind  string `json:"kind"`            // FromDefault … FromFlag
+	Where string `json:"where,omitempty"` // "<path> [table]", or the env var
+}
+
+func (s Source) String() string {
+	if s.Where == "" {
+		return s.Kind
+	}
+	return s.Kind + " " + s.Where
+}
+
+// Setting is one key's effective value.
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// Config is a loaded config file, or the lack of one, with the
+// profile picked from it.
+type Config struct {
+	Path    string // the file read; "" when there is none
+	Profile string // "" for none
+
+	// What Load was asked for, so Reload can ask again.
+	wantPath, wantProfile string
+
+	tree map[string]any
+	// applied records where Apply took each flag's value from.
+	applied map[string]Source
+}
+
+// DefaultPath is where the config file lives when --config isn't set.
+func DefaultPath() (string, error) {
+	if x := os.Getenv("XDG_CONFIG_HOME"); x != "" {
+		return filepath.Join(x, "real-esrgan-serve", "config.toml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locate home dir: %w", err)
+	}
+	return filepath.Join(home, ".config", "real-esrgan-serve", "config.toml"), nil
+}
+
+// Load reads the config file at path, or the default one, which may
+// be missing; a path asked for must exist. profile overrides the
+// file's own choice.
+func Load(path, profile string) (*Config, error) {
+	c := &Config{wantPath: path, wantProfile: profile, tree: map[string]any{}, applied: map[string]Source{}}
+	c.Path = path
+	if c.Path == "" {
+		if p, err := DefaultPath(); err == nil {
+			c.Path = p
+		}
+	}
+	raw, err := os.ReadFile(c.Path)
+	switch {
+	case c.Path == "" || (errors.Is(err, fs.ErrNotExist) && path == ""):
+		c.Path = ""
+	case err != nil:
+		return nil, errs.New(errs.User, "config: %w", err)
+	default:
+		if _, err := toml.Decode(string(raw), &c.tree); err != nil {
+			return nil, errs.New(errs.User, "config %s: %w", c.Path, err)
+		}
+	}
+
+	c.Profile = profile
+	if c.Profile == "" {
+		c.Profile, _ = c.tree["profile"].(string)
+	}
+	if c.Profile != "" {
+		if _, ok := c.table("profiles", c.Profile); !ok {
+			return nil, errs.New(errs.User, "profile %q: not in %s (profiles: %s)",
+				c.Profile, c.describe(), strings.Join(c.profiles(), ", "))
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) describe() string {
+	if c.Path == "" {
+		return "the config (there is no config file)"
+	}
+	return c.Path
+}
+
+func (c *Config) profiles() []string {
+	t, _ := c.table("profiles")
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{"none"}
+	}
+	return names
+}
+
+// table returns the table at path in the file.
+func (c *Config) table(path ...string) (map[string]any, bool) {
+	t := c.tree
+	for _, name := range path {
+		next, ok := t[name].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		t = next
+	}
+	return t, true
+}
+
+// layers are the tables that may set a key for command, most
+// specific first.
+func (c *Config) layers(command string) [][]string {
+	var ls [][]string
+	if c.Profile != "" {
+		if command != "" {
+			ls = append(ls, []string{"profiles", c.Profile, command})
+		}
+		ls = append(ls, []string{"profiles", c.Profile})
+	}
+	if command != "" {
+		ls = append(ls, []string{command})
+	}
+	return append(ls, nil)
+}
+
+// lookup finds key for command in the file.
+func (c *Config) lookup(command, key string) (any, Source, bool) {
+	for _, path := range c.layers(command) {
+		t, ok := c.table(path...)
+		if !ok {
+			continue
+		}
+		if v, ok := t[key]; ok {
+			if _, isTable := v.(map[string]any); isTable {
+				continue
+			}
+			where := c.Path
+			if len(path) > 0 {
+				where += " [" + strings.Join(path, ".") + "]"
+			}
+			return v, Source{Kind: FromFile, Where: where}, true
+		}
+	}
+	return nil, Source{}, false
+}
+
+// EnvName is the environment variable for key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// modelEnv is $REAL_ESRGAN_MODEL. The images and the RunPod handler
+// set it to the baked model's file, so a path there is --model-path,
+// not a manifest name for --model.
+var modelEnv = EnvName("model")
+
+// isPath tells a model file from a manifest name.
+func isPath(v string) bool {
+	switch filepath.Ext(v) {
+	case ".onnx", ".engine":
+		return true
+	}
+	return strings.ContainsAny(v, `/\`)
+}
+
+// env is f's value from the environment and the variable it came
+// from. A --model given on the command line keeps a path in
+// $REAL_ESRGAN_MODEL from overriding it as --model-path.
+func (c *Config) env(cmd *cobra.Command, f *pflag.Flag) (string, string, bool) {
+	name := EnvName(f.Name)
+	v, ok := os.LookupEnv(name)
+	switch f.Name {
+	case "model":
+		if ok && isPath(v) {
+			return "", "", false
+		}
+	case "model-path":
+		if ok {
+			break
+		}
+		if m, set := os.LookupEnv(modelEnv); set && isPath(m) {
+			src, applied := c.applied["model"]
+			typed := applied && src.Kind == FromFlag || !applied && cmd.Flags().Changed("model")
+			if !typed {
+				return m, modelEnv, true
+			}
+		}
+	}
+	return v, name, ok
+}
+
+// Value is a setting without a flag (APIKey): from the environment,
+// else the file.
+func (c *Config) Value(command, key string) (string, Source) {
+	if v, ok := os.LookupEnv(EnvName(key)); ok {
+		return v, Source{Kind: FromEnv, Where: EnvName(key)}
+	}
+	if v, src, ok := c.lookup(command, key); ok {
+		return fmt.Sprint(v), src
+	}
+	return "", Source{Kind: FromDefault}
+}
+
+// resolved is one flag's value from the layers, as the strings to
+// Set it with.
+type resolved struct {
+	flag   *pflag.Flag
+	values []string
+	src    Source
+}
+
+// CommandName is the name of cmd's table: its top-level subcommand,
+// so `models list` reads [models].
+func CommandName(cmd *cobra.Command) string {
+	for cmd.HasParent() && cmd.Parent().HasParent() {
+		cmd = cmd.Parent()
+	}
+	if !cmd.HasParent() {
+		return ""
+	}
+	return cmd.Name()
+}
+
+// flags are the settings of cmd, its own and inherited.
+func flags(cmd *cobra.Command) []*pflag.Flag {
+	var fs []*pflag.Flag
+	visit := func(f *pflag.Flag) {
+		if !layering[f.Name] {
+			fs = append(fs, f)
+		}
+	}
+	cmd.LocalFlags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	return fs
+}
+
+// resolve works out where each of cmd's flags gets its value.
+func (c *Config) resolve(cmd *cobra.Command) []resolved {
+	command := CommandName(cmd)
+	var out []resolved
+	for _, f := range flags(cmd) {
+		r := resolved{flag: f, src: Source{Kind: FromDefault}}
+		switch src, ok := c.applied[f.Name]; {
+		case f.Changed && ok:
+			// Set by Apply; it stands as applied.
+			r.src = src
+		case f.Changed:
+			r.src = Source{Kind: FromFlag}
+		default:
+			if v, name, ok := c.env(cmd, f); ok {
+				r.values, r.src = []string{v}, Source{Kind: FromEnv, Where: name}
+				if strings.HasSuffix(f.Value.Type(), "Array") || strings.HasSuffix(f.Value.Type(), "Slice") {
+					r.values = strings.Split(v, ",")
+				}
+			} else if v, src, ok := c.lookup(command, f.Name); ok {
+				r.values, r.src = flagValues(v), src
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// flagValues turns a TOML value into the strings pflag parses.
+func flagValues(v any) []string {
+	if list, ok := v.([]any); ok {
+		vs := make([]string, len(list))
+		for i, x := range list {
+			vs[i] = fmt.Sprint(x)
+		}
+		return vs
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// Apply sets every flag of cmd not given on the command line from the
+// environment or the file, and checks the file for keys no command
+// knows. Call it once the flags are parsed.
+func (c *Config) Apply(cmd *cobra.Command) error {
+	if err := c.check(cmd.Root()); err != nil {
+		return err
+	}
+	for _, r := range c.resolve(cmd) {
+		if err := c.set(cmd, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set applies s, as Reload reported it, to cmd's flag.
+func (c *Config) Set(cmd *cobra.Command, s Setting) error {
+	f := cmd.Flags().Lookup(s.Key)
+	if f == nil {
+		return fmt.Errorf("no flag --%s", s.Key)
+	}
+	values := []string{s.Value}
+	if s.Source.Kind == FromDefault {
+		values = []string{f.DefValue}
+	}
+	return c.set(cmd, resolved{flag: f, values: values, src: s.Source})
+}
+
+func (c *Config) set(cmd *cobra.Command, r resolved) error {
+	c.applied[r.flag.Name] = r.src
+	if r.values == nil {
+		return nil
+	}
+	if sv, ok := r.flag.Value.(pflag.SliceValue); ok {
+		if err := sv.Replace(r.values); err != nil {
+			return errs.New(errs.User, "%s: %s: %w", r.src, r.flag.Name, err)
+		}
+		r.flag.Changed = true
+		return nil
+	}
+	for _, v := range r.values {
+		if err := cmd.Flags().Set(r.flag.Name, v); err != nil {
+			return errs.New(errs.User, "%s: %s: %w", r.src, r.flag.Name, err)
+		}
+	}
+	return nil
+}
+
+// Settings are cmd's effective settings, sorted by key, with APIKey
+// when it is set and cmd takes --endpoint. Values are what cmd would
+// run with after Apply.
+func (c *Config) Settings(cmd *cobra.Command) []Setting {
+	var out []Setting
+	for _, r := range c.resolve(cmd) {
+		v := r.flag.Value.String()
+		switch {
+		case r.values == nil:
+		case len(r.values) == 1:
+			v = r.values[0]
+		default:
+			v = "[" + strings.Join(r.values, ",") + "]"
+		}
+		out = append(out, Setting{Key: r.flag.Name, Value: v, Source: r.src})
+	}
+	if v, src := c.Value(CommandName(cmd), APIKey); v != "" && cmd.Flags().Lookup("endpoint") != nil {
+		out = append(out, Setting{Key: APIKey, Value: Redact(v), Source: src})
+		sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	}
+	return out
+}
+
+// Redact keeps enough of a secret to tell two apart.
+func Redact(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// Reload reads the file again, as Load was first asked to, and
+// returns the settings of cmd it changes. Flags set from the
+// environment or command line are left out: a reload can't see those
+// change. Nothing is applied; Set each change cmd can take while it
+// runs. A file that no longer loads changes nothing.
+func (c *Config) Reload(cmd *cobra.Command) ([]Setting, error) {
+	next, err := Load(c.wantPath, c.wantProfile)
+	if err == nil {
+		err = next.check(cmd.Root())
+	}
+	if err != nil {
+		return nil, err
+	}
+	command := CommandName(cmd)
+	var out []Setting
+	for _, f := range flags(cmd) {
+		if k := c.applied[f.Name].Kind; k == FromEnv || k == FromFlag {
+			continue
+		}
+		s := Setting{Key: f.Name, Value: f.DefValue, Source: Source{Kind: FromDefault}}
+		if v, src, ok := next.lookup(command, f.Name); ok {
+			s.Value, s.Source = strings.Join(flagValues(v), ","), src
+		}
+		current := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			current = strings.Join(sv.GetSlice(), ",")
+		}
+		if s.Value != current && (f.Changed || s.Source.Kind != FromDefault) {
+			out = append(out, s)
+		}
+	}
+	if v, src := next.Value(command, APIKey); src.Kind != FromEnv {
+		if old, _ := c.Value(command, APIKey); old != v {
+			out = append(out, Setting{Key: APIKey, Value: v, Source: src})
+		}
+	}
+	c.Path, c.Profile, c.tree = next.Path, next.Profile, next.tree
+	return out, nil
+}
+
+// check refuses keys that are no setting of the command they are
+// under: a misspelt key would otherwise be silently ignored.
+func (c *Config) check(root *cobra.Command) error {
+	var bad []string
+	var checkTable func(t map[string]any, path []string, command *cobra.Command)
+	checkTable = func(t map[string]any, path []string, command *cobra.Command) {
+		keys := keysOf(command)
+		for key, v := range t {
+			sub, isTable := v.(map[string]any)
+			inner := append(slices.Clone(path), key)
+			switch {
+			case command == root && key == "profile" && len(path) == 0:
+			case command == root && key == "profiles" && len(path) == 0 && isTable:
+				for name, p := range sub {
+					pt, ok := p.(map[string]any)
+					if !ok {
+						bad = append(bad, "profiles."+name)
+						continue
+					}
+					checkTable(pt, []string{"profiles", name}, root)
+				}
+			case isTable && command == root && subcommand(root, key) != nil:
+				checkTable(sub, inner, subcommand(root, key))
+			case isTable || !keys[key]:
+				bad = append(bad, strings.Join(inner, "."))
+			}
+		}
+	}
+	checkTable(c.tree, nil, root)
+	if len(bad) == 0 {
+		return nil
+	}
+	sort.Strings(bad)
+	return errs.New(errs.User, "config %s: unknown key(s) %s; keys are flag names, under [<command>] or [profiles.<name>]",
+		c.Path, strings.Join(bad, ", "))
+}
+
+func subcommand(root *cobra.Command, name string) *cobra.Command {
+	for _, sub := range root.Commands() {
+		if sub.Name() == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// keysOf are the settings of cmd and the commands under it.
+func keysOf(cmd *cobra.Command) map[string]bool {
+	keys := map[string]bool{APIKey: true}
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, f := range flags(cmd) {
+			keys[f.Name] = true
+		}
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(cmd)
+	return keys
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying c.
+func NewContext(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the Config the root command loaded, or an empty
+// one when there is none (a command run outside the CLI, as in
+// tests).
+func FromContext(ctx context.Context) *Config {
+	if ctx != nil {
+		if c, ok := ctx.Value(ctxKey{}).(*Config); ok {
+			return c
+		}
+	}
+	return &Config{tree: map[string]any{}, applied: map[string]Source{}}
+}
@@ -0,0 +1,303 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/spf13/cobra"
+)
+
+const sample = `
+gpu-id = 0
+model = "realesrgan-x4plus"
+api-key = "sk-top-0123456789"
+
+[serve]
+concurrency = 2
+extensions = ["png", "jpg"]
+
+[profiles.cpu]
+gpu-id = -1
+
+[profiles.cpu.serve]
+concurrency = 4
+`
+
+// tree is a root with serve and upscale under it, the way main wires
+// them, and the settings serve would run with.
+type tree struct {
+	root, serve, upscale *cobra.Command
+	gpu, concurrency     int
+	model                string
+	extensions           []string
+}
+
+func newTree() *tree {
+	t := &tree{}
+	t.root = &cobra.Command{Use: "real-esrgan-serve"}
+	t.root.PersistentFlags().String("config", "", "")
+	t.root.PersistentFlags().String("profile", "", "")
+	t.serve = &cobra.Command{Use: "serve", Run: func(*cobra.Command, []string) {}}
+	t.serve.Flags().IntVar(&t.gpu, "gpu-id", 0, "")
+	t.serve.Flags().IntVar(&t.concurrency, "concurrency", 1, "")
+	t.serve.Flags().StringVar(&t.model, "model", "nearest", "")
+	t.serve.Flags().String("model-path", "", "")
+	t.serve.Flags().StringSliceVar(&t.extensions, "extensions", []string{"png"}, "")
+	t.serve.Flags().String("endpoint", "", "")
+	t.upscale = &cobra.Command{Use: "super-resolution", Run: func(*cobra.Command, []string) {}}
+	t.upscale.Flags().Int("gpu-id", 0, "")
+	t.upscale.Flags().Int("scale", 4, "")
+	t.root.AddCommand(t.serve, t.upscale)
+	return t
+}
+
+// parse sets args on cmd the way cobra would before PreRun.
+func parse(tb testing.TB, cmd *cobra.Command, args ...string) {
+	tb.Helper()
+	if err := cmd.ParseFlags(args); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func settingsOf(c *Config, cmd *cobra.Command) map[string]Setting {
+	m := map[string]Setting{}
+	for _, s := range c.Settings(cmd) {
+		m[s.Key] = s
+	}
+	return m
+}
+
+// TestApply: each layer overrides the one below it, and Settings says
+// which one a value came from.
+func TestApply(t *testing.T) {
+	path := writeConfig(t, sample)
+	cases := []struct {
+		name    string
+		profile string
+		env     map[string]string
+		args    []string
+		want    map[string]string // key: "value kind"
+	}{
+		{"file", "", nil, nil, map[string]string{
+			"gpu-id": "0 file", "concurrency": "2 file", "model": "realesrgan-x4plus file",
+			"extensions": "[png,jpg] file", "endpoint": " default", "api-key": "****6789 file",
+		}},
+		{"profile", "cpu", nil, nil, map[string]string{
+			"gpu-id": "-1 file", "concurrency": "4 file", "model": "realesrgan-x4plus file",
+		}},
+		{"env", "cpu", map[string]string{"REAL_ESRGAN_GPU_ID": "3", "REAL_ESRGAN_EXTENSIONS": "webp,png"}, nil, map[string]string{
+			"gpu-id": "3 env", "concurrency": "4 file", "extensions": "[webp,png] env",
+		}},
+		{"flag", "cpu", map[string]string{"REAL_ESRGAN_GPU_ID": "3"}, []string{"--gpu-id", "5"}, map[string]string{
+			"gpu-id": "5 flag", "concurrency": "4 file",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			tr := newTree()
+			parse(t, tr.serve, tc.args...)
+			c, err := Load(path, tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Apply(tr.serve); err != nil {
+				t.Fatal(err)
+			}
+			got := settingsOf(c, tr.serve)
+			for key, want := range tc.want {
+				s := got[key]
+				if s.Value+" "+s.Source.Kind != want {
+					t.Errorf("%s: %q from %s, want %s", key, s.Value, s.Source, want)
+				}
+			}
+			if tc.name == "profile" && (tr.gpu != -1 || tr.concurrency != 4) {
+				t.Errorf("applied gpu-id %d, concurrency %d", tr.gpu, tr.concurrency)
+			}
+		})
+	}
+}
+
+// TestApply_modelEnv: the images set $REAL_ESRGAN_MODEL to the baked
+// model's file. A path there is --model-path; a name is still --model,
+// and --model on the command line keeps the path out.
+func TestApply_modelEnv(t *testing.T) {
+	const baked = "/var/cache/real-esrgan-serve/models/realesrgan-x4plus_fp16.onnx"
+	cases := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		model string // "value kind"
+		path  string
+	}{
+		{"path", map[string]string{"REAL_ESRGAN_MODEL": baked}, nil,
+			"nearest default", baked + " env"},
+		{"bare filename", map[string]string{"REAL_ESRGAN_MODEL": "x4plus.onnx"}, nil,
+			"nearest default", "x4plus.onnx env"},
+		{"name", map[string]string{"REAL_ESRGAN_MODEL": "realesrgan-x4plus"}, nil,
+			"realesrgan-x4plus env", " default"},
+		{"model-path wins", map[string]string{"REAL_ESRGAN_MODEL": baked, "REAL_ESRGAN_MODEL_PATH": "/m/other.onnx"}, nil,
+			"nearest default", "/m/other.onnx env"},
+		{"--model flag", map[string]string{"REAL_ESRGAN_MODEL": baked}, []string{"--model", "realesrgan-x4plus"},
+			"realesrgan-x4plus flag", " default"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			tr := newTree()
+			parse(t, tr.serve, tc.args...)
+			c, err := Load("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Apply(tr.serve); err != nil {
+				t.Fatal(err)
+			}
+			got := settingsOf(c, tr.serve)
+			if s := got["model"]; s.Value+" "+s.Source.Kind != tc.model {
+				t.Errorf("model: %q from %s, want %s", s.Value, s.Source, tc.model)
+			}
+			if s := got["model-path"]; s.Value+" "+s.Source.Kind != tc.path {
+				t.Errorf("model-path: %q from %s, want %s", s.Value, s.Source, tc.path)
+			}
+			if tc.name == "path" && got["model-path"].Source.Where != "REAL_ESRGAN_MODEL" {
+				t.Errorf("model-path source: %s", got["model-path"].Source)
+			}
+		})
+	}
+}
+
+// TestApplyScopes: a [serve] key stays out of other commands, and
+// only commands with --endpoint list the api-key.
+func TestApplyScopes(t *testing.T) {
+	c, err := Load(writeConfig(t, sample), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTree()
+	parse(t, tr.upscale)
+	if err := c.Apply(tr.upscale); err != nil {
+		t.Fatal(err)
+	}
+	got := settingsOf(c, tr.upscale)
+	if _, ok := got["concurrency"]; ok {
+		t.Error("super-resolution got serve's concurrency")
+	}
+	if _, ok := got[APIKey]; ok {
+		t.Error("super-resolution lists an api-key")
+	}
+	if got["gpu-id"].Source.Kind != FromFile || got["scale"].Source.Kind != FromDefault {
+		t.Errorf("settings: %+v", got)
+	}
+}
+
+func TestLoad_refused(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string // "" = no file at the path
+		profile string
+		want    string
+	}{
+		{"missing --config", "", "", "no such file"},
+		{"bad toml", "gpu-id = ", "", "config"},
+		{"unknown profile", sample, "gpu", `profile "gpu"`},
+		{"file's unknown profile", "profile = \"gpu\"\n" + sample, "", `profile "gpu"`},
+		{"typo", sample + "conccurency = 3\n", "", "profiles.cpu.serve.conccurency"},
+		{"other command's key", "[super-resolution]\nconcurrency = 3\n", "", "super-resolution.concurrency"},
+		{"unknown command", "[serv]\nconcurrency = 3\n", "", "serv"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if tc.body != "" {
+				path = writeConfig(t, tc.body)
+			}
+			c, err := Load(path, tc.profile)
+			if err == nil {
+				err = c.Apply(newTree().serve)
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error naming %q", err, tc.want)
+			}
+			var e *errs.Error
+			if !errors.As(err, &e) || e.Category != errs.User {
+				t.Errorf("%v: not a user error", err)
+			}
+		})
+	}
+
+	// The default file may be missing.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if c, err := Load("", ""); err != nil || c.Path != "" {
+		t.Errorf("no default file: %+v, %v", c, err)
+	}
+}
+
+// TestReload: only what the file changed is reported, and a setting
+// pinned by the environment or a flag is left alone.
+func TestReload(t *testing.T) {
+	path := writeConfig(t, sample)
+	t.Setenv("REAL_ESRGAN_MODEL", "from-env")
+	tr := newTree()
+	parse(t, tr.serve, "--gpu-id", "1")
+	c, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(tr.serve); err != nil {
+		t.Fatal(err)
+	}
+
+	if changes, err := c.Reload(tr.serve); err != nil || len(changes) != 0 {
+		t.Fatalf("unchanged file: %v, %v", changes, err)
+	}
+
+	next := strings.NewReplacer(
+		"concurrency = 2", "concurrency = 3",
+		"gpu-id = 0", "gpu-id = 2",
+		`model = "realesrgan-x4plus"`, `model = "x2"`,
+		"sk-top-0123456789", "sk-top-9876543210",
+	).Replace(sample)
+	if err := os.WriteFile(path, []byte(next), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := c.Reload(tr.serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ch := range changes {
+		got = append(got, ch.Key+"="+ch.Value)
+	}
+	if want := "concurrency=3 api-key=sk-top-9876543210"; strings.Join(got, " ") != want {
+		t.Errorf("changes %v, want %s", got, want)
+	}
+	if err := c.Set(tr.serve, changes[0]); err != nil || tr.concurrency != 3 {
+		t.Errorf("set concurrency: %d, %v", tr.concurrency, err)
+	}
+
+	if err := os.WriteFile(path, []byte(next+"bogus = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reload(tr.serve); err == nil {
+		t.Error("reloaded a file with an unknown key")
+	}
+}
@@ -0,0 +1,397 @@
+// Package doctor implements `doctor`: one command that checks
+// everything `upscale` and `serve` depend on and says how to fix what
+// is wrong.
+//
+//	manifest     found, signed by a trusted key (unsigned warns with
+//	             --allow-unsigned-manifest)
+//	python       interpreter, version, which lookup rule found it
+//	helper       runtime/upscaler.py, which lookup rule found it
+//	onnxruntime  importable, new enough for the manifest's models
+//	numpy/pillow importable
+//	gpu          NVIDIA devices and driver (nvidia-smi)
+//	providers    onnxruntime execution providers vs the GPU
+//	cache dir    exists (or can be created) and is writable
+//	models       cached artefacts and their hash status
+//	model files  cached artefacts that can't be read (only on failure)
+//
+// Each check is pass, warn or fail. Warnings are setups that work
+// but not the way they probably should (CPU-only onnxruntime on a GPU
+// host); any failure exits 3 (environment).
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/runtime"
+	"github.com/spf13/cobra"
+)
+
+// Check statuses.
+const (
+	Pass = "pass"
+	Warn = "warn"
+	Fail = "fail"
+)
+
+// Result is one check's outcome. Remedy is empty on a pass.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Remedy string `json:"remedy,omitempty"`
+}
+
+// minPython is the oldest interpreter the pinned onnxruntime (1.20)
+// ships wheels for.
+const minPython = "3.10"
+
+// ortRequirement is what the install remedies pin, matching the
+// container images.
+const ortRequirement = "onnxruntime-gpu==1.20.1"
+
+// inspectTimeout bounds the Python probe: importing onnxruntime with
+// the CUDA libraries takes a few seconds on a cold page cache.
+const inspectTimeout = 60 * time.Second
+
+// Config is what the checks run against.
+type Config struct {
+	Locator   runtime.Locator
+	Dest      string     // cache dir override, as fetch-model --dest
+	Manifest  string     // manifest override
+	Unsigned  bool       // --allow-unsigned-manifest: an unsigned manifest warns
+	GPURunner gpu.Runner // nil = nvidia-smi on the host
+}
+
+// Run performs every check in order. Later checks use what earlier
+// ones found (the providers check needs to know whether there is a
+// GPU); one whose input failed is left out rather than failing twice.
+func Run(ctx context.Context, c Config) []Result {
+	var out []Result
+	add := func(r Result) { out = append(out, r) }
+
+	mf, r := checkManifest(c.Manifest, c.Unsigned)
+	add(r)
+
+	var py *runtime.Resolved
+	var env *runtime.PythonEnv
+	if path, from, err := c.Locator.FindPython(); err != nil {
+		add(Result{Name: "python", Status: Fail, Detail: err.Error(),
+			Remedy: "install python3 (" + minPython + "+) and run `real-esrgan-serve runtime setup`, or pass --python / set $PYTHON"})
+	} else {
+		py = &runtime.Resolved{Python: path, PythonFrom: from}
+		env, r = checkPython(ctx, py)
+		add(r)
+	}
+	if script, from, err := c.Locator.FindScript(); err != nil {
+		add(Result{Name: "helper", Status: Fail, Detail: err.Error(),
+			Remedy: "point --runtime or $REAL_ESRGAN_RUNTIME at runtime/upscaler.py, or reinstall"})
+	} else {
+		add(Result{Name: "helper", Status: Pass, Detail: fmt.Sprintf("%s (%s)", script, from)})
+	}
+	if env != nil {
+		for _, r := range checkDeps(py.Python, env, mf) {
+			add(r)
+		}
+	}
+
+	gpus, r := checkGPU(ctx, c.GPURunner)
+	add(r)
+	if env != nil && env.OnnxRuntime != "" {
+		add(checkProviders(py.Python, env, len(gpus) > 0))
+	}
+
+	dir, r := checkCacheDir(c.Dest)
+	add(r)
+	if mf != nil && dir != "" {
+		for _, r := range checkModels(mf, dir) {
+			add(r)
+		}
+	}
+	return out
+}
+
+func checkManifest(override string, allowUnsigned bool) (*models.Manifest, Result) {
+	r := Result{Name: "manifest"}
+	mf, src, err := modelfetch.LoadManifest(override, allowUnsigned)
+	if err != nil {
+		r.Status, r.Detail = Fail, err.Error()
+		r.Remedy = "reinstall, or re-sign an edited manifest with `make manifest-sign`; " +
+			"`real-esrgan-serve manifest validate` shows what is wrong with it"
+		return nil, r
+	}
+	if src.KeyID == "" {
+		r.Status = Warn
+		r.Detail = fmt.Sprintf("%s, unsigned, %d entries", src.Path, len(mf.Models))
+		r.Remedy = "trusted only because of --allow-unsigned-manifest; sign it with `make manifest-sign`"
+		return mf, r
+	}
+	r.Status = Pass
+	r.Detail = fmt.Sprintf("%s, signed by %s, %d entries", src.Path, src.KeyID, len(mf.Models))
+	return mf, r
+}
+
+func checkPython(ctx context.Context, py *runtime.Resolved) (*runtime.PythonEnv, Result) {
+	r := Result{Name: "python"}
+	ctx, cancel := context.WithTimeout(ctx, inspectTimeout)
+	defer cancel()
+	env, err := py.Inspect(ctx)
+	if err != nil {
+		r.Status, r.Detail = Fail, err.Error()
+		r.Remedy = "check the interpreter runs, or pass --python / set $PYTHON to one that does"
+		return nil, r
+	}
+	r.Detail = fmt.Sprintf("%s %s (%s)", py.Python, env.Python, py.PythonFrom)
+	r.Status = Pass
+	if !models.AtLeast(env.Python, minPython) {
+		r.Status = Warn
+		r.Remedy = fmt.Sprintf("onnxruntime 1.20 needs Python %s+; use a newer interpreter with --python", minPython)
+	}
+	return env, r
+}
+
+// checkDeps reports onnxruntime, numpy and Pillow. onnxruntime is
+// held to the newest min_onnxruntime among the manifest's ONNX
+// entries (engines don't go through onnxruntime's model loader).
+func checkDeps(python string, env *runtime.PythonEnv, mf *models.Manifest) []Result {
+	pip := python + " -m pip install "
+	dep := func(name, version, pkg string) Result {
+		r := Result{Name: name, Status: Pass, Detail: version}
+		if version == "" {
+			r.Status, r.Detail = Fail, "not importable: "+env.Errors[name]
+			r.Remedy = "real-esrgan-serve runtime setup (or: " + pip + pkg + ")"
+		}
+		return r
+	}
+	ort := dep("onnxruntime", env.OnnxRuntime, ortRequirement+" (onnxruntime==1.20.1 on a CPU-only host)")
+	if ort.Status == Pass && mf != nil {
+		need, who := "", ""
+		for _, m := range mf.Models {
+			if !m.IsEngine() && m.MinORT != "" && !models.AtLeast(need, m.MinORT) {
+				need, who = m.MinORT, m.Name+"/"+m.Variant
+			}
+		}
+		if need != "" && !models.AtLeast(env.OnnxRuntime, need) {
+			ort.Status = Warn
+			ort.Detail += fmt.Sprintf(" (%s needs %s+)", who, need)
+			ort.Remedy = pip + "--upgrade " + ortRequirement
+		}
+	}
+	return []Result{ort, dep("numpy", env.NumPy, "numpy"), dep("pillow", env.Pillow, "pillow")}
+}
+
+func checkGPU(ctx context.Context, run gpu.Runner) ([]gpu.Info, Result) {
+	r := Result{Name: "gpu"}
+	gpus, err := gpu.Detect(ctx, run)
+	switch {
+	case errors.Is(err, gpu.ErrNoGPU):
+		r.Status, r.Detail = Warn, err.Error()+"; inference runs on CPU (slow)"
+		r.Remedy = "on a GPU host, install the NVIDIA driver; on a CPU host, pass --gpu-id -1"
+		return nil, r
+	case err != nil:
+		r.Status, r.Detail = Warn, err.Error()
+		r.Remedy = "check `nvidia-smi` runs; pass --sm-arch to pick engines without it"
+		return nil, r
+	}
+	names := make([]string, len(gpus))
+	for i, g := range gpus {
+		names[i] = fmt.Sprintf("%d: %s", g.Index, g)
+	}
+	r.Status, r.Detail = Pass, strings.Join(names, "; ")
+	return gpus, r
+}
+
+func checkProviders(python string, env *runtime.PythonEnv, haveGPU bool) Result {
+	r := Result{Name: "providers", Status: Pass, Detail: strings.Join(env.Providers, ", ")}
+	cuda := env.HasProvider("CUDAExecutionProvider")
+	switch {
+	case haveGPU && !cuda:
+		r.Status = Warn
+		r.Detail += " — no CUDA provider, the GPU goes unused"
+		r.Remedy = python + " -m pip uninstall -y onnxruntime && " + python + " -m pip install " + ortRequirement
+	case cuda && !env.HasProvider("TensorrtExecutionProvider"):
+		r.Detail += " (no TensorRT EP: --provider auto never picks TensorRT for .onnx artefacts)"
+	}
+	return r
+}
+
+func checkCacheDir(override string) (string, Result) {
+	r := Result{Name: "cache dir"}
+	dir, err := models.CacheDir(override)
+	if err != nil {
+		r.Status, r.Detail, r.Remedy = Fail, err.Error(), "set $XDG_CACHE_HOME or pass --dest"
+		return "", r
+	}
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		// fetch-model creates it; check that it could.
+		parent := filepath.Dir(dir)
+		for {
+			if _, err := os.Stat(parent); err == nil || parent == filepath.Dir(parent) {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if err := writable(parent); err != nil {
+			r.Status, r.Detail = Fail, fmt.Sprintf("%s does not exist and %s is not writable", dir, parent)
+			r.Remedy = "pass --dest (or set $XDG_CACHE_HOME) to a writable directory"
+			return "", r
+		}
+		r.Status, r.Detail = Warn, dir+" does not exist yet"
+		r.Remedy = "real-esrgan-serve fetch-model --variant auto creates it"
+		return "", r
+	}
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", dir)
+	}
+	if err == nil {
+		err = writable(dir)
+	}
+	if err != nil {
+		r.Status, r.Detail = Fail, err.Error()
+		r.Remedy = fmt.Sprintf("chmod u+rwx %s, or pass --dest (or set $XDG_CACHE_HOME) to a writable directory", dir)
+		return dir, r
+	}
+	r.Status, r.Detail = Pass, dir+" (writable)"
+	return dir, r
+}
+
+// writable tries to create a file in dir: permission bits alone miss
+// read-only mounts and ACLs.
+func writable(dir string) error {
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkModels verifies every cached artefact the manifest lists,
+// trusting .verified stamps the way upscale does (`models verify`
+// re-hashes regardless). A file that can't be read is not known to be
+// corrupt, so it is its own failure ("model files"), with a remedy
+// that doesn't delete it.
+func checkModels(mf *models.Manifest, dir string) []Result {
+	r := Result{Name: "models"}
+	var ok, corrupt, unreadable []string
+	for _, m := range mf.Models {
+		p := filepath.Join(dir, m.Filename)
+		if _, err := os.Stat(p); err != nil || m.Placeholder() {
+			continue
+		}
+		good, got := models.Verify(p, m.SHA256)
+		if !good && got == "" {
+			// Verify doesn't say why it read nothing; hashing
+			// again does.
+			sum, err := models.HashFile(p)
+			if err != nil {
+				unreadable = append(unreadable, fmt.Sprintf("%s (%v)", m.Filename, err))
+				continue
+			}
+			good = sum == m.SHA256
+		}
+		if good {
+			ok = append(ok, m.Filename)
+		} else {
+			corrupt = append(corrupt, m.Filename)
+		}
+	}
+	switch {
+	case len(corrupt) > 0:
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("%d verified, corrupt: %s", len(ok), strings.Join(corrupt, ", "))
+		r.Remedy = "real-esrgan-serve models rm <file>, then fetch-model to download it again"
+	case len(ok) == 0 && len(unreadable) == 0:
+		r.Status, r.Detail = Warn, "nothing cached in "+dir
+		r.Remedy = "real-esrgan-serve fetch-model --variant auto"
+	default:
+		r.Status, r.Detail = Pass, fmt.Sprintf("%d verified: %s", len(ok), strings.Join(ok, ", "))
+	}
+	out := []Result{r}
+	if len(unreadable) > 0 {
+		out = append(out, Result{Name: "model files", Status: Fail,
+			Detail: "can't read " + strings.Join(unreadable, ", "),
+			Remedy: "check the permissions and the disk under " + dir + "; the files may be intact, so don't remove them"})
+	}
+	return out
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// command
+// ─────────────────────────────────────────────────────────────────────
+
+// Command returns the Cobra command for `doctor`.
+func Command() *cobra.Command {
+	var c Config
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the Python runtime, GPU, model cache and manifest",
+		Long: `Check everything upscale and serve depend on: the manifest and its
+signature, the Python interpreter and helper script (and which lookup
+rule found each), onnxruntime / numpy / Pillow, onnxruntime's
+execution providers, the GPU and driver, the model cache directory and
+the hashes of cached models.
+
+Each check passes, warns or fails; a failure comes with a remedy and
+makes the command exit 3. --json prints one JSON document instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results := Run(cmd.Context(), c)
+			failed := 0
+			for _, r := range results {
+				if r.Status == Fail {
+					failed++
+				}
+			}
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(map[string]any{"ok": failed == 0, "checks": results}); err != nil {
+					return err
+				}
+			} else {
+				Print(os.Stdout, results)
+			}
+			if failed > 0 {
+				return errs.New(errs.Environment, "doctor: %d check(s) failed", failed)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&c.Locator.PythonOverride, "python", "", "Python interpreter (default: --python > $PYTHON > python3)")
+	f.StringVar(&c.Locator.ScriptOverride, "runtime", "", "Override path to runtime/upscaler.py")
+	f.StringVar(&c.Dest, "dest", "", "Model cache directory (default: XDG cache dir, as fetch-model)")
+	f.StringVar(&c.Manifest, "manifest", "", "Override manifest path (default: built-in / repo-relative)")
+	f.BoolVar(&c.Unsigned, "allow-unsigned-manifest", false, "Check against a manifest without a valid signature (a warning, not a failure) — dev only")
+	f.BoolVar(&asJSON, "json", false, "Print one JSON document on stdout")
+	return cmd
+}
+
+// Print writes results as a table, remedies indented under their
+// check.
+func Print(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	marks := map[string]string{Pass: "✓", Warn: "!", Fail: "✗"}
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", marks[r.Status], r.Name, r.Detail)
+		if r.Remedy != "" {
+			fmt.Fprintf(tw, "\t\t→ %s\n", r.Remedy)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,216 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/runtime"
+)
+
+const l40s = "0, NVIDIA L40S, 8.9, 550.54.15\n"
+
+func gpuRunner(out string) gpu.Runner {
+	return func(context.Context, string, ...string) ([]byte, error) {
+		if out == "" {
+			return nil, exec.ErrNotFound
+		}
+		return []byte(out), nil
+	}
+}
+
+// fakePython writes an "interpreter" that prints the JSON Inspect
+// expects, whatever it is asked to run.
+func fakePython(t *testing.T, inspect string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "python3")
+	script := "#!/bin/sh\ncat <<'EOF'\n" + inspect + "\nEOF\n"
+	if err := os.WriteFile(p, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestCheckManifest: an unsigned manifest fails, unless
+// --allow-unsigned-manifest turns it into a warning.
+func TestCheckManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MANIFEST.json")
+	os.WriteFile(path, []byte(`{"version":2,"models":[]}`), 0o644)
+	if mf, r := checkManifest(path, false); mf != nil || r.Status != Fail || !strings.Contains(r.Detail, "unsigned") {
+		t.Errorf("unsigned: %+v", r)
+	}
+	if mf, r := checkManifest(path, true); mf == nil || r.Status != Warn || !strings.Contains(r.Remedy, "--allow-unsigned-manifest") {
+		t.Errorf("unsigned, allowed: %+v", r)
+	}
+}
+
+func TestCheckDeps(t *testing.T) {
+	mf := &models.Manifest{Models: []models.Model{
+		{Name: "x4", Variant: "fp16", MinORT: "1.18"},
+		{Name: "x4", Variant: "engine", MinORT: "1.99"}, // engines don't count
+	}}
+	cases := []struct {
+		name string
+		env  runtime.PythonEnv
+		want string // statuses of onnxruntime, numpy, pillow
+	}{
+		{"all present", runtime.PythonEnv{OnnxRuntime: "1.20.1", NumPy: "2.1", Pillow: "11.0"}, "pass pass pass"},
+		{"old onnxruntime", runtime.PythonEnv{OnnxRuntime: "1.16.3", NumPy: "2.1", Pillow: "11.0"}, "warn pass pass"},
+		{"missing onnxruntime", runtime.PythonEnv{NumPy: "2.1", Pillow: "11.0",
+			Errors: map[string]string{"onnxruntime": "ModuleNotFoundError"}}, "fail pass pass"},
+		{"missing pillow", runtime.PythonEnv{OnnxRuntime: "1.20.1", NumPy: "2.1"}, "pass pass fail"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := checkDeps("python3", &tc.env, mf)
+			var got []string
+			for _, r := range rs {
+				got = append(got, r.Status)
+				if r.Status != Pass && r.Remedy == "" {
+					t.Errorf("%s: %s without a remedy", r.Name, r.Status)
+				}
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Fatalf("got %v, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckProviders(t *testing.T) {
+	cpu := []string{"CPUExecutionProvider"}
+	cuda := []string{"CUDAExecutionProvider", "CPUExecutionProvider"}
+	trt := append([]string{"TensorrtExecutionProvider"}, cuda...)
+	cases := []struct {
+		name      string
+		providers []string
+		haveGPU   bool
+		want      string
+		detail    string
+	}{
+		{"cpu host", cpu, false, Pass, "CPUExecutionProvider"},
+		{"cpu wheel on a gpu host", cpu, true, Warn, "GPU goes unused"},
+		{"cuda without trt", cuda, true, Pass, "no TensorRT"},
+		{"trt", trt, true, Pass, "TensorrtExecutionProvider"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := checkProviders("python3", &runtime.PythonEnv{Providers: tc.providers}, tc.haveGPU)
+			if r.Status != tc.want || !strings.Contains(r.Detail, tc.detail) {
+				t.Fatalf("got %+v", r)
+			}
+		})
+	}
+}
+
+func TestCheckCacheDir(t *testing.T) {
+	root := t.TempDir()
+	if _, r := checkCacheDir(root); r.Status != Pass {
+		t.Errorf("writable dir: %+v", r)
+	}
+	if dir, r := checkCacheDir(filepath.Join(root, "a", "b")); r.Status != Warn || dir != "" {
+		t.Errorf("missing dir: %+v", r)
+	}
+	file := filepath.Join(root, "file")
+	os.WriteFile(file, nil, 0o644)
+	if _, r := checkCacheDir(file); r.Status != Fail || !strings.Contains(r.Detail, "not a directory") {
+		t.Errorf("file: %+v", r)
+	}
+}
+
+func TestCheckModels(t *testing.T) {
+	dir := t.TempDir()
+	mf := &models.Manifest{Models: []models.Model{
+		{Name: "x4", Variant: "fp16", Filename: "a.onnx", SHA256: strings.Repeat("a", 64)},
+		{Name: "x4", Variant: "fp32", Filename: "b.onnx", SHA256: strings.Repeat("b", 64)},
+		{Name: "x4", Variant: "engine", Filename: "c.engine", SHA256: strings.Repeat("c", 64)},
+	}}
+	if r := checkModels(mf, dir)[0]; r.Status != Warn {
+		t.Errorf("empty cache: %+v", r)
+	}
+
+	// A stamp vouches for a.onnx as it would after fetch-model.
+	a := filepath.Join(dir, "a.onnx")
+	os.WriteFile(a, []byte("a"), 0o644)
+	if err := models.MarkVerified(a, mf.Models[0].SHA256); err != nil {
+		t.Fatal(err)
+	}
+	if r := checkModels(mf, dir)[0]; r.Status != Pass || !strings.Contains(r.Detail, "1 verified") {
+		t.Errorf("verified: %+v", r)
+	}
+
+	// A read error (a directory where the file should be; permissions
+	// don't stop root) is not corruption: its own failure, and no
+	// advice to delete it.
+	os.Mkdir(filepath.Join(dir, "c.engine"), 0o755)
+	rs := checkModels(mf, dir)
+	if len(rs) != 2 || rs[0].Status != Pass || rs[1].Name != "model files" || rs[1].Status != Fail ||
+		!strings.Contains(rs[1].Detail, "c.engine") || strings.Contains(rs[1].Remedy, "models rm") {
+		t.Errorf("unreadable: %+v", rs)
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.onnx"), []byte("not b"), 0o644)
+	if r := checkModels(mf, dir)[0]; r.Status != Fail || !strings.Contains(r.Detail, "corrupt: b.onnx") || strings.Contains(r.Detail, "c.engine") {
+		t.Errorf("corrupt: %+v", r)
+	}
+}
+
+// TestRun wires the checks together against a fake interpreter, a
+// canned nvidia-smi and a temp cache; the manifest is the embedded
+// one, let through unsigned so the result doesn't depend on whether
+// this build trusts its signature.
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name    string
+		inspect string
+		gpus    string
+		want    map[string]string
+	}{
+		{
+			name: "healthy gpu host",
+			inspect: `{"python": "3.11.7", "onnxruntime": "1.20.1", "numpy": "2.1.3", "pillow": "11.0.0",
+				"providers": ["TensorrtExecutionProvider", "CUDAExecutionProvider", "CPUExecutionProvider"]}`,
+			gpus: l40s,
+			want: map[string]string{"python": Pass, "helper": Pass, "onnxruntime": Pass,
+				"gpu": Pass, "providers": Pass, "cache dir": Pass, "models": Warn},
+		},
+		{
+			name:    "old python, no onnxruntime, no gpu",
+			inspect: `{"python": "3.8.10", "onnxruntime": "", "numpy": "1.24.4", "pillow": "10.0.0", "providers": [], "errors": {"onnxruntime": "ModuleNotFoundError"}}`,
+			want: map[string]string{"python": Warn, "onnxruntime": Fail, "gpu": Warn,
+				"providers": ""}, // not run without onnxruntime
+		},
+		{
+			name:    "interpreter does not speak JSON",
+			inspect: "Python 2.7.18",
+			want:    map[string]string{"python": Fail, "onnxruntime": ""},
+		},
+	}
+	script := filepath.Join(t.TempDir(), "upscaler.py")
+	os.WriteFile(script, nil, 0o644)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := Run(context.Background(), Config{
+				Locator:   runtime.Locator{PythonOverride: fakePython(t, tc.inspect), ScriptOverride: script},
+				Dest:      t.TempDir(),
+				Unsigned:  true,
+				GPURunner: gpuRunner(tc.gpus),
+			})
+			got := map[string]string{}
+			for _, r := range rs {
+				got[r.Name] = r.Status
+			}
+			for name, want := range tc.want {
+				if got[name] != want {
+					t.Errorf("%s: got %q, want %q (all: %s)", name, got[name], want, fmt.Sprint(rs))
+				}
+			}
+		})
+	}
+}
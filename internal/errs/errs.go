@@ -0,0 +1,113 @@
+// Package errs is the error taxonomy shared by every subcommand, and
+// the single place exit codes are decided.
+//
+// ARCHITECTURE.md promises iosuite a stable exit-code contract:
+//
+//	0    success
+//	1    user error         bad flags, bad input image, unknown model name
+//	2    runtime error      model load / inference / helper crash
+//	3    environment error  python or onnxruntime missing, model not cached, unwritable dirs
+//	4    integrity error    SHA-256 mismatch, placeholder manifest hash, wrong output geometry
+//	5    network error      download failed, HTTP error from a release host
+//	130  interrupted        SIGINT / SIGTERM
+//
+// 1–3 match runtime/upscaler.py's own exit codes, so a helper failure
+// passes through unchanged. Packages return errors built here (or
+// wrapped with Wrap); each subcommand's RunE wraps whatever is left
+// with its own default, and main maps the result with Classify.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Category is the coarse class of a failure. The string value is what
+// `--json-events` puts in the final error event.
+type Category string
+
+const (
+	User        Category = "user"
+	Runtime     Category = "runtime"
+	Environment Category = "environment"
+	Integrity   Category = "integrity"
+	Network     Category = "network"
+	Interrupted Category = "interrupted"
+)
+
+// exitCodes is the contract table above. Keep the two in step.
+var exitCodes = map[Category]int{
+	User:        1,
+	Runtime:     2,
+	Environment: 3,
+	Integrity:   4,
+	Network:     5,
+	Interrupted: 130,
+}
+
+// Error is a categorised error. Code overrides the category's exit
+// code when non-zero — used to pass a helper's exit status through
+// verbatim.
+type Error struct {
+	Category Category
+	Code     int
+	Err      error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// ExitCode is the process exit status for this error.
+func (e *Error) ExitCode() int {
+	if e.Code != 0 {
+		return e.Code
+	}
+	return exitCodes[e.Category]
+}
+
+// New builds a categorised error from a format string.
+func New(c Category, format string, a ...any) error {
+	return &Error{Category: c, Err: fmt.Errorf(format, a...)}
+}
+
+// Wrap categorises err. A nil err stays nil. An err that already
+// carries a category keeps it — the innermost classification is the
+// most specific one, so `fmt.Errorf("download: %w", netErr)` wrapped
+// as Runtime still exits as a network error.
+func Wrap(c Category, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Category: c, Err: err}
+}
+
+// WithCode categorises err with an explicit exit code.
+func WithCode(c Category, code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Category: c, Code: code, Err: err}
+}
+
+// Classify returns the category and exit code for err. Uncategorised
+// errors are runtime errors; nil is success.
+func Classify(err error) (Category, int) {
+	if err == nil {
+		return "", 0
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category, e.ExitCode()
+	}
+	return Runtime, exitCodes[Runtime]
+}
+
+// Is reports whether err carries category c.
+func Is(err error, c Category) bool {
+	got, _ := Classify(err)
+	return got == c
+}
@@ -0,0 +1,52 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestClassify pins the exit-code contract iosuite scripts against.
+// Changing a number here is a breaking change to the CLI surface.
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		category Category
+		code     int
+	}{
+		{"nil is success", nil, "", 0},
+		{"bare error is runtime", errors.New("boom"), Runtime, 2},
+		{"user", New(User, "bad flag"), User, 1},
+		{"environment", New(Environment, "no python"), Environment, 3},
+		{"integrity", New(Integrity, "sha256 mismatch"), Integrity, 4},
+		{"network", New(Network, "http 503"), Network, 5},
+		{"interrupted", New(Interrupted, "ctrl-c"), Interrupted, 130},
+		{"explicit code wins", WithCode(Runtime, 7, errors.New("helper")), Runtime, 7},
+		{"survives fmt wrapping", fmt.Errorf("download: %w", New(Network, "reset")), Network, 5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, code := Classify(tc.err)
+			if c != tc.category || code != tc.code {
+				t.Errorf("Classify = (%q, %d), want (%q, %d)", c, code, tc.category, tc.code)
+			}
+		})
+	}
+}
+
+// TestWrap_keepsInnerCategory: a RunE-level default must not
+// overwrite the specific category a deeper call already chose.
+func TestWrap_keepsInnerCategory(t *testing.T) {
+	inner := New(Integrity, "sha256 mismatch")
+	err := Wrap(Runtime, fmt.Errorf("fetch: %w", inner))
+	if !Is(err, Integrity) {
+		t.Fatalf("Wrap replaced the inner category: %v", err)
+	}
+	if Wrap(User, nil) != nil {
+		t.Fatal("Wrap(nil) should stay nil")
+	}
+	if !errors.Is(Wrap(User, inner), inner) {
+		t.Fatal("Wrap should keep the chain unwrappable")
+	}
+}
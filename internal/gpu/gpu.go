@@ -0,0 +1,171 @@
+// Package gpu reads what NVIDIA hardware the host has: each device's
+// name, compute capability and the driver version, from
+//
+//	nvidia-smi --query-gpu=index,name,compute_cap,driver_version --format=csv,noheader
+//
+// The compute capability is what picks a TensorRT engine: an engine
+// only loads on the SM architecture it was built for (models.Find).
+// This is the Go twin of the RunPod handler's _gpu_sm_arch().
+//
+// nvidia-smi is the one dependency that's on every host with an
+// NVIDIA driver — no CUDA toolkit, no cgo, no NVML bindings. It is
+// run through a Runner so tests can feed it canned output.
+package gpu
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Info is one GPU as nvidia-smi reports it.
+type Info struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name"`        // e.g. "NVIDIA L40S"
+	ComputeCap string `json:"compute_cap"` // e.g. "8.9"
+	Driver     string `json:"driver_version"`
+}
+
+// SMArch is the compute capability in manifest form: "8.9" → "sm89".
+func (i Info) SMArch() string {
+	return "sm" + strings.ReplaceAll(i.ComputeCap, ".", "")
+}
+
+// String is the human form used in logs: "NVIDIA L40S (sm89, driver 550.54.15)".
+func (i Info) String() string {
+	return fmt.Sprintf("%s (%s, driver %s)", i.Name, i.SMArch(), i.Driver)
+}
+
+// Runner runs a command and returns its stdout. ExecRunner is the real
+// one; tests substitute canned output.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// ExecRunner runs the command on the host.
+func ExecRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// ErrNoGPU is returned (wrapped) when the host has no usable NVIDIA
+// GPU: no nvidia-smi, or nvidia-smi listing no devices.
+var ErrNoGPU = errors.New("no NVIDIA GPU detected")
+
+// queryTimeout bounds one nvidia-smi call. It normally answers in
+// ~50 ms; a wedged driver can make it hang indefinitely.
+const queryTimeout = 5 * time.Second
+
+// Detect lists the host's GPUs. run == nil means ExecRunner.
+func Detect(ctx context.Context, run Runner) ([]Info, error) {
+	if run == nil {
+		run = ExecRunner
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	out, err := run(ctx, "nvidia-smi",
+		"--query-gpu=index,name,compute_cap,driver_version", "--format=csv,noheader")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%w (nvidia-smi not on PATH)", ErrNoGPU)
+		}
+		msg := bytes.TrimSpace(out)
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			msg = bytes.TrimSpace(ee.Stderr)
+		}
+		if len(msg) > 0 {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		// Drivers older than ~510 don't know compute_cap and reject
+		// the whole query.
+		if bytes.Contains(msg, []byte("compute_cap")) {
+			return nil, fmt.Errorf("nvidia-smi can't report compute_cap (driver too old?): %w", err)
+		}
+		return nil, fmt.Errorf("nvidia-smi: %w", err)
+	}
+	gpus, err := Parse(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(gpus) == 0 {
+		return nil, fmt.Errorf("%w (nvidia-smi lists no devices)", ErrNoGPU)
+	}
+	return gpus, nil
+}
+
+// Parse reads `--query-gpu=index,name,compute_cap,driver_version
+// --format=csv,noheader` output.
+func Parse(out []byte) ([]Info, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	recs, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse nvidia-smi output: %w", err)
+	}
+	var gpus []Info
+	for _, rec := range recs {
+		if len(rec) != 4 {
+			return nil, fmt.Errorf("parse nvidia-smi output: want 4 fields, got %d in %q", len(rec), strings.Join(rec, ","))
+		}
+		idx, err := strconv.Atoi(rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse nvidia-smi output: index %q: %w", rec[0], err)
+		}
+		cc := strings.TrimSpace(rec[2])
+		if _, err := strconv.ParseFloat(cc, 64); err != nil {
+			return nil, fmt.Errorf("parse nvidia-smi output: GPU %d compute capability %q", idx, cc)
+		}
+		gpus = append(gpus, Info{
+			Index:      idx,
+			Name:       strings.TrimSpace(rec[1]),
+			ComputeCap: cc,
+			Driver:     strings.TrimSpace(rec[3]),
+		})
+	}
+	return gpus, nil
+}
+
+// Device returns the GPU the helper will see as CUDA device id (the
+// --gpu-id flag). nvidia-smi numbers every GPU on the host, CUDA only
+// the ones in $CUDA_VISIBLE_DEVICES, so a numeric list there is used to
+// translate one to the other.
+func Device(ctx context.Context, run Runner, id int) (*Info, error) {
+	if id < 0 {
+		return nil, fmt.Errorf("%w (CPU requested)", ErrNoGPU)
+	}
+	gpus, err := Detect(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	want := id
+	if v := os.Getenv("CUDA_VISIBLE_DEVICES"); v != "" {
+		visible := strings.Split(v, ",")
+		if id >= len(visible) {
+			return nil, fmt.Errorf("GPU %d: CUDA_VISIBLE_DEVICES=%s exposes %d device(s)", id, v, len(visible))
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(visible[id])); err == nil {
+			want = n
+		} // a UUID list: assume the order matches, nothing better to do
+	}
+	for i := range gpus {
+		if gpus[i].Index == want {
+			return &gpus[i], nil
+		}
+	}
+	return nil, fmt.Errorf("GPU %d not found; nvidia-smi lists %d device(s)", want, len(gpus))
+}
+
+// SMArch is Device's architecture, or "" when it can't be told — the
+// caller then behaves as if no --sm-arch was given.
+func SMArch(ctx context.Context, run Runner, id int) string {
+	d, err := Device(ctx, run, id)
+	if err != nil {
+		return ""
+	}
+	return d.SMArch()
+}
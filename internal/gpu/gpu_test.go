@@ -0,0 +1,131 @@
+package gpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// canned returns a Runner that answers every call with out/err.
+func canned(out string, err error) Runner {
+	return func(context.Context, string, ...string) ([]byte, error) {
+		return []byte(out), err
+	}
+}
+
+const twoGPUs = `0, NVIDIA GeForce RTX 4090, 8.9, 550.54.15
+1, NVIDIA A40, 8.6, 550.54.15
+`
+
+func TestParse(t *testing.T) {
+	gpus, err := Parse([]byte(twoGPUs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Info{
+		{Index: 0, Name: "NVIDIA GeForce RTX 4090", ComputeCap: "8.9", Driver: "550.54.15"},
+		{Index: 1, Name: "NVIDIA A40", ComputeCap: "8.6", Driver: "550.54.15"},
+	}
+	if fmt.Sprint(gpus) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", gpus, want)
+	}
+	if got := gpus[0].SMArch(); got != "sm89" {
+		t.Errorf("SMArch = %q, want sm89", got)
+	}
+	if got := (Info{ComputeCap: "12.0"}).SMArch(); got != "sm120" {
+		t.Errorf("SMArch(12.0) = %q, want sm120", got)
+	}
+
+	for _, bad := range []string{
+		"0, NVIDIA A40, 8.6\n",           // field missing
+		"x, NVIDIA A40, 8.6, 550.54\n",   // index not a number
+		"0, NVIDIA A40, [N/A], 550.54\n", // no compute capability
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%q): want an error", bad)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name    string
+		run     Runner
+		noGPU   bool
+		errPart string
+	}{
+		{name: "no nvidia-smi", run: canned("", exec.ErrNotFound), noGPU: true},
+		{name: "no devices", run: canned("", nil), noGPU: true},
+		{
+			name:    "driver too old for compute_cap",
+			run:     canned(`Field "compute_cap" is not a valid field to query.`, errors.New("exit status 2")),
+			errPart: "driver too old",
+		},
+		{name: "driver wedged", run: canned("", errors.New("exit status 9")), errPart: "nvidia-smi: exit status 9"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Detect(context.Background(), c.run)
+			if err == nil {
+				t.Fatal("want an error")
+			}
+			if errors.Is(err, ErrNoGPU) != c.noGPU {
+				t.Errorf("errors.Is(ErrNoGPU) = %v, want %v (%v)", !c.noGPU, c.noGPU, err)
+			}
+			if !strings.Contains(err.Error(), c.errPart) {
+				t.Errorf("error %q doesn't mention %q", err, c.errPart)
+			}
+		})
+	}
+
+	var gotArgs []string
+	run := func(_ context.Context, name string, args ...string) ([]byte, error) {
+		gotArgs = append([]string{name}, args...)
+		return []byte(twoGPUs), nil
+	}
+	if gpus, err := Detect(context.Background(), run); err != nil || len(gpus) != 2 {
+		t.Fatalf("Detect = %v, %v", gpus, err)
+	}
+	if !strings.Contains(strings.Join(gotArgs, " "), "--query-gpu=index,name,compute_cap,driver_version") {
+		t.Errorf("ran %v", gotArgs)
+	}
+}
+
+// TestDevice: --gpu-id is a CUDA device id, which CUDA_VISIBLE_DEVICES
+// renumbers relative to nvidia-smi's host-wide index.
+func TestDevice(t *testing.T) {
+	cases := []struct {
+		visible string
+		id      int
+		want    string // SM arch; "" = error
+	}{
+		{visible: "", id: 0, want: "sm89"},
+		{visible: "", id: 1, want: "sm86"},
+		{visible: "", id: 2},
+		{visible: "1", id: 0, want: "sm86"},
+		{visible: "1,0", id: 1, want: "sm89"},
+		{visible: "1", id: 1},
+		{visible: "", id: -1},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%q/%d", c.visible, c.id), func(t *testing.T) {
+			t.Setenv("CUDA_VISIBLE_DEVICES", c.visible)
+			d, err := Device(context.Background(), canned(twoGPUs, nil), c.id)
+			if c.want == "" {
+				if err == nil {
+					t.Fatalf("want an error, got %v", d)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.SMArch() != c.want {
+				t.Errorf("got %s, want %s", d.SMArch(), c.want)
+			}
+		})
+	}
+}
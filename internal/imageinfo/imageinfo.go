@@ -0,0 +1,135 @@
+// Package imageinfo reads image dimensions from headers (no pixel
+// decode) and checks helper output against the geometry the caller
+// asked for.
+//
+// The Python helper owns pixels; the Go side only ever needs W×H.
+// `image.DecodeConfig` gives us that from the first few hundred bytes
+// of the file, so validating a 20 MP output costs microseconds rather
+// than a full decode.
+package imageinfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register decoder for DecodeConfig
+	_ "image/jpeg" // register decoder for DecodeConfig
+	_ "image/png"  // register decoder for DecodeConfig
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	// The helper (PIL) reads these too; registering them here means
+	// preflight sees every format docs/IMAGE-IO.md lists as accepted.
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// NativeScale is Real-ESRGAN's fixed model factor. Any other requested
+// scale is produced by resampling the model's 4× output (see
+// runtime/upscaler.py --outscale).
+const NativeScale = 4.0
+
+// Info is what we know about an image without decoding its pixels.
+type Info struct {
+	Width  int
+	Height int
+	Format string // "png", "jpeg", ... as registered with package image
+}
+
+// Probe reads the header of the image at path.
+func Probe(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	return probe(f)
+}
+
+// ProbeBytes reads the header of an in-memory image.
+func ProbeBytes(b []byte) (Info, error) {
+	return probe(bytes.NewReader(b))
+}
+
+func probe(r io.Reader) (Info, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Width: cfg.Width, Height: cfg.Height, Format: format}, nil
+}
+
+// ScaledDims returns the output W×H for a given scale. Round-half-up,
+// floored at 1 px — runtime/upscaler.py:_outscale_dims computes the
+// same numbers, so keep the two in lockstep.
+func ScaledDims(w, h int, scale float64) (int, int) {
+	sw := int(math.Floor(float64(w)*scale + 0.5))
+	sh := int(math.Floor(float64(h)*scale + 0.5))
+	return max(sw, 1), max(sh, 1)
+}
+
+// ValidateScale rejects factors no plan can produce. Factors above the
+// native 4× are legal — internal/sizing chains passes for them — so
+// the only hard rule here is "positive and finite".
+func ValidateScale(scale float64) error {
+	if math.IsNaN(scale) || math.IsInf(scale, 0) || scale <= 0 {
+		return fmt.Errorf("scale must be > 0, got %v", scale)
+	}
+	return nil
+}
+
+// ValidateResample rejects filter names upscaler.py's --resample
+// doesn't accept. Checked Go-side so a typo fails before the helper
+// pays model-load cost.
+func ValidateResample(name string) error {
+	switch name {
+	case "lanczos", "bicubic", "bilinear", "nearest":
+		return nil
+	}
+	return fmt.Errorf("unknown resample filter %q (want lanczos | bicubic | bilinear | nearest)", name)
+}
+
+// FormatScale renders a scale for filenames: 4 → "4", 1.5 → "1.5".
+func FormatScale(scale float64) string {
+	return strconv.FormatFloat(scale, 'f', -1, 64)
+}
+
+// CheckScaled confirms the file at outPath is exactly `in` scaled by
+// `scale`. Formats package image can't read (anything PIL decodes
+// that we have no registered decoder for) are skipped rather than failed — the helper already
+// reported success, and an unverifiable file isn't a wrong one.
+func CheckScaled(in Info, outPath string, scale float64) error {
+	out, err := Probe(outPath)
+	if errors.Is(err, image.ErrFormat) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read output header %s: %w", outPath, err)
+	}
+	return checkDims(in, out, scale)
+}
+
+// CheckScaledBytes is CheckScaled for an in-memory output.
+func CheckScaledBytes(in Info, out []byte, scale float64) error {
+	info, err := ProbeBytes(out)
+	if errors.Is(err, image.ErrFormat) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read output header: %w", err)
+	}
+	return checkDims(in, info, scale)
+}
+
+func checkDims(in, out Info, scale float64) error {
+	wantW, wantH := ScaledDims(in.Width, in.Height, scale)
+	if out.Width != wantW || out.Height != wantH {
+		return fmt.Errorf("output is %dx%d, expected %dx%d (%dx%d at %sx)",
+			out.Width, out.Height, wantW, wantH, in.Width, in.Height, FormatScale(scale))
+	}
+	return nil
+}
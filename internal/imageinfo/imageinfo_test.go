@@ -0,0 +1,132 @@
+package imageinfo
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// writePNG drops a w×h PNG into dir and returns its path. Pixel
+// content is irrelevant — everything here reads headers only.
+func writePNG(t *testing.T, dir, name string, w, h int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestScaledDims pins the rounding rule shared with upscaler.py's
+// _outscale_dims. If these drift, every non-integer scale fails
+// output validation by one pixel.
+func TestScaledDims(t *testing.T) {
+	cases := []struct {
+		w, h         int
+		scale        float64
+		wantW, wantH int
+	}{
+		{64, 64, 4, 256, 256},
+		{720, 480, 2, 1440, 960},
+		{101, 33, 1.5, 152, 50}, // 151.5 → 152, 49.5 → 50 (half up)
+		{100, 100, 3, 300, 300},
+		{1, 1, 0.1, 1, 1}, // floored at 1 px
+	}
+	for _, tc := range cases {
+		gotW, gotH := ScaledDims(tc.w, tc.h, tc.scale)
+		if gotW != tc.wantW || gotH != tc.wantH {
+			t.Errorf("ScaledDims(%d, %d, %v) = %dx%d, want %dx%d",
+				tc.w, tc.h, tc.scale, gotW, gotH, tc.wantW, tc.wantH)
+		}
+	}
+}
+
+func TestValidateScale(t *testing.T) {
+	for _, ok := range []float64{0.5, 1, 1.5, 2, 3, 4, 8, 16} {
+		if err := ValidateScale(ok); err != nil {
+			t.Errorf("ValidateScale(%v) unexpected error: %v", ok, err)
+		}
+	}
+	for _, bad := range []float64{0, -1, math.Inf(1), math.NaN()} {
+		if err := ValidateScale(bad); err == nil {
+			t.Errorf("ValidateScale(%v) should fail", bad)
+		}
+	}
+}
+
+func TestFormatScale(t *testing.T) {
+	for in, want := range map[float64]string{4: "4", 2: "2", 1.5: "1.5", 0.25: "0.25"} {
+		if got := FormatScale(in); got != want {
+			t.Errorf("FormatScale(%v) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestCheckScaled covers the three outcomes: dims match, dims wrong
+// (error names both), and an unreadable format (skipped, not failed).
+func TestCheckScaled(t *testing.T) {
+	dir := t.TempDir()
+	in, err := Probe(writePNG(t, dir, "in.png", 40, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Format != "png" || in.Width != 40 || in.Height != 30 {
+		t.Fatalf("Probe = %+v", in)
+	}
+
+	good := writePNG(t, dir, "good.png", 80, 60)
+	if err := CheckScaled(in, good, 2); err != nil {
+		t.Fatalf("2x output should validate: %v", err)
+	}
+
+	bad := writePNG(t, dir, "bad.png", 160, 120)
+	err = CheckScaled(in, bad, 2)
+	if err == nil || !strings.Contains(err.Error(), "expected 80x60") {
+		t.Fatalf("4x output at scale 2 should fail with expected dims, got %v", err)
+	}
+
+	// Not a format package image (or x/image) registers.
+	unknown := filepath.Join(dir, "out.qoi")
+	if err := os.WriteFile(unknown, []byte("qoif\x00\x00\x00\x50"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckScaled(in, unknown, 2); err != nil {
+		t.Fatalf("undecodable format should be skipped, got %v", err)
+	}
+}
+
+// TestProbe_extraFormats: BMP and TIFF come from x/image, not the
+// standard library. If the blank imports go, preflight starts
+// rejecting inputs the helper would happily read.
+func TestProbe_extraFormats(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 70, 90))
+	for name, enc := range map[string]func(*bytes.Buffer) error{
+		"bmp":  func(b *bytes.Buffer) error { return bmp.Encode(b, img) },
+		"tiff": func(b *bytes.Buffer) error { return tiff.Encode(b, img, nil) },
+	} {
+		var buf bytes.Buffer
+		if err := enc(&buf); err != nil {
+			t.Fatal(err)
+		}
+		info, err := ProbeBytes(buf.Bytes())
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if info.Format != name || info.Width != 70 || info.Height != 90 {
+			t.Errorf("%s: Probe = %+v", name, info)
+		}
+	}
+}
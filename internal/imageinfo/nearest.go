@@ -0,0 +1,40 @@
+package imageinfo
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Nearest decodes a PNG or JPEG from r and writes it to w resized by
+// nearest neighbour to ScaledDims × scale (0 = native),
+// encoded as format ("png", "jpg", with or without the dot; default
+// png).
+func Nearest(r io.Reader, w io.Writer, scale float64, format string) error {
+	if scale == 0 {
+		scale = NativeScale
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return err
+	}
+	b := src.Bounds()
+	dw, dh := ScaledDims(b.Dx(), b.Dy(), scale)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		sy := b.Min.Y + y*b.Dy()/dh
+		for x := range dw {
+			dst.Set(x, y, src.At(b.Min.X+x*b.Dx()/dw, sy))
+		}
+	}
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "jpg", "jpeg":
+		return jpeg.Encode(w, dst, nil)
+	case "", "png":
+		return png.Encode(w, dst)
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
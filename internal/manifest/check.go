@@ -0,0 +1,253 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// Severities of a Problem. Errors fail `manifest validate`; warnings
+// only do with --strict.
+const (
+	SevError   = "error"
+	SevWarning = "warning"
+)
+
+// Problem is one finding about a manifest. Entry is the index into
+// `models`, -1 for the file as a whole.
+type Problem struct {
+	Severity string `json:"severity"`
+	Entry    int    `json:"entry"`
+	Label    string `json:"label,omitempty"` // name/variant[@arch]
+	Msg      string `json:"msg"`
+}
+
+func (p Problem) String() string {
+	if p.Entry < 0 {
+		return fmt.Sprintf("%s: %s", p.Severity, p.Msg)
+	}
+	return fmt.Sprintf("%s: models[%d] (%s): %s", p.Severity, p.Entry, p.Label, p.Msg)
+}
+
+var (
+	hexSHA256 = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	smArchRe  = regexp.MustCompile(`^sm[0-9]{2,3}$`)
+	versionRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+// Check validates a manifest body without migrating it: the file is
+// judged as written. It covers what fetch-model depends on — a
+// strict schema, unique name/variant/arch keys and filenames, real
+// hashes, release URLs that end in the filename — plus the v2
+// metadata's internal consistency.
+func Check(body []byte) []Problem {
+	var ps []Problem
+	fileErr := func(sev, format string, a ...any) {
+		ps = append(ps, Problem{Severity: sev, Entry: -1, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	var m models.Manifest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		fileErr(SevError, "invalid JSON: %v", err)
+		return ps
+	}
+	switch m.Version {
+	case models.SchemaVersion:
+	case 1:
+		fileErr(SevWarning, "schema version 1; readers migrate it, but `manifest add` writes version %d", models.SchemaVersion)
+	default:
+		fileErr(SevError, "schema version %d: this build knows 1 and %d", m.Version, models.SchemaVersion)
+		return ps
+	}
+	if len(m.Models) == 0 {
+		fileErr(SevError, "models must list at least one entry")
+	}
+
+	keys := map[string]int{}
+	files := map[string]int{}
+	for i := range m.Models {
+		e := &m.Models[i]
+		for _, msg := range checkEntry(e, m.Version) {
+			ps = append(ps, Problem{Severity: msg.sev, Entry: i, Label: label(e), Msg: msg.text})
+		}
+		if j, dup := keys[key(e)]; dup {
+			ps = append(ps, Problem{Severity: SevError, Entry: i, Label: label(e),
+				Msg: fmt.Sprintf("duplicate of models[%d]: same name, variant and sm_arch", j)})
+		} else {
+			keys[key(e)] = i
+		}
+		if e.Filename == "" {
+			continue
+		}
+		if j, dup := files[e.Filename]; dup {
+			ps = append(ps, Problem{Severity: SevError, Entry: i, Label: label(e),
+				Msg: fmt.Sprintf("filename %s is also used by models[%d]", e.Filename, j)})
+		} else {
+			files[e.Filename] = i
+		}
+	}
+	return ps
+}
+
+type finding struct{ sev, text string }
+
+// checkEntry is every per-entry rule.
+func checkEntry(e *models.Model, version int) []finding {
+	var out []finding
+	bad := func(format string, a ...any) { out = append(out, finding{SevError, fmt.Sprintf(format, a...)}) }
+	warn := func(format string, a ...any) { out = append(out, finding{SevWarning, fmt.Sprintf(format, a...)}) }
+
+	for _, f := range []struct{ name, v string }{
+		{"name", e.Name}, {"variant", e.Variant}, {"filename", e.Filename},
+		{"url", e.URL}, {"sha256", e.SHA256}, {"license", e.License}, {"license_url", e.LicenseURL},
+	} {
+		if f.v == "" {
+			bad("missing required field %q", f.name)
+		}
+	}
+
+	switch e.Variant {
+	case "", models.VariantFP16, models.VariantFP32:
+		if e.SMArch != "" || e.GPUClass != "" || e.TRTVersion != "" {
+			warn("sm_arch / gpu_class / trt_version only mean something on engines")
+		}
+	case models.VariantEngine, models.VariantEngineBatched:
+		if !smArchRe.MatchString(e.SMArch) {
+			bad("engine sm_arch %q: want e.g. sm89", e.SMArch)
+		}
+		if e.TRTVersion == "" {
+			bad("engine without trt_version: nobody can tell which TensorRT loads it")
+		}
+	default:
+		bad("unknown variant %q (want fp16 | fp32 | engine | engine-batched)", e.Variant)
+	}
+
+	switch {
+	case e.Placeholder() && e.SHA256 != "":
+		bad("sha256 is a placeholder (%s) — run `manifest add` against the built file", e.SHA256)
+	case e.SHA256 != "" && !hexSHA256.MatchString(e.SHA256):
+		bad("sha256 %q is not 64 lowercase hex digits", e.SHA256)
+	}
+	if e.Bytes <= 0 && !e.Placeholder() {
+		bad("bytes must be > 0")
+	}
+
+	if e.Filename != "" {
+		if strings.ContainsAny(e.Filename, `/\`) || e.Filename == ".." {
+			bad("filename %q must be a bare file name", e.Filename)
+		}
+		want := ".onnx"
+		if e.IsEngine() {
+			want = ".engine"
+		}
+		if e.Variant != "" && filepath.Ext(e.Filename) != want {
+			bad("filename %q: a %s artefact should end in %s", e.Filename, e.Variant, want)
+		}
+	}
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		switch {
+		case err != nil || u.Host == "":
+			bad("url %q is not an absolute URL", e.URL)
+		case u.Scheme != "https":
+			bad("url %q: release URLs must be https", e.URL)
+		case e.Filename != "" && path.Base(u.Path) != e.Filename:
+			// Mirrors look artefacts up by filename (modelfetch/mirror.go).
+			bad("url %q does not end in the filename %s", e.URL, e.Filename)
+		}
+	}
+	if u, err := url.Parse(e.LicenseURL); e.LicenseURL != "" && (err != nil || u.Host == "" || !strings.HasPrefix(u.Scheme, "http")) {
+		bad("license_url %q is not an http(s) URL", e.LicenseURL)
+	}
+
+	if version < models.SchemaVersion {
+		return out // v1: no metadata to check
+	}
+	if e.Scale <= 0 {
+		bad("scale must be > 0 (the model's native factor)")
+	}
+	switch e.Content {
+	case "", models.ContentGeneral, models.ContentPhoto, models.ContentAnime:
+	default:
+		bad("content %q: want general | photo | anime", e.Content)
+	}
+	if in := e.Input; in != (models.InputLimits{}) &&
+		(in.MinDim <= 0 || in.MinDim > in.MaxUntiledDim || in.MaxUntiledDim > in.MaxTiledDim) {
+		bad("input limits %d / %d / %d: want 0 < min_dim ≤ max_untiled_dim ≤ max_tiled_dim",
+			in.MinDim, in.MaxUntiledDim, in.MaxTiledDim)
+	}
+	if b := e.Batch; b != nil {
+		if !e.IsEngine() {
+			warn("batch profile on a non-engine artefact is ignored")
+		}
+		if b.Min < 1 || b.Min > b.Opt || b.Opt > b.Max || b.OptDim <= 0 || b.OptDim > b.MaxDim {
+			bad("batch profile %+v: want 1 ≤ min ≤ opt ≤ max and 0 < opt_dim ≤ max_dim", *b)
+		}
+	}
+	for _, f := range []struct{ name, v string }{{"min_onnxruntime", e.MinORT}, {"min_cuda", e.MinCUDA}} {
+		if f.v != "" && !versionRe.MatchString(f.v) {
+			bad("%s %q is not a dotted version", f.name, f.v)
+		}
+	}
+	return out
+}
+
+// CheckDist compares entries against built artefacts in dir, as
+// build/update_manifest.py --check does: a file whose hash or size
+// differs from its entry is drift, and a built .onnx/.engine with no
+// entry would never be fetched.
+func CheckDist(m *models.Manifest, dir string) ([]Problem, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ps []Problem
+	listed := map[string]bool{}
+	for i := range m.Models {
+		e := &m.Models[i]
+		listed[e.Filename] = true
+		p := filepath.Join(dir, e.Filename)
+		info, err := os.Stat(p)
+		if err != nil {
+			continue // not built on this host
+		}
+		sum, err := models.HashFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if sum != e.SHA256 || info.Size() != e.Bytes {
+			ps = append(ps, Problem{Severity: SevError, Entry: i, Label: label(e), Msg: fmt.Sprintf(
+				"drift: %s is sha256 %s, %d bytes; the entry says %s, %d bytes", p, sum, info.Size(), e.SHA256, e.Bytes)})
+		}
+	}
+	for _, de := range des {
+		ext := filepath.Ext(de.Name())
+		if de.Type().IsRegular() && (ext == ".onnx" || ext == ".engine") && !listed[de.Name()] {
+			ps = append(ps, Problem{Severity: SevError, Entry: -1,
+				Msg: fmt.Sprintf("%s has no manifest entry (add it with `manifest add`)", filepath.Join(dir, de.Name()))})
+		}
+	}
+	return ps, nil
+}
+
+// key identifies an entry the way Find does: engines per SM arch.
+func key(e *models.Model) string {
+	return e.Name + "/" + e.Variant + "@" + e.SMArch
+}
+
+func label(e *models.Model) string {
+	if e.SMArch != "" {
+		return e.Name + "/" + e.Variant + "@" + e.SMArch
+	}
+	return e.Name + "/" + e.Variant
+}
@@ -0,0 +1,113 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// Delta is what changed between two manifests. Both sides are
+// migrated first, so a v1 → v2 upgrade alone shows no entry changes.
+type Delta struct {
+	OldVersion int      `json:"old_version"`
+	NewVersion int      `json:"new_version"`
+	Changes    []Change `json:"changes"`
+}
+
+// Change is one entry added, removed or edited, keyed like Check's
+// duplicate detection (name, variant, sm_arch).
+type Change struct {
+	Kind   string        `json:"kind"` // added | removed | changed
+	Label  string        `json:"label"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is one JSON field of an entry, before and after.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// Empty reports whether the manifests are equivalent.
+func (d Delta) Empty() bool { return len(d.Changes) == 0 }
+
+// Diff compares old and new entry by entry: removed and changed ones
+// in old's order, then additions in new's.
+func Diff(oldM, newM *models.Manifest, oldVersion, newVersion int) Delta {
+	d := Delta{OldVersion: oldVersion, NewVersion: newVersion, Changes: []Change{}}
+	newByKey := map[string]*models.Model{}
+	for i := range newM.Models {
+		newByKey[key(&newM.Models[i])] = &newM.Models[i]
+	}
+	seen := map[string]bool{}
+	for i := range oldM.Models {
+		o := &oldM.Models[i]
+		k := key(o)
+		seen[k] = true
+		n, ok := newByKey[k]
+		if !ok {
+			d.Changes = append(d.Changes, Change{Kind: "removed", Label: label(o)})
+			continue
+		}
+		if fields := diffFields(o, n); len(fields) > 0 {
+			d.Changes = append(d.Changes, Change{Kind: "changed", Label: label(o), Fields: fields})
+		}
+	}
+	for i := range newM.Models {
+		if n := &newM.Models[i]; !seen[key(n)] {
+			d.Changes = append(d.Changes, Change{Kind: "added", Label: label(n)})
+		}
+	}
+	return d
+}
+
+// diffFields compares two entries field by field, by their JSON form
+// so every field is covered without listing them here.
+func diffFields(o, n *models.Model) []FieldChange {
+	om, nm := asMap(o), asMap(n)
+	names := map[string]bool{}
+	for k := range om {
+		names[k] = true
+	}
+	for k := range nm {
+		names[k] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var out []FieldChange
+	for _, k := range sorted {
+		if !reflect.DeepEqual(om[k], nm[k]) {
+			out = append(out, FieldChange{Field: k, Old: om[k], New: nm[k]})
+		}
+	}
+	return out
+}
+
+func asMap(e *models.Model) map[string]any {
+	b, _ := json.Marshal(e)
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+// render is a FieldChange value for the text diff.
+func render(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "(unset)"
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(int64(v)) // every number in an entry is an integer
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,425 @@
+// Package manifest implements the `manifest` command group: the
+// maintainer-side tooling for models/MANIFEST.json.
+//
+//	manifest validate [file]        schema, keys, hashes, URLs, signature
+//	manifest diff <old> <new>       entries added, removed and changed
+//	manifest add --file f --url u   hash a built artefact into the file
+//
+// validate with --dist does what build/update_manifest.py --check does
+// (hash drift, unlisted artefacts) and adds the structural rules
+// fetch-model relies on but nothing enforced until a download failed.
+// Exit codes follow internal/errs: 4 (integrity) when validation
+// fails, 1 when `diff --exit-code` finds a difference.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// DefaultPath is the manifest the subcommands work on when not told.
+const DefaultPath = "models/MANIFEST.json"
+
+// Command returns the Cobra command tree for `manifest`.
+func Command() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "manifest",
+		Short: "Validate, diff and update models/MANIFEST.json",
+		Long: `Maintainer tooling for the model manifest. validate is what CI
+runs; add is how a freshly built artefact gets its sha256 and size
+into the file. Editing the manifest invalidates its signature — re-sign
+with ` + "`make manifest-sign`" + ` before committing.`,
+	}
+	cmd.PersistentFlags().BoolVar(&asJSON, "json", false, "Print one JSON document on stdout")
+	cmd.AddCommand(validateCommand(&asJSON), diffCommand(&asJSON), addCommand())
+	return cmd
+}
+
+// printJSON writes v as the command's single JSON document.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// read returns a manifest file's bytes, classifying a missing file as
+// the caller's mistake.
+func read(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errs.New(errs.User, "manifest %s does not exist", path)
+	}
+	if err != nil {
+		return nil, errs.Wrap(errs.Environment, err)
+	}
+	return b, nil
+}
+
+// load reads and parses (migrating) a manifest for diff and add.
+func load(path string) (*models.Manifest, int, error) {
+	b, err := read(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var head struct {
+		Version int `json:"version"`
+	}
+	_ = json.Unmarshal(b, &head)
+	m, err := models.Parse(b)
+	if err != nil {
+		return nil, 0, errs.Wrap(errs.Integrity, fmt.Errorf("%s: %w", path, err))
+	}
+	return m, head.Version, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// validate
+// ─────────────────────────────────────────────────────────────────────
+
+func validateCommand(asJSON *bool) *cobra.Command {
+	var dist string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a manifest's schema, keys, hashes, URLs and signature",
+		Long: `Check a manifest (default ` + DefaultPath + `):
+
+  - strict schema: unknown fields and unknown versions are errors
+  - no two entries share name/variant/sm_arch, or a filename
+  - no REPLACE_ placeholder hashes; sha256 is 64 hex, bytes > 0
+  - url is https and ends in the filename; license_url is http(s)
+  - engines carry sm_arch and trt_version; v2 metadata is consistent
+
+With --dist, built artefacts in that directory are hashed and compared
+with their entries, as build/update_manifest.py --check does. A
+missing or invalid <file>.sig is a warning: validation is about the
+content, the signature is re-made on release.
+
+Exits 4 if there are errors (or, with --strict, warnings).`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := DefaultPath
+			if len(args) == 1 {
+				path = args[0]
+			}
+			body, err := read(path)
+			if err != nil {
+				return err
+			}
+			ps := Check(body)
+			keyID, sigErr := signature(path, body)
+			if sigErr != nil {
+				ps = append(ps, Problem{Severity: SevWarning, Entry: -1, Msg: "signature: " + sigErr.Error()})
+			}
+			if dist != "" && !hasErrors(ps) {
+				m, err := models.Parse(body)
+				if err != nil {
+					return errs.Wrap(errs.Integrity, err)
+				}
+				drift, err := CheckDist(m, dist)
+				if err != nil {
+					return errs.Wrap(errs.Environment, fmt.Errorf("--dist: %w", err))
+				}
+				ps = append(ps, drift...)
+			}
+
+			nErr, nWarn := count(ps)
+			if *asJSON {
+				if ps == nil {
+					ps = []Problem{}
+				}
+				if err := printJSON(map[string]any{
+					"file": path, "signed": sigErr == nil, "key_id": keyID,
+					"errors": nErr, "warnings": nWarn, "problems": ps,
+				}); err != nil {
+					return err
+				}
+			} else {
+				for _, p := range ps {
+					fmt.Println(p)
+				}
+			}
+			if nErr > 0 || (strict && nWarn > 0) {
+				return errs.New(errs.Integrity, "manifest %s: %d error(s), %d warning(s)", path, nErr, nWarn)
+			}
+			if !*asJSON {
+				fmt.Fprintf(os.Stderr, "manifest %s: ok (%d warning(s))\n", path, nWarn)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dist, "dist", "", "Also compare entries with the built artefacts in this directory")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail on warnings too")
+	return cmd
+}
+
+// signature verifies path's detached .sig against body.
+func signature(path string, body []byte) (string, error) {
+	sig, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		return "", fmt.Errorf("no %s.sig (unsigned)", filepath.Base(path))
+	}
+	return modelfetch.VerifySignature(body, sig)
+}
+
+func count(ps []Problem) (nErr, nWarn int) {
+	for _, p := range ps {
+		if p.Severity == SevError {
+			nErr++
+		} else {
+			nWarn++
+		}
+	}
+	return nErr, nWarn
+}
+
+func hasErrors(ps []Problem) bool {
+	n, _ := count(ps)
+	return n > 0
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// diff
+// ─────────────────────────────────────────────────────────────────────
+
+func diffCommand(asJSON *bool) *cobra.Command {
+	var exitCode bool
+	cmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Show entries added, removed and changed between two manifests",
+		Long: `Compare two manifests entry by entry, keyed by name, variant and
+sm_arch. Both are migrated to the current schema first, so upgrading a
+v1 file is not itself a change. With --exit-code, exits 1 when they
+differ (like git diff --exit-code).`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			oldM, oldV, err := load(args[0])
+			if err != nil {
+				return err
+			}
+			newM, newV, err := load(args[1])
+			if err != nil {
+				return err
+			}
+			d := Diff(oldM, newM, oldV, newV)
+			if *asJSON {
+				if err := printJSON(d); err != nil {
+					return err
+				}
+			} else {
+				if oldV != newV {
+					fmt.Printf("~ version %d → %d\n", oldV, newV)
+				}
+				for _, c := range d.Changes {
+					switch c.Kind {
+					case "added":
+						fmt.Printf("+ %s\n", c.Label)
+					case "removed":
+						fmt.Printf("- %s\n", c.Label)
+					default:
+						fmt.Printf("~ %s\n", c.Label)
+						for _, f := range c.Fields {
+							fmt.Printf("    %s: %s → %s\n", f.Field, render(f.Old), render(f.New))
+						}
+					}
+				}
+			}
+			if exitCode && !d.Empty() {
+				return errs.New(errs.User, "manifests differ")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "Exit 1 when the manifests differ")
+	return cmd
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// add
+// ─────────────────────────────────────────────────────────────────────
+
+type addOpts struct {
+	manifest, file, url string
+	entry               models.Model
+}
+
+func addCommand() *cobra.Command {
+	o := &addOpts{}
+	cmd := &cobra.Command{
+		Use:   "add --file <artefact> [--url <release url>]",
+		Short: "Hash a built artefact and add or update its manifest entry",
+		Long: `Compute the SHA-256 and size of --file and write them into the
+manifest. An entry whose filename matches is updated in place (and its
+url, if --url is given); otherwise a new entry is appended, which needs
+--name, --variant and --url (and --sm-arch, --trt-version for engines).
+License, scale, content and input limits default to another entry of
+the same name.
+
+The result is validated before it is written; the file's formatting is
+kept, so the commit diff shows only the changed entry. Re-sign
+afterwards with ` + "`make manifest-sign`" + `.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&o.manifest, "manifest", DefaultPath, "Manifest to update")
+	f.StringVar(&o.file, "file", "", "Built artefact (.onnx or .engine) to hash")
+	f.StringVar(&o.url, "url", "", "Release download URL (must end in the file name)")
+	f.StringVar(&o.entry.Name, "name", "", "Model name, e.g. realesrgan-x4plus")
+	f.StringVar(&o.entry.Variant, "variant", "", "fp16 | fp32 | engine | engine-batched")
+	f.StringVar(&o.entry.SMArch, "sm-arch", "", "Engine SM arch, e.g. sm89")
+	f.StringVar(&o.entry.GPUClass, "gpu-class", "", "Engine GPU class, e.g. l40s")
+	f.StringVar(&o.entry.TRTVersion, "trt-version", "", "TensorRT version the engine was built with")
+	f.StringVar(&o.entry.License, "license", "", "SPDX licence (default: from a same-name entry)")
+	f.StringVar(&o.entry.LicenseURL, "license-url", "", "Licence URL (default: from a same-name entry)")
+	f.StringVar(&o.entry.Notes, "notes", "", "Free-text notes")
+	f.IntVar(&o.entry.Scale, "scale", 0, "Native scale factor (default: from a same-name entry, else 4)")
+	f.StringVar(&o.entry.Content, "content", "", "general | photo | anime (default: from a same-name entry)")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func (o *addOpts) run(cmd *cobra.Command) error {
+	m, _, err := load(o.manifest)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(o.file)
+	if err != nil {
+		return errs.New(errs.User, "--file: %v", err)
+	}
+	sum, err := models.HashFile(o.file)
+	if err != nil {
+		return errs.Wrap(errs.Environment, err)
+	}
+	verb, e, err := o.apply(m, cmd, filepath.Base(o.file), sum, info.Size())
+	if err != nil {
+		return err
+	}
+
+	body, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, p := range Check(body) {
+		if p.Severity == SevError {
+			problems = append(problems, p.String())
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		return errs.New(errs.User, "not writing %s: the result would not validate", o.manifest)
+	}
+	if err := writeFile(o.manifest, body); err != nil {
+		return errs.Wrap(errs.Environment, err)
+	}
+	fmt.Printf("%s %s: sha256 %s, %d bytes\n", verb, label(e), sum, info.Size())
+	fmt.Fprintf(os.Stderr, "%s changed; re-sign it with `make manifest-sign`\n", o.manifest)
+	return nil
+}
+
+// apply updates the entry for filename in m, or appends one, and
+// returns which it did.
+func (o *addOpts) apply(m *models.Manifest, cmd *cobra.Command, filename, sum string, size int64) (string, *models.Model, error) {
+	set := func(name string) bool { return cmd.Flags().Changed(name) }
+	for i := range m.Models {
+		e := &m.Models[i]
+		if e.Filename != filename {
+			continue
+		}
+		e.SHA256, e.Bytes = sum, size
+		if o.url != "" {
+			e.URL = o.url
+		}
+		if set("notes") {
+			e.Notes = o.entry.Notes
+		}
+		return "updated", e, nil
+	}
+
+	e := o.entry
+	if e.Name == "" || e.Variant == "" || o.url == "" {
+		return "", nil, errs.New(errs.User, "%s is not in %s: a new entry needs --name, --variant and --url", filename, o.manifest)
+	}
+	e.Filename, e.URL, e.SHA256, e.Bytes = filename, o.url, sum, size
+	if sib := sibling(m, e.Name); sib != nil {
+		if e.License == "" {
+			e.License = sib.License
+		}
+		if e.LicenseURL == "" {
+			e.LicenseURL = sib.LicenseURL
+		}
+		if e.Scale == 0 {
+			e.Scale = sib.Scale
+		}
+		if e.Content == "" {
+			e.Content = sib.Content
+		}
+		e.Input = sib.Input
+	}
+	if e.Scale == 0 {
+		e.Scale = 4
+	}
+	if e.Content == "" {
+		e.Content = models.ContentGeneral
+	}
+	m.Models = append(m.Models, e)
+	return "added", &m.Models[len(m.Models)-1], nil
+}
+
+func sibling(m *models.Manifest, name string) *models.Model {
+	for i := range m.Models {
+		if m.Models[i].Name == name {
+			return &m.Models[i]
+		}
+	}
+	return nil
+}
+
+// Encode renders m the way models/MANIFEST.json is written: two-space
+// indent, no HTML escaping, trailing newline. Parsing the committed
+// file and encoding it again gives the same bytes.
+func Encode(m *models.Manifest) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFile replaces path atomically so a failed write never leaves
+// a half manifest behind.
+func writeFile(path string, body []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
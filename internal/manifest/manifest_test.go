@@ -0,0 +1,290 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+const sumA = "e662cfd8c4280d1247b0bf248a09b9a410b5ee231e1c1b24374f03546d53ae6b"
+
+// fixture: one ONNX entry and one engine, both valid under v2.
+func newManifest() *models.Manifest {
+	in := models.InputLimits{MinDim: 64, MaxUntiledDim: 1280, MaxTiledDim: 4096}
+	return &models.Manifest{
+		Version: models.SchemaVersion,
+		Models: []models.Model{
+			{Name: "x4", Variant: "fp16", Filename: "x4_fp16.onnx",
+				URL: "https://example.test/v1/x4_fp16.onnx", SHA256: sumA, Bytes: 100,
+				License: "BSD-3-Clause", LicenseURL: "https://example.test/LICENSE",
+				Scale: 4, Content: "general", Input: in},
+			{Name: "x4", Variant: "engine", SMArch: "sm89", GPUClass: "l40s", TRTVersion: "10.8",
+				Filename: "x4-sm89.engine", URL: "https://example.test/v1/x4-sm89.engine",
+				SHA256: strings.Repeat("b", 64), Bytes: 200,
+				License: "BSD-3-Clause", LicenseURL: "https://example.test/LICENSE",
+				Scale: 4, Content: "general", Input: in,
+				Batch: &models.BatchProfile{Min: 1, Opt: 1, Max: 1, OptDim: 720, MaxDim: 1280}},
+		},
+	}
+}
+
+func encode(t *testing.T, m *models.Manifest) []byte {
+	t.Helper()
+	b, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestCheck applies one mutation of the valid fixture per rule and
+// looks for the finding that rule reports.
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		mutate  func(m *models.Manifest)
+		raw     string // used instead of the fixture when set
+		wantSev string // "" = no problems
+		want    string
+	}{
+		{name: "valid", mutate: func(*models.Manifest) {}},
+		{name: "placeholder hash", mutate: func(m *models.Manifest) { m.Models[0].SHA256 = "REPLACE_AFTER_BUILD" },
+			wantSev: SevError, want: "placeholder"},
+		{name: "short hash", mutate: func(m *models.Manifest) { m.Models[0].SHA256 = "abc" },
+			wantSev: SevError, want: "64 lowercase hex"},
+		{name: "duplicate key", mutate: func(m *models.Manifest) {
+			d := m.Models[1]
+			d.Filename, d.URL = "other.engine", "https://example.test/v1/other.engine"
+			m.Models = append(m.Models, d)
+		}, wantSev: SevError, want: "duplicate of models[1]"},
+		{name: "duplicate filename", mutate: func(m *models.Manifest) {
+			d := m.Models[1]
+			d.SMArch = "sm86"
+			m.Models = append(m.Models, d)
+		}, wantSev: SevError, want: "also used by models[1]"},
+		{name: "http url", mutate: func(m *models.Manifest) { m.Models[0].URL = "http://example.test/v1/x4_fp16.onnx" },
+			wantSev: SevError, want: "must be https"},
+		{name: "url not ending in filename", mutate: func(m *models.Manifest) { m.Models[0].URL = "https://example.test/v1/latest" },
+			wantSev: SevError, want: "does not end in the filename"},
+		{name: "relative url", mutate: func(m *models.Manifest) { m.Models[0].URL = "x4_fp16.onnx" },
+			wantSev: SevError, want: "not an absolute URL"},
+		{name: "missing license", mutate: func(m *models.Manifest) { m.Models[0].License = "" },
+			wantSev: SevError, want: `"license"`},
+		{name: "unknown variant", mutate: func(m *models.Manifest) { m.Models[0].Variant = "int8" },
+			wantSev: SevError, want: "unknown variant"},
+		{name: "engine without arch", mutate: func(m *models.Manifest) { m.Models[1].SMArch = "" },
+			wantSev: SevError, want: "sm_arch"},
+		{name: "engine without trt", mutate: func(m *models.Manifest) { m.Models[1].TRTVersion = "" },
+			wantSev: SevError, want: "trt_version"},
+		{name: "wrong extension", mutate: func(m *models.Manifest) {
+			m.Models[0].Filename, m.Models[0].URL = "x4_fp16.engine", "https://example.test/v1/x4_fp16.engine"
+		}, wantSev: SevError, want: "should end in .onnx"},
+		{name: "path in filename", mutate: func(m *models.Manifest) {
+			m.Models[0].Filename = "../x4_fp16.onnx"
+		}, wantSev: SevError, want: "bare file name"},
+		{name: "zero scale", mutate: func(m *models.Manifest) { m.Models[0].Scale = 0 },
+			wantSev: SevError, want: "scale"},
+		{name: "input order", mutate: func(m *models.Manifest) { m.Models[0].Input.MaxUntiledDim = 8192 },
+			wantSev: SevError, want: "input limits"},
+		{name: "batch order", mutate: func(m *models.Manifest) { m.Models[1].Batch.Opt = 8 },
+			wantSev: SevError, want: "batch profile"},
+		{name: "batch on onnx", mutate: func(m *models.Manifest) { m.Models[0].Batch = m.Models[1].Batch },
+			wantSev: SevWarning, want: "ignored"},
+		{name: "arch on onnx", mutate: func(m *models.Manifest) { m.Models[0].SMArch = "sm89" },
+			wantSev: SevWarning, want: "only mean something on engines"},
+		{name: "bad version string", mutate: func(m *models.Manifest) { m.Models[0].MinORT = "1.20-rc" },
+			wantSev: SevError, want: "dotted version"},
+		{name: "unknown field", raw: `{"version":2,"models":[],"extra":1}`,
+			wantSev: SevError, want: "unknown field"},
+		{name: "unknown schema", raw: `{"version":9,"models":[]}`,
+			wantSev: SevError, want: "schema version 9"},
+		{name: "v1 file", raw: `{"version":1,"models":[{"name":"x4","variant":"fp16","filename":"x4_fp16.onnx",` +
+			`"url":"https://example.test/v1/x4_fp16.onnx","sha256":"` + sumA + `","bytes":1,` +
+			`"license":"MIT","license_url":"https://example.test/LICENSE"}]}`,
+			wantSev: SevWarning, want: "schema version 1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := []byte(tc.raw)
+			if tc.raw == "" {
+				m := newManifest()
+				tc.mutate(m)
+				body = encode(t, m)
+			}
+			ps := Check(body)
+			if tc.wantSev == "" {
+				if len(ps) != 0 {
+					t.Fatalf("want no problems, got %v", ps)
+				}
+				return
+			}
+			for _, p := range ps {
+				if p.Severity == tc.wantSev && strings.Contains(p.Msg, tc.want) {
+					return
+				}
+			}
+			t.Fatalf("want %s containing %q, got %v", tc.wantSev, tc.want, ps)
+		})
+	}
+}
+
+func TestCheckDist(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte("onnx bytes")
+	sum := sha256.Sum256(body)
+	if err := os.WriteFile(filepath.Join(dir, "x4_fp16.onnx"), body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := newManifest()
+	m.Models[0].SHA256, m.Models[0].Bytes = hex.EncodeToString(sum[:]), int64(len(body))
+
+	ps, err := CheckDist(m, dir)
+	if err != nil || len(ps) != 0 {
+		t.Fatalf("in sync: got %v, %v", ps, err)
+	}
+
+	// Rebuilt artefact, stale entry; and a new artefact nobody listed.
+	os.WriteFile(filepath.Join(dir, "x4_fp16.onnx"), []byte("rebuilt"), 0o644)
+	os.WriteFile(filepath.Join(dir, "x2_fp16.onnx"), []byte("new"), 0o644)
+	ps, err = CheckDist(m, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || !strings.Contains(ps[0].Msg, "drift") || !strings.Contains(ps[1].Msg, "x2_fp16.onnx has no manifest entry") {
+		t.Fatalf("got %v", ps)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	oldM, newM := newManifest(), newManifest()
+	newM.Models[0].SHA256, newM.Models[0].Bytes = strings.Repeat("c", 64), 101
+	newM.Models = newM.Models[:1]
+	newM.Models = append(newM.Models, models.Model{Name: "x2", Variant: "fp16"})
+
+	d := Diff(oldM, newM, 2, 2)
+	var got []string
+	for _, c := range d.Changes {
+		s := c.Kind + " " + c.Label
+		for _, f := range c.Fields {
+			s += " " + f.Field
+		}
+		got = append(got, s)
+	}
+	want := []string{"changed x4/fp16 bytes sha256", "removed x4/engine@sm89", "added x2/fp16"}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if !Diff(oldM, newManifest(), 1, 2).Empty() {
+		t.Error("identical entries should diff empty regardless of version")
+	}
+}
+
+// TestEncode_roundTrip pins that `manifest add` rewrites the committed
+// manifest byte for byte, so its diffs show only the edited entry.
+func TestEncode_roundTrip(t *testing.T) {
+	b, err := os.ReadFile("../../models/MANIFEST.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := models.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := encode(t, m); !bytes.Equal(out, b) {
+		t.Fatal("parse + Encode does not reproduce models/MANIFEST.json")
+	}
+	if ps := Check(b); len(ps) != 0 {
+		t.Fatalf("committed manifest has problems: %v", ps)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	dir := t.TempDir()
+	mpath := filepath.Join(dir, "MANIFEST.json")
+	if err := os.WriteFile(mpath, encode(t, newManifest()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	art := filepath.Join(dir, "x4-sm120.engine")
+	os.WriteFile(art, []byte("engine"), 0o644)
+	sum := sha256.Sum256([]byte("engine"))
+
+	run := func(args ...string) error {
+		cmd := Command()
+		cmd.SetArgs(append([]string{"add", "--manifest", mpath}, args...))
+		return cmd.Execute()
+	}
+
+	// New entry: licence, scale, content and input come from x4/fp16.
+	if err := run("--file", art, "--url", "https://example.test/v1/x4-sm120.engine",
+		"--name", "x4", "--variant", "engine", "--sm-arch", "sm120", "--trt-version", "10.8"); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := load(mpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := m.Models[2]
+	if e.SHA256 != hex.EncodeToString(sum[:]) || e.Bytes != 6 || e.License != "BSD-3-Clause" || e.Scale != 4 || e.Input.MaxTiledDim != 4096 {
+		t.Fatalf("added entry: %+v", e)
+	}
+
+	// Same filename again: updated in place, not appended.
+	os.WriteFile(art, []byte("engine v2"), 0o644)
+	if err := run("--file", art); err != nil {
+		t.Fatal(err)
+	}
+	if m, _, _ = load(mpath); len(m.Models) != 3 || m.Models[2].Bytes != 9 {
+		t.Fatalf("update: %d entries, %+v", len(m.Models), m.Models[2])
+	}
+
+	// A new entry that would not validate is refused and nothing written.
+	before, _ := os.ReadFile(mpath)
+	bad := filepath.Join(dir, "x4-sm75.engine")
+	os.WriteFile(bad, []byte("x"), 0o644)
+	err = run("--file", bad, "--url", "https://example.test/v1/x4-sm75.engine", "--name", "x4", "--variant", "engine")
+	if !errs.Is(err, errs.User) {
+		t.Fatalf("want a user error, got %v", err)
+	}
+	if after, _ := os.ReadFile(mpath); !bytes.Equal(before, after) {
+		t.Error("manifest was rewritten despite the failed check")
+	}
+
+	if err := run("--file", filepath.Join(dir, "missing.onnx")); !errs.Is(err, errs.User) {
+		t.Fatalf("missing file: want a user error, got %v", err)
+	}
+}
+
+func TestValidate_exitCategory(t *testing.T) {
+	dir := t.TempDir()
+	m := newManifest()
+	m.Models[0].SHA256 = "REPLACE_AFTER_BUILD"
+	p := filepath.Join(dir, "MANIFEST.json")
+	os.WriteFile(p, encode(t, m), 0o644)
+
+	cmd := Command()
+	cmd.SetArgs([]string{"validate", p})
+	err := cmd.Execute()
+	if !errs.Is(err, errs.Integrity) {
+		t.Fatalf("want an integrity error (exit 4), got %v", err)
+	}
+
+	// Unsigned is only a warning, fatal under --strict.
+	os.WriteFile(p, encode(t, newManifest()), 0o644)
+	cmd = Command()
+	cmd.SetArgs([]string{"validate", p})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	cmd = Command()
+	cmd.SetArgs([]string{"validate", "--strict", p})
+	if err := cmd.Execute(); !errs.Is(err, errs.Integrity) {
+		t.Fatalf("--strict: want an integrity error, got %v", err)
+	}
+}
@@ -0,0 +1,594 @@
+// Package modelcache implements the `models` command group: inspect
+// and tidy the model cache that `fetch-model` fills.
+//
+//	models list            manifest entries, cached or not
+//	models info <name>     full manifest entries + licence
+//	models verify          re-hash every cached file
+//	models prune           delete what the manifest no longer lists
+//	models rm <name|file>  delete one artefact
+//
+// Every subcommand takes --json and then prints one JSON document on
+// stdout (not a JSON-events stream) for iosuite to parse. The library
+// side — types, cache dir, hashing — is internal/models; this package
+// is only the CLI over it.
+package modelcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/spf13/cobra"
+)
+
+type opts struct {
+	dest          string
+	manifest      string
+	allowUnsigned bool
+	json          bool
+}
+
+// Command returns the Cobra command tree for `models`.
+func Command() *cobra.Command {
+	o := &opts{}
+	cmd := &cobra.Command{
+		Use:   "models",
+		Short: "Inspect and manage the local model cache",
+		Long: `List, inspect, verify and clean up model artefacts in the cache
+fetch-model writes to ($XDG_CACHE_HOME/real-esrgan-serve/models, or
+~/.cache/real-esrgan-serve/models). Pass --json for machine-readable
+output.`,
+	}
+
+	f := cmd.PersistentFlags()
+	f.StringVar(&o.dest, "dest", "", "Cache directory (default: XDG cache dir, as fetch-model)")
+	f.StringVar(&o.manifest, "manifest", "", "Override manifest path (default: built-in / repo-relative)")
+	f.BoolVar(&o.allowUnsigned, "allow-unsigned-manifest", false, "Accept a manifest without a valid signature — dev only")
+	f.BoolVar(&o.json, "json", false, "Print one JSON document on stdout instead of a table")
+
+	cmd.AddCommand(listCommand(o), infoCommand(o), verifyCommand(o), pruneCommand(o), rmCommand(o))
+	return cmd
+}
+
+// load returns the manifest and the cache directory every subcommand
+// works against.
+func (o *opts) load() (*models.Manifest, string, error) {
+	mf, _, err := modelfetch.LoadManifest(o.manifest, o.allowUnsigned)
+	if err != nil {
+		return nil, "", errs.Wrap(errs.Environment, fmt.Errorf("manifest: %w", err))
+	}
+	dir, err := models.CacheDir(o.dest)
+	if err != nil {
+		return nil, "", errs.Wrap(errs.Environment, err)
+	}
+	return mf, dir, nil
+}
+
+// printJSON writes v as the command's single JSON document.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func table() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// list / info
+// ─────────────────────────────────────────────────────────────────────
+
+// entry is a manifest entry plus where it stands in the cache.
+type entry struct {
+	models.Model
+	Cached bool   `json:"cached"`
+	Path   string `json:"path,omitempty"`
+}
+
+func entries(mf *models.Manifest, dir string) []entry {
+	out := make([]entry, 0, len(mf.Models))
+	for _, m := range mf.Models {
+		e := entry{Model: m}
+		p := filepath.Join(dir, m.Filename)
+		if _, err := os.Stat(p); err == nil {
+			e.Cached, e.Path = true, p
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func listCommand(o *opts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List manifest entries and whether each is cached",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mf, dir, err := o.load()
+			if err != nil {
+				return err
+			}
+			es := entries(mf, dir)
+			if o.json {
+				return printJSON(map[string]any{"cache_dir": dir, "models": es})
+			}
+			tw := table()
+			fmt.Fprintln(tw, "\tNAME\tVARIANT\tSM ARCH\tSIZE\tLICENSE")
+			for _, e := range es {
+				mark := " "
+				if e.Cached {
+					mark = "✓"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					mark, e.Name, e.Variant, dash(e.SMArch), modelfetch.Human(e.Bytes), e.License)
+			}
+			tw.Flush()
+			fmt.Fprintf(os.Stderr, "cache: %s (✓ = cached; `models verify` checks hashes)\n", dir)
+			return nil
+		},
+	}
+}
+
+func infoCommand(o *opts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "info <name>",
+		Short: "Show every manifest field for a model, licence URL included",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mf, dir, err := o.load()
+			if err != nil {
+				return err
+			}
+			var es []entry
+			for _, e := range entries(mf, dir) {
+				if e.Name == args[0] {
+					es = append(es, e)
+				}
+			}
+			if len(es) == 0 {
+				return errs.New(errs.User, "no model named %q in the manifest (see `models list`)", args[0])
+			}
+			if o.json {
+				return printJSON(map[string]any{"name": args[0], "variants": es})
+			}
+			for i, e := range es {
+				if i > 0 {
+					fmt.Println()
+				}
+				tw := table()
+				row := func(k, v string) {
+					if v != "" {
+						fmt.Fprintf(tw, "%s:\t%s\n", k, v)
+					}
+				}
+				row("name", e.Name)
+				row("variant", e.Variant)
+				row("gpu class", e.GPUClass)
+				row("sm arch", e.SMArch)
+				row("tensorrt", e.TRTVersion)
+				row("scale", fmt.Sprintf("%dx", e.Scale))
+				row("content", e.Content)
+				row("input", fmt.Sprintf("%d–%d px per side (tiled above %d)", e.Input.MinDim, e.Input.MaxTiledDim, e.Input.MaxUntiledDim))
+				if b := e.Batch; b != nil {
+					row("batch", fmt.Sprintf("%d–%d (opt %d×%dpx, max %dpx)", b.Min, b.Max, b.Opt, b.OptDim, b.MaxDim))
+				}
+				row("onnxruntime", atLeast(e.MinORT))
+				row("cuda", atLeast(e.MinCUDA))
+				row("filename", e.Filename)
+				row("url", e.URL)
+				row("sha256", e.SHA256)
+				row("size", fmt.Sprintf("%s (%d bytes)", modelfetch.Human(e.Bytes), e.Bytes))
+				row("license", e.License)
+				row("license url", e.LicenseURL)
+				row("notes", e.Notes)
+				if e.Cached {
+					row("cached", e.Path)
+				} else {
+					row("cached", "no")
+				}
+				tw.Flush()
+			}
+			return nil
+		},
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// verify
+// ─────────────────────────────────────────────────────────────────────
+
+// cacheFile is one artefact in the cache dir, or the download of one
+// that never finished.
+type cacheFile struct {
+	Filename string        `json:"filename"`
+	Path     string        `json:"path"`
+	Bytes    int64         `json:"bytes"`
+	ModTime  time.Time     `json:"mtime"`
+	Model    *models.Model `json:"-"` // nil = not in the manifest
+	// Partial: an interrupted fetch (`<file>.part`, `.part.meta`) with
+	// no finished artefact beside it. Filename and Path name the .part.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// artefact is the path of the artefact f is, or is the download of;
+// the fetch lock is taken on it.
+func (f cacheFile) artefact() string {
+	return strings.TrimSuffix(f.Path, partSuffix)
+}
+
+// sidecars are the files fetch-model and the resolver keep next to an
+// artefact. They are never reported on their own; rm and prune take
+// them along with their artefact. The `.lock` file is not one: only
+// the lock's holder removes it.
+var sidecars = []string{models.StampSuffix, partSuffix, partSuffix + ".meta"}
+
+// partSuffix marks a download in progress, or interrupted.
+const partSuffix = ".part"
+
+// isArtefact reports whether name is a file fetch-model writes. The
+// cache dir may hold anything else; it is none of our business.
+func isArtefact(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".onnx" || ext == ".engine"
+}
+
+// scan lists the artefacts in dir, matched against the manifest by
+// filename, then the orphaned partial downloads. A .part beside its
+// finished artefact is that artefact's sidecar, not listed apart.
+// Files that aren't artefacts are ignored. A missing dir is an empty
+// cache.
+func scan(mf *models.Manifest, dir string) ([]cacheFile, error) {
+	ents, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.New(errs.Environment, "read cache dir: %w", err)
+	}
+	byName := map[string]*models.Model{}
+	for i := range mf.Models {
+		byName[mf.Models[i].Filename] = &mf.Models[i]
+	}
+	var out []cacheFile
+	partials := map[string]*cacheFile{}
+	for _, de := range ents {
+		name := de.Name()
+		base, part := strings.CutSuffix(name, partSuffix+".meta")
+		if !part {
+			base, part = strings.CutSuffix(name, partSuffix)
+		}
+		if !de.Type().IsRegular() || !isArtefact(base) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		if !part {
+			out = append(out, cacheFile{
+				Filename: name,
+				Path:     filepath.Join(dir, name),
+				Bytes:    info.Size(),
+				ModTime:  info.ModTime(),
+				Model:    byName[name],
+			})
+			continue
+		}
+		p := partials[base]
+		if p == nil {
+			p = &cacheFile{Filename: base + partSuffix, Path: filepath.Join(dir, base+partSuffix), Model: byName[base], Partial: true}
+			partials[base] = p
+		}
+		p.Bytes += info.Size()
+		if info.ModTime().After(p.ModTime) {
+			p.ModTime = info.ModTime()
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(partials)) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			out = append(out, *partials[name])
+		}
+	}
+	return out, nil
+}
+
+// verifyResult is one file's verdict.
+type verifyResult struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"` // ok | corrupt | unknown | unverifiable | partial
+	SHA256   string `json:"sha256,omitempty"`
+	Expected string `json:"expected,omitempty"`
+}
+
+// verifyCache re-hashes every known artefact, ignoring stamps: this is
+// the command to run when a stamp itself is in doubt. A good file gets
+// a fresh stamp; a corrupt one loses its stamp so nothing trusts it.
+func verifyCache(mf *models.Manifest, dir string) ([]verifyResult, error) {
+	files, err := scan(mf, dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]verifyResult, 0, len(files))
+	for _, f := range files {
+		r := verifyResult{Filename: f.Filename}
+		switch {
+		case f.Partial:
+			r.Status = "partial"
+		case f.Model == nil:
+			r.Status = "unknown"
+		case f.Model.Placeholder():
+			r.Status = "unverifiable"
+		default:
+			r.Expected = f.Model.SHA256
+			if r.SHA256, err = models.HashFile(f.Path); err != nil {
+				return nil, errs.New(errs.Environment, "hash %s: %w", f.Path, err)
+			}
+			if r.SHA256 == r.Expected {
+				r.Status = "ok"
+				_ = models.MarkVerified(f.Path, r.SHA256)
+			} else {
+				r.Status = "corrupt"
+				os.Remove(f.Path + models.StampSuffix)
+			}
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func verifyCommand(o *opts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Re-hash every cached file against the manifest",
+		Long: `Re-hash every file in the cache against the manifest, ignoring the
+remembered results super-resolution and serve rely on. Corrupt files
+are reported (and exit with status 4); files the manifest doesn't
+know are reported as unknown — 'models prune' removes them. So are
+interrupted downloads (partial), which fetch-model resumes.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mf, dir, err := o.load()
+			if err != nil {
+				return err
+			}
+			results, err := verifyCache(mf, dir)
+			if err != nil {
+				return err
+			}
+			counts := map[string]int{}
+			for _, r := range results {
+				counts[r.Status]++
+			}
+			if o.json {
+				if err := printJSON(map[string]any{"cache_dir": dir, "files": results, "counts": counts}); err != nil {
+					return err
+				}
+			} else {
+				for _, r := range results {
+					switch r.Status {
+					case "ok":
+						fmt.Printf("✓ %s\n", r.Filename)
+					case "corrupt":
+						fmt.Printf("✗ %s: corrupt (sha256 %s, manifest says %s)\n", r.Filename, r.SHA256, r.Expected)
+					case "unknown":
+						fmt.Printf("? %s: not in the manifest\n", r.Filename)
+					case "unverifiable":
+						fmt.Printf("? %s: manifest hash is a placeholder\n", r.Filename)
+					case "partial":
+						fmt.Printf("… %s: interrupted download; fetch-model resumes it\n", r.Filename)
+					}
+				}
+				fmt.Fprintf(os.Stderr, "%d file(s): %d ok, %d corrupt, %d unknown, %d partial\n",
+					len(results), counts["ok"], counts["corrupt"], counts["unknown"], counts["partial"])
+			}
+			if n := counts["corrupt"]; n > 0 {
+				return errs.New(errs.Integrity, "%d corrupt file(s); re-fetch with fetch-model", n)
+			}
+			return nil
+		},
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────
+// prune / rm
+// ─────────────────────────────────────────────────────────────────────
+
+// removed is one artefact deleted (or, with --dry-run, that would be).
+type removed struct {
+	Filename string `json:"filename"`
+	Bytes    int64  `json:"bytes"`
+	Reason   string `json:"reason"`
+}
+
+// pruneCache picks what `models prune` deletes: artefacts the manifest
+// doesn't list, plus — with olderThan > 0 — listed ones not modified
+// (i.e. fetched) within olderThan of now. Interrupted downloads go by
+// the same rules, so a listed one stays to be resumed until it is
+// older than olderThan. Anything a fetch is writing is skipped.
+func pruneCache(mf *models.Manifest, dir string, olderThan time.Duration, now time.Time, dryRun bool) ([]removed, error) {
+	files, err := scan(mf, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []removed
+	for _, f := range files {
+		reason := ""
+		switch {
+		case f.Model == nil:
+			reason = "not in manifest"
+		case olderThan > 0 && now.Sub(f.ModTime) > olderThan:
+			reason = fmt.Sprintf("fetched %s ago", now.Sub(f.ModTime).Round(time.Hour))
+		default:
+			continue
+		}
+		if f.Partial {
+			reason = "interrupted download, " + reason
+		}
+		err := remove(f, dryRun)
+		if errors.Is(err, modelfetch.ErrLocked) {
+			fmt.Fprintf(os.Stderr, "skipping %v\n", err)
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, removed{Filename: f.Filename, Bytes: f.Bytes, Reason: reason})
+	}
+	return out, nil
+}
+
+// remove deletes f and its sidecars (a partial download: the .part and
+// its .meta), holding the artefact's fetch lock so a fetch in progress
+// is never pulled out from under; a held lock fails with
+// modelfetch.ErrLocked. With dryRun it only checks the lock.
+func remove(f cacheFile, dryRun bool) error {
+	target := f.artefact()
+	unlock, err := modelfetch.TryLock(target)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if dryRun {
+		return nil
+	}
+	files := []string{target + partSuffix, target + partSuffix + ".meta"}
+	if !f.Partial {
+		files = []string{target}
+		for _, s := range sidecars {
+			files = append(files, target+s)
+		}
+	}
+	if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+		return errs.New(errs.Environment, "remove %s: %w", files[0], err)
+	}
+	for _, p := range files[1:] {
+		os.Remove(p)
+	}
+	return nil
+}
+
+func pruneCommand(o *opts) *cobra.Command {
+	var days int
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete cached files the manifest no longer lists, or older than N days",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 0 {
+				return errs.New(errs.User, "--older-than: want a number of days ≥ 0")
+			}
+			mf, dir, err := o.load()
+			if err != nil {
+				return err
+			}
+			gone, err := pruneCache(mf, dir, time.Duration(days)*24*time.Hour, time.Now(), dryRun)
+			if err != nil {
+				return err
+			}
+			return report(o, dir, gone, dryRun)
+		},
+	}
+	cmd.Flags().IntVar(&days, "older-than", 0, "Also delete manifest artefacts fetched more than N days ago (0 = only unknown files)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be deleted without deleting it")
+	return cmd
+}
+
+func rmCommand(o *opts) *cobra.Command {
+	var variant string
+	cmd := &cobra.Command{
+		Use:   "rm <name|filename>",
+		Short: "Delete a cached artefact",
+		Long: `Delete cached artefacts. The argument is a model name (every cached
+variant, or just --variant) or the filename of one cached file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mf, dir, err := o.load()
+			if err != nil {
+				return err
+			}
+			gone, err := removeNamed(mf, dir, args[0], variant)
+			if err != nil {
+				return err
+			}
+			return report(o, dir, gone, false)
+		},
+	}
+	cmd.Flags().StringVar(&variant, "variant", "", "Only this variant (fp16 | fp32 | engine) when removing by model name")
+	return cmd
+}
+
+// removeNamed deletes the cached files matching target: a model name
+// (optionally narrowed to one variant) or a cached filename.
+func removeNamed(mf *models.Manifest, dir, target, variant string) ([]removed, error) {
+	files, err := scan(mf, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []removed
+	for _, f := range files {
+		byName := f.Model != nil && f.Model.Name == target && (variant == "" || f.Model.Variant == variant)
+		if !byName && f.Filename != target {
+			continue
+		}
+		if err := remove(f, false); err != nil {
+			return out, err
+		}
+		out = append(out, removed{Filename: f.Filename, Bytes: f.Bytes, Reason: "requested"})
+	}
+	if len(out) == 0 {
+		return nil, errs.New(errs.User, "nothing cached matches %q (see `models list`)", target)
+	}
+	return out, nil
+}
+
+// report prints what prune / rm deleted.
+func report(o *opts, dir string, gone []removed, dryRun bool) error {
+	var total int64
+	for _, r := range gone {
+		total += r.Bytes
+	}
+	if o.json {
+		if gone == nil {
+			gone = []removed{}
+		}
+		return printJSON(map[string]any{"cache_dir": dir, "removed": gone, "bytes": total, "dry_run": dryRun})
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i].Filename < gone[j].Filename })
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, r := range gone {
+		fmt.Printf("%s %s (%s; %s)\n", verb, r.Filename, modelfetch.Human(r.Bytes), r.Reason)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d file(s), %s\n", verb, len(gone), modelfetch.Human(total))
+	return nil
+}
+
+// atLeast renders a minimum version requirement, "" if none.
+func atLeast(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "≥ " + v
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
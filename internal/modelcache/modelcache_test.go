@@ -0,0 +1,237 @@
+package modelcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// fixture: a two-entry manifest and a cache holding a good fp16, a
+// corrupt fp32, a file the manifest doesn't know, and sidecars that
+// must never be reported on their own.
+func fixture(t *testing.T) (*models.Manifest, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	mf := &models.Manifest{Version: 1, Models: []models.Model{
+		{Name: "x4", Variant: "fp16", Filename: "x4_fp16.onnx", SHA256: sum("fp16")},
+		{Name: "x4", Variant: "fp32", Filename: "x4_fp32.onnx", SHA256: sum("fp32")},
+	}}
+	for name, body := range map[string]string{
+		"x4_fp16.onnx":          "fp16",
+		"x4_fp32.onnx":          "rotten",
+		"old_model.onnx":        "gone from the manifest",
+		"x4_fp16.onnx.verified": "{}",
+		"x4_fp32.onnx.part":     "half",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mf, dir
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestVerifyCache(t *testing.T) {
+	mf, dir := fixture(t)
+	// A stale stamp claiming the corrupt file is fine: verify must
+	// ignore it and then drop it.
+	if err := os.WriteFile(filepath.Join(dir, "x4_fp32.onnx.verified"), []byte(`{"sha256":"x"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	results, err := verifyCache(mf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, r := range results {
+		got[r.Filename] = r.Status
+	}
+	want := map[string]string{"x4_fp16.onnx": "ok", "x4_fp32.onnx": "corrupt", "old_model.onnx": "unknown"}
+	if len(got) != len(want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	for f, s := range want {
+		if got[f] != s {
+			t.Errorf("%s: status %q, want %q", f, got[f], s)
+		}
+	}
+	if exists(filepath.Join(dir, "x4_fp32.onnx.verified")) {
+		t.Error("corrupt file kept its stamp")
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	t.Run("unknown files only by default", func(t *testing.T) {
+		mf, dir := fixture(t)
+		gone, err := pruneCache(mf, dir, 0, time.Now(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gone) != 1 || gone[0].Filename != "old_model.onnx" || exists(filepath.Join(dir, "old_model.onnx")) {
+			t.Fatalf("removed %+v", gone)
+		}
+	})
+	t.Run("older-than takes old manifest artefacts and their sidecars", func(t *testing.T) {
+		mf, dir := fixture(t)
+		old := time.Now().Add(-40 * 24 * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, "x4_fp32.onnx"), old, old); err != nil {
+			t.Fatal(err)
+		}
+		gone, err := pruneCache(mf, dir, 30*24*time.Hour, time.Now(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range gone {
+			names = append(names, r.Filename)
+		}
+		sort.Strings(names)
+		if len(names) != 2 || names[0] != "old_model.onnx" || names[1] != "x4_fp32.onnx" {
+			t.Fatalf("removed %v", names)
+		}
+		if exists(filepath.Join(dir, "x4_fp32.onnx.part")) {
+			t.Error("sidecar survived its artefact")
+		}
+		if !exists(filepath.Join(dir, "x4_fp16.onnx")) {
+			t.Error("fresh artefact was pruned")
+		}
+	})
+	t.Run("dry run deletes nothing", func(t *testing.T) {
+		mf, dir := fixture(t)
+		gone, err := pruneCache(mf, dir, 0, time.Now(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gone) != 1 || !exists(filepath.Join(dir, "old_model.onnx")) {
+			t.Fatalf("dry run: removed %+v", gone)
+		}
+	})
+}
+
+func TestRemoveNamed(t *testing.T) {
+	mf, dir := fixture(t)
+	gone, err := removeNamed(mf, dir, "x4", "fp16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gone) != 1 || exists(filepath.Join(dir, "x4_fp16.onnx")) || exists(filepath.Join(dir, "x4_fp16.onnx.verified")) {
+		t.Fatalf("rm x4 --variant fp16 removed %+v", gone)
+	}
+	if !exists(filepath.Join(dir, "x4_fp32.onnx")) {
+		t.Fatal("--variant fp16 removed fp32 too")
+	}
+	if _, err := removeNamed(mf, dir, "old_model.onnx", ""); err != nil {
+		t.Fatalf("rm by filename: %v", err)
+	}
+	if _, err := removeNamed(mf, dir, "nope", ""); err == nil {
+		t.Fatal("rm of something not cached should fail")
+	}
+}
+
+// TestPruneCache_partials: files that aren't artefacts are never
+// touched; orphaned downloads are reported, and pruned by the same
+// rules as artefacts, .part.meta with them.
+func TestPruneCache_partials(t *testing.T) {
+	mf, dir := fixture(t)
+	for _, name := range []string{"notes.txt", "backup.tar", "x4_fp32.onnx.verified.bak",
+		"old_fp16.onnx.part", "old_fp16.onnx.part.meta", "x4_engine.engine.part.meta"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A listed, resumable download: its artefact isn't there yet.
+	mf.Models = append(mf.Models, models.Model{Name: "x4", Variant: "engine", Filename: "x4_engine.engine"})
+
+	results, err := verifyCache(mf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, r := range results {
+		got[r.Filename] = r.Status
+	}
+	if got["old_fp16.onnx.part"] != "partial" || got["x4_engine.engine.part"] != "partial" || len(got) != 5 {
+		t.Fatalf("verify: %v", got)
+	}
+
+	gone, err := pruneCache(mf, dir, 0, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range gone {
+		names = append(names, r.Filename)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "old_fp16.onnx.part" || names[1] != "old_model.onnx" {
+		t.Fatalf("removed %v", names)
+	}
+	for _, name := range []string{"old_fp16.onnx.part", "old_fp16.onnx.part.meta"} {
+		if exists(filepath.Join(dir, name)) {
+			t.Errorf("%s survived", name)
+		}
+	}
+	for _, name := range []string{"notes.txt", "backup.tar", "x4_fp32.onnx.verified.bak", "x4_engine.engine.part.meta"} {
+		if !exists(filepath.Join(dir, name)) {
+			t.Errorf("%s was pruned", name)
+		}
+	}
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "x4_engine.engine.part.meta"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if gone, err = pruneCache(mf, dir, 30*24*time.Hour, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(gone) != 1 || gone[0].Filename != "x4_engine.engine.part" || exists(filepath.Join(dir, "x4_engine.engine.part.meta")) {
+		t.Fatalf("--older-than removed %+v", gone)
+	}
+}
+
+// TestRemove_locked: an artefact a fetch holds the lock on is left
+// alone, lock file included — rm fails, prune skips it — and goes
+// once the fetch lets go.
+func TestRemove_locked(t *testing.T) {
+	mf, dir := fixture(t)
+	unlock, err := modelfetch.TryLock(filepath.Join(dir, "old_model.onnx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := filepath.Join(dir, "old_model.onnx.lock")
+
+	if _, err := removeNamed(mf, dir, "old_model.onnx", ""); !errors.Is(err, modelfetch.ErrLocked) {
+		t.Fatalf("rm under a held lock: %v", err)
+	}
+	gone, err := pruneCache(mf, dir, 0, time.Now(), false)
+	if err != nil || len(gone) != 0 {
+		t.Fatalf("prune under a held lock: removed %+v, %v", gone, err)
+	}
+	if !exists(filepath.Join(dir, "old_model.onnx")) || !exists(lock) {
+		t.Fatal("artefact or lock file removed while held")
+	}
+
+	unlock()
+	if gone, err = pruneCache(mf, dir, 0, time.Now(), false); err != nil || len(gone) != 1 {
+		t.Fatalf("prune after release: removed %+v, %v", gone, err)
+	}
+	if exists(filepath.Join(dir, "old_model.onnx")) || exists(lock) {
+		t.Error("artefact or lock file left after prune")
+	}
+}
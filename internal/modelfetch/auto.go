@@ -0,0 +1,92 @@
+package modelfetch
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// `--variant auto` fetches the TensorRT engine built for this host's
+// GPU when the manifest has one, and fp16 ONNX otherwise. An engine is
+// only worth downloading if it will load, so it must match the SM arch
+// (of the --gpu-id device, detected with nvidia-smi unless
+// --sm-arch/--gpu-class says) and the TensorRT major.minor it was
+// built with — TRT refuses engines from any other version. Nothing
+// here can see which TensorRT the engine will run under, so without
+// --trt-version that can't be confirmed and auto settles for fp16.
+
+// autoChoice is what --variant auto settled on.
+type autoChoice struct {
+	variant string
+	smArch  string
+	gpu     *gpu.Info // nil when not detected
+	reason  string
+}
+
+// pickVariant resolves --variant auto for r against mf.
+func pickVariant(ctx context.Context, mf *models.Manifest, r Request) autoChoice {
+	c := autoChoice{variant: models.VariantFP16, smArch: r.SMArch}
+	if c.smArch == "" && r.GPUClass == "" {
+		if r.GPUID < 0 {
+			c.reason = fmt.Sprintf("fp16: --gpu-id %d runs on the CPU", r.GPUID)
+			return c
+		}
+		d, err := gpu.Device(ctx, r.GPURunner, r.GPUID)
+		if err != nil {
+			c.reason = "fp16: " + err.Error()
+			return c
+		}
+		c.gpu, c.smArch = d, d.SMArch()
+	}
+	on := c.smArch
+	if on == "" {
+		on = r.GPUClass
+	}
+	entry, err := mf.Find(models.Filter{
+		Name:     r.Name,
+		Variant:  models.VariantEngine,
+		Hardware: models.Hardware{SMArch: c.smArch, GPUClass: r.GPUClass},
+	})
+	if err != nil {
+		c.reason = "fp16: no engine in manifest for " + on
+		return c
+	}
+	switch {
+	case r.TRTVersion == "" || entry.TRTVersion == "":
+		built := entry.TRTVersion
+		if built == "" {
+			built = "an unrecorded version"
+		}
+		c.reason = fmt.Sprintf("fp16: the %s engine needs TensorRT %s, which can't be confirmed here (pass --trt-version)", on, built)
+		return c
+	case !models.SameMinor(entry.TRTVersion, r.TRTVersion):
+		c.reason = fmt.Sprintf("fp16: the %s engine is built for TensorRT %s, not %s", on, entry.TRTVersion, r.TRTVersion)
+		return c
+	}
+	c.variant = models.VariantEngine
+	c.reason = "engine for " + on
+	if c.gpu != nil {
+		c.reason = "engine for " + c.gpu.String()
+	}
+	c.reason += ", TensorRT " + entry.TRTVersion
+	return c
+}
+
+// resolveAuto rewrites an auto request into a concrete one and says
+// why on the event stream and stderr.
+func resolveAuto(ctx context.Context, mf *models.Manifest, r *Request) {
+	c := pickVariant(ctx, mf, *r)
+	r.Variant = c.variant
+	if c.variant == models.VariantEngine {
+		r.SMArch = c.smArch
+	}
+	fields := map[string]any{"variant": c.variant, "sm_arch": c.smArch, "reason": c.reason}
+	if c.gpu != nil {
+		fields["gpu"] = c.gpu
+	}
+	emit(r.Events, r.JSONEvents, "variant", fields)
+	fmt.Fprintf(os.Stderr, "variant auto → %s\n", c.reason)
+}
@@ -0,0 +1,143 @@
+package modelfetch
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// TestPickVariant: --variant auto takes the engine only when it will
+// load here — right SM arch on the --gpu-id device, TensorRT confirmed
+// the same — and otherwise says why it settled for fp16.
+func TestPickVariant(t *testing.T) {
+	mf := &models.Manifest{Models: []models.Model{
+		{Name: "m", Variant: "fp16", Filename: "m_fp16.onnx"},
+		{Name: "m", Variant: "engine", SMArch: "sm89", GPUClass: "l40s", TRTVersion: "10.8", Filename: "m_sm89.engine"},
+		{Name: "old", Variant: "fp16", Filename: "old_fp16.onnx"},
+		{Name: "old", Variant: "engine", SMArch: "sm89", Filename: "old_sm89.engine"},
+	}}
+	smi := func(line string) gpu.Runner {
+		return func(context.Context, string, ...string) ([]byte, error) {
+			if line == "" {
+				return nil, exec.ErrNotFound
+			}
+			return []byte(line + "\n"), nil
+		}
+	}
+	const l40s = "0, NVIDIA L40S, 8.9, 550.54.15"
+	const t4AndL40S = "0, Tesla T4, 7.5, 535.104.05\n1, NVIDIA L40S, 8.9, 535.104.05"
+
+	cases := []struct {
+		name    string
+		req     Request
+		variant string
+		reason  string
+	}{
+		{
+			name:    "detected sm89 has an engine",
+			req:     Request{GPURunner: smi(l40s), TRTVersion: "10.8"},
+			variant: "engine", reason: "NVIDIA L40S (sm89, driver 550.54.15), TensorRT 10.8",
+		},
+		{
+			name:    "unknown TensorRT",
+			req:     Request{GPURunner: smi(l40s)},
+			variant: "fp16", reason: "needs TensorRT 10.8, which can't be confirmed here (pass --trt-version)",
+		},
+		{
+			name:    "engine without a recorded TensorRT",
+			req:     Request{Name: "old", GPURunner: smi(l40s), TRTVersion: "10.8"},
+			variant: "fp16", reason: "needs TensorRT an unrecorded version",
+		},
+		{
+			name:    "--gpu-id picks the device",
+			req:     Request{GPURunner: smi(t4AndL40S), GPUID: 1, TRTVersion: "10.8"},
+			variant: "engine", reason: "engine for NVIDIA L40S",
+		},
+		{
+			name:    "--gpu-id 0 on the same host",
+			req:     Request{GPURunner: smi(t4AndL40S), TRTVersion: "10.8"},
+			variant: "fp16", reason: "no engine in manifest for sm75",
+		},
+		{
+			name:    "--gpu-id -1",
+			req:     Request{GPURunner: smi(l40s), GPUID: -1, TRTVersion: "10.8"},
+			variant: "fp16", reason: "runs on the CPU",
+		},
+		{
+			name:    "patch release of the same TensorRT",
+			req:     Request{GPURunner: smi(l40s), TRTVersion: "10.8.0.43"},
+			variant: "engine", reason: "engine for NVIDIA L40S",
+		},
+		{
+			name:    "different TensorRT",
+			req:     Request{GPURunner: smi(l40s), TRTVersion: "10.9"},
+			variant: "fp16", reason: "built for TensorRT 10.8, not 10.9",
+		},
+		{
+			name:    "no engine for this arch",
+			req:     Request{GPURunner: smi("0, Tesla T4, 7.5, 535.104.05")},
+			variant: "fp16", reason: "no engine in manifest for sm75",
+		},
+		{
+			name:    "no GPU",
+			req:     Request{GPURunner: smi("")},
+			variant: "fp16", reason: "no NVIDIA GPU detected",
+		},
+		{
+			name:    "explicit --sm-arch skips detection",
+			req:     Request{SMArch: "sm89", GPURunner: smi(""), TRTVersion: "10.8"},
+			variant: "engine", reason: "engine for sm89",
+		},
+		{
+			name:    "explicit --gpu-class",
+			req:     Request{GPUClass: "l40s", GPURunner: smi(""), TRTVersion: "10.8"},
+			variant: "engine", reason: "engine for l40s",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.req.Name == "" {
+				c.req.Name = "m"
+			}
+			got := pickVariant(context.Background(), mf, c.req)
+			if got.variant != c.variant || !strings.Contains(got.reason, c.reason) {
+				t.Fatalf("got %s (%q), want %s (…%q…)", got.variant, got.reason, c.variant, c.reason)
+			}
+		})
+	}
+}
+
+// TestFetch_auto: on a host without a GPU, --variant auto downloads
+// fp16 and reports the choice as a `variant` event.
+func TestFetch_auto(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(artefact)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	var events bytes.Buffer
+	res, err := Fetch(context.Background(), Request{
+		Name: "tiny", Variant: "auto",
+		Dest:       filepath.Join(dir, "cache"),
+		Manifest:   writeManifest(t, dir, srv.URL, artefact),
+		GPURunner:  func(context.Context, string, ...string) ([]byte, error) { return nil, exec.ErrNotFound },
+		JSONEvents: true, Events: &events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Model.Variant != "fp16" {
+		t.Errorf("fetched %s, want fp16", res.Model.Variant)
+	}
+	if !strings.Contains(events.String(), `"event":"variant"`) {
+		t.Errorf("no variant event in:\n%s", events.String())
+	}
+}
@@ -0,0 +1,396 @@
+package modelfetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// Downloads are resumable. A failed transfer leaves `<file>.part` plus
+// a `<file>.part.meta` sidecar recording the URL, ETag and total size
+// the bytes came from. The next attempt — a retry inside this process
+// or a later `fetch-model` run — asks for the rest with
+// `Range: bytes=N-`, guarded by `If-Range: <etag>` so a re-uploaded
+// release asset restarts from zero instead of splicing two files. The
+// SHA-256 check in Fetch is still the final word; the sidecar only
+// saves bandwidth.
+//
+// Transient failures (5xx, 408/429, connection resets, short bodies,
+// stalls) are retried with exponential backoff and jitter. There is no
+// whole-transfer timeout: a slow link making progress is fine, a link
+// delivering nothing for `stall` is not.
+
+// partMeta is the sidecar next to a .part file.
+type partMeta struct {
+	URL   string `json:"url"`
+	ETag  string `json:"etag,omitempty"`
+	Total int64  `json:"total,omitempty"` // 0 = server didn't say
+}
+
+type downloader struct {
+	client     *http.Client
+	attempts   int           // total tries, first included
+	backoff    time.Duration // first retry delay; doubles each retry
+	maxBackoff time.Duration
+	stall      time.Duration // abort an attempt when no bytes arrive for this long
+	jsonEvts   bool
+	events     io.Writer // where JSON events go; stdout unless a caller says otherwise
+}
+
+func newDownloader(jsonEvts bool) *downloader {
+	return &downloader{
+		client:     &http.Client{},
+		attempts:   5,
+		backoff:    time.Second,
+		maxBackoff: 30 * time.Second,
+		stall:      60 * time.Second,
+		jsonEvts:   jsonEvts,
+		events:     os.Stdout,
+	}
+}
+
+// transient marks an error worth retrying.
+type transient struct{ error }
+
+func (t transient) Unwrap() error { return t.error }
+
+var errStalled = errors.New("transfer stalled")
+
+// get downloads url into part, resuming whatever part already holds.
+// want is the manifest's byte count (0 = unknown); a server that
+// disagrees is serving the wrong artefact, and that is not retried.
+func (d *downloader) get(ctx context.Context, url, part string, want int64) error {
+	for attempt := 1; ; attempt++ {
+		err := d.attempt(ctx, url, part, want)
+		if err == nil {
+			return nil
+		}
+		var t transient
+		if !errors.As(err, &t) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return errs.Wrap(errs.Interrupted, ctx.Err())
+		}
+		if attempt >= d.attempts {
+			return fmt.Errorf("giving up after %d attempt(s): %w", attempt, err)
+		}
+		delay := d.delay(attempt)
+		emit(d.events, d.jsonEvts, "retry", map[string]any{
+			"attempt": attempt,
+			"of":      d.attempts,
+			"delay":   delay.Seconds(),
+			"error":   err.Error(),
+		})
+		fmt.Fprintf(os.Stderr, "  attempt %d/%d failed: %v — retrying in %s\n",
+			attempt, d.attempts, err, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errs.Wrap(errs.Interrupted, ctx.Err())
+		}
+	}
+}
+
+// delay is backoff·2^(attempt-1), capped, with "equal jitter": half
+// fixed, half random, so a fleet of workers that failed together
+// doesn't retry together.
+func (d *downloader) delay(attempt int) time.Duration {
+	b := d.backoff << (attempt - 1)
+	if b <= 0 || b > d.maxBackoff {
+		b = d.maxBackoff
+	}
+	half := b / 2
+	if half <= 0 {
+		return b
+	}
+	return half + rand.N(half)
+}
+
+// attempt is one HTTP request: resume if part + sidecar agree with
+// url, otherwise start from zero.
+func (d *downloader) attempt(parent context.Context, url, part string, want int64) error {
+	meta, offset := loadPart(part, url)
+
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf(
+		"real-esrgan-serve/dev (Go %s; %s/%s)",
+		runtime.Version(), runtime.GOOS, runtime.GOARCH,
+	))
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// Weak ETags can't guard a range; the Content-Range total
+		// check below and the final hash cover that case.
+		if meta.ETag != "" && !strings.HasPrefix(meta.ETag, "W/") {
+			req.Header.Set("If-Range", meta.ETag)
+		}
+	}
+
+	// The stall timer covers the wait for headers too.
+	timer := time.AfterFunc(d.stall, func() { cancel(errStalled) })
+	defer timer.Stop()
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return d.transportErr(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		cr := resp.Header.Get("Content-Range")
+		start, total, ok := parseContentRange(cr)
+		etag := resp.Header.Get("ETag")
+		if !ok || start != offset || (meta.Total > 0 && total != meta.Total) ||
+			(meta.ETag != "" && etag != "" && etag != meta.ETag) {
+			// Not the continuation we asked for. Drop it; the
+			// retry starts over.
+			discardPart(part)
+			return transient{fmt.Errorf("server sent an inconsistent range (%q); restarting from zero", cr)}
+		}
+		if meta.Total == 0 && total > 0 {
+			// The first response had no Content-Length; the range
+			// total is the first word on the size, so hold it to the
+			// manifest the way the 200 branch does, then record it.
+			if want > 0 && total != want {
+				discardPart(part)
+				return errs.New(errs.Integrity,
+					"server reports %d bytes, manifest says %d — refusing to download the wrong artefact",
+					total, want)
+			}
+			meta.Total = total
+			if err := saveMeta(part, meta); err != nil {
+				return err
+			}
+		}
+		flag |= os.O_APPEND
+		emit(d.events, d.jsonEvts, "resuming", map[string]any{"offset": offset, "total": meta.Total})
+		fmt.Fprintf(os.Stderr, "  resuming at %s of %s\n", Human(offset), Human(meta.Total))
+
+	case resp.StatusCode == http.StatusOK:
+		// First attempt, or the server declined the range (no range
+		// support, or If-Range said the file changed).
+		offset = 0
+		meta = partMeta{URL: url, ETag: resp.Header.Get("ETag"), Total: max(resp.ContentLength, 0)}
+		if want > 0 && meta.Total > 0 && meta.Total != want {
+			return errs.New(errs.Integrity,
+				"server reports %d bytes, manifest says %d — refusing to download the wrong artefact",
+				meta.Total, want)
+		}
+		if err := saveMeta(part, meta); err != nil {
+			return err
+		}
+		flag |= os.O_TRUNC
+
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Nothing left to send. Complete if the sidecar agrees;
+		// otherwise the .part is junk.
+		if meta.Total > 0 && offset == meta.Total {
+			return nil
+		}
+		discardPart(part)
+		return transient{fmt.Errorf("http 416 for a %d-byte partial; restarting from zero", offset)}
+
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		err := fmt.Errorf("http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		switch code := resp.StatusCode; {
+		case code >= 500, code == http.StatusTooManyRequests, code == http.StatusRequestTimeout:
+			return transient{err}
+		}
+		return err
+	}
+
+	out, err := os.OpenFile(part, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	pw := &progressWriter{
+		total:    meta.Total,
+		written:  offset,
+		jsonEvts: d.jsonEvts,
+		events:   d.events,
+		started:  time.Now(),
+		nextTick: time.Now().Add(2 * time.Second),
+	}
+	body := &stallReader{r: resp.Body, timer: timer, stall: d.stall}
+	_, err = io.Copy(io.MultiWriter(out, pw), body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if body.err != nil {
+			return d.transportErr(ctx, err)
+		}
+		return err // local write failure: disk full, permissions
+	}
+	pw.finish()
+	if meta.Total > 0 && pw.written != meta.Total {
+		return transient{fmt.Errorf("connection closed at %d of %d bytes", pw.written, meta.Total)}
+	}
+	return nil
+}
+
+// transportErr classifies a failure on the wire. Everything here is
+// retryable; the stall case just gets a clearer message.
+func (d *downloader) transportErr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), errStalled) {
+		return transient{fmt.Errorf("no data received for %s; aborted stalled transfer", d.stall)}
+	}
+	return transient{err}
+}
+
+// stallReader pushes the stall deadline back on every read that
+// returns bytes, and remembers read-side errors so attempt can tell
+// them from write-side ones.
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+	stall time.Duration
+	err   error
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.stall)
+	}
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// parseContentRange reads "bytes START-END/TOTAL". TOTAL may be "*"
+// (unknown), reported as 0.
+func parseContentRange(v string) (start, total int64, ok bool) {
+	rest, found := strings.CutPrefix(v, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	span, size, found := strings.Cut(rest, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// loadPart returns the sidecar and the resume offset for part. A
+// .part without a sidecar, or one recorded for a different URL, can't
+// be trusted as a prefix: offset 0.
+func loadPart(part, url string) (partMeta, int64) {
+	b, err := os.ReadFile(part + ".meta")
+	if err != nil {
+		return partMeta{}, 0
+	}
+	var m partMeta
+	if json.Unmarshal(b, &m) != nil || m.URL != url {
+		return partMeta{}, 0
+	}
+	info, err := os.Stat(part)
+	if err != nil {
+		return m, 0
+	}
+	return m, info.Size()
+}
+
+func saveMeta(part string, m partMeta) error {
+	b, _ := json.Marshal(m)
+	return os.WriteFile(part+".meta", b, 0o644)
+}
+
+// discardPart removes a partial download and its sidecar.
+func discardPart(part string) {
+	os.Remove(part)
+	os.Remove(part + ".meta")
+}
+
+type progressWriter struct {
+	total    int64
+	written  int64
+	jsonEvts bool
+	events   io.Writer
+	started  time.Time
+	nextTick time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	p.written += int64(n)
+	if time.Now().After(p.nextTick) {
+		p.tick()
+		p.nextTick = time.Now().Add(2 * time.Second)
+	}
+	return n, nil
+}
+
+func (p *progressWriter) tick() {
+	if p.jsonEvts {
+		emit(p.events, true, "progress", map[string]any{
+			"bytes":   p.written,
+			"total":   p.total,
+			"elapsed": time.Since(p.started).Seconds(),
+		})
+		return
+	}
+	if p.total > 0 {
+		fmt.Fprintf(os.Stderr, "  %s / %s  (%.1f %%)\n",
+			Human(p.written), Human(p.total),
+			100*float64(p.written)/float64(p.total))
+	} else {
+		fmt.Fprintf(os.Stderr, "  %s\n", Human(p.written))
+	}
+}
+
+func (p *progressWriter) finish() {
+	if p.jsonEvts {
+		emit(p.events, true, "downloaded", map[string]any{
+			"bytes":   p.written,
+			"elapsed": time.Since(p.started).Seconds(),
+		})
+	}
+}
+
+// Human formats a byte count for people: "64.0 MiB".
+func Human(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for n2 := n / unit; n2 >= unit; n2 /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
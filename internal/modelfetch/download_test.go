@@ -0,0 +1,317 @@
+package modelfetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// testDownloader retries fast so the suite doesn't sleep through
+// backoff.
+func testDownloader() *downloader {
+	d := newDownloader(false)
+	d.backoff = time.Millisecond
+	d.maxBackoff = 5 * time.Millisecond
+	d.stall = 2 * time.Second
+	return d
+}
+
+// artefact is a deterministic body big enough to cut in half.
+var artefact = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// rangeServer serves artefact with a strong ETag and real Range /
+// If-Range handling (http.ServeContent does both). dropFirst makes
+// the first response die mid-body: full Content-Length promised, half
+// the bytes sent, connection aborted.
+type rangeServer struct {
+	etag      string
+	dropFirst bool
+
+	mu     sync.Mutex
+	ranges []string // Range header of every request, "" for none
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	first := len(s.ranges) == 1
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", s.etag)
+	if first && s.dropFirst {
+		w.Header().Set("Content-Length", strconv.Itoa(len(artefact)))
+		_, _ = w.Write(artefact[:len(artefact)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // drop the connection mid-body
+	}
+	http.ServeContent(w, r, "artefact.bin", time.Time{}, bytes.NewReader(artefact))
+}
+
+func (s *rangeServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func readPart(t *testing.T, path string) []byte {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// TestDownload_resumesAfterDrop is the case the feature exists for:
+// the connection dies halfway, and the retry asks for exactly the
+// missing tail instead of starting over.
+func TestDownload_resumesAfterDrop(t *testing.T) {
+	rs := &rangeServer{etag: `"v1"`, dropFirst: true}
+	srv := httptest.NewServer(rs)
+	defer srv.Close()
+
+	part := filepath.Join(t.TempDir(), "model.onnx.part")
+	if err := testDownloader().get(context.Background(), srv.URL, part, int64(len(artefact))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readPart(t, part), artefact) {
+		t.Fatal("resumed file differs from the artefact")
+	}
+	reqs := rs.requests()
+	want := fmt.Sprintf("bytes=%d-", len(artefact)/2)
+	if len(reqs) != 2 || reqs[0] != "" || reqs[1] != want {
+		t.Fatalf("requests = %q, want [\"\" %q]", reqs, want)
+	}
+}
+
+// TestDownload_resumesAcrossRuns: a .part + sidecar left by an earlier
+// process is picked up by a fresh downloader.
+func TestDownload_resumesAcrossRuns(t *testing.T) {
+	rs := &rangeServer{etag: `"v1"`}
+	srv := httptest.NewServer(rs)
+	defer srv.Close()
+
+	part := filepath.Join(t.TempDir(), "model.onnx.part")
+	half := int64(len(artefact) / 2)
+	if err := os.WriteFile(part, artefact[:half], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMeta(part, partMeta{URL: srv.URL, ETag: `"v1"`, Total: int64(len(artefact))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testDownloader().get(context.Background(), srv.URL, part, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readPart(t, part), artefact) {
+		t.Fatal("resumed file differs from the artefact")
+	}
+	if reqs := rs.requests(); len(reqs) != 1 || reqs[0] != fmt.Sprintf("bytes=%d-", half) {
+		t.Fatalf("requests = %q, want a single ranged request", reqs)
+	}
+}
+
+// TestDownload_etagChangedRestarts: the release asset was replaced
+// since the partial was written. If-Range makes the server send the
+// whole new file, which must overwrite — not extend — the stale bytes.
+func TestDownload_etagChangedRestarts(t *testing.T) {
+	rs := &rangeServer{etag: `"v2"`}
+	srv := httptest.NewServer(rs)
+	defer srv.Close()
+
+	part := filepath.Join(t.TempDir(), "model.onnx.part")
+	if err := os.WriteFile(part, []byte("stale bytes from v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMeta(part, partMeta{URL: srv.URL, ETag: `"v1"`, Total: int64(len(artefact))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testDownloader().get(context.Background(), srv.URL, part, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readPart(t, part), artefact) {
+		t.Fatal("stale partial was not replaced")
+	}
+}
+
+// TestDownload_retries5xx: two 503s then success. A 404 not being
+// retried is covered in modelfetch_test.go.
+func TestDownload_retries5xx(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(artefact)
+	}))
+	defer srv.Close()
+
+	part := filepath.Join(t.TempDir(), "model.onnx.part")
+	if err := testDownloader().get(context.Background(), srv.URL, part, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+// TestDownload_givesUp: every attempt is a 500; the error says how
+// many times we tried.
+func TestDownload_givesUp(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	d := testDownloader()
+	err := d.get(context.Background(), srv.URL, filepath.Join(t.TempDir(), "x.part"), 0)
+	if err == nil || !strings.Contains(err.Error(), "after 5 attempt(s)") {
+		t.Fatalf("expected give-up error, got %v", err)
+	}
+	if n := int(hits.Load()); n != d.attempts {
+		t.Fatalf("expected %d requests, got %d", d.attempts, n)
+	}
+}
+
+// TestDownload_stall: headers and a few bytes, then silence. The
+// stall detector must abort rather than wait forever.
+func TestDownload_stall(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(artefact)))
+		_, _ = w.Write(artefact[:100])
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	d := testDownloader()
+	d.attempts = 1
+	d.stall = 100 * time.Millisecond
+	start := time.Now()
+	err := d.get(context.Background(), srv.URL, filepath.Join(t.TempDir(), "x.part"), 0)
+	if err == nil || !strings.Contains(err.Error(), "stalled") {
+		t.Fatalf("expected stall error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("stall detector did not fire promptly")
+	}
+}
+
+// TestDownload_sizeMismatch: the server's Content-Length disagrees
+// with the manifest. That's the wrong file, not a flaky link — no
+// retry, integrity category.
+func TestDownload_sizeMismatch(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Length", strconv.Itoa(len(artefact)))
+		_, _ = w.Write(artefact)
+	}))
+	defer srv.Close()
+
+	err := testDownloader().get(context.Background(), srv.URL, filepath.Join(t.TempDir(), "x.part"), 12345)
+	if !errs.Is(err, errs.Integrity) {
+		t.Fatalf("expected an integrity error, got %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+// TestDownload_rangeTotal: the first response has no Content-Length
+// and dies mid-body. The resume's Content-Range total is then the
+// first word on the size: recorded in the sidecar, and held to the
+// manifest's bytes like a Content-Length would be.
+func TestDownload_rangeTotal(t *testing.T) {
+	cases := []struct {
+		name string
+		want int64
+		err  bool
+	}{
+		{"matches the manifest", int64(len(artefact)), false},
+		{"unknown manifest size", 0, false},
+		{"disagrees with the manifest", int64(len(artefact)) + 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				if hits.Add(1) == 1 {
+					// Chunked: no Content-Length, so no total yet.
+					_, _ = w.Write(artefact[:len(artefact)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "artefact.bin", time.Time{}, bytes.NewReader(artefact))
+			}))
+			defer srv.Close()
+
+			part := filepath.Join(t.TempDir(), "model.onnx.part")
+			err := testDownloader().get(context.Background(), srv.URL, part, tc.want)
+			if tc.err {
+				if !errs.Is(err, errs.Integrity) {
+					t.Fatalf("expected an integrity error, got %v", err)
+				}
+				if n := hits.Load(); n != 2 {
+					t.Fatalf("expected 2 requests, got %d", n)
+				}
+				if _, err := os.Stat(part); !os.IsNotExist(err) {
+					t.Fatalf("partial of the wrong artefact kept: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(readPart(t, part), artefact) {
+				t.Fatal("resumed file differs from the artefact")
+			}
+			if meta, _ := loadPart(part, srv.URL); meta.Total != int64(len(artefact)) {
+				t.Fatalf("sidecar total %d, want %d", meta.Total, len(artefact))
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		in           string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-0/*", 0, 0, true},
+		{"bytes */200", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tc := range cases {
+		start, total, ok := parseContentRange(tc.in)
+		if start != tc.start || total != tc.total || ok != tc.ok {
+			t.Errorf("parseContentRange(%q) = (%d, %d, %v), want (%d, %d, %v)",
+				tc.in, start, total, ok, tc.start, tc.total, tc.ok)
+		}
+	}
+}
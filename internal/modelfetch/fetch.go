@@ -0,0 +1,223 @@
+package modelfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// Request names one manifest artefact to place in the cache. Field
+// meanings match the `fetch-model` flags of the same names.
+type Request struct {
+	Name     string
+	Variant  string
+	GPUClass string
+	SMArch   string
+	Dest     string   // cache dir; "" = models.CacheDir default
+	Manifest string   // manifest path; "" = LoadManifest default
+	Mirrors  []string // tried in order before entry.URL; nil = $REAL_ESRGAN_MIRRORS
+	NoVerify bool
+
+	// TRTVersion is the TensorRT version the engine will run under
+	// (--trt-version); --variant auto skips an engine built for
+	// another. "" = not checked.
+	TRTVersion string
+	// GPUID is the device --variant auto looks up, as --gpu-id
+	// (CUDA_VISIBLE_DEVICES applies); < 0 = the CPU, so no engine.
+	GPUID int
+	// GPURunner runs nvidia-smi for --variant auto; nil = the real one.
+	GPURunner gpu.Runner
+
+	// AllowUnsigned accepts a manifest without a valid signature
+	// (--allow-unsigned-manifest).
+	AllowUnsigned bool
+
+	JSONEvents bool
+	Events     io.Writer // JSON event sink; nil = stdout
+}
+
+// Result is where the artefact ended up.
+type Result struct {
+	Path   string
+	Model  models.Model
+	Cached bool // already present and verified; nothing was downloaded
+}
+
+// Fetch is `fetch-model` as a library call: resolve the manifest
+// entry, download (resuming any .part), verify SHA-256, rename into
+// place. `super-resolution --auto-fetch` and `serve --auto-fetch`
+// call it on a cache miss.
+//
+// The artefact is locked for the duration, so two processes missing
+// the cache at once (a scaled-out worker pool on one volume) don't
+// both download, or rename over each other's half-verified file. The
+// loser waits, then finds the winner's file already verified.
+func Fetch(ctx context.Context, r Request) (*Result, error) {
+	events := r.Events
+	if events == nil {
+		events = os.Stdout
+	}
+	r.Events = events
+
+	mf, src, err := LoadManifest(r.Manifest, r.AllowUnsigned)
+	if err != nil {
+		return nil, errs.Wrap(errs.Environment, fmt.Errorf("manifest: %w", err))
+	}
+	emit(events, r.JSONEvents, "manifest", map[string]any{
+		"source": src.Path,
+		"signed": src.KeyID != "",
+		"key_id": src.KeyID,
+	})
+	if r.Variant == models.VariantAuto {
+		resolveAuto(ctx, mf, &r)
+	}
+	entry, err := mf.Find(models.Filter{
+		Name:     r.Name,
+		Variant:  r.Variant,
+		Hardware: models.Hardware{SMArch: r.SMArch, GPUClass: r.GPUClass},
+	})
+	if err != nil {
+		return nil, errs.Wrap(errs.User, err)
+	}
+	dest, err := models.CacheDir(r.Dest)
+	if err != nil {
+		return nil, errs.Wrap(errs.Environment, err)
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, errs.New(errs.Environment, "mkdir cache dir: %w", err)
+	}
+	target := filepath.Join(dest, entry.Filename)
+
+	// Already cached + verified? Bail early — the common case.
+	cached := func() (*Result, bool) {
+		if ok, _ := models.Verify(target, entry.SHA256); !ok {
+			return nil, false
+		}
+		emit(events, r.JSONEvents, "cached", map[string]any{
+			"path":   target,
+			"sha256": entry.SHA256,
+			"bytes":  entry.Bytes,
+		})
+		fmt.Fprintf(os.Stderr, "already cached + verified: %s\n", target)
+		return &Result{Path: target, Model: *entry, Cached: true}, true
+	}
+	if res, ok := cached(); ok {
+		return res, nil
+	}
+
+	mirrors := r.Mirrors
+	if mirrors == nil {
+		mirrors = ParseMirrors(os.Getenv(MirrorsEnv))
+	}
+	srcs, err := sourcesFor(mirrors, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockArtefact(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// Whoever held the lock may have just finished this very file.
+	if res, ok := cached(); ok {
+		return res, nil
+	}
+
+	// The .part file (and its .meta sidecar) survive a failed run on
+	// purpose: the next fetch resumes from them. Only a hash mismatch
+	// or a successful rename clears them.
+	tmp := target + ".part"
+	d := newDownloader(r.JSONEvents)
+	d.events = events
+	verified := false
+	for i, src := range srcs {
+		emit(events, r.JSONEvents, "downloading", map[string]any{
+			"url":      src.url,
+			"mirror":   src.mirror,
+			"dest":     target,
+			"expected": entry.SHA256,
+		})
+		fmt.Fprintf(os.Stderr, "fetching %s\n  → %s\n", src.url, target)
+
+		err := fetchFrom(ctx, d, src, entry, tmp)
+		if err == nil {
+			if !src.mirror {
+				break
+			}
+			// A mirror is only as good as its bytes: checked here,
+			// whatever --no-verify says.
+			if err = checkPart(tmp, entry); err == nil {
+				verified = true
+				break
+			}
+		}
+		if errs.Is(err, errs.Interrupted) || i == len(srcs)-1 {
+			return nil, errs.Wrap(errs.Network, fmt.Errorf("download: %w", err))
+		}
+		emit(events, r.JSONEvents, "source_failed", map[string]any{"url": src.url, "error": err.Error()})
+		fmt.Fprintf(os.Stderr, "  %s failed: %v — trying the next source\n", src.url, err)
+	}
+
+	switch {
+	case verified: // mirror bytes, checked in the loop
+	case !r.NoVerify:
+		if err := checkPart(tmp, entry); err != nil {
+			return nil, err
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "WARNING: --no-verify — skipping SHA-256 check.")
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return nil, errs.New(errs.Environment, "rename %s -> %s: %w", tmp, target, err)
+	}
+	discardPart(tmp) // just the sidecar now
+	if verified || !r.NoVerify {
+		// Stamp it so the first run loading the model doesn't hash
+		// it again (models.Verify).
+		_ = models.MarkVerified(target, entry.SHA256)
+	}
+
+	emit(events, r.JSONEvents, "done", map[string]any{
+		"path":   target,
+		"sha256": entry.SHA256,
+		"bytes":  entry.Bytes,
+	})
+	fmt.Fprintf(os.Stderr, "done: %s\n", target)
+	return &Result{Path: target, Model: *entry}, nil
+}
+
+// fetchFrom puts src's copy of entry into part.
+func fetchFrom(ctx context.Context, d *downloader, src source, entry *models.Model, part string) error {
+	if strings.HasPrefix(src.url, "file:") {
+		return fetchLocal(src.url, entry.Filename, part)
+	}
+	return d.get(ctx, src.url, part, entry.Bytes)
+}
+
+// checkPart verifies a finished download against the manifest. A
+// mismatch deletes it so nothing resumes from bad bytes.
+func checkPart(part string, entry *models.Model) error {
+	if entry.Placeholder() {
+		return errs.New(errs.Integrity, "manifest hash for %s/%s is a placeholder (%q) — refusing to declare verified",
+			entry.Name, entry.Variant, entry.SHA256)
+	}
+	gotSum, err := models.HashFile(part)
+	if err != nil {
+		return errs.New(errs.Environment, "hash %s: %w", part, err)
+	}
+	if gotSum != entry.SHA256 {
+		discardPart(part)
+		return errs.New(errs.Integrity, "sha256 mismatch — got %s, manifest says %s. Partial file deleted.",
+			gotSum, entry.SHA256)
+	}
+	return nil
+}
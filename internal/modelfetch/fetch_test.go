@@ -0,0 +1,111 @@
+package modelfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// writeManifest points a signed one-entry manifest at url and returns
+// its path.
+func writeManifest(t *testing.T, dir, url string, body []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(body)
+	mf := models.Manifest{Version: 1, Models: []models.Model{{
+		Name: "tiny", Variant: "fp16", Filename: "tiny_fp16.onnx",
+		URL: url, SHA256: hex.EncodeToString(sum[:]), Bytes: int64(len(body)),
+	}}}
+	b, _ := json.Marshal(mf)
+	path := filepath.Join(dir, "MANIFEST.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signManifest(t, path)
+	return path
+}
+
+// TestFetch covers the library entry point end to end: download +
+// verify + place, then a second call served from cache without
+// touching the network. The events land on the writer the caller
+// passed, not stdout — super-resolution relies on that with -o -.
+func TestFetch(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write(artefact)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var events bytes.Buffer
+	req := Request{
+		Name: "tiny", Variant: "fp16",
+		Dest:       filepath.Join(dir, "cache"),
+		Manifest:   writeManifest(t, dir, srv.URL, artefact),
+		JSONEvents: true,
+		Events:     &events,
+	}
+
+	res, err := Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cached || res.Path != filepath.Join(dir, "cache", "tiny_fp16.onnx") {
+		t.Fatalf("first fetch = %+v", res)
+	}
+	if !strings.Contains(events.String(), `"event":"done"`) {
+		t.Fatalf("no done event on the caller's writer: %s", events.String())
+	}
+	for _, leftover := range []string{".part", ".part.meta", ".lock"} {
+		if _, err := os.Stat(res.Path + leftover); err == nil {
+			t.Errorf("%s left behind after a successful fetch", leftover)
+		}
+	}
+
+	res, err = Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Cached || hits.Load() != 1 {
+		t.Fatalf("second fetch should be a cache hit; cached=%v requests=%d", res.Cached, hits.Load())
+	}
+}
+
+// TestFetch_hashMismatch: the server sends different bytes than the
+// manifest hashes. Nothing may be placed, and the partial is gone so
+// the next run doesn't resume garbage.
+func TestFetch_hashMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.ToUpper(artefact))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	req := Request{
+		Name: "tiny", Variant: "fp16",
+		Dest:     filepath.Join(dir, "cache"),
+		Manifest: writeManifest(t, dir, srv.URL, artefact),
+		Events:   &bytes.Buffer{},
+	}
+	_, err := Fetch(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected sha256 mismatch, got %v", err)
+	}
+	target := filepath.Join(dir, "cache", "tiny_fp16.onnx")
+	for _, p := range []string{target, target + ".part", target + ".part.meta"} {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("%s should not exist after a mismatch", p)
+		}
+	}
+}
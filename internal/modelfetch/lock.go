@@ -0,0 +1,85 @@
+package modelfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// Fetches of one artefact are serialised by an advisory lock on
+// `<target>.lock`, so two processes sharing a cache dir (a fleet on
+// one volume, a `serve --auto-fetch` racing a CI job) don't both
+// download into the same .part or rename over each other. The loser
+// waits, then finds the winner's verified file and returns it as
+// cached.
+//
+// On Unix the lock is flock(2) (lock_unix.go): the kernel drops it
+// when the holder exits, however it exits, so a lock file left by a
+// crashed process is simply taken over. Elsewhere (lock_other.go) the
+// file is created O_EXCL and the holder refreshes its mtime; one not
+// refreshed for lockStale is presumed abandoned.
+//
+// Either way the lock file holds the holder's PID, for the waiting
+// message and for spotting leftovers. Only the holder unlinks it.
+
+// lockPoll is how often a waiter retries.
+const lockPoll = 250 * time.Millisecond
+
+// lockStale is how long an un-refreshed O_EXCL lock file survives
+// before a waiter takes it over (non-Unix only).
+const lockStale = 30 * time.Second
+
+// lockHolder reads the PID recorded in a lock file, "" if none.
+func lockHolder(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// ErrLocked is TryLock's answer while a fetch holds the artefact.
+var ErrLocked = errors.New("artefact is being fetched")
+
+// TryLock takes the fetch lock on target without waiting, for callers
+// that change the cache behind fetch's back (`models rm` / `prune`).
+// While a fetch holds it, the error wraps ErrLocked and names the
+// holder. The returned func releases it.
+func TryLock(target string) (func(), error) {
+	unlock, err := tryLock(target)
+	if errors.Is(err, ErrLocked) {
+		path := target + ".lock"
+		return nil, errs.New(errs.Environment, "%s: %w (pid %s holds %s)", filepath.Base(target), ErrLocked, lockHolder(path), path)
+	}
+	return unlock, err
+}
+
+// lockArtefact takes the lock on target, waiting (politely,
+// cancellably) while someone else holds it. The returned func
+// releases it.
+func lockArtefact(ctx context.Context, target string) (func(), error) {
+	waiting := false
+	for {
+		unlock, err := tryLock(target)
+		if !errors.Is(err, ErrLocked) {
+			return unlock, err
+		}
+		if !waiting {
+			path := target + ".lock"
+			fmt.Fprintf(os.Stderr, "waiting for another fetch of %s (pid %s holds %s)\n",
+				filepath.Base(target), lockHolder(path), path)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errs.Wrap(errs.Interrupted, ctx.Err())
+		case <-time.After(lockPoll):
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !unix
+
+package modelfetch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// tryLock creates `<target>.lock` O_EXCL, so exactly one process
+// wins, and keeps its mtime fresh while held. A lock file nobody has
+// refreshed for lockStale belongs to a process that died; it is
+// removed and the race re-run. Otherwise an existing lock file fails
+// with ErrLocked.
+func tryLock(target string) (func(), error) {
+	path := target + ".lock"
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			stop := make(chan struct{})
+			go func() {
+				t := time.NewTicker(lockStale / 3)
+				defer t.Stop()
+				for {
+					select {
+					case <-stop:
+						return
+					case now := <-t.C:
+						_ = os.Chtimes(path, now, now)
+					}
+				}
+			}()
+			return func() { close(stop); os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, errs.New(errs.Environment, "lock %s: %w", path, err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			fmt.Fprintf(os.Stderr, "removing a stale lock left by pid %s (%s, untouched for %s)\n",
+				lockHolder(path), path, time.Since(info.ModTime()).Round(time.Second))
+			os.Remove(path)
+			continue
+		}
+		return nil, ErrLocked
+	}
+}
@@ -0,0 +1,171 @@
+package modelfetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// slowServer serves artefact slowly enough that concurrent fetchers
+// genuinely overlap, and counts the downloads.
+func slowServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write(artefact)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// TestFetch_concurrent: eight goroutines miss the cache at once. One
+// downloads; the rest wait on the lock and come back with the cached
+// file.
+func TestFetch_concurrent(t *testing.T) {
+	srv, hits := slowServer(t)
+	dir := t.TempDir()
+	req := Request{
+		Name: "tiny", Variant: "fp16",
+		Dest:     filepath.Join(dir, "cache"),
+		Manifest: writeManifest(t, dir, srv.URL, artefact),
+		Events:   &bytes.Buffer{},
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	var downloaded atomic.Int32
+	errc := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := req
+			r.Events = &bytes.Buffer{} // not shared across goroutines
+			res, err := Fetch(context.Background(), r)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if !res.Cached {
+				downloaded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+	if h, d := hits.Load(), downloaded.Load(); h != 1 || d != 1 {
+		t.Fatalf("want exactly one download; server saw %d request(s), %d fetcher(s) downloaded", h, d)
+	}
+}
+
+// TestFetch_concurrentProcesses is the shared-build-host case for
+// real: separate processes (this test binary re-run as a helper), so
+// the lock is exercised across process boundaries, not just between
+// goroutines.
+func TestFetch_concurrentProcesses(t *testing.T) {
+	if os.Getenv("MODELFETCH_HELPER_REQ") != "" {
+		t.Skip("helper process")
+	}
+	srv, hits := slowServer(t)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "cache")
+	manifest := writeManifest(t, dir, srv.URL, artefact)
+
+	const n = 4
+	cmds := make([]*exec.Cmd, n)
+	for i := range cmds {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperFetch$")
+		cmd.Env = append(os.Environ(), "MODELFETCH_HELPER_REQ="+dest+"|"+manifest)
+		var out bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &out, &out
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds[i] = cmd
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("helper failed: %v\n%s", err, cmd.Stdout)
+		}
+	}
+	if h := hits.Load(); h != 1 {
+		t.Fatalf("%d processes caused %d downloads, want 1", n, h)
+	}
+}
+
+// TestHelperFetch is the child side of TestFetch_concurrentProcesses.
+func TestHelperFetch(t *testing.T) {
+	spec := os.Getenv("MODELFETCH_HELPER_REQ")
+	if spec == "" {
+		t.Skip("only runs as a helper process")
+	}
+	var dest, manifest string
+	for i := range spec {
+		if spec[i] == '|' {
+			dest, manifest = spec[:i], spec[i+1:]
+		}
+	}
+	_, err := Fetch(context.Background(), Request{
+		Name: "tiny", Variant: "fp16", Dest: dest, Manifest: manifest, Events: &bytes.Buffer{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLockArtefact_stale: a lock file left by a process that died
+// mid-fetch (PID inside, nobody holding it) must not block anyone.
+func TestLockArtefact_stale(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "m.onnx")
+	if err := os.WriteFile(target+".lock", []byte(fmt.Sprintln(1<<30)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Old enough for the non-flock fallback's staleness rule too.
+	old := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(target+".lock", old, old)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	unlock, err := lockArtefact(ctx, target)
+	if err != nil {
+		t.Fatalf("stale lock blocked the fetch: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(target + ".lock"); err == nil {
+		t.Error("lock file left behind after unlock")
+	}
+}
+
+// TestLockArtefact_waitIsCancellable: while the lock is held, a
+// second taker waits until its context ends, then reports an
+// interruption rather than hanging.
+func TestLockArtefact_waitIsCancellable(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "m.onnx")
+	unlock, err := lockArtefact(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	if _, err := lockArtefact(ctx, target); !errs.Is(err, errs.Interrupted) {
+		t.Fatalf("want an interrupted wait, got %v", err)
+	}
+}
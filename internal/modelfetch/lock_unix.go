@@ -0,0 +1,63 @@
+//go:build unix
+
+package modelfetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// tryLock makes one attempt at an exclusive flock on `<target>.lock`,
+// failing with ErrLocked while someone else holds it.
+func tryLock(target string) (func(), error) {
+	path := target + ".lock"
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, errs.New(errs.Environment, "lock %s: %w", path, err)
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		switch {
+		case err == nil && sameFile(f, path):
+			if prev := lockHolder(path); prev != "" {
+				// Whoever wrote this exited without releasing: a
+				// live holder would still have the flock.
+				fmt.Fprintf(os.Stderr, "taking over a stale lock left by pid %s (%s)\n", prev, path)
+			}
+			_ = f.Truncate(0)
+			_, _ = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			return func() {
+				// Unlink before releasing, so nobody can lock the
+				// old inode after we're done with it.
+				os.Remove(path)
+				f.Close()
+			}, nil
+		case err == nil:
+			// The holder we waited on unlinked the file as it let go;
+			// our lock is on an orphaned inode. Try again on the
+			// current one.
+			f.Close()
+			continue
+		case !errors.Is(err, syscall.EWOULDBLOCK):
+			f.Close()
+			return nil, errs.New(errs.Environment, "lock %s: %w", path, err)
+		}
+		f.Close()
+		return nil, ErrLocked
+	}
+}
+
+// sameFile reports whether the open f is still what path names.
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
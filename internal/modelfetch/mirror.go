@@ -0,0 +1,147 @@
+package modelfetch
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// Mirrors let a machine without GitHub access fetch artefacts from
+// somewhere else. Each mirror is a base location; an entry's filename
+// is looked up under every mirror, in order, before the manifest's
+// canonical URL. Three kinds:
+//
+//	https://models.internal/real-esrgan/   HTTP(S) directory
+//	file:///mnt/usb/models/                local directory
+//	file:///mnt/usb/real-esrgan-models.tar offline bundle (.tar, .tar.gz, .tgz)
+//
+// An offline bundle is any tarball holding the artefacts by filename
+// (directories inside it are ignored), e.g. `tar czf bundle.tgz -C
+// ~/.cache/real-esrgan-serve/models .` on a connected machine.
+//
+// Mirrors are untrusted: whatever they serve must match the manifest
+// SHA-256, --no-verify or not. A mirror that fails or serves the wrong
+// bytes is skipped in favour of the next source.
+
+// MirrorsEnv is the environment variable holding a comma-separated
+// mirror list, used when no --mirror flag is given.
+const MirrorsEnv = "REAL_ESRGAN_MIRRORS"
+
+// ParseMirrors splits a comma-separated mirror list, dropping blanks.
+func ParseMirrors(s string) []string {
+	var out []string
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// source is one place to fetch an artefact from.
+type source struct {
+	url    string
+	mirror bool // false = the manifest's canonical URL
+}
+
+// sourcesFor lists where to look for entry: every mirror, then the
+// canonical URL. A mirror that isn't a usable URL is a user error —
+// better to say so than to silently go to GitHub.
+func sourcesFor(mirrors []string, entry *models.Model) ([]source, error) {
+	var out []source
+	for _, m := range mirrors {
+		u, err := url.Parse(m)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return nil, errs.New(errs.User, "mirror %q: want an http://, https:// or file:// URL", m)
+		}
+		if entry.Placeholder() {
+			// Nothing to check a mirror's bytes against.
+			continue
+		}
+		if u.Scheme == "file" {
+			out = append(out, source{url: m, mirror: true})
+			continue
+		}
+		if isBundle(u.Path) {
+			return nil, errs.New(errs.User, "mirror %q: offline bundles must be local (file://)", m)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + entry.Filename
+		out = append(out, source{url: u.String(), mirror: true})
+	}
+	return append(out, source{url: entry.URL}), nil
+}
+
+func isBundle(p string) bool {
+	return strings.HasSuffix(p, ".tar") || strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// fetchLocal copies filename out of a file:// mirror — a directory or
+// an offline bundle — into part.
+func fetchLocal(mirror, filename, part string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return err
+	}
+	if isBundle(u.Path) {
+		return extractFromBundle(u.Path, filename, part)
+	}
+	src, err := os.Open(path.Join(u.Path, filename))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return copyTo(part, src)
+}
+
+// extractFromBundle finds filename in the tarball and writes it to part.
+func extractFromBundle(bundle, filename, part string) error {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(bundle, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", bundle, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s not in bundle %s", filename, bundle)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", bundle, err)
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == filename {
+			return copyTo(part, tr)
+		}
+	}
+}
+
+func copyTo(part string, r io.Reader) error {
+	discardPart(part) // a stale sidecar must not survive into a resume
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package modelfetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+)
+
+// countingServer serves body (or 404 when body is nil) and counts hits.
+func countingServer(t *testing.T, body []byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if body == nil {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// TestFetch_mirrors: mirrors are tried in order before the canonical
+// URL, and a missing or corrupt copy on one moves on to the next.
+func TestFetch_mirrors(t *testing.T) {
+	cases := []struct {
+		name          string
+		mirrors       [][]byte // per mirror: what it serves (nil = 404)
+		wantCanonical int32    // requests reaching the canonical URL
+	}{
+		{"first mirror has it", [][]byte{artefact, artefact}, 0},
+		{"404 then next mirror", [][]byte{nil, artefact}, 0},
+		{"corrupt mirror is skipped", [][]byte{bytes.ToUpper(artefact), artefact}, 0},
+		{"every mirror misses", [][]byte{nil, nil}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, canonHits := countingServer(t, artefact)
+			var mirrors []string
+			for _, body := range tc.mirrors {
+				srv, _ := countingServer(t, body)
+				mirrors = append(mirrors, srv.URL+"/models/")
+			}
+			dir := t.TempDir()
+			res, err := Fetch(context.Background(), Request{
+				Name: "tiny", Variant: "fp16",
+				Dest:     filepath.Join(dir, "cache"),
+				Manifest: writeManifest(t, dir, canonical.URL, artefact),
+				Mirrors:  mirrors,
+				Events:   &bytes.Buffer{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(res.Path); !bytes.Equal(got, artefact) {
+				t.Fatal("placed file differs from the artefact")
+			}
+			if n := canonHits.Load(); n != tc.wantCanonical {
+				t.Fatalf("canonical URL hit %d time(s), want %d", n, tc.wantCanonical)
+			}
+		})
+	}
+}
+
+// TestFetch_offline: file:// directories and bundles work with the
+// canonical URL unreachable — the air-gapped build farm case.
+func TestFetch_offline(t *testing.T) {
+	seed := t.TempDir()
+	if err := os.WriteFile(filepath.Join(seed, "tiny_fp16.onnx"), artefact, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	_ = tw.WriteHeader(&tar.Header{Name: "./models/tiny_fp16.onnx", Mode: 0o644, Size: int64(len(artefact)), Typeflag: tar.TypeReg})
+	_, _ = tw.Write(artefact)
+	_ = tw.Close()
+	_ = gz.Close()
+	bundle := filepath.Join(seed, "bundle.tgz")
+	if err := os.WriteFile(bundle, tgz.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mirror := range map[string]string{
+		"directory": "file://" + seed,
+		"bundle":    "file://" + bundle,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Nothing listens here; reaching it would fail the test.
+			canonical := "http://127.0.0.1:1/tiny_fp16.onnx"
+			_, err := Fetch(context.Background(), Request{
+				Name: "tiny", Variant: "fp16",
+				Dest:     filepath.Join(dir, "cache"),
+				Manifest: writeManifest(t, dir, canonical, artefact),
+				Mirrors:  []string{mirror},
+				Events:   &bytes.Buffer{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSourcesFor(t *testing.T) {
+	entry := &models.Model{Filename: "m.onnx", URL: "https://github.test/m.onnx", SHA256: "abc"}
+	got, err := sourcesFor([]string{"https://mirror.test/base", "file:///srv/models/"}, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://mirror.test/base/m.onnx", "file:///srv/models/", "https://github.test/m.onnx"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d sources, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].url != want[i] {
+			t.Errorf("source %d = %s, want %s", i, got[i].url, want[i])
+		}
+	}
+
+	for _, bad := range []string{"ftp://x/", "models.internal/x", "https://x/bundle.tgz"} {
+		if _, err := sourcesFor([]string{bad}, entry); err == nil {
+			t.Errorf("mirror %q should be rejected", bad)
+		}
+	}
+}
+
+func TestParseMirrors(t *testing.T) {
+	got := ParseMirrors(" https://a/ ,, file:///b ")
+	if len(got) != 2 || got[0] != "https://a/" || got[1] != "file:///b" {
+		t.Fatalf("ParseMirrors = %q", got)
+	}
+}
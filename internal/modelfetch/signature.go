@@ -0,0 +1,83 @@
+package modelfetch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// The per-entry SHA-256 only protects an artefact if the manifest
+// carrying it is authentic, so the manifest itself is signed. Every
+// MANIFEST.json ships with a detached `MANIFEST.json.sig`:
+//
+//	ed25519 <key-id> <base64 signature over the manifest bytes>
+//
+// and is only trusted if the signature verifies against one of the
+// public keys below, compiled into the binary. A manifest that is
+// unsigned, signed by an unknown key, or modified after signing is
+// refused unless the caller passes --allow-unsigned-manifest (local
+// development against an edited manifest).
+//
+// The signature covers the exact bytes on disk — no JSON
+// canonicalisation — so re-signing is required after any edit,
+// whitespace included. cmd/manifest-sign does both signing and key
+// generation; the release workflow signs with the
+// MANIFEST_SIGNING_KEY secret.
+
+// trustedKeys maps key ID → ed25519 public key. Rotation: add the new
+// key here and ship a release before signing with it, then drop the
+// old one a release later. A var, not a const table, so tests can
+// trust a throwaway key.
+//
+// Empty until the maintainers generate the release key
+// (`manifest-sign -keygen`), add its public half as
+//
+//	"<key-id>": mustKey("<base64 public key>"),
+//
+// and sign models/MANIFEST.json with it. Until then no manifest
+// verifies, and runs that read one need --allow-unsigned-manifest.
+var trustedKeys = map[string]ed25519.PublicKey{}
+
+func mustKey(b64 string) ed25519.PublicKey {
+	k, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(k) != ed25519.PublicKeySize {
+		panic("modelfetch: bad embedded public key " + b64)
+	}
+	return ed25519.PublicKey(k)
+}
+
+// sigAlg is the only algorithm tag a .sig line may carry.
+const sigAlg = "ed25519"
+
+// Sign returns the .sig file contents for body.
+func Sign(body []byte, keyID string, priv ed25519.PrivateKey) []byte {
+	sig := ed25519.Sign(priv, body)
+	return []byte(fmt.Sprintf("%s %s %s\n", sigAlg, keyID, base64.StdEncoding.EncodeToString(sig)))
+}
+
+// VerifySignature checks sig (a .sig file's contents) over body and
+// returns the ID of the trusted key that made it.
+func VerifySignature(body, sig []byte) (string, error) {
+	fields := strings.Fields(string(bytes.TrimSpace(sig)))
+	if len(fields) != 3 || fields[0] != sigAlg {
+		return "", fmt.Errorf("malformed signature file (want %q)", sigAlg+" <key-id> <base64>")
+	}
+	keyID := fields[1]
+	pub, ok := trustedKeys[keyID]
+	switch {
+	case !ok && len(trustedKeys) == 0:
+		return "", fmt.Errorf("signed by unknown key %q (this build trusts no signing key yet)", keyID)
+	case !ok:
+		return "", fmt.Errorf("signed by unknown key %q", keyID)
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed signature from key %q", keyID)
+	}
+	if !ed25519.Verify(pub, body, raw) {
+		return "", fmt.Errorf("signature from key %q does not match the manifest (modified after signing?)", keyID)
+	}
+	return keyID, nil
+}
@@ -0,0 +1,102 @@
+package modelfetch
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// testKey is trusted for the whole package's tests, so fixtures can
+// sign the manifests they write (signManifest) without the release key.
+var testKey = ed25519.NewKeyFromSeed([]byte("real-esrgan-serve test key 32 b!"))
+
+const testKeyID = "test"
+
+func init() {
+	trustedKeys[testKeyID] = testKey.Public().(ed25519.PublicKey)
+}
+
+// signManifest writes path.sig for path's current bytes.
+func signManifest(t *testing.T, path string) {
+	t.Helper()
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".sig", Sign(body, testKeyID, testKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLoadManifest_signature covers the trust decision: only an
+// untouched manifest signed by a compiled-in key loads by default;
+// --allow-unsigned-manifest lets the rest through.
+func TestLoadManifest_signature(t *testing.T) {
+	const body = `{"version":1,"models":[]}`
+	_, stranger, _ := ed25519.GenerateKey(nil)
+	cases := []struct {
+		name    string
+		sig     func(path string) // nil = no .sig
+		wantErr string            // "" = loads, signed by testKeyID
+	}{
+		{
+			name: "signed by a trusted key",
+			sig:  func(path string) { signManifest(t, path) },
+		},
+		{
+			name:    "unsigned",
+			wantErr: "unsigned",
+		},
+		{
+			name: "modified after signing",
+			sig: func(path string) {
+				signManifest(t, path)
+				_ = os.WriteFile(path, []byte(body+"\n"), 0o600)
+			},
+			wantErr: "does not match",
+		},
+		{
+			name: "signed by an unknown key",
+			sig: func(path string) {
+				_ = os.WriteFile(path+".sig", Sign([]byte(body), "stranger", stranger), 0o600)
+			},
+			wantErr: `unknown key "stranger"`,
+		},
+		{
+			name: "garbage signature file",
+			sig: func(path string) {
+				_ = os.WriteFile(path+".sig", []byte("hello"), 0o600)
+			},
+			wantErr: "malformed",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "MANIFEST.json")
+			if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if tc.sig != nil {
+				tc.sig(path)
+			}
+
+			_, src, err := LoadManifest(path, false)
+			if tc.wantErr == "" {
+				if err != nil || src.KeyID != testKeyID || src.Path != path {
+					t.Fatalf("want a load signed by %q from %s; got src=%+v err=%v", testKeyID, path, src, err)
+				}
+				return
+			}
+			if !errs.Is(err, errs.Integrity) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("want an integrity error mentioning %q, got %v", tc.wantErr, err)
+			}
+			if _, src, err := LoadManifest(path, true); err != nil || src.KeyID != "" {
+				t.Fatalf("--allow-unsigned-manifest should load it unattributed; src=%+v err=%v", src, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+// Package models owns the manifest types and the model cache: where
+// artefacts live on disk, which manifest entry a name refers to, and
+// which cached artefact a run should load (resolve.go).
+//
+// `fetch-model` writes the cache, `super-resolution` and `serve` read
+// it. All three go through CacheDir so they can't disagree about where
+// it is.
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+)
+
+// SchemaVersion is the manifest version this binary reads natively.
+// Older versions are upgraded by Parse (see migrate).
+const SchemaVersion = 2
+
+type Manifest struct {
+	Note    string  `json:"$schema_note,omitempty"` // free text for whoever edits the file
+	Version int     `json:"version"`
+	Models  []Model `json:"models"`
+}
+
+type Model struct {
+	Name       string `json:"name"`
+	Variant    string `json:"variant"`
+	GPUClass   string `json:"gpu_class,omitempty"`
+	SMArch     string `json:"sm_arch,omitempty"`
+	TRTVersion string `json:"trt_version,omitempty"`
+	Filename   string `json:"filename"`
+	URL        string `json:"url"`
+	SHA256     string `json:"sha256"`
+	Bytes      int64  `json:"bytes"`
+	License    string `json:"license"`
+	LicenseURL string `json:"license_url"`
+	Notes      string `json:"notes,omitempty"`
+
+	// v2: what the model is and what it needs to run.
+	Scale   int           `json:"scale"`             // native factor of one forward pass
+	Content string        `json:"content,omitempty"` // general | photo | anime
+	Input   InputLimits   `json:"input"`
+	Batch   *BatchProfile `json:"batch,omitempty"` // engines only: the profile they were built with
+	MinORT  string        `json:"min_onnxruntime,omitempty"`
+	MinCUDA string        `json:"min_cuda,omitempty"`
+}
+
+// InputLimits are the per-axis input sizes one forward pass accepts:
+// below MinDim is rejected, above MaxUntiledDim is tiled, above
+// MaxTiledDim is refused.
+type InputLimits struct {
+	MinDim        int `json:"min_dim"`
+	MaxUntiledDim int `json:"max_untiled_dim"`
+	MaxTiledDim   int `json:"max_tiled_dim"`
+}
+
+// BatchProfile is a TensorRT optimisation profile: the batch sizes it
+// accepts and the per-axis dimension it is tuned for / capped at.
+type BatchProfile struct {
+	Min    int `json:"min"`
+	Opt    int `json:"opt"`
+	Max    int `json:"max"`
+	OptDim int `json:"opt_dim"`
+	MaxDim int `json:"max_dim"`
+}
+
+// Parse decodes a manifest and upgrades it to SchemaVersion, so the
+// rest of the code only ever sees v2 fields filled in.
+func Parse(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if err := m.migrate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// migrate upgrades m in place. v1 entries carry no model metadata, but
+// every v1 manifest described Real-ESRGAN x4 artefacts exported and
+// built the one way build/ knows: 4x, the stock input limits, and
+// single-image engine profiles. v2 entries may leave the limits out;
+// they get the same defaults.
+func (m *Manifest) migrate() error {
+	switch m.Version {
+	case 1:
+		for i := range m.Models {
+			m.Models[i].Scale = int(imageinfo.NativeScale)
+		}
+		m.Version = SchemaVersion
+	case SchemaVersion:
+	default:
+		return fmt.Errorf("manifest schema version %d: this build reads versions 1 and %d — upgrade real-esrgan-serve", m.Version, SchemaVersion)
+	}
+	for i := range m.Models {
+		e := &m.Models[i]
+		if e.Scale == 0 {
+			return fmt.Errorf("manifest entry %s/%s: missing scale", e.Name, e.Variant)
+		}
+		if e.Content == "" {
+			e.Content = ContentGeneral
+		}
+		if e.Input == (InputLimits{}) {
+			e.Input = InputLimits{MinDim: sizing.MinDim, MaxUntiledDim: sizing.MaxUntiledDim, MaxTiledDim: sizing.MaxTiledDim}
+		}
+		if e.Batch == nil {
+			// build/compile_engine.py's two profile modes.
+			switch e.Variant {
+			case VariantEngine:
+				e.Batch = &BatchProfile{Min: 1, Opt: 1, Max: 1, OptDim: 720, MaxDim: sizing.MaxUntiledDim}
+			case VariantEngineBatched:
+				e.Batch = &BatchProfile{Min: 2, Opt: 4, Max: 4, OptDim: 512, MaxDim: 720}
+			}
+		}
+	}
+	return nil
+}
+
+// VariantEngineBatched is the batched-profile engine built alongside
+// each single-image one. The resolver never picks it; the RunPod
+// handler fetches it by name for --batched-model.
+const VariantEngineBatched = "engine-batched"
+
+// Content kinds a model can be tuned for.
+const (
+	ContentGeneral = "general"
+	ContentPhoto   = "photo"
+	ContentAnime   = "anime"
+)
+
+// Placeholder reports whether the entry's hash is unset or a
+// `REPLACE_…` stand-in, i.e. nothing can be verified against it.
+func (e *Model) Placeholder() bool {
+	return e.SHA256 == "" || strings.HasPrefix(e.SHA256, "REPLACE_")
+}
+
+// IsEngine reports whether e is a TensorRT engine (single-image or
+// batched), i.e. only loads on the SM arch it was built for.
+func (e *Model) IsEngine() bool {
+	return e.Variant == VariantEngine || e.Variant == VariantEngineBatched
+}
+
+// Limits is e's input bounds in the sizing planner's terms.
+func (e *Model) Limits() sizing.Limits {
+	return sizing.Limits{
+		MinDim:        e.Input.MinDim,
+		MaxUntiledDim: e.Input.MaxUntiledDim,
+		MaxTiledDim:   e.Input.MaxTiledDim,
+	}
+}
+
+// Runnable says why this build can't serve scale with e, or nil if it
+// can; scale 0 is target sizing, where the factor follows the input.
+// The sizing planner and runtime/upscaler.py chain native 4x passes
+// and resample the result, so a 4x model serves any factor: `--scale
+// 2` is a resample of one 4x pass. A model of another native scale
+// would be planned wrong, so nothing can be resampled from it here —
+// least of all a factor below its own — and it is refused rather than
+// silently mis-sized.
+func (e *Model) Runnable(scale float64) error {
+	switch {
+	case e.Scale == int(imageinfo.NativeScale):
+		return nil
+	case scale != 0 && scale < float64(e.Scale):
+		return fmt.Errorf("model %s is %dx; this build can't resample it down to %sx (it only chains %gx passes)",
+			e.Name, e.Scale, imageinfo.FormatScale(scale), imageinfo.NativeScale)
+	}
+	return fmt.Errorf("model %s is %dx; this build only drives %gx models (other scales resample the %gx output)",
+		e.Name, e.Scale, imageinfo.NativeScale, imageinfo.NativeScale)
+}
+
+// Filter selects manifest entries for Query and Find. Zero fields
+// match anything.
+type Filter struct {
+	Name    string
+	Variant string
+	Scale   int // native scale
+
+	// Hardware: CPU drops engines; SMArch (else GPUClass) keeps only
+	// the engines built for it. Unknown hardware keeps every engine.
+	Hardware Hardware
+}
+
+// Query returns every entry matching f, in manifest order.
+func (m *Manifest) Query(f Filter) []*Model {
+	var out []*Model
+	for i := range m.Models {
+		if e := &m.Models[i]; f.match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (f Filter) match(e *Model) bool {
+	switch {
+	case f.Name != "" && e.Name != f.Name,
+		f.Variant != "" && e.Variant != f.Variant,
+		f.Scale != 0 && e.Scale != f.Scale:
+		return false
+	}
+	if !e.IsEngine() {
+		return true
+	}
+	hw := f.Hardware
+	switch {
+	case hw.CPU,
+		hw.SMArch != "" && e.SMArch != hw.SMArch,
+		hw.SMArch == "" && hw.GPUClass != "" && e.GPUClass != hw.GPUClass:
+		return false
+	}
+	return true
+}
+
+// Find picks the first entry matching f. Asking for an engine variant
+// needs an SMArch or GPUClass: an engine only loads on the
+// architecture it was built for. SMArch is the preferred discriminator
+// because multiple GPUs share an SM (RTX 4090 + L40S + L4 are all sm89
+// → one engine works for all).
+func (m *Manifest) Find(f Filter) (*Model, error) {
+	hw := f.Hardware
+	if (f.Variant == VariantEngine || f.Variant == VariantEngineBatched) && hw.SMArch == "" && hw.GPUClass == "" {
+		return nil, fmt.Errorf("--sm-arch or --gpu-class required when --variant %s", f.Variant)
+	}
+	if found := m.Query(f); len(found) > 0 {
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("no manifest entry for %s — available: %s", f, m.summarise())
+}
+
+// String renders the set fields, e.g. "name=x variant=engine (sm-arch=sm89)".
+func (f Filter) String() string {
+	var parts []string
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	add("name", f.Name)
+	add("variant", f.Variant)
+	if f.Scale != 0 {
+		add("scale", fmt.Sprintf("%dx", f.Scale))
+	}
+	s := strings.Join(parts, " ")
+	if f.Variant == "" || f.Variant == VariantEngine || f.Variant == VariantEngineBatched {
+		if f.Hardware.SMArch != "" {
+			s += fmt.Sprintf(" (sm-arch=%s)", f.Hardware.SMArch)
+		} else if f.Hardware.GPUClass != "" {
+			s += fmt.Sprintf(" (gpu-class=%s)", f.Hardware.GPUClass)
+		}
+	}
+	return s
+}
+
+// has reports whether any entry is named name.
+func (m *Manifest) has(name string) bool {
+	for _, e := range m.Models {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manifest) summarise() string {
+	var sb strings.Builder
+	for i, e := range m.Models {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(e.Name)
+		sb.WriteString("/")
+		sb.WriteString(e.Variant)
+		if e.SMArch != "" {
+			sb.WriteString("@")
+			sb.WriteString(e.SMArch)
+		} else if e.GPUClass != "" {
+			sb.WriteString("@")
+			sb.WriteString(e.GPUClass)
+		}
+	}
+	return sb.String()
+}
+
+// CacheDir returns the model cache directory. Order: override (the
+// --dest flag), $XDG_CACHE_HOME/real-esrgan-serve/models,
+// ~/.cache/real-esrgan-serve/models. There is deliberately no
+// system-wide fallback: the container images set XDG_CACHE_HOME=/var/cache,
+// which lands on the same path through the rule above.
+func CacheDir(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
+		return filepath.Join(x, "real-esrgan-serve", "models"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locate home dir: %w", err)
+	}
+	return filepath.Join(home, ".cache", "real-esrgan-serve", "models"), nil
+}
+
+// AtLeast reports whether dotted version have is ≥ want, comparing
+// numerically component by component ("1.20.1" ≥ "1.18"). A missing
+// component counts as 0; a non-numeric one compares as 0 too, which
+// errs towards accepting.
+func AtLeast(have, want string) bool {
+	h, w := versionParts(have), versionParts(want)
+	for i := 0; i < max(len(h), len(w)); i++ {
+		var a, b int
+		if i < len(h) {
+			a = h[i]
+		}
+		if i < len(w) {
+			b = w[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
+
+// SameMinor reports whether two versions agree on major.minor —
+// TensorRT engines are portable across patch releases, not minor ones.
+func SameMinor(a, b string) bool {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < 2; i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func versionParts(v string) []int {
+	var out []int
+	for _, p := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, _ := strconv.Atoi(p)
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,545 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// fixture: a manifest covering both ONNX variants and an engine entry,
+// matching the shape that ships in models/MANIFEST.json. Tests construct
+// from this rather than reading the embedded copy so each case is
+// self-contained and the assertions don't track release-asset churn.
+func newTestManifest() *Manifest {
+	return &Manifest{
+		Version: 1,
+		Models: []Model{
+			{Name: "realesrgan-x4plus", Variant: "fp16", Filename: "realesrgan-x4plus_fp16.onnx",
+				URL: "https://example.test/x4plus_fp16.onnx", SHA256: "abc", Bytes: 100},
+			{Name: "realesrgan-x4plus", Variant: "fp32", Filename: "realesrgan-x4plus_fp32.onnx",
+				URL: "https://example.test/x4plus_fp32.onnx", SHA256: "def", Bytes: 200},
+			{Name: "realesrgan-x4plus", Variant: "engine", GPUClass: "rtx-4090", SMArch: "sm89",
+				TRTVersion: "10.1", Filename: "x4plus-rtx-4090-sm89-trt10.1_fp16.engine",
+				URL: "https://example.test/eng-4090.engine", SHA256: "111", Bytes: 300},
+			{Name: "realesrgan-x4plus", Variant: "engine", GPUClass: "rtx-3090", SMArch: "sm86",
+				TRTVersion: "10.1", Filename: "x4plus-rtx-3090-sm86-trt10.1_fp16.engine",
+				URL: "https://example.test/eng-3090.engine", SHA256: "222", Bytes: 300},
+		},
+	}
+}
+
+// TestManifestFind exercises the matching logic — the part of the file
+// that's pure logic with multiple branches (variant matching,
+// sm-arch preference over gpu-class, error paths). The hash/download
+// happy paths are covered separately because they exercise different
+// code (filesystem + http).
+func TestManifestFind(t *testing.T) {
+	m := newTestManifest()
+	cases := []struct {
+		name      string
+		variant   string
+		gpuClass  string
+		smArch    string
+		wantFile  string
+		wantError string
+	}{
+		{
+			name:    "fp16 by variant only",
+			variant: "fp16", wantFile: "realesrgan-x4plus_fp16.onnx",
+		},
+		{
+			name:    "fp32 by variant only",
+			variant: "fp32", wantFile: "realesrgan-x4plus_fp32.onnx",
+		},
+		{
+			name:    "engine by sm-arch (preferred discriminator)",
+			variant: "engine", smArch: "sm89",
+			wantFile: "x4plus-rtx-4090-sm89-trt10.1_fp16.engine",
+		},
+		{
+			name:    "engine by sm-arch picks 3090's matching engine",
+			variant: "engine", smArch: "sm86",
+			wantFile: "x4plus-rtx-3090-sm86-trt10.1_fp16.engine",
+		},
+		{
+			name:    "engine by gpu-class when no sm-arch given",
+			variant: "engine", gpuClass: "rtx-4090",
+			wantFile: "x4plus-rtx-4090-sm89-trt10.1_fp16.engine",
+		},
+		{
+			name:      "engine without sm-arch or gpu-class fails clearly",
+			variant:   "engine",
+			wantError: "--sm-arch or --gpu-class required",
+		},
+		{
+			name:      "batched engine needs an arch too",
+			variant:   "engine-batched",
+			wantError: "--sm-arch or --gpu-class required",
+		},
+		{
+			name:    "engine sm-arch with no matching entry returns not-found with hint",
+			variant: "engine", smArch: "sm70",
+			wantError: "(sm-arch=sm70)",
+		},
+		{
+			name:      "unknown variant is reported with available list",
+			variant:   "fp64",
+			wantError: "no manifest entry",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := m.Find(Filter{
+				Name:     "realesrgan-x4plus",
+				Variant:  tc.variant,
+				Hardware: Hardware{GPUClass: tc.gpuClass, SMArch: tc.smArch},
+			})
+			if tc.wantError != "" {
+				if err == nil {
+					t.Fatalf("expected error containing %q, got nil (file=%s)", tc.wantError, got.Filename)
+				}
+				if !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error %q does not contain %q", err.Error(), tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Filename != tc.wantFile {
+				t.Fatalf("got filename %s, want %s", got.Filename, tc.wantFile)
+			}
+		})
+	}
+}
+
+// TestManifestFind_smArchPrecedence covers the documented behaviour that
+// sm-arch wins over gpu-class when both are passed. Multiple GPUs share
+// an SM (4090 + L4 + L40S = sm89), so sm-arch is the right disambiguator
+// — gpu-class is a human-friendly fallback only.
+func TestManifestFind_smArchPrecedence(t *testing.T) {
+	m := newTestManifest()
+	// Caller passes both sm89 (matches 4090 entry) and gpu-class=rtx-3090
+	// (matches 3090 entry). sm-arch must win.
+	got, err := m.Find(Filter{
+		Name:     "realesrgan-x4plus",
+		Variant:  "engine",
+		Hardware: Hardware{GPUClass: "rtx-3090", SMArch: "sm89"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.GPUClass != "rtx-4090" {
+		t.Fatalf("sm-arch should have selected the 4090 entry; got gpu_class=%s", got.GPUClass)
+	}
+}
+
+// TestManifestQuery: each filter narrows on its own field, and the
+// hardware filter drops only engines.
+func TestManifestQuery(t *testing.T) {
+	m := &Manifest{Version: 2, Models: []Model{
+		{Name: "x4", Variant: "fp16", Scale: 4, Content: "general", MinORT: "1.18", Filename: "a"},
+		{Name: "x4", Variant: "engine", Scale: 4, Content: "general", SMArch: "sm89", GPUClass: "l40s", TRTVersion: "10.8", MinCUDA: "12.0", Filename: "b"},
+		{Name: "x4", Variant: "engine", Scale: 4, Content: "general", SMArch: "sm86", TRTVersion: "10.8", MinCUDA: "12.0", Filename: "c"},
+		{Name: "x2", Variant: "fp16", Scale: 2, Content: "general", Filename: "d"},
+		{Name: "anime", Variant: "fp32", Scale: 4, Content: "anime", Filename: "e"},
+	}}
+	cases := []struct {
+		name string
+		f    Filter
+		want string
+	}{
+		{name: "everything", f: Filter{}, want: "abcde"},
+		{name: "by scale", f: Filter{Scale: 2}, want: "d"},
+		{name: "CPU drops engines", f: Filter{Name: "x4", Hardware: Hardware{CPU: true}}, want: "a"},
+		{name: "sm arch keeps its engine", f: Filter{Name: "x4", Hardware: Hardware{SMArch: "sm86"}}, want: "ac"},
+		{name: "GPU class when the arch is unknown", f: Filter{Variant: "engine", Hardware: Hardware{GPUClass: "l40s"}}, want: "b"},
+		{name: "engines by variant", f: Filter{Variant: "engine"}, want: "bc"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			for _, e := range m.Query(tc.f) {
+				got += e.Filename
+			}
+			if got != tc.want {
+				t.Fatalf("Query(%+v) = %q, want %q", tc.f, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestParse_migrate: a v1 manifest loads as v2 with the metadata every
+// v1 artefact had; versions this build doesn't know are refused.
+func TestParse_migrate(t *testing.T) {
+	m, err := Parse([]byte(`{"version":1,"models":[
+		{"name":"realesrgan-x4plus","variant":"fp16","filename":"a.onnx"},
+		{"name":"realesrgan-x4plus","variant":"engine","sm_arch":"sm89","filename":"b.engine"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != SchemaVersion {
+		t.Errorf("version = %d, want %d", m.Version, SchemaVersion)
+	}
+	onnx, engine := m.Models[0], m.Models[1]
+	if onnx.Scale != 4 || onnx.Content != ContentGeneral || onnx.Input.MinDim != 64 || onnx.Input.MaxTiledDim != 4096 {
+		t.Errorf("v1 onnx entry migrated to %+v", onnx)
+	}
+	if onnx.Batch != nil {
+		t.Errorf("onnx entry got an engine batch profile: %+v", onnx.Batch)
+	}
+	if engine.Batch == nil || engine.Batch.Max != 1 || engine.Batch.MaxDim != 1280 {
+		t.Errorf("v1 engine entry migrated to batch %+v", engine.Batch)
+	}
+	if err := onnx.Runnable(0); err != nil {
+		t.Errorf("migrated x4 entry not runnable: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"version":3,"models":[]}`,
+		`{"version":2,"models":[{"name":"x","variant":"fp16","filename":"x.onnx"}]}`, // no scale
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%s): want an error", bad)
+		}
+	}
+}
+
+// TestRunnable: a 4x model serves any factor, below 4 as a resample
+// of one pass. A model of another native scale can't be driven by the
+// 4x pipeline, nor resampled below its scale.
+func TestRunnable(t *testing.T) {
+	x4, x2 := &Model{Name: "x4", Scale: 4}, &Model{Name: "x2", Scale: 2}
+	cases := []struct {
+		m     *Model
+		scale float64
+		want  string // in the error; "" = runnable
+	}{
+		{x4, 0, ""},
+		{x4, 4, ""},
+		{x4, 6, ""},
+		{x4, 16, ""},
+		{x4, 3, ""},
+		{x4, 2, ""},
+		{x4, 1.5, ""},
+		{x2, 1.5, "can't resample it down to 1.5x"},
+		{x2, 2, "only drives 4x models"},
+		{x2, 0, "only drives 4x models"},
+	}
+	for _, tc := range cases {
+		err := tc.m.Runnable(tc.scale)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s.Runnable(%v) = %v, want %q", tc.m.Name, tc.scale, err, tc.want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	for _, c := range []struct {
+		have, want string
+		atLeast    bool
+	}{
+		{"1.20.1", "1.18", true},
+		{"1.18", "1.18.0", true},
+		{"1.9", "1.18", false},
+		{"12.4", "12.8", false},
+		{"v13.0", "12.8", true},
+	} {
+		if got := AtLeast(c.have, c.want); got != c.atLeast {
+			t.Errorf("AtLeast(%s, %s) = %v", c.have, c.want, got)
+		}
+	}
+	if !SameMinor("10.8", "10.8.0.43") || SameMinor("10.8", "10.9") || SameMinor("10.8", "11.8") {
+		t.Error("SameMinor compares major.minor only")
+	}
+}
+
+// TestVerify covers the hash-verification primitive. The interesting
+// branches are the mismatch case (returns the actual hash for the error
+// message) and the missing-file case (returns false without panicking).
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "ok.bin")
+	content := []byte("the quick brown fox")
+	if err := os.WriteFile(good, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	expected := hex.EncodeToString(sum[:])
+
+	if ok, got := Verify(good, expected); !ok {
+		t.Fatalf("correct hash should match; got=%s expected=%s", got, expected)
+	}
+	if ok, got := Verify(good, "wrongprefix"+expected[len("wrongprefix"):]); ok {
+		t.Fatalf("wrong hash should not match; got=%s", got)
+	}
+	if ok, _ := Verify(filepath.Join(dir, "missing.bin"), expected); ok {
+		t.Fatal("missing file should not match")
+	}
+}
+
+// TestCacheDir exercises the cache-dir resolution priority: explicit
+// override → XDG_CACHE_HOME → $HOME/.cache. The override path is the one
+// the runpod handler hits in production (handler.py builds it from
+// $XDG_CACHE_HOME/real-esrgan-serve/models), so getting that wrong
+// would silently relocate every model to ~/.cache.
+func TestCacheDir(t *testing.T) {
+	t.Run("explicit override wins over env", func(t *testing.T) {
+		t.Setenv("XDG_CACHE_HOME", "/should/not/win")
+		got, err := CacheDir("/explicit/override")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "/explicit/override" {
+			t.Fatalf("got %s, want /explicit/override", got)
+		}
+	})
+	t.Run("XDG_CACHE_HOME used when no override", func(t *testing.T) {
+		t.Setenv("XDG_CACHE_HOME", "/var/cache")
+		got, err := CacheDir("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := filepath.Join("/var/cache", "real-esrgan-serve", "models")
+		if got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	})
+	t.Run("falls back to home/.cache when XDG unset", func(t *testing.T) {
+		t.Setenv("XDG_CACHE_HOME", "")
+		t.Setenv("HOME", "/home/test")
+		got, err := CacheDir("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := filepath.Join("/home/test", ".cache", "real-esrgan-serve", "models")
+		if got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	})
+}
+
+// TestVerify_stamp: a successful check is remembered, and the memo
+// dies with any change to the file — a stamp must never vouch for
+// bytes it wasn't written against.
+func TestVerify_stamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.onnx")
+	content := []byte("weights")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:])
+
+	if ok, got := Verify(path, want); !ok || got != want {
+		t.Fatalf("first Verify should hash the file; ok=%v got=%q", ok, got)
+	}
+	if ok, got := Verify(path, want); !ok || got != "" {
+		t.Fatalf("second Verify should be a stamp hit; ok=%v got=%q", ok, got)
+	}
+
+	// Same size, new bytes, new mtime: the stamp no longer applies.
+	if err := os.WriteFile(path, []byte("WEIGHTS"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Verify(path, want); ok {
+		t.Fatal("stamp vouched for a modified file")
+	}
+}
+
+// cacheFixture writes the named variants of realesrgan-x4plus into a
+// temp cache and returns a manifest whose hashes match them. corrupt
+// lists variants written with bytes that don't match.
+func cacheFixture(t *testing.T, present []string, corrupt ...string) (*Manifest, string) {
+	t.Helper()
+	dir := t.TempDir()
+	mf := newTestManifest()
+	for i := range mf.Models {
+		e := &mf.Models[i]
+		body := []byte(e.Filename)
+		sum := sha256.Sum256(body)
+		e.SHA256 = hex.EncodeToString(sum[:])
+		for _, v := range present {
+			if v != e.Variant {
+				continue
+			}
+			for _, c := range corrupt {
+				if c == v {
+					body = []byte("garbage")
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, e.Filename), body, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return mf, dir
+}
+
+// TestResolve covers the preference order and the reasons each
+// candidate was passed over.
+func TestResolve(t *testing.T) {
+	sm89 := Hardware{SMArch: "sm89"}
+	cases := []struct {
+		name     string
+		present  []string
+		corrupt  []string
+		variant  string
+		provider string
+		hw       Hardware
+		wantFile string // "" = expect ErrNotCached
+		wantNote string // substring of Reason
+	}{
+		{
+			name:    "engine beats fp16 when the arch matches",
+			present: []string{"engine", "fp16", "fp32"}, hw: sm89,
+			wantFile: "x4plus-rtx-4090-sm89-trt10.1_fp16.engine",
+		},
+		{
+			name:     "unknown arch skips engines",
+			present:  []string{"engine", "fp16"},
+			wantFile: "realesrgan-x4plus_fp16.onnx", wantNote: "GPU architecture unknown",
+		},
+		{
+			name:    "CPU run skips engines",
+			present: []string{"engine", "fp16"}, hw: Hardware{CPU: true, SMArch: "sm89"},
+			wantFile: "realesrgan-x4plus_fp16.onnx", wantNote: "CPU run",
+		},
+		{
+			name:    "no engine for this arch in the manifest",
+			present: []string{"fp16"}, hw: Hardware{SMArch: "sm70"},
+			wantFile: "realesrgan-x4plus_fp16.onnx", wantNote: "no engine in manifest for sm70",
+		},
+		{
+			name:    "fp32 when fp16 isn't cached",
+			present: []string{"fp32"}, hw: sm89,
+			wantFile: "realesrgan-x4plus_fp32.onnx", wantNote: "fp16: not in cache",
+		},
+		{
+			name:    "corrupt fp16 falls through to fp32",
+			present: []string{"fp16", "fp32"}, corrupt: []string{"fp16"},
+			wantFile: "realesrgan-x4plus_fp32.onnx", wantNote: "sha256 mismatch",
+		},
+		{
+			name:    "explicit variant doesn't fall back",
+			present: []string{"fp32"}, variant: "fp16",
+			wantNote: "fp16: not in cache",
+		},
+		{
+			name:     "nothing cached",
+			wantNote: "fp32: not in cache",
+		},
+		{
+			name:    "cuda rules engines out",
+			present: []string{"engine", "fp16"}, hw: sm89, provider: ProviderCUDA,
+			wantFile: "realesrgan-x4plus_fp16.onnx", wantNote: "--provider cuda runs .onnx only",
+		},
+		{
+			name:    "tensorrt takes only an engine",
+			present: []string{"fp16"}, hw: sm89, provider: ProviderTensorRT,
+			wantNote: "engine: not in cache",
+		},
+		{
+			name:    "trt is tensorrt",
+			present: []string{"engine", "fp16"}, hw: sm89, provider: "trt",
+			wantFile: "x4plus-rtx-4090-sm89-trt10.1_fp16.engine",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mf, dir := cacheFixture(t, tc.present, tc.corrupt...)
+			res, err := Resolve(mf, Query{Name: "realesrgan-x4plus", Variant: tc.variant, Provider: tc.provider, Hardware: tc.hw, Dir: dir})
+			if tc.wantFile == "" {
+				if !errors.Is(err, ErrNotCached) {
+					t.Fatalf("expected ErrNotCached, got %v (path=%s)", err, res.Path)
+				}
+				if !strings.Contains(err.Error(), tc.wantNote) {
+					t.Fatalf("error %q does not explain %q", err, tc.wantNote)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Base(res.Path) != tc.wantFile {
+				t.Fatalf("picked %s, want %s (reason: %s)", filepath.Base(res.Path), tc.wantFile, res.Reason)
+			}
+			if !strings.Contains(res.Reason, tc.wantNote) {
+				t.Fatalf("reason %q does not mention %q", res.Reason, tc.wantNote)
+			}
+		})
+	}
+}
+
+// TestResolve_lazyHash: with fp16 good and fp32 also cached, only
+// the chosen file is hashed — fp32 gets no stamp.
+func TestResolve_lazyHash(t *testing.T) {
+	mf, dir := cacheFixture(t, []string{"fp16", "fp32"})
+	if _, err := Resolve(mf, Query{Name: "realesrgan-x4plus", Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "realesrgan-x4plus_fp16.onnx"+StampSuffix)); err != nil {
+		t.Error("the selected artefact should be stamped")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "realesrgan-x4plus_fp32.onnx"+StampSuffix)); err == nil {
+		t.Error("fp32 was hashed although fp16 was picked")
+	}
+}
+
+func TestResolve_userErrors(t *testing.T) {
+	mf := newTestManifest()
+	if _, err := Resolve(mf, Query{Name: "nope"}); err == nil || !strings.Contains(err.Error(), "unknown model") {
+		t.Fatalf("unknown name: got %v", err)
+	}
+	if _, err := Resolve(mf, Query{Name: "realesrgan-x4plus", Variant: "int8"}); err == nil || !strings.Contains(err.Error(), "want auto") {
+		t.Fatalf("bad variant: got %v", err)
+	}
+	for _, q := range []Query{
+		{Provider: "rocm"},
+		{Provider: ProviderTensorRT, Variant: VariantFP16},
+		{Provider: ProviderCUDA, Variant: VariantEngine},
+		{Provider: ProviderCUDA, Hardware: Hardware{CPU: true}},
+	} {
+		q.Name = "realesrgan-x4plus"
+		if _, err := Resolve(mf, q); !errs.Is(err, errs.User) || !strings.Contains(err.Error(), "--provider") {
+			t.Errorf("%+v: got %v", q, err)
+		}
+	}
+}
+
+// TestProviderAlias: "trt", as the helper, the RunPod handler and
+// Dockerfile.trt spell it, is --provider tensorrt everywhere.
+func TestProviderAlias(t *testing.T) {
+	if err := ValidateProvider("trt"); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if err := ValidateProvider("rocm"); !errs.Is(err, errs.User) {
+		t.Fatalf("rocm: got %v", err)
+	}
+	if got := CanonicalProvider("trt"); got != ProviderTensorRT {
+		t.Errorf("canonical: %q", got)
+	}
+	if got := CanonicalProvider(ProviderCUDA); got != ProviderCUDA {
+		t.Errorf("canonical cuda: %q", got)
+	}
+	if got, err := HelperProvider("x4.engine", "trt"); err != nil || got != "trt" {
+		t.Errorf("helper provider for an engine: %q, %v", got, err)
+	}
+	if _, err := HelperProvider("x4.onnx", "trt"); !errs.Is(err, errs.User) || !strings.Contains(err.Error(), ".engine") {
+		t.Errorf("helper provider for an .onnx: %v", err)
+	}
+	if got := FetchVariant(VariantAuto, "trt"); got != VariantEngine {
+		t.Errorf("fetch variant: %q", got)
+	}
+}
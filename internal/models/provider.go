@@ -0,0 +1,107 @@
+package models
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// Execution providers, as given to --provider. auto leaves the choice
+// to the helper (TensorRT > CUDA > CPU, whichever onnxruntime has);
+// the others are strict: the helper fails rather than fall back.
+const (
+	ProviderAuto     = "auto"
+	ProviderCUDA     = "cuda"
+	ProviderTensorRT = "tensorrt"
+	ProviderCPU      = "cpu"
+)
+
+// Providers lists every --provider value.
+var Providers = []string{ProviderAuto, ProviderCUDA, ProviderTensorRT, ProviderCPU}
+
+// providerAliases are the other spellings --provider takes: the
+// helper, the RunPod handler and Dockerfile.trt's
+// REAL_ESRGAN_PROVIDER call TensorRT "trt".
+var providerAliases = map[string]string{"trt": ProviderTensorRT}
+
+// CanonicalProvider is provider with an alias replaced by the name it
+// stands for. Commands call it once validated, so the rest compare
+// against the constants above.
+func CanonicalProvider(provider string) string {
+	if p, ok := providerAliases[provider]; ok {
+		return p
+	}
+	return provider
+}
+
+// ValidateProvider refuses a --provider value this build doesn't have.
+func ValidateProvider(provider string) error {
+	if provider != "" && !slices.Contains(Providers, CanonicalProvider(provider)) {
+		return errs.New(errs.User, "--provider %q: want %s", provider, strings.Join(Providers, " | "))
+	}
+	return nil
+}
+
+// checkProvider refuses a query whose provider can't run its variant
+// or its hardware. tensorrt is the helper's TRT-direct path, which
+// loads engines only; cuda and cpu go through onnxruntime, which loads
+// .onnx only.
+func checkProvider(q Query) error {
+	if err := ValidateProvider(q.Provider); err != nil {
+		return err
+	}
+	switch q.Provider {
+	case ProviderTensorRT:
+		if q.Variant == VariantFP16 || q.Variant == VariantFP32 {
+			return errs.New(errs.User, "--provider tensorrt runs .engine artefacts; --variant %s is an .onnx one (use --variant engine or auto)", q.Variant)
+		}
+	case ProviderCUDA, ProviderCPU:
+		if q.Variant == VariantEngine {
+			return errs.New(errs.User, "--provider %s runs .onnx artefacts; --variant engine needs --provider tensorrt or auto", q.Provider)
+		}
+	}
+	if q.Hardware.CPU && (q.Provider == ProviderCUDA || q.Provider == ProviderTensorRT) {
+		return errs.New(errs.User, "--provider %s needs a GPU; --gpu-id -1 runs on the CPU", q.Provider)
+	}
+	return nil
+}
+
+// HelperProvider is the helper's --provider for running the artefact
+// at path on provider, "" to leave the helper its own auto. The
+// helper calls TensorRT "trt"; an engine only loads on that path.
+func HelperProvider(path, provider string) (string, error) {
+	if err := ValidateProvider(provider); err != nil {
+		return "", err
+	}
+	provider = CanonicalProvider(provider)
+	engine := filepath.Ext(path) == ".engine"
+	switch provider {
+	case "", ProviderAuto:
+		return ProviderFor(path), nil
+	case ProviderTensorRT:
+		if !engine {
+			return "", errs.New(errs.User, "--provider tensorrt loads .engine artefacts; %s is not one", filepath.Base(path))
+		}
+		return "trt", nil
+	}
+	if engine {
+		return "", errs.New(errs.User, "--provider %s runs .onnx artefacts; %s is a TensorRT engine (use --provider tensorrt or auto)", provider, filepath.Base(path))
+	}
+	return provider, nil
+}
+
+// FetchVariant is what --auto-fetch downloads for a --variant
+// preference: that variant when one was named; for auto, an engine
+// when --provider tensorrt needs one, else fp16 — it runs everywhere,
+// engines only on the GPU they were built for.
+func FetchVariant(variant, provider string) string {
+	switch {
+	case variant != "" && variant != VariantAuto:
+		return variant
+	case CanonicalProvider(provider) == ProviderTensorRT:
+		return VariantEngine
+	}
+	return VariantFP16
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+)
+
+// Resolve answers "which file should this run load?". Given a model
+// name, a variant preference and what is known about the GPU, it
+// walks the candidates best-first and returns the first one that is
+// cached and matches its manifest hash:
+//
+//  1. a TensorRT engine built for this GPU's SM arch (fastest, but
+//     only usable on exactly that architecture)
+//  2. fp16 .onnx
+//  3. fp32 .onnx
+//
+// Hashes are checked lazily: only the candidate about to be picked is
+// verified (and that result is stamped, see verify.go), so a cache
+// holding every variant costs one hash at most. A candidate failing
+// its hash is passed over, not fatal — the next one may be fine.
+//
+// Every candidate looked at lands in Resolution.Considered with the
+// reason it was or wasn't taken; callers print that with --json-events
+// so "why is it running fp32?" has an answer in the logs.
+
+// Variant preferences accepted by Query.Variant.
+const (
+	VariantAuto   = "auto"
+	VariantEngine = "engine"
+	VariantFP16   = "fp16"
+	VariantFP32   = "fp32"
+)
+
+// ErrNotCached is returned (wrapped) when no candidate is usable.
+// Callers with --auto-fetch check for it before downloading.
+var ErrNotCached = errors.New("no usable cached artefact")
+
+// Hardware is what the caller knows about the device the helper will
+// run on. Zero value = a GPU of unknown architecture, which rules out
+// engines but nothing else.
+type Hardware struct {
+	CPU      bool   // --gpu-id -1: no GPU, so no engine
+	SMArch   string // e.g. "sm89"; preferred over GPUClass, as in Find
+	GPUClass string // e.g. "rtx-4090"
+}
+
+// HardwareFor is the Hardware of a run on CUDA device gpuID (-1 =
+// CPU). An unset smArch is detected with nvidia-smi, but only when
+// variant could pick an engine: fp16/fp32 runs don't pay for the
+// query. A failed detection leaves it unknown.
+func HardwareFor(ctx context.Context, gpuID int, smArch, variant string) Hardware {
+	hw := Hardware{CPU: gpuID < 0, SMArch: smArch}
+	if !hw.CPU && hw.SMArch == "" && (variant == "" || variant == VariantAuto || variant == VariantEngine) {
+		hw.SMArch = gpu.SMArch(ctx, nil, gpuID)
+	}
+	return hw
+}
+
+// Query is one resolution request.
+type Query struct {
+	Name     string
+	Variant  string // "" or "auto" = best available; otherwise only that variant
+	Provider string // --provider; "" = auto. tensorrt needs an engine, cuda and cpu rule engines out
+	Hardware Hardware
+	Dir      string // cache dir; "" = CacheDir default
+}
+
+// Candidate is one artefact the resolver looked at.
+type Candidate struct {
+	Variant  string `json:"variant"`
+	Filename string `json:"filename,omitempty"`
+	Status   string `json:"status"` // selected | missing | hash_mismatch | unverifiable | skipped
+	Note     string `json:"note,omitempty"`
+}
+
+// Resolution is the resolver's answer.
+type Resolution struct {
+	Path       string      `json:"path"`
+	Model      Model       `json:"-"`
+	Variant    string      `json:"variant"`
+	Reason     string      `json:"reason"`
+	Considered []Candidate `json:"considered"`
+}
+
+// ProviderFor is the helper --provider an artefact needs: engines only
+// load on the TRT-direct path, .onnx files ("") leave it to the helper.
+func ProviderFor(path string) string {
+	if filepath.Ext(path) == ".engine" {
+		return "trt"
+	}
+	return ""
+}
+
+// Resolve picks the best cached artefact for q. On a miss the error
+// wraps ErrNotCached and the returned Resolution still lists what was
+// considered. An unknown model name or variant is a user error.
+func Resolve(mf *Manifest, q Query) (*Resolution, error) {
+	if !mf.has(q.Name) {
+		return nil, errs.New(errs.User, "unknown model %q — available: %s", q.Name, mf.summarise())
+	}
+	order := []string{VariantEngine, VariantFP16, VariantFP32}
+	switch q.Variant {
+	case "", VariantAuto:
+	case VariantEngine, VariantFP16, VariantFP32:
+		order = []string{q.Variant}
+	default:
+		return nil, errs.New(errs.User, "variant %q: want auto | engine | fp16 | fp32", q.Variant)
+	}
+	q.Provider = CanonicalProvider(q.Provider)
+	if err := checkProvider(q); err != nil {
+		return nil, err
+	}
+	if q.Provider == ProviderTensorRT {
+		order = []string{VariantEngine}
+	}
+	dir, err := CacheDir(q.Dir)
+	if err != nil {
+		return nil, errs.Wrap(errs.Environment, err)
+	}
+
+	res := &Resolution{}
+	for _, variant := range order {
+		c, entry := consider(mf, q, dir, variant)
+		res.Considered = append(res.Considered, c)
+		if c.Status != "selected" {
+			continue
+		}
+		res.Path = filepath.Join(dir, entry.Filename)
+		res.Model = *entry
+		res.Variant = variant
+		res.Reason = res.explain()
+		return res, nil
+	}
+	return res, fmt.Errorf("%w for %s in %s (%s)", ErrNotCached, q.Name, dir, res.explain())
+}
+
+// consider checks one variant, hashing it only if everything else
+// says it's usable.
+func consider(mf *Manifest, q Query, dir, variant string) (Candidate, *Model) {
+	c := Candidate{Variant: variant}
+	hw := q.Hardware
+	if variant == VariantEngine {
+		switch {
+		case q.Provider == ProviderCUDA || q.Provider == ProviderCPU:
+			c.Status, c.Note = "skipped", "--provider "+q.Provider+" runs .onnx only"
+			return c, nil
+		case hw.CPU:
+			c.Status, c.Note = "skipped", "CPU run; engines need a GPU"
+			return c, nil
+		case hw.SMArch == "" && hw.GPUClass == "":
+			c.Status, c.Note = "skipped", "GPU architecture unknown (nvidia-smi unavailable; pass --sm-arch)"
+			return c, nil
+		}
+	}
+	entry, err := mf.Find(Filter{Name: q.Name, Variant: variant, Hardware: hw})
+	if err != nil {
+		c.Status, c.Note = "skipped", "not in manifest"
+		if variant == VariantEngine {
+			c.Note = "no engine in manifest for " + firstNonEmpty(hw.SMArch, hw.GPUClass)
+		}
+		return c, nil
+	}
+	c.Filename = entry.Filename
+	path := filepath.Join(dir, entry.Filename)
+	if _, err := os.Stat(path); err != nil {
+		c.Status, c.Note = "missing", "not in cache"
+		return c, nil
+	}
+	if entry.Placeholder() {
+		c.Status, c.Note = "unverifiable", "manifest hash is a placeholder"
+		return c, nil
+	}
+	if ok, got := Verify(path, entry.SHA256); !ok {
+		c.Status, c.Note = "hash_mismatch", "sha256 mismatch (got "+got+"), re-fetch it"
+		return c, nil
+	}
+	c.Status = "selected"
+	return c, entry
+}
+
+// explain renders Considered as one line, e.g.
+// "fp16 (engine: GPU architecture unknown (pass --sm-arch))".
+func (r *Resolution) explain() string {
+	var passed []string
+	for _, c := range r.Considered {
+		if c.Status == "selected" {
+			continue
+		}
+		passed = append(passed, c.Variant+": "+c.Note)
+	}
+	if r.Variant == "" {
+		return strings.Join(passed, "; ")
+	}
+	if len(passed) == 0 {
+		return r.Variant
+	}
+	return r.Variant + " (" + strings.Join(passed, "; ") + ")"
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
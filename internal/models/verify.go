@@ -0,0 +1,83 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Hashing a 64 MB .onnx on every `super-resolution` run would cost
+// more than small inputs take to upscale, so a successful check is
+// remembered in a `<file>.verified` stamp recording the hash and the
+// file's size + mtime at the time. A stamp only vouches for the file
+// it was written against: touch, truncate or replace the artefact and
+// the next Verify hashes it again.
+
+// StampSuffix is appended to an artefact's path for its stamp.
+const StampSuffix = ".verified"
+
+type stamp struct {
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime_ns"`
+}
+
+// HashFile returns the hex SHA-256 of path.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify reports whether path hashes to want, trusting a matching
+// stamp instead of re-reading the file. got is the actual hash when
+// the file was read ("" on a stamp hit or a read failure). A fresh
+// match writes the stamp; failing to write it only costs a re-hash
+// next time.
+func Verify(path, want string) (ok bool, got string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, ""
+	}
+	if s, err := readStamp(path); err == nil && s.SHA256 == want &&
+		s.Size == info.Size() && s.ModTime == info.ModTime().UnixNano() {
+		return true, ""
+	}
+	got, err = HashFile(path)
+	if err != nil || got != want {
+		return false, got
+	}
+	_ = MarkVerified(path, want)
+	return true, got
+}
+
+// MarkVerified records that path (as it is now) hashes to sum. Fetch
+// calls it after checking a download, so the first run that loads the
+// artefact doesn't hash it a second time.
+func MarkVerified(path, sum string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(stamp{SHA256: sum, Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+	return os.WriteFile(path+StampSuffix, b, 0o644)
+}
+
+func readStamp(path string) (stamp, error) {
+	var s stamp
+	b, err := os.ReadFile(path + StampSuffix)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
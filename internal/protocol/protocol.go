@@ -0,0 +1,155 @@
+// Package protocol is the JSONL contract between the Go side and an
+// inference helper in serve mode: runtime/upscaler.py --serve, or
+// anything else that speaks it.
+//
+// The helper writes one ready event once its model is loaded, then
+// answers each frame read from stdin with a done, error or cancelled
+// event carrying the frame's id (progress events may come first).
+// Frames are processed in order; a failed frame does not end the
+// session, EOF on stdin does.
+//
+// The ready event carries a protocol version and a capability list.
+// The version changes only when an existing frame or event changes
+// incompatibly, and both sides must agree on it exactly. Optional
+// features are capabilities instead: a helper advertises what it
+// implements and the caller gates on it, so a feature can be added
+// without a version bump. protocoltest drives any helper through the
+// contract.
+package protocol
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Version is the protocol version this build speaks. Keep it in
+// lockstep with PROTOCOL_VERSION in runtime/upscaler.py.
+const Version = 1
+
+// Capabilities a helper may advertise.
+const (
+	// CapTile: frames with "tile": true take the slice/blend path,
+	// for inputs beyond the model's single-shot size.
+	CapTile = "tile"
+	// CapBatched: frames with "inputs" / "outputs" lists, answered by
+	// one done event with per-item results.
+	CapBatched = "batched"
+	// CapInlineIO: frames with "input_b64", answered with
+	// "output_b64", for a helper that shares no filesystem.
+	CapInlineIO = "inline_io"
+	// CapCancel: Cancel control frames. A queued job is dropped, a
+	// running one stops at its next tile, pass or batch item; either
+	// way it is answered with a cancelled event instead of done. A
+	// cancel for an id that isn't queued or running is ignored.
+	CapCancel = "cancel"
+)
+
+// Known lists every capability this build understands.
+var Known = []string{CapTile, CapBatched, CapInlineIO, CapCancel}
+
+// Event types.
+const (
+	EventReady     = "ready"
+	EventDone      = "done"
+	EventError     = "error"
+	EventCancelled = "cancelled"
+)
+
+// Frame is one job on the helper's stdin. Exactly one of Input,
+// Inputs or InputB64 is set. Zero OutScale / Resample and a nil
+// Passes mean the helper's process-wide default (native 4×, one pass).
+type Frame struct {
+	ID string `json:"id"`
+
+	Input  string `json:"input,omitempty"`
+	Output string `json:"output,omitempty"`
+
+	Inputs  []string `json:"inputs,omitempty"` // CapBatched
+	Outputs []string `json:"outputs,omitempty"`
+
+	InputB64     string `json:"input_b64,omitempty"` // CapInlineIO
+	OutputFormat string `json:"output_format,omitempty"`
+
+	OutScale float64 `json:"outscale,omitempty"`
+	Resample string  `json:"resample,omitempty"`
+	Passes   *int    `json:"passes,omitempty"`
+	Tile     bool    `json:"tile,omitempty"` // CapTile
+}
+
+// Cancel is the control frame asking the helper to stop job ID.
+type Cancel struct {
+	ID string `json:"cancel"`
+}
+
+// Event is one line of the helper's stdout. The ready-only fields are
+// grouped at the end.
+type Event struct {
+	Event string `json:"event"`
+	ID    string `json:"id,omitempty"`
+	Msg   string `json:"msg,omitempty"`
+	// ElapsedMS is the helper's time on the job, on done and
+	// cancelled events; 0 when the helper doesn't report it.
+	ElapsedMS int64 `json:"elapsed_ms,omitempty"`
+
+	Output    string   `json:"output,omitempty"`
+	OutputB64 string   `json:"output_b64,omitempty"`
+	Results   []Result `json:"results,omitempty"`
+	Batched   bool     `json:"batched,omitempty"`
+	BatchSize int      `json:"batch_size,omitempty"`
+	Engine    string   `json:"engine,omitempty"`
+
+	ProtocolVersion   int      `json:"protocol_version,omitempty"`
+	Capabilities      []string `json:"capabilities,omitempty"`
+	Providers         []string `json:"providers,omitempty"`
+	RequestedProvider string   `json:"requested_provider,omitempty"`
+	Model             string   `json:"model,omitempty"`
+	BatchedModel      string   `json:"batched_model,omitempty"`
+}
+
+// Result is one item of a batched done event.
+type Result struct {
+	Output string `json:"output"`
+}
+
+// Final reports whether e answers its job: no more events follow for
+// that id.
+func (e *Event) Final() bool {
+	switch e.Event {
+	case EventDone, EventError, EventCancelled:
+		return true
+	}
+	return false
+}
+
+// Has reports whether a ready event advertises capability c.
+func (e *Event) Has(c string) bool {
+	return slices.Contains(e.Capabilities, c)
+}
+
+// CheckReady refuses a ready event this build can't work with. A
+// helper that predates the handshake sends no version at all.
+// Capabilities this build doesn't know are ignored.
+func CheckReady(e *Event) error {
+	if e.Event != EventReady {
+		return fmt.Errorf("expected a %s event, got %q", EventReady, e.Event)
+	}
+	switch v := e.ProtocolVersion; {
+	case v == 0:
+		return fmt.Errorf("helper speaks no protocol version (it predates the handshake); this build needs protocol %d", Version)
+	case v < Version:
+		return fmt.Errorf("helper speaks protocol %d, older than this build's %d", v, Version)
+	case v > Version:
+		return fmt.Errorf("helper speaks protocol %d, newer than this build's %d", v, Version)
+	}
+	return nil
+}
+
+// Describe is a one-line summary of a ready event for logs.
+func Describe(e *Event) string {
+	caps := "none"
+	if len(e.Capabilities) > 0 {
+		caps = strings.Join(e.Capabilities, ",")
+	}
+	return fmt.Sprintf("protocol %d, capabilities %s", e.ProtocolVersion, caps)
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckReady(t *testing.T) {
+	cases := []struct {
+		name string
+		ev   Event
+		want string // substring of the error; "" = compatible
+	}{
+		{"current", Event{Event: EventReady, ProtocolVersion: Version}, ""},
+		{"unknown capability ignored", Event{Event: EventReady, ProtocolVersion: Version, Capabilities: []string{"warp"}}, ""},
+		{"pre-handshake helper", Event{Event: EventReady}, "predates the handshake"},
+		{"newer", Event{Event: EventReady, ProtocolVersion: Version + 1}, "newer"},
+		{"not ready", Event{Event: EventError, Msg: "boom"}, "expected a ready event"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckReady(&tc.ev)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestHas(t *testing.T) {
+	ev := Event{Capabilities: []string{CapTile, CapBatched}}
+	if !ev.Has(CapTile) || ev.Has(CapCancel) {
+		t.Fatalf("Has: %v", ev.Capabilities)
+	}
+	if got := Describe(&Event{ProtocolVersion: 1}); got != "protocol 1, capabilities none" {
+		t.Errorf("Describe: %q", got)
+	}
+}
@@ -0,0 +1,242 @@
+package protocoltest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+)
+
+// Fake is an in-process helper that speaks the protocol without a
+// model: every upscale is a nearest-neighbour resize to the size the
+// real helper would produce. It is what the conformance suite is
+// checked against, and what tests of the Go side run instead of
+// Python.
+type Fake struct {
+	// Version is the protocol_version the ready event reports: 0
+	// means protocol.Version, -1 omits it like a helper that predates
+	// the handshake.
+	Version int
+	// Caps is the advertised capability list. Frames that need a
+	// capability not listed are refused with an error event.
+	Caps []string
+	// Delay is how long each image takes, standing in for inference
+	// so that tests can cancel a job mid-batch.
+	Delay time.Duration
+	// Provider is the requested_provider the ready event echoes, as
+	// the helper's --provider: "" means its default, auto. Providers
+	// are the active ones it reports; nil means the CPU.
+	Provider  string
+	Providers []string
+
+	mu        sync.Mutex
+	live      map[string]bool
+	cancelled map[string]bool
+}
+
+// AllCaps is every capability Fake implements.
+var AllCaps = []string{protocol.CapTile, protocol.CapBatched, protocol.CapInlineIO, protocol.CapCancel}
+
+// errCancelled is a job stopping at a checkpoint after a cancel.
+var errCancelled = errors.New("cancelled")
+
+// Serve runs the helper loop: ready, then one answer per frame read
+// from r, until r reaches EOF. As in upscaler.py, frames are read on
+// a goroutine of their own so a cancel is seen mid-job.
+func (f *Fake) Serve(r io.Reader, w io.Writer) error {
+	var encMu sync.Mutex
+	enc := json.NewEncoder(w)
+	emit := func(ev protocol.Event) error {
+		encMu.Lock()
+		defer encMu.Unlock()
+		return enc.Encode(ev)
+	}
+	f.live, f.cancelled = map[string]bool{}, map[string]bool{}
+	ready := protocol.Event{
+		Event:             protocol.EventReady,
+		ProtocolVersion:   f.Version,
+		Capabilities:      f.Caps,
+		Providers:         f.Providers,
+		RequestedProvider: f.Provider,
+		Model:             "fake",
+	}
+	if ready.Providers == nil {
+		ready.Providers = []string{"CPUExecutionProvider"}
+	}
+	if ready.RequestedProvider == "" {
+		ready.RequestedProvider = "auto"
+	}
+	switch f.Version {
+	case 0:
+		ready.ProtocolVersion = protocol.Version
+	case -1:
+		ready.ProtocolVersion, ready.Capabilities = 0, nil
+	}
+	if err := emit(ready); err != nil {
+		return err
+	}
+
+	jobs := make(chan protocol.Frame, 64)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var fr struct {
+				protocol.Frame
+				Cancel *string `json:"cancel"`
+			}
+			if err := json.Unmarshal(line, &fr); err != nil {
+				emit(protocol.Event{Event: protocol.EventError, Msg: "bad jsonl frame: " + err.Error()})
+				continue
+			}
+			f.mu.Lock()
+			if fr.Cancel != nil {
+				if f.live[*fr.Cancel] {
+					f.cancelled[*fr.Cancel] = true
+				}
+				f.mu.Unlock()
+				continue
+			}
+			f.live[fr.ID] = true
+			f.mu.Unlock()
+			jobs <- fr.Frame
+		}
+		readErr <- sc.Err()
+	}()
+
+	for fr := range jobs {
+		t0 := time.Now()
+		ev, err := f.handle(&fr)
+		switch {
+		case errors.Is(err, errCancelled):
+			ev = protocol.Event{Event: protocol.EventCancelled, ID: fr.ID}
+		case err != nil:
+			ev = protocol.Event{Event: protocol.EventError, ID: fr.ID, Msg: err.Error()}
+		}
+		if ev.Event != protocol.EventError {
+			ev.ElapsedMS = time.Since(t0).Milliseconds()
+		}
+		f.mu.Lock()
+		delete(f.live, fr.ID)
+		delete(f.cancelled, fr.ID)
+		f.mu.Unlock()
+		if err := emit(ev); err != nil {
+			return err
+		}
+	}
+	return <-readErr
+}
+
+// check is the checkpoint before each image: it waits out Delay and
+// stops the job if it has been cancelled by then.
+func (f *Fake) check(id string) error {
+	time.Sleep(f.Delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancelled[id] && slices.Contains(f.Caps, protocol.CapCancel) {
+		return errCancelled
+	}
+	return nil
+}
+
+func (f *Fake) need(c string) error {
+	if !(&protocol.Event{Capabilities: f.Caps}).Has(c) {
+		return fmt.Errorf("capability %s not advertised", c)
+	}
+	return nil
+}
+
+func (f *Fake) handle(fr *protocol.Frame) (protocol.Event, error) {
+	done := protocol.Event{Event: protocol.EventDone, ID: fr.ID}
+	if fr.Tile {
+		if err := f.need(protocol.CapTile); err != nil {
+			return done, err
+		}
+	}
+	scale := fr.OutScale
+	if scale == 0 {
+		scale = imageinfo.NativeScale
+	}
+	switch {
+	case fr.Inputs != nil:
+		if err := f.need(protocol.CapBatched); err != nil {
+			return done, err
+		}
+		if len(fr.Inputs) != len(fr.Outputs) {
+			return done, fmt.Errorf("inputs/outputs length mismatch: %d vs %d", len(fr.Inputs), len(fr.Outputs))
+		}
+		if len(fr.Inputs) == 0 {
+			return done, errors.New("empty batch")
+		}
+		for i, in := range fr.Inputs {
+			if err := f.check(fr.ID); err != nil {
+				return done, err
+			}
+			if err := upscaleFile(in, fr.Outputs[i], scale); err != nil {
+				return done, err
+			}
+			done.Results = append(done.Results, protocol.Result{Output: fr.Outputs[i]})
+		}
+		done.Batched, done.BatchSize, done.Engine = true, len(fr.Inputs), "primary"
+		return done, nil
+
+	case fr.InputB64 != "":
+		if err := f.need(protocol.CapInlineIO); err != nil {
+			return done, err
+		}
+		if err := f.check(fr.ID); err != nil {
+			return done, err
+		}
+		raw, err := base64.StdEncoding.DecodeString(fr.InputB64)
+		if err != nil {
+			return done, err
+		}
+		var out bytes.Buffer
+		if err := imageinfo.Nearest(bytes.NewReader(raw), &out, scale, fr.OutputFormat); err != nil {
+			return done, err
+		}
+		done.OutputB64 = base64.StdEncoding.EncodeToString(out.Bytes())
+		return done, nil
+	}
+	if err := f.check(fr.ID); err != nil {
+		return done, err
+	}
+	if err := upscaleFile(fr.Input, fr.Output, scale); err != nil {
+		return done, err
+	}
+	done.Output = fr.Output
+	return done, nil
+}
+
+func upscaleFile(in, out string, scale float64) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var buf bytes.Buffer
+	if err := imageinfo.Nearest(src, &buf, scale, filepath.Ext(out)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(out, buf.Bytes(), 0o644)
+}
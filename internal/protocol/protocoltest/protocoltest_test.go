@@ -0,0 +1,61 @@
+package protocoltest
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// pipe runs f in-process and connects the suite to it.
+func pipe(t *testing.T, f *Fake) Conn {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		outW.CloseWithError(f.Serve(inR, outW))
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return Conn{Stdin: inW, Stdout: outR}
+}
+
+func TestFake(t *testing.T) {
+	t.Run("all capabilities", func(t *testing.T) {
+		Run(t, pipe(t, &Fake{Caps: AllCaps}))
+	})
+	t.Run("none", func(t *testing.T) {
+		Run(t, pipe(t, &Fake{}))
+	})
+}
+
+// TestHelperCommand runs the suite against any helper: set
+// REAL_ESRGAN_HELPER_CMD to a shell command that starts one in serve
+// mode, e.g.
+//
+//	REAL_ESRGAN_HELPER_CMD='python3 runtime/upscaler.py --serve --model x4.onnx --gpu-id -1' \
+//	    go test ./internal/protocol/... -run HelperCommand
+//
+// (`make test-protocol` does that for the bundled helper).
+func TestHelperCommand(t *testing.T) {
+	line := os.Getenv("REAL_ESRGAN_HELPER_CMD")
+	if line == "" {
+		t.Skip("REAL_ESRGAN_HELPER_CMD not set")
+	}
+	cmd := exec.Command("sh", "-c", line)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+	Run(t, Conn{Stdin: stdin, Stdout: stdout})
+}
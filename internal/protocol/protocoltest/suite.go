@@ -0,0 +1,304 @@
+// Package protocoltest is the conformance suite for serve-mode
+// helpers: it drives a running helper through every part of the
+// protocol package's contract, and through each optional capability
+// the helper advertises. Fake is a model-free helper that passes it.
+package protocoltest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+)
+
+// Conn is a started helper: frames go to Stdin, events come from
+// Stdout. Run closes Stdin at the end and expects Stdout to reach EOF.
+type Conn struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+	// Timeout bounds each wait for an event; 0 means two minutes,
+	// enough for a real model's first inference on CPU.
+	Timeout time.Duration
+}
+
+// Run drives the helper on c through the protocol. The helper must
+// not have been sent anything yet: the first check is its ready event.
+func Run(t *testing.T, c Conn) {
+	s := &session{c: c, events: make(chan protocol.Event, 16)}
+	if s.c.Timeout == 0 {
+		s.c.Timeout = 2 * time.Minute
+	}
+	go s.read()
+	dir := t.TempDir()
+	img := func(name string, w, h int) string { return writePNG(t, filepath.Join(dir, name), w, h) }
+	out := func(name string) string { return filepath.Join(dir, "out", name) }
+
+	ready := s.next(t)
+	if err := protocol.CheckReady(&ready); err != nil {
+		t.Fatalf("ready: %v (%+v)", err, ready)
+	}
+	for _, c := range ready.Capabilities {
+		if !slices.Contains(protocol.Known, c) {
+			t.Logf("ready: unknown capability %q (ignored)", c)
+		}
+	}
+	if len(ready.Providers) == 0 {
+		t.Errorf("ready: no providers reported")
+	}
+	t.Logf("helper: %s", protocol.Describe(&ready))
+
+	in := img("in.png", 24, 16)
+	t.Run("single", func(t *testing.T) {
+		fr := protocol.Frame{ID: "single", Input: in, Output: out("single.png")}
+		ev := s.roundTrip(t, fr)
+		if ev.Output != fr.Output {
+			t.Errorf("done output %q, want %q", ev.Output, fr.Output)
+		}
+		checkDims(t, fr.Output, 96, 64)
+	})
+	t.Run("outscale", func(t *testing.T) {
+		fr := protocol.Frame{ID: "outscale", Input: in, Output: out("outscale.png"), OutScale: 2, Resample: "bicubic"}
+		s.roundTrip(t, fr)
+		checkDims(t, fr.Output, 48, 32)
+	})
+	t.Run("resample only", func(t *testing.T) {
+		zero := 0
+		fr := protocol.Frame{ID: "passes0", Input: in, Output: out("passes0.png"), OutScale: 0.5, Passes: &zero}
+		s.roundTrip(t, fr)
+		checkDims(t, fr.Output, 12, 8)
+	})
+	t.Run("error keeps serving", func(t *testing.T) {
+		s.send(t, protocol.Frame{ID: "missing", Input: filepath.Join(dir, "nope.png"), Output: out("nope.png")})
+		if ev := s.await(t, "missing"); ev.Event != protocol.EventError || ev.Msg == "" {
+			t.Errorf("missing input: want an error event with a msg, got %+v", ev)
+		}
+		s.roundTrip(t, protocol.Frame{ID: "after-error", Input: in, Output: out("after-error.png")})
+	})
+	t.Run("malformed frame", func(t *testing.T) {
+		s.write(t, []byte("{not json\n"))
+		if ev := s.await(t, ""); ev.Event != protocol.EventError {
+			t.Errorf("malformed frame: want an id-less error event, got %+v", ev)
+		}
+		s.roundTrip(t, protocol.Frame{ID: "after-malformed", Input: in, Output: out("after-malformed.png")})
+	})
+	t.Run("pipelined", func(t *testing.T) {
+		ids := []string{"p1", "p2", "p3"}
+		for _, id := range ids {
+			s.send(t, protocol.Frame{ID: id, Input: in, Output: out(id + ".png")})
+		}
+		for _, id := range ids {
+			if ev := s.await(t, id); ev.Event != protocol.EventDone {
+				t.Errorf("%s: %+v", id, ev)
+			}
+		}
+	})
+
+	t.Run(protocol.CapTile, func(t *testing.T) {
+		skipUnless(t, &ready, protocol.CapTile)
+		fr := protocol.Frame{ID: "tile", Input: in, Output: out("tile.png"), Tile: true}
+		s.roundTrip(t, fr)
+		checkDims(t, fr.Output, 96, 64)
+	})
+	t.Run(protocol.CapBatched, func(t *testing.T) {
+		skipUnless(t, &ready, protocol.CapBatched)
+		fr := protocol.Frame{ID: "batch",
+			Inputs:  []string{in, img("in2.png", 24, 16)},
+			Outputs: []string{out("b1.png"), out("b2.png")}}
+		ev := s.roundTrip(t, fr)
+		if !ev.Batched || len(ev.Results) != 2 {
+			t.Fatalf("batched done: %+v", ev)
+		}
+		for i, r := range ev.Results {
+			if r.Output != fr.Outputs[i] {
+				t.Errorf("result %d: %q, want %q", i, r.Output, fr.Outputs[i])
+			}
+			checkDims(t, fr.Outputs[i], 96, 64)
+		}
+	})
+	t.Run(protocol.CapInlineIO, func(t *testing.T) {
+		skipUnless(t, &ready, protocol.CapInlineIO)
+		raw, err := os.ReadFile(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev := s.roundTrip(t, protocol.Frame{ID: "inline",
+			InputB64: base64.StdEncoding.EncodeToString(raw), OutputFormat: "png"})
+		body, err := base64.StdEncoding.DecodeString(ev.OutputB64)
+		if err != nil {
+			t.Fatalf("output_b64: %v", err)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+		if err != nil || cfg.Width != 96 || cfg.Height != 64 {
+			t.Errorf("inline output: %dx%d, %v; want 96x64", cfg.Width, cfg.Height, err)
+		}
+	})
+
+	t.Run(protocol.CapCancel, func(t *testing.T) {
+		skipUnless(t, &ready, protocol.CapCancel)
+		// c1 is big enough to still be running when the cancels
+		// arrive, so c2 is cancelled while queued whatever the
+		// helper's speed. A cancel for an unknown id is ignored.
+		s.send(t, protocol.Frame{ID: "c1", Input: img("big.png", 256, 256), Output: out("c1.png")})
+		s.send(t, protocol.Frame{ID: "c2", Input: in, Output: out("c2.png")})
+		s.send(t, protocol.Cancel{ID: "c2"})
+		s.send(t, protocol.Cancel{ID: "never-sent"})
+		if ev := s.await(t, "c1"); ev.Event != protocol.EventDone {
+			t.Errorf("c1: want done, got %+v", ev)
+		}
+		if ev := s.await(t, "c2"); ev.Event != protocol.EventCancelled {
+			t.Errorf("c2: want cancelled, got %+v", ev)
+		}
+		if _, err := os.Stat(out("c2.png")); err == nil {
+			t.Error("cancelled job wrote its output")
+		}
+		s.roundTrip(t, protocol.Frame{ID: "after-cancel", Input: in, Output: out("after-cancel.png")})
+	})
+
+	t.Run("eof", func(t *testing.T) {
+		if err := c.Stdin.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ev, ok := <-s.events:
+			if ok {
+				t.Errorf("event after stdin closed: %+v", ev)
+			}
+		case <-time.After(s.c.Timeout):
+			t.Errorf("helper still running %s after stdin closed", s.c.Timeout)
+		}
+	})
+}
+
+type session struct {
+	c      Conn
+	events chan protocol.Event
+}
+
+func (s *session) read() {
+	defer close(s.events)
+	sc := bufio.NewScanner(s.c.Stdout)
+	sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for sc.Scan() {
+		var ev protocol.Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || ev.Event == "" {
+			// Reported by whichever test reads it: stdout is for
+			// events only, logs belong on stderr.
+			ev = protocol.Event{Msg: sc.Text()}
+		}
+		s.events <- ev
+	}
+}
+
+// next is the helper's next event, whatever it is.
+func (s *session) next(t *testing.T) protocol.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-s.events:
+		if !ok {
+			t.Fatal("helper closed stdout")
+		}
+		if ev.Event == "" {
+			t.Errorf("stdout line is not an event: %q", ev.Msg)
+		}
+		return ev
+	case <-time.After(s.c.Timeout):
+		t.Fatalf("no event within %s", s.c.Timeout)
+	}
+	return protocol.Event{}
+}
+
+// await is the final event answering id, skipping progress.
+func (s *session) await(t *testing.T, id string) protocol.Event {
+	t.Helper()
+	for {
+		ev := s.next(t)
+		if !ev.Final() {
+			continue
+		}
+		if ev.ID != id {
+			t.Fatalf("waiting for %q, got an answer for %q: %+v", id, ev.ID, ev)
+		}
+		return ev
+	}
+}
+
+// send writes one frame: a protocol.Frame or a control frame.
+func (s *session) send(t *testing.T, fr any) {
+	t.Helper()
+	b, err := json.Marshal(fr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write(t, append(b, '\n'))
+}
+
+func (s *session) write(t *testing.T, b []byte) {
+	t.Helper()
+	if _, err := s.c.Stdin.Write(b); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+// roundTrip sends fr and requires a done event for it.
+func (s *session) roundTrip(t *testing.T, fr protocol.Frame) protocol.Event {
+	t.Helper()
+	s.send(t, fr)
+	ev := s.await(t, fr.ID)
+	if ev.Event != protocol.EventDone {
+		t.Fatalf("%s: want done, got %+v", fr.ID, ev)
+	}
+	return ev
+}
+
+func skipUnless(t *testing.T, ready *protocol.Event, c string) {
+	t.Helper()
+	if !ready.Has(c) {
+		t.Skipf("helper does not advertise %s", c)
+	}
+}
+
+// writePNG writes a w×h gradient: enough structure that a helper
+// can't pass by echoing a constant image.
+func writePNG(t *testing.T, path string, w, h int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkDims(t *testing.T, path string, w, h int) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Errorf("output: %v", err)
+		return
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width != w || cfg.Height != h {
+		t.Errorf("%s: %dx%d, %v; want %dx%d", filepath.Base(path), cfg.Width, cfg.Height, err, w, h)
+	}
+}
@@ -0,0 +1,231 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/gpu"
+	"github.com/spf13/cobra"
+)
+
+// Command returns the Cobra command tree for `runtime`.
+func Command() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "runtime",
+		Short: "Set up, inspect or remove the managed Python runtime",
+		Long: `Manage a dedicated Python venv for the inference helper, so
+onnxruntime and friends never land in the wrong environment.
+
+  runtime setup    create the venv and install pinned requirements
+  runtime status   what is installed, and whether it is in use
+  runtime remove   delete the venv
+
+Once set up, upscale / serve / doctor use the venv's interpreter
+unless --python or $PYTHON says otherwise.`,
+	}
+	cmd.PersistentFlags().BoolVar(&asJSON, "json", false, "Print one JSON document on stdout")
+	cmd.AddCommand(setupCommand(&asJSON), statusCommand(&asJSON), removeCommand(&asJSON))
+	return cmd
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func setupCommand(asJSON *bool) *cobra.Command {
+	var o SetupOptions
+	var python string
+	cmd := &cobra.Command{
+		Use:   "setup",
+		Short: "Create the managed venv and install the pinned requirements",
+		Long: `Create a venv under $XDG_DATA_HOME/real-esrgan-serve/venv (default
+~/.local/share/…) and install the --flavour's requirements, pinned to
+the container images' versions:
+
+` + requirementsHelp() + `
+--flavour auto picks cuda when nvidia-smi finds a GPU, cpu otherwise.
+Packages are installed with --no-deps, so a wheelhouse needs exactly
+these wheels; --wheelhouse alone installs offline (pip --no-index).
+For example, to prepare one on a connected machine:
+
+  pip download --no-deps --only-binary=:all: -d wheels onnxruntime==1.20.1 numpy==1.26.4 Pillow==10.4.0
+
+Running setup again updates the venv in place; --force rebuilds it, and
+is needed to switch flavour (cpu, cuda and trt pull different onnxruntime
+packages that cannot share a venv).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, _, err := (&Locator{PythonOverride: python, IgnoreManaged: true}).FindPython()
+			if err != nil {
+				return err
+			}
+			o.BasePython = base
+			if o.Flavour == "auto" {
+				o.Flavour = autoFlavour(cmd.Context())
+				fmt.Fprintf(os.Stderr, "flavour auto → %s\n", o.Flavour)
+			}
+			m, err := Setup(cmd.Context(), o, os.Stderr)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				return printJSON(m)
+			}
+			fmt.Printf("managed runtime ready: %s (%s)\n", m.Dir, m.Flavour)
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&o.Flavour, "flavour", "auto", "cpu | cuda | trt | auto")
+	f.StringVar(&o.Wheelhouse, "wheelhouse", "", "Directory of wheels to install from (offline unless --index-url is also set)")
+	f.StringVar(&o.IndexURL, "index-url", "", "Package index URL (default: PyPI)")
+	f.StringVar(&python, "python", "", "Interpreter to create the venv from (default: $PYTHON > python3)")
+	f.BoolVar(&o.Force, "force", false, "Delete and recreate an existing venv")
+	return cmd
+}
+
+func requirementsHelp() string {
+	var b strings.Builder
+	for _, f := range Flavours() {
+		fmt.Fprintf(&b, "  %-5s %s\n", f, strings.Join(Requirements[f], " "))
+	}
+	return b.String()
+}
+
+// autoFlavour is cuda on a host with an NVIDIA GPU. trt is never
+// picked automatically: it is a much larger install, worth it only
+// for prebuilt engines.
+func autoFlavour(ctx context.Context) string {
+	if _, err := gpu.Detect(ctx, nil); err == nil {
+		return FlavourCUDA
+	}
+	return FlavourCPU
+}
+
+// status is `runtime status`'s report.
+type status struct {
+	Installed bool       `json:"installed"`
+	Managed   *Managed   `json:"managed,omitempty"`
+	Env       *PythonEnv `json:"env,omitempty"`
+	InUse     bool       `json:"in_use"`
+	Note      string     `json:"note,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func statusCommand(asJSON *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the managed runtime, its packages and whether it is in use",
+		Long: `Show what runtime setup installed and check it still works. Exits
+0 when no managed runtime is set up, 3 when one is set up but broken.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := checkStatus(cmd.Context())
+			if *asJSON {
+				if err := printJSON(s); err != nil {
+					return err
+				}
+			} else {
+				printStatus(os.Stdout, s)
+			}
+			if s.Error != "" {
+				return errs.New(errs.Environment, "managed runtime is broken: %s", s.Error)
+			}
+			return nil
+		},
+	}
+}
+
+func checkStatus(ctx context.Context) status {
+	var s status
+	m, err := LoadManaged()
+	if errors.Is(err, fs.ErrNotExist) {
+		s.Note = "not set up; `real-esrgan-serve runtime setup` creates it"
+		return s
+	}
+	if err != nil {
+		s.Installed, s.Error = true, err.Error()
+		return s
+	}
+	s.Installed, s.Managed = true, m
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	if s.Env, err = (&Resolved{Python: m.Python}).Inspect(ctx); err != nil {
+		s.Error = err.Error() + "; rebuild with `runtime setup --force`"
+		return s
+	}
+	for _, name := range []string{"onnxruntime", "numpy", "pillow"} {
+		if msg, bad := s.Env.Errors[name]; bad {
+			s.Error = fmt.Sprintf("%s does not import (%s); rebuild with `runtime setup --force`", name, msg)
+			return s
+		}
+	}
+	// In use when an upscale without --python would pick it.
+	if py, from, err := (&Locator{}).FindPython(); err == nil && py == m.Python {
+		s.InUse = true
+	} else if err == nil {
+		s.Note = fmt.Sprintf("not in use: %s picks %s", from, py)
+	}
+	return s
+}
+
+func printStatus(w io.Writer, s status) {
+	if !s.Installed || s.Managed == nil {
+		if s.Error != "" {
+			fmt.Fprintln(w, "error:", s.Error)
+		} else {
+			fmt.Fprintln(w, s.Note)
+		}
+		return
+	}
+	m := s.Managed
+	fmt.Fprintf(w, "venv       %s (%s, set up %s)\n", m.Dir, m.Flavour, m.CreatedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "source     %s\n", m.Source())
+	if s.Env != nil {
+		fmt.Fprintf(w, "python     %s %s\n", m.Python, s.Env.Python)
+		fmt.Fprintf(w, "packages   onnxruntime %s, numpy %s, pillow %s\n", s.Env.OnnxRuntime, s.Env.NumPy, s.Env.Pillow)
+		fmt.Fprintf(w, "providers  %s\n", strings.Join(s.Env.Providers, ", "))
+	}
+	switch {
+	case s.Error != "":
+		fmt.Fprintln(w, "error     ", s.Error)
+	case s.InUse:
+		fmt.Fprintln(w, "in use     yes")
+	default:
+		fmt.Fprintln(w, "in use     no —", strings.TrimPrefix(s.Note, "not in use: "))
+	}
+}
+
+func removeCommand(asJSON *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove",
+		Short: "Delete the managed venv",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := RemoveManaged()
+			if err != nil {
+				return errs.Wrap(errs.Environment, err)
+			}
+			if *asJSON {
+				return printJSON(map[string]any{"removed": m != nil, "managed": m})
+			}
+			if m == nil {
+				fmt.Println("no managed runtime to remove")
+				return nil
+			}
+			fmt.Printf("removed %s; the python3 on $PATH is used again\n", m.Dir)
+			return nil
+		},
+	}
+}
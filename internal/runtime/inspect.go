@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// PythonEnv is what the interpreter reports about itself and the
+// helper's dependencies. A dependency that fails to import has an
+// empty version and the exception text in Errors.
+type PythonEnv struct {
+	Python      string            `json:"python"`
+	OnnxRuntime string            `json:"onnxruntime"`
+	NumPy       string            `json:"numpy"`
+	Pillow      string            `json:"pillow"`
+	Providers   []string          `json:"providers"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+// inspectScript imports each dependency on its own so one missing
+// package doesn't hide the state of the others.
+const inspectScript = `
+import json, sys
+out = {"python": "%d.%d.%d" % sys.version_info[:3], "providers": [], "errors": {}}
+for key, mod in (("onnxruntime", "onnxruntime"), ("numpy", "numpy"), ("pillow", "PIL")):
+    try:
+        m = __import__(mod)
+        out[key] = getattr(m, "__version__", "unknown")
+        if mod == "onnxruntime":
+            out["providers"] = m.get_available_providers()
+    except Exception as e:
+        out[key] = ""
+        out["errors"][key] = "%s: %s" % (type(e).__name__, e)
+print(json.dumps(out))
+`
+
+// Inspect asks the interpreter for its version, the versions of
+// onnxruntime / numpy / Pillow and onnxruntime's execution providers.
+// Unlike Probe it doesn't stop at the first missing package.
+func (r *Resolved) Inspect(ctx context.Context) (*PythonEnv, error) {
+	out, err := exec.CommandContext(ctx, r.Python, "-c", inspectScript).Output()
+	if err != nil {
+		detail := err.Error()
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			detail = strings.TrimSpace(string(ee.Stderr))
+		}
+		return nil, errs.New(errs.Environment, "%s does not run: %s", r.Python, detail)
+	}
+	var env PythonEnv
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, errs.New(errs.Environment, "%s: unexpected output %q", r.Python, strings.TrimSpace(string(out)))
+	}
+	return &env, nil
+}
+
+// HasProvider reports whether onnxruntime lists the named execution
+// provider ("CUDAExecutionProvider", "TensorrtExecutionProvider", …).
+func (e *PythonEnv) HasProvider(name string) bool {
+	for _, p := range e.Providers {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
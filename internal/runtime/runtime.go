@@ -14,12 +14,13 @@
 // Lookup order for the Python interpreter:
 //  1. --python flag
 //  2. $PYTHON env var
-//  3. python3 on $PATH
+//  3. the managed venv `runtime setup` created (venv.go)
+//  4. python3 on $PATH
 //
 // We deliberately do NOT auto-install onnxruntime if missing. The
-// install step is the user's responsibility (or the Dockerfile's, or
-// the install script's). `Probe()` checks importability and surfaces
-// a helpful error so the caller can render it.
+// install step is the user's responsibility: `runtime setup`, the
+// Dockerfile or the install script. `Probe()` checks importability
+// and surfaces a helpful error so the caller can render it.
 package runtime
 
 import (
@@ -29,98 +30,125 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
 )
 
 // Locator resolves the Python interpreter + helper script paths,
 // using the lookup orders documented above.
 type Locator struct {
-	PythonOverride  string // value of --python (may be empty)
-	ScriptOverride  string // value of --runtime (may be empty)
+	PythonOverride string // value of --python (may be empty)
+	ScriptOverride string // value of --runtime (may be empty)
+
+	// IgnoreManaged skips the managed venv: `runtime setup` wants the
+	// interpreter to build a venv from, not the venv itself.
+	IgnoreManaged bool
 }
 
 // Resolved is the output of Locate(): both paths exist + work.
 type Resolved struct {
 	Python string
 	Script string
+
+	// Which lookup rule found each, e.g. "--python" or "$PATH
+	// (python3)"; doctor reports them.
+	PythonFrom string
+	ScriptFrom string
 }
 
 // Locate finds the Python interpreter and helper script. Returns an
 // error with a human-readable explanation of which step failed and
 // what the user can do about it (callers print it as-is).
 func (l *Locator) Locate() (*Resolved, error) {
-	py, err := l.findPython()
+	py, pyFrom, err := l.FindPython()
 	if err != nil {
 		return nil, err
 	}
-	script, err := l.findScript()
+	script, scriptFrom, err := l.FindScript()
 	if err != nil {
 		return nil, err
 	}
-	return &Resolved{Python: py, Script: script}, nil
+	return &Resolved{Python: py, Script: script, PythonFrom: pyFrom, ScriptFrom: scriptFrom}, nil
+}
+
+// candidate is one place a lookup tries, and the rule that put it
+// there.
+type candidate struct {
+	rule, path string
 }
 
-func (l *Locator) findPython() (string, error) {
-	candidates := []string{}
+// FindPython is the interpreter half of Locate: the path and the rule
+// that found it.
+func (l *Locator) FindPython() (string, string, error) {
+	candidates := []candidate{}
 	if l.PythonOverride != "" {
-		candidates = append(candidates, l.PythonOverride)
+		candidates = append(candidates, candidate{"--python", l.PythonOverride})
 	}
 	if env := os.Getenv("PYTHON"); env != "" {
-		candidates = append(candidates, env)
+		candidates = append(candidates, candidate{"$PYTHON", env})
+	}
+	if !l.IgnoreManaged {
+		if m, err := LoadManaged(); err == nil {
+			candidates = append(candidates, candidate{"managed venv (runtime setup)", m.Python})
+		}
 	}
-	candidates = append(candidates, "python3", "python")
+	candidates = append(candidates, candidate{"python3 on $PATH", "python3"}, candidate{"python on $PATH", "python"})
 
 	for _, c := range candidates {
 		// `exec.LookPath` honours absolute paths and $PATH. If `c` is
 		// already absolute, this checks the file is exec'able.
-		if path, err := exec.LookPath(c); err == nil {
-			return path, nil
+		if path, err := exec.LookPath(c.path); err == nil {
+			return path, c.rule, nil
 		}
 	}
-	return "", errors.New(
-		"no python interpreter found. Tried --python, $PYTHON, python3, python on $PATH. " +
-			"Install python3 + pip, then `pip install onnxruntime-gpu numpy pillow`.",
+	return "", "", errs.New(errs.Environment,
+		"no python interpreter found. Tried --python, $PYTHON, the managed venv, python3, python on $PATH. "+
+			"Install python3, then run `real-esrgan-serve runtime setup`.",
 	)
 }
 
-func (l *Locator) findScript() (string, error) {
-	candidates := []string{}
+// FindScript is the helper-script half of Locate.
+func (l *Locator) FindScript() (string, string, error) {
+	candidates := []candidate{}
 	if l.ScriptOverride != "" {
-		candidates = append(candidates, l.ScriptOverride)
+		candidates = append(candidates, candidate{"--runtime", l.ScriptOverride})
 	}
 	if env := os.Getenv("REAL_ESRGAN_RUNTIME"); env != "" {
-		candidates = append(candidates, env)
+		candidates = append(candidates, candidate{"$REAL_ESRGAN_RUNTIME", env})
 	}
 
 	if exe, err := os.Executable(); err == nil {
 		exeDir := filepath.Dir(exe)
 		candidates = append(candidates,
-			filepath.Join(exeDir, "runtime", "upscaler.py"),
-			filepath.Join(exeDir, "..", "share", "real-esrgan-serve", "runtime", "upscaler.py"),
+			candidate{"next to the binary", filepath.Join(exeDir, "runtime", "upscaler.py")},
+			candidate{"<binary>/../share", filepath.Join(exeDir, "..", "share", "real-esrgan-serve", "runtime", "upscaler.py")},
 		)
 	}
 	candidates = append(candidates,
-		"/usr/share/real-esrgan-serve/runtime/upscaler.py",
-		"/usr/local/share/real-esrgan-serve/runtime/upscaler.py",
+		candidate{"system install", "/usr/share/real-esrgan-serve/runtime/upscaler.py"},
+		candidate{"system install", "/usr/local/share/real-esrgan-serve/runtime/upscaler.py"},
 		// Developer convenience: when running from the repo root.
-		"runtime/upscaler.py",
+		candidate{"./runtime in the source tree", "runtime/upscaler.py"},
 	)
 
+	tried := make([]string, 0, len(candidates))
 	for _, c := range candidates {
-		if c == "" {
+		if c.path == "" {
 			continue
 		}
-		abs, err := filepath.Abs(c)
+		tried = append(tried, c.path)
+		abs, err := filepath.Abs(c.path)
 		if err != nil {
 			continue
 		}
 		if info, err := os.Stat(abs); err == nil && !info.IsDir() {
-			return abs, nil
+			return abs, c.rule, nil
 		}
 	}
-	return "", fmt.Errorf(
+	return "", "", errs.New(errs.Environment,
 		"upscaler.py not found. Looked in: %v. "+
 			"Set $REAL_ESRGAN_RUNTIME or use --runtime to point at it.",
-		candidates,
+		tried,
 	)
 }
 
@@ -129,18 +157,50 @@ func (l *Locator) findScript() (string, error) {
 //
 // Cheap (sub-second) and worth doing once at startup so we surface
 // missing-dep errors as a separate failure mode from inference
-// failures. doctor command will use this too.
+// failures. `doctor` goes further with Inspect.
 func (r *Resolved) Probe(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, r.Python, "-c",
 		`import onnxruntime, numpy, PIL; print("ok")`,
 	)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf(
+		return errs.New(errs.Environment,
 			"python deps probe failed:\n  python: %s\n  err: %v\n  out: %s\n"+
-				"Install with: %s -m pip install onnxruntime-gpu numpy pillow",
+				"Install them into a managed venv with `real-esrgan-serve runtime setup`, "+
+				"or into this interpreter with: %s -m pip install onnxruntime-gpu numpy pillow",
 			r.Python, err, string(out), r.Python,
 		)
 	}
 	return nil
 }
+
+// HelperExit maps a finished helper's Wait() error onto the errs
+// taxonomy. upscaler.py exits 1/2/3 for user/runtime/environment
+// errors — the same numbering as ours — so those pass through with
+// their code intact. A helper killed because ctx was cancelled is an
+// interruption, not a crash; any other status (signal, OOM-kill) is a
+// runtime error.
+func HelperExit(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return errs.Wrap(errs.Interrupted, fmt.Errorf("upscaler interrupted: %w", ctx.Err()))
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		code := ee.ExitCode()
+		if c, ok := helperCategories[code]; ok {
+			return errs.WithCode(c, code, fmt.Errorf("upscaler exited %d", code))
+		}
+	}
+	return errs.Wrap(errs.Runtime, fmt.Errorf("upscaler failed: %w", err))
+}
+
+// helperCategories mirrors the exit-code table in upscaler.py's
+// module docstring.
+var helperCategories = map[int]errs.Category{
+	1: errs.User,
+	2: errs.Runtime,
+	3: errs.Environment,
+}
@@ -0,0 +1,291 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// A managed runtime is a venv that `runtime setup` owns, under
+//
+//	$XDG_DATA_HOME/real-esrgan-serve/venv        (~/.local/share/… by default)
+//	$XDG_DATA_HOME/real-esrgan-serve/runtime.json
+//
+// runtime.json records what was installed and from where; its
+// presence is what makes the Locator prefer the venv's interpreter
+// over python3 on $PATH. Packages go in with --no-deps, pinned to
+// what the container image of the same flavour installs, so `pip
+// install` into the wrong environment — the usual way a hand-built
+// setup breaks — can't happen, and a wheelhouse of exactly these
+// wheels is enough to set up offline.
+
+// Flavours of managed runtime.
+const (
+	FlavourCPU  = "cpu"
+	FlavourCUDA = "cuda"
+	FlavourTRT  = "trt"
+)
+
+// Requirements pins each flavour to the versions in Dockerfile.cpu,
+// Dockerfile.cuda and Dockerfile.trt. trt adds the TensorRT 10.8
+// Python packages (the version the engines in the manifest are built
+// with) on top of cuda, so one venv runs both .onnx and .engine.
+var Requirements = map[string][]string{
+	FlavourCPU:  {"onnxruntime==1.20.1", "numpy==1.26.4", "Pillow==10.4.0"},
+	FlavourCUDA: {"onnxruntime-gpu==1.20.1", "numpy==1.26.4", "Pillow==10.4.0"},
+	FlavourTRT: {"onnxruntime-gpu==1.20.1", "numpy==1.26.4", "Pillow==10.4.0",
+		"tensorrt-cu12==10.8.0.43", "tensorrt-cu12-bindings==10.8.0.43", "tensorrt-cu12-libs==10.8.0.43",
+		"cuda-python==12.6.0"},
+}
+
+// Managed is the runtime.json record of a managed venv.
+type Managed struct {
+	Dir          string    `json:"dir"`
+	Python       string    `json:"python"`
+	BasePython   string    `json:"base_python"`
+	Flavour      string    `json:"flavour"`
+	Requirements []string  `json:"requirements"`
+	Wheelhouse   string    `json:"wheelhouse,omitempty"`
+	IndexURL     string    `json:"index_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Source says where the packages came from, for status output.
+func (m *Managed) Source() string {
+	switch {
+	case m.Wheelhouse != "" && m.IndexURL != "":
+		return fmt.Sprintf("wheelhouse %s, then %s", m.Wheelhouse, m.IndexURL)
+	case m.Wheelhouse != "":
+		return "wheelhouse " + m.Wheelhouse + " (offline)"
+	case m.IndexURL != "":
+		return m.IndexURL
+	}
+	return "PyPI"
+}
+
+// DataDir is where managed runtimes live: $XDG_DATA_HOME or
+// ~/.local/share, plus real-esrgan-serve.
+func DataDir() (string, error) {
+	if x := os.Getenv("XDG_DATA_HOME"); x != "" {
+		return filepath.Join(x, "real-esrgan-serve"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locate home dir: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "real-esrgan-serve"), nil
+}
+
+func recordPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "runtime.json"), nil
+}
+
+// LoadManaged reads the runtime.json record. A runtime that was never
+// set up is an error satisfying errors.Is(err, fs.ErrNotExist).
+func LoadManaged() (*Managed, error) {
+	p, err := recordPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m Managed
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return &m, nil
+}
+
+// venvPython is the interpreter inside a venv.
+func venvPython(dir string) string {
+	if goruntime.GOOS == "windows" {
+		return filepath.Join(dir, "Scripts", "python.exe")
+	}
+	return filepath.Join(dir, "bin", "python")
+}
+
+// SetupOptions are `runtime setup`'s inputs.
+type SetupOptions struct {
+	Flavour    string // cpu | cuda | trt
+	BasePython string // interpreter the venv is created from
+	Wheelhouse string // directory of wheels; alone, installs offline
+	IndexURL   string // package index; with Wheelhouse, the fallback
+	Force      bool   // recreate the venv from scratch
+}
+
+// Setup creates (or updates) the managed venv, installs the flavour's
+// pinned requirements, checks they import and writes the record.
+// pip's output goes to log.
+func Setup(ctx context.Context, o SetupOptions, log io.Writer) (*Managed, error) {
+	reqs, ok := Requirements[o.Flavour]
+	if !ok {
+		return nil, errs.New(errs.User, "--flavour %q: want %s", o.Flavour, strings.Join(Flavours(), " | "))
+	}
+	if o.Wheelhouse != "" {
+		abs, err := filepath.Abs(o.Wheelhouse)
+		if err == nil {
+			var info fs.FileInfo
+			if info, err = os.Stat(abs); err == nil && !info.IsDir() {
+				err = errors.New("not a directory")
+			}
+		}
+		if err != nil {
+			return nil, errs.New(errs.User, "--wheelhouse %s: %v", o.Wheelhouse, err)
+		}
+		o.Wheelhouse = abs
+	}
+	data, err := DataDir()
+	if err != nil {
+		return nil, errs.Wrap(errs.Environment, err)
+	}
+	m := &Managed{
+		Dir:          filepath.Join(data, "venv"),
+		BasePython:   o.BasePython,
+		Flavour:      o.Flavour,
+		Requirements: reqs,
+		Wheelhouse:   o.Wheelhouse,
+		IndexURL:     o.IndexURL,
+		CreatedAt:    time.Now().UTC(),
+	}
+	m.Python = venvPython(m.Dir)
+
+	// pip can't swap onnxruntime for onnxruntime-gpu in place: both
+	// own the onnxruntime module, so a flavour change starts afresh.
+	if prev, err := LoadManaged(); err == nil && prev.Flavour != o.Flavour && !o.Force {
+		return nil, errs.New(errs.User,
+			"the managed runtime is the %s flavour; switching to %s needs a fresh venv (pass --force)", prev.Flavour, o.Flavour)
+	}
+	if o.Force {
+		if err := os.RemoveAll(m.Dir); err != nil {
+			return nil, errs.Wrap(errs.Environment, err)
+		}
+	}
+	if _, err := os.Stat(m.Python); err != nil {
+		fmt.Fprintf(log, "creating venv %s from %s\n", m.Dir, o.BasePython)
+		if err := run(ctx, log, o.BasePython, "-m", "venv", m.Dir); err != nil {
+			return nil, errs.New(errs.Environment,
+				"%s -m venv failed: %v (on Debian/Ubuntu, install python3-venv)", o.BasePython, err)
+		}
+	}
+
+	args := []string{"-m", "pip", "install", "--disable-pip-version-check", "--no-deps", "--upgrade"}
+	switch {
+	case o.Wheelhouse != "" && o.IndexURL == "":
+		args = append(args, "--no-index", "--find-links", o.Wheelhouse)
+	case o.Wheelhouse != "":
+		args = append(args, "--find-links", o.Wheelhouse, "--index-url", o.IndexURL)
+	case o.IndexURL != "":
+		args = append(args, "--index-url", o.IndexURL)
+	}
+	fmt.Fprintf(log, "installing %s from %s\n", strings.Join(reqs, " "), m.Source())
+	if err := run(ctx, log, m.Python, append(args, reqs...)...); err != nil {
+		if ctx.Err() != nil {
+			return nil, errs.Wrap(errs.Interrupted, ctx.Err())
+		}
+		return nil, errs.New(errs.Environment, "pip install into %s failed: %v", m.Dir, err)
+	}
+
+	env, err := (&Resolved{Python: m.Python}).Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"onnxruntime", "numpy", "pillow"} {
+		if msg, bad := env.Errors[name]; bad {
+			return nil, errs.New(errs.Environment, "installed, but %s does not import: %s", name, msg)
+		}
+	}
+	if err := writeRecord(m); err != nil {
+		return nil, errs.Wrap(errs.Environment, err)
+	}
+	return m, nil
+}
+
+// run execs name, sending its output to log. The error carries the
+// last lines of output, which is where pip says what went wrong.
+func run(ctx context.Context, log io.Writer, name string, args ...string) error {
+	var tail bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = io.MultiWriter(log, &tail)
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(tail.String()), "\n")
+		if len(lines) > 3 {
+			lines = lines[len(lines)-3:]
+		}
+		return fmt.Errorf("%w: %s", err, strings.Join(lines, " / "))
+	}
+	return nil
+}
+
+func writeRecord(m *Managed) error {
+	p, err := recordPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// RemoveManaged deletes the managed venv and its record, returning
+// what was removed (nil when nothing was set up).
+func RemoveManaged() (*Managed, error) {
+	m, err := LoadManaged()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	p, perr := recordPath()
+	if perr != nil {
+		return nil, perr
+	}
+	if err != nil {
+		// Unreadable record: drop it and the default venv location.
+		data, _ := DataDir()
+		m = &Managed{Dir: filepath.Join(data, "venv")}
+	}
+	if err := os.RemoveAll(m.Dir); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Flavours lists the known flavours in a stable order.
+func Flavours() []string {
+	out := make([]string, 0, len(Requirements))
+	for f := range Requirements {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,192 @@
+package runtime
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// modules maps a requirement's distribution to the module it imports as.
+var modules = map[string]string{"onnxruntime": "onnxruntime", "onnxruntime-gpu": "onnxruntime", "numpy": "numpy", "Pillow": "PIL"}
+
+// wheelhouse writes a stand-in pure-Python wheel for each of reqs:
+// right name and version, a module whose __version__ matches, and for
+// onnxruntime a get_available_providers(). Enough for pip to install
+// offline and for Inspect to see the versions.
+func wheelhouse(t *testing.T, reqs []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, req := range reqs {
+		dist, ver, _ := strings.Cut(req, "==")
+		mod := modules[dist]
+		norm := strings.ReplaceAll(dist, "-", "_")
+		info := norm + "-" + ver + ".dist-info"
+		init := `__version__ = "` + ver + `"` + "\n"
+		if mod == "onnxruntime" {
+			init += "def get_available_providers():\n    return [\"CPUExecutionProvider\"]\n"
+		}
+		files := map[string]string{
+			mod + "/__init__.py": init,
+			info + "/METADATA":   "Metadata-Version: 2.1\nName: " + dist + "\nVersion: " + ver + "\n",
+			info + "/WHEEL":      "Wheel-Version: 1.0\nGenerator: venv_test\nRoot-Is-Purelib: true\nTag: py3-none-any\n",
+		}
+		var record strings.Builder
+		for name := range files {
+			record.WriteString(name + ",,\n")
+		}
+		record.WriteString(info + "/RECORD,,\n")
+		files[info+"/RECORD"] = record.String()
+
+		f, err := os.Create(filepath.Join(dir, norm+"-"+ver+"-py3-none-any.whl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		for name, body := range files {
+			w, _ := zw.Create(name)
+			io.WriteString(w, body)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return dir
+}
+
+// basePython skips the test on hosts that can't build a venv.
+func basePython(t *testing.T) string {
+	t.Helper()
+	py, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3")
+	}
+	if exec.Command(py, "-c", "import venv, ensurepip").Run() != nil {
+		t.Skip("python3 without venv/ensurepip")
+	}
+	return py
+}
+
+// TestSetup_offline is the end-to-end path `runtime setup --wheelhouse`
+// takes: venv, --no-index install, import check, record, and the
+// Locator then preferring the venv over python3 on $PATH.
+func TestSetup_offline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creates a venv")
+	}
+	py := basePython(t)
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("PYTHON", "")
+
+	// The flavour's pins must be exactly what is installed: drop one
+	// wheel and the offline install fails.
+	partial := wheelhouse(t, Requirements[FlavourCPU][:2])
+	_, err := Setup(context.Background(), SetupOptions{Flavour: FlavourCPU, BasePython: py, Wheelhouse: partial}, io.Discard)
+	if !errs.Is(err, errs.Environment) || !strings.Contains(err.Error(), "pip install") {
+		t.Fatalf("partial wheelhouse: want a pip install failure, got %v", err)
+	}
+	if _, err := LoadManaged(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("a failed setup must not write the record: %v", err)
+	}
+
+	wh := wheelhouse(t, Requirements[FlavourCPU])
+	m, err := Setup(context.Background(), SetupOptions{Flavour: FlavourCPU, BasePython: py, Wheelhouse: wh}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadManaged()
+	if err != nil || rec.Python != m.Python || rec.Flavour != FlavourCPU || rec.Wheelhouse != wh {
+		t.Fatalf("record: %+v, %v", rec, err)
+	}
+
+	env, err := (&Resolved{Python: m.Python}).Inspect(context.Background())
+	if err != nil || env.OnnxRuntime != "1.20.1" || env.Pillow != "10.4.0" || !env.HasProvider("CPUExecutionProvider") {
+		t.Fatalf("venv env: %+v, %v", env, err)
+	}
+
+	got, from, err := (&Locator{}).FindPython()
+	if err != nil || got != m.Python || !strings.Contains(from, "managed") {
+		t.Fatalf("locator: %s (%s), %v", got, from, err)
+	}
+	// --python and $PYTHON still win.
+	t.Setenv("PYTHON", py)
+	if got, _, _ := (&Locator{}).FindPython(); got == m.Python {
+		t.Error("$PYTHON should override the managed venv")
+	}
+	if s := checkStatus(context.Background()); !s.Installed || s.InUse || s.Error != "" {
+		t.Errorf("status with $PYTHON set: %+v", s)
+	}
+
+	removed, err := RemoveManaged()
+	if err != nil || removed == nil {
+		t.Fatalf("remove: %+v, %v", removed, err)
+	}
+	if _, err := os.Stat(m.Dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("venv still there: %v", err)
+	}
+	if removed, _ := RemoveManaged(); removed != nil {
+		t.Error("second remove should be a no-op")
+	}
+}
+
+func TestSetup_badInput(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	cases := []struct {
+		name string
+		o    SetupOptions
+		want string
+	}{
+		{"unknown flavour", SetupOptions{Flavour: "rocm"}, "--flavour"},
+		{"missing wheelhouse", SetupOptions{Flavour: FlavourCPU, Wheelhouse: "/does/not/exist"}, "--wheelhouse"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Setup(context.Background(), tc.o, io.Discard)
+			if !errs.Is(err, errs.User) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+// TestSetup_flavourChange: an existing cpu runtime isn't upgraded in
+// place to cuda (onnxruntime and onnxruntime-gpu would both land in
+// site-packages); Setup refuses before touching the venv.
+func TestSetup_flavourChange(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	data, err := DataDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := &Managed{Dir: filepath.Join(data, "venv"), Flavour: FlavourCPU}
+	if err := writeRecord(prev); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Setup(context.Background(), SetupOptions{Flavour: FlavourCUDA, BasePython: "/does/not/exist"}, io.Discard)
+	if !errs.Is(err, errs.User) || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("cpu -> cuda: want a --force hint, got %v", err)
+	}
+	if rec, err := LoadManaged(); err != nil || rec.Flavour != FlavourCPU {
+		t.Fatalf("record changed: %+v, %v", rec, err)
+	}
+	// The same flavour, or --force, gets past the check (and then
+	// fails on the missing interpreter).
+	for _, o := range []SetupOptions{
+		{Flavour: FlavourCPU, BasePython: "/does/not/exist"},
+		{Flavour: FlavourCUDA, BasePython: "/does/not/exist", Force: true},
+	} {
+		_, err := Setup(context.Background(), o, io.Discard)
+		if !errs.Is(err, errs.Environment) || !strings.Contains(err.Error(), "-m venv") {
+			t.Errorf("%+v: want a venv failure, got %v", o, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/backend"
+)
+
+// Kubernetes-style probes. /livez fails only when restarting is the
+// cure: the helper has died and nothing brings it back. /readyz also
+// fails while the server shouldn't be sent work: shutting down, or
+// the synthetic probe failing or not yet run. Both answer in plain
+// text; /health carries the detail.
+
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if !s.alive() {
+		http.Error(w, "helper dead", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if ok, why := s.ready(); !ok {
+		http.Error(w, why, http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) alive() bool {
+	c, ok := s.backend.(backend.Checker)
+	return !ok || c.Alive()
+}
+
+// ready reports whether the server should be sent work, and why not.
+func (s *Server) ready() (bool, string) {
+	switch p := s.probe.result(); {
+	case !s.alive():
+		return false, "helper dead"
+	case s.draining.Load():
+		return false, "shutting down"
+	case s.probe != nil && p == nil:
+		return false, "waiting for the first probe"
+	case p != nil && p.Error != "":
+		return false, "probe failed: " + p.Error
+	}
+	return true, ""
+}
+
+// health is the /health body. Provider and Providers are what the
+// model is running on, when the backend knows; the rest is only
+// filled in with ?verbose=1.
+type health struct {
+	Status    string   `json:"status"`
+	Provider  string   `json:"provider,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+
+	*healthDetail
+}
+
+type healthDetail struct {
+	Ready       bool         `json:"ready"`
+	Reason      string       `json:"reason,omitempty"` // why not ready
+	Version     string       `json:"version"`
+	UptimeS     int64        `json:"uptime_s"`
+	Backend     string       `json:"backend"`
+	Model       healthModel  `json:"model"`
+	GPUID       int          `json:"gpu_id"`
+	Helper      *healthProc  `json:"helper,omitempty"`
+	QueueDepth  int64        `json:"queue_depth"` // requests waiting for a slot
+	InFlight    int64        `json:"in_flight"`
+	LastSuccess *time.Time   `json:"last_success,omitempty"`
+	Probe       *probeResult `json:"probe,omitempty"`
+}
+
+type healthModel struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type healthProc struct {
+	PID     int   `json:"pid"`
+	UptimeS int64 `json:"uptime_s"`
+}
+
+// handleHealth is 200 while the backend is alive, ready or not, so
+// existing callers see what they always did; ?verbose=1 adds what
+// is loaded and how busy the server is.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !s.alive() {
+		http.Error(w, "helper dead", http.StatusServiceUnavailable)
+		return
+	}
+	caps := s.backend.Capabilities()
+	h := health{Status: "ok", Provider: caps.Provider(), Providers: caps.Providers}
+	if v, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); v {
+		h.healthDetail = s.detail(caps)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+func (s *Server) detail(caps backend.Capabilities) *healthDetail {
+	now := time.Now()
+	d := &healthDetail{
+		Version:    s.version,
+		UptimeS:    int64(now.Sub(s.started).Seconds()),
+		Backend:    caps.Backend,
+		Model:      healthModel{File: caps.Model, SHA256: s.modelSHA},
+		GPUID:      s.gpuID,
+		QueueDepth: s.gates.waiting.Load(),
+		InFlight:   s.gates.inFlight.Load(),
+		Probe:      s.probe.result(),
+	}
+	d.Ready, d.Reason = s.ready()
+	if caps.Backend == backend.NamePython {
+		d.Model.File = filepath.Base(caps.Model)
+	}
+	if p, ok := s.backend.(backend.Process); ok {
+		d.Helper = &healthProc{PID: p.PID(), UptimeS: int64(now.Sub(p.Started()).Seconds())}
+	}
+	if ns := s.lastJob.Load(); ns != 0 {
+		t := time.Unix(0, ns).UTC()
+		d.LastSuccess = &t
+	}
+	return d
+}
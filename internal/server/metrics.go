@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleMetrics serves the counters in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := s.stats
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, "# HELP real_esrgan_jobs_total Jobs sent to the backend, by outcome.\n")
+	fmt.Fprint(w, "# TYPE real_esrgan_jobs_total counter\n")
+	fmt.Fprintf(w, "real_esrgan_jobs_total{outcome=\"done\"} %d\n", m.Done.Load())
+	fmt.Fprintf(w, "real_esrgan_jobs_total{outcome=\"error\"} %d\n", m.Failed.Load())
+	fmt.Fprintf(w, "real_esrgan_jobs_total{outcome=\"abandoned\"} %d\n", m.Abandoned.Load())
+	fmt.Fprint(w, "# HELP real_esrgan_helper_busy_seconds_total Backend time spent on jobs.\n")
+	fmt.Fprint(w, "# TYPE real_esrgan_helper_busy_seconds_total counter\n")
+	fmt.Fprintf(w, "real_esrgan_helper_busy_seconds_total %.3f\n", float64(m.BusyMS.Load())/1000)
+	fmt.Fprint(w, "# HELP real_esrgan_wasted_gpu_seconds_total Backend time spent on abandoned jobs before they stopped.\n")
+	fmt.Fprint(w, "# TYPE real_esrgan_wasted_gpu_seconds_total counter\n")
+	fmt.Fprintf(w, "real_esrgan_wasted_gpu_seconds_total %.3f\n", float64(m.WastedMS.Load())/1000)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+)
+
+// probeImage is deploy/bench/64x64-rgb.png.b64, the image iosuite
+// benchmarks with: the smallest input the model takes, so a probe
+// costs the least inference there is. TestProbeImage keeps the two
+// the same.
+const probeImage = "iVBORw0KGgoAAAANSUhEUgAAAEAAAABACAIAAAAlC+aJAAAAeklEQVR4nO3PUQkAIBTAwBfNaEYzmiH8OITBAtxm7fN1wwUNaEEDWtCAFjSgBQ1oQQNa0IAW" +
+	"NKAFDWhBA1rQgBY0oAUNaEEDWtCAFjSgBQ1oQQNa0IAWNKAFDWhBA1rQgBY0oAUNaEEDWtCAFjSgBQ1oQQNa0IAWPHYBHsYBafyS08sAAAAASUVORK5CYII="
+
+// probe is the optional synthetic inference: every interval it runs
+// probeImage through the backend as a request would, and a failure
+// or an answer slower than maxLatency marks the server unready. A
+// request that succeeded since the last probe stands in for it, so
+// a busy server isn't probed on top of its load.
+type probe struct {
+	interval, maxLatency time.Duration
+
+	mu   sync.Mutex
+	last *probeResult // nil until the first probe
+}
+
+// probeResult is one probe, as /health?verbose=1 shows it.
+type probeResult struct {
+	At        time.Time `json:"at"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	// Skipped: a request succeeded since the previous probe, so none ran.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// result is the latest probe; nil before the first, or when probing
+// is off.
+func (p *probe) result() *probeResult {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last
+}
+
+func (p *probe) set(r probeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prev := p.last
+	p.last = &r
+	switch {
+	case r.Error != "" && (prev == nil || prev.Error == ""):
+		fmt.Fprintf(os.Stderr, "warn: probe: %s; not ready\n", r.Error)
+	case r.Error == "" && prev != nil && prev.Error != "":
+		fmt.Fprintf(os.Stderr, "probe: passing again (%d ms); ready\n", r.LatencyMS)
+	}
+}
+
+// runProbes probes at once, then every interval until ctx ends.
+func (s *Server) runProbes(ctx context.Context) {
+	t := time.NewTicker(s.probe.interval)
+	defer t.Stop()
+	since := time.Now()
+	for {
+		if last := s.lastJob.Load(); last > since.UnixNano() {
+			s.probe.set(probeResult{At: time.Now(), Skipped: true})
+		} else {
+			s.probe.set(s.probeOnce(ctx))
+		}
+		since = time.Now()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probeOnce runs probeImage at the native scale, outside the request
+// gate: it stands for a request, not in line with them, so its
+// latency includes any wait behind jobs the backend is running.
+func (s *Server) probeOnce(ctx context.Context) probeResult {
+	raw, _ := base64.StdEncoding.DecodeString(probeImage)
+	t0 := time.Now()
+	r := probeResult{At: t0}
+	spec, err := planJob(raw, ".png", sizing.Request{Scale: 4, Limits: s.limits}, "")
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, s.probe.maxLatency)
+		defer cancel()
+		_, _, err = s.runOnePathBased(ctx, raw, spec)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("no answer within %s", s.probe.maxLatency)
+		}
+	}
+	r.LatencyMS = time.Since(t0).Milliseconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
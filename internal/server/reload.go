@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/config"
+)
+
+// gate bounds the jobs in flight. A reload can resize it under load:
+// each job gives its slot back to the channel it took it from, so the
+// jobs already running finish under the old bound and new ones queue
+// under the new. waiting and inFlight are for /health.
+type gate struct {
+	mu sync.Mutex
+	ch chan struct{}
+
+	waiting, inFlight atomic.Int64
+}
+
+func newGate(n int) *gate {
+	return &gate{ch: make(chan struct{}, n)}
+}
+
+// enter waits for a slot. ok is false when ctx ends first; otherwise
+// call leave once the job is done.
+func (g *gate) enter(ctx context.Context) (leave func(), ok bool) {
+	g.mu.Lock()
+	ch := g.ch
+	g.mu.Unlock()
+	g.waiting.Add(1)
+	defer g.waiting.Add(-1)
+	select {
+	case ch <- struct{}{}:
+		g.inFlight.Add(1)
+		return func() { g.inFlight.Add(-1); <-ch }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (g *gate) resize(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ch = make(chan struct{}, n)
+}
+
+// reload re-reads the config file, on SIGHUP. A running server takes
+// a new concurrency and api-key; anything else it logs, to apply at
+// the next start. A file that no longer loads changes nothing.
+func (s *Server) reload(o *opts) {
+	changes, err := o.cfg.Reload(o.cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warn: reload: %v; keeping the running settings\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stderr, "reload: no changes")
+		return
+	}
+	for _, ch := range changes {
+		switch ch.Key {
+		case "concurrency":
+			n, err := strconv.Atoi(ch.Value)
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "warn: reload: concurrency %q (%s) must be >= 1; keeping %d\n", ch.Value, ch.Source, o.concurrency)
+				continue
+			}
+			if err := o.cfg.Set(o.cmd, ch); err != nil {
+				fmt.Fprintf(os.Stderr, "warn: reload: %v\n", err)
+				continue
+			}
+			s.gates.resize(n)
+			fmt.Fprintf(os.Stderr, "reload: concurrency %d (%s)\n", n, ch.Source)
+		case config.APIKey:
+			k, ok := s.backend.(interface{ SetAPIKey(string) })
+			if !ok {
+				continue // only --backend remote sends one
+			}
+			k.SetAPIKey(ch.Value)
+			fmt.Fprintf(os.Stderr, "reload: api-key %s (%s)\n", config.Redact(ch.Value), ch.Source)
+		default:
+			fmt.Fprintf(os.Stderr, "reload: %s = %s (%s) takes effect on restart\n", ch.Key, ch.Value, ch.Source)
+		}
+	}
+}
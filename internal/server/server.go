@@ -23,12 +23,14 @@
 // One Python helper process keeps the ORT session alive. The Go server
 // muxes N concurrent HTTP handlers onto the helper's stdin/stdout via
 // a per-job-ID result channel populated by a single stdout reader
-// goroutine. Backpressure is natural: when the helper is slow,
-// requests pile up in their own goroutines waiting for their channel.
+// goroutine (backend.Helper). Backpressure is natural: when the helper
+// is slow, requests pile up in their own goroutines waiting for their
+// channel. The picture above is the default --backend python; with
+// --backend remote the jobs are forwarded to another /runsync, and
+// with --backend fake they never leave the process.
 package server
 
 import (
-	"bufio"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -37,16 +39,23 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strconv"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/ls-ads/real-esrgan-serve/internal/backend"
+	"github.com/ls-ads/real-esrgan-serve/internal/config"
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
 	rrt "github.com/ls-ads/real-esrgan-serve/internal/runtime"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+	"github.com/ls-ads/real-esrgan-serve/pkg/client"
 	"github.com/spf13/cobra"
 )
 
@@ -59,6 +68,21 @@ type opts struct {
 	gpuID         int
 	pythonBin     string
 	runtimeScript string
+	backend       string // --backend: python | remote | fake
+	endpoint      string // /runsync URL for --backend remote
+	autoFetch     bool
+	variant       string // models.Resolve preference: auto | engine | fp16 | fp32
+	provider      string // --provider: auto | cuda | tensorrt | cpu
+	smArch        string
+	allowUnsigned bool
+	apiKey        string // the api-key setting, for --backend remote
+	probeInterval time.Duration
+	probeLatency  time.Duration
+	version       string // the binary's, for /health
+
+	// cfg and cmd let SIGHUP re-read the config file.
+	cfg *config.Config
+	cmd *cobra.Command
 }
 
 // Command returns the Cobra command tree for `serve`.
@@ -73,9 +97,21 @@ the engine warmup cost (~1–30s depending on GPU + EP), subsequent
 requests run at hot-path latency.
 
 For one-shot use, prefer 'real-esrgan-serve upscale' — same code
-path, no daemon to manage.`,
+path, no daemon to manage.
+
+On SIGHUP the config file is read again: concurrency and api-key
+take effect for new requests, other changes wait for a restart.
+
+GET /livez fails only when the helper has died; GET /readyz also
+fails while shutting down and, with --probe-interval, while the
+synthetic 64x64 inference fails or is slower than --probe-max-latency.
+GET /health?verbose=1 reports the model, providers, helper, queue and
+last success.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(o)
+			o.cfg, o.cmd = config.FromContext(cmd.Context()), cmd
+			o.version = cmd.Root().Version
+			o.apiKey, _ = o.cfg.Value(config.CommandName(cmd), config.APIKey)
+			return errs.Wrap(errs.Runtime, run(o))
 		},
 	}
 
@@ -83,46 +119,83 @@ path, no daemon to manage.`,
 	f.IntVarP(&o.port, "port", "p", 8311, "TCP port to bind")
 	f.StringVar(&o.bind, "bind", "127.0.0.1", "Bind address (use 0.0.0.0 to expose on LAN — opt-in)")
 	f.StringVar(&o.model, "model", "realesrgan-x4plus", "Model to keep warm in the session")
-	f.StringVar(&o.modelPath, "model-path", "", "Absolute path to .onnx (skips manifest lookup)")
+	f.StringVar(&o.modelPath, "model-path", "", "Absolute path to .onnx or .engine (skips manifest lookup)")
+	f.BoolVar(&o.autoFetch, "auto-fetch", false, "Download + verify the model before starting when it isn't cached")
+	f.StringVar(&o.variant, "variant", models.VariantAuto, "Cached artefact to load: auto (engine > fp16 > fp32) | engine | fp16 | fp32")
+	f.StringVar(&o.smArch, "sm-arch", "", "GPU SM arch (e.g. sm89); detected with nvidia-smi when unset")
+	f.BoolVar(&o.allowUnsigned, "allow-unsigned-manifest", false, "Accept a model manifest without a valid signature — dev only")
 	f.IntVar(&o.concurrency, "concurrency", 1, "Max in-flight requests; default 1 per physical GPU")
 	f.IntVarP(&o.gpuID, "gpu-id", "g", 0, "GPU device index (-1 = CPU)")
+	f.StringVar(&o.provider, "provider", models.ProviderAuto, "Execution provider: auto (TensorRT > CUDA > CPU, warning on a CPU fallback) | cuda | tensorrt (or trt) | cpu; a named one fails rather than fall back")
 	f.StringVar(&o.pythonBin, "python", "", "Python interpreter (default: --python > $PYTHON > python3)")
 	f.StringVar(&o.runtimeScript, "runtime", "", "Override path to runtime/upscaler.py")
+	f.StringVar(&o.backend, "backend", backend.NamePython, "What runs the model: python (warm upscaler.py helper) | remote (another serve or RunPod, see --endpoint) | fake (pure-Go nearest neighbour, for tests)")
+	f.StringVar(&o.endpoint, "endpoint", "", "With --backend remote: URL of the /runsync endpoint to forward to")
+	f.DurationVar(&o.probeInterval, "probe-interval", 0, "Run a 64x64 synthetic inference this often, failing /readyz when it fails (0 = off)")
+	f.DurationVar(&o.probeLatency, "probe-max-latency", 10*time.Second, "With --probe-interval: a probe slower than this fails too")
 
 	return cmd
 }
 
 func run(o *opts) error {
-	loc := &rrt.Locator{
-		PythonOverride: o.pythonBin,
-		ScriptOverride: o.runtimeScript,
-	}
-	resolved, err := loc.Locate()
-	if err != nil {
-		return err
-	}
+	// Trap signals so Ctrl-C drains gracefully + reaps the helper (and
+	// interrupts an --auto-fetch download before that).
+	ctx, cancel := signal.NotifyContext(context.Background(),
+		syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	model, err := resolveModel(o)
-	if err != nil {
+	if err := backend.Validate(o.backend); err != nil {
 		return err
 	}
-
-	probeCtx, probeCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	if err := resolved.Probe(probeCtx); err != nil {
-		probeCancel()
+	if err := models.ValidateProvider(o.provider); err != nil {
 		return err
 	}
-	probeCancel()
-
-	// Start the warm helper before we open the listener — first
-	// request never pays the warmup cost.
-	helper, err := startHelper(resolved, model, o.gpuID)
+	o.provider = models.CanonicalProvider(o.provider)
+	if o.backend != backend.NamePython && o.provider != models.ProviderAuto {
+		return errs.New(errs.User, "--provider applies to --backend python, not %s", o.backend)
+	}
+	if o.concurrency < 1 {
+		return errs.New(errs.User, "--concurrency must be >= 1, got %d", o.concurrency)
+	}
+	if o.probeInterval < 0 || (o.probeInterval > 0 && o.probeLatency <= 0) {
+		return errs.New(errs.User, "--probe-interval and --probe-max-latency must be positive (--probe-interval 0 turns probing off)")
+	}
+	stats := &backend.Stats{}
+	b, model, err := openBackend(ctx, o, stats)
 	if err != nil {
 		return err
 	}
-	defer helper.Close()
+	defer b.Close()
 
-	srv := &Server{helper: helper, gates: make(chan struct{}, o.concurrency)}
+	srv := &Server{
+		backend:  b,
+		stats:    stats,
+		gates:    newGate(o.concurrency),
+		started:  time.Now(),
+		version:  o.version,
+		gpuID:    o.gpuID,
+		modelSHA: model.sha256,
+	}
+	if model.spec != nil {
+		srv.limits = model.spec.Limits()
+	}
+	if o.probeInterval > 0 {
+		srv.probe = &probe{interval: o.probeInterval, maxLatency: o.probeLatency}
+		go srv.runProbes(ctx)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				srv.reload(o)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 	mux := http.NewServeMux()
 	// /super-resolution is the canonical multipart route; /upscale is
 	// kept as a name-only alias for any existing callers that learned
@@ -130,6 +203,9 @@ func run(o *opts) error {
 	mux.HandleFunc("/super-resolution", srv.handleUpscale)
 	mux.HandleFunc("/upscale", srv.handleUpscale)
 	mux.HandleFunc("/health", srv.handleHealth)
+	mux.HandleFunc("/livez", srv.handleLivez)
+	mux.HandleFunc("/readyz", srv.handleReadyz)
+	mux.HandleFunc("/metrics", srv.handleMetrics)
 	// /runsync is the JSON envelope shape iosuite-serve and RunPod
 	// workers use. The multipart routes above stay for ad-hoc curl /
 	// `real-esrgan-serve super-resolution` local mode.
@@ -143,264 +219,163 @@ func run(o *opts) error {
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	// Trap signals so Ctrl-C drains gracefully + reaps the helper.
-	ctx, cancel := signal.NotifyContext(context.Background(),
-		syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
 	go func() {
 		<-ctx.Done()
+		srv.draining.Store(true)
 		fmt.Fprintln(os.Stderr, "shutting down…")
 		shutCtx, shutCancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer shutCancel()
 		_ = httpSrv.Shutdown(shutCtx)
 	}()
 
-	fmt.Fprintf(os.Stderr, "real-esrgan-serve serving on http://%s (model=%s gpu=%d concurrency=%d)\n",
-		addr, filepath.Base(model), o.gpuID, o.concurrency)
+	caps := b.Capabilities()
+	name := caps.Model
+	if o.backend == backend.NamePython {
+		name = filepath.Base(name)
+	}
+	provider := ""
+	if p := caps.Provider(); p != "" {
+		provider = " provider=" + p
+	}
+	fmt.Fprintf(os.Stderr, "real-esrgan-serve serving on http://%s (backend=%s model=%s%s gpu=%d concurrency=%d)\n",
+		addr, o.backend, name, provider, o.gpuID, o.concurrency)
 	if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return fmt.Errorf("http: %w", err)
+		// Bind failures (port in use, privileged port) are the host's
+		// problem, not ours.
+		return errs.New(errs.Environment, "http: %w", err)
 	}
 	return nil
 }
 
-func resolveModel(o *opts) (string, error) {
-	if o.modelPath != "" {
-		if _, err := os.Stat(o.modelPath); err != nil {
-			return "", fmt.Errorf("--model-path %s: %w", o.modelPath, err)
-		}
-		return o.modelPath, nil
-	}
-	// Mirror upscale's lookup paths.
-	for _, variant := range []string{"_fp16", "_fp32"} {
-		filename := o.model + variant + ".onnx"
-		paths := []string{}
-		if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
-			paths = append(paths, filepath.Join(x, "real-esrgan-serve", "models", filename))
-		}
-		if home, err := os.UserHomeDir(); err == nil {
-			paths = append(paths, filepath.Join(home, ".cache", "real-esrgan-serve", "models", filename))
-		}
-		paths = append(paths, filepath.Join("/var/cache/real-esrgan-serve/models", filename))
-		for _, p := range paths {
-			if _, err := os.Stat(p); err == nil {
-				return p, nil
-			}
-		}
-	}
-	return "", fmt.Errorf(
-		"model %q not cached. Run: real-esrgan-serve fetch-model --name %s",
-		o.model, o.model,
-	)
-}
-
-// ─────────────────────────────────────────────────────────────────────
-// Persistent helper subprocess
-// ─────────────────────────────────────────────────────────────────────
-
-// helperProc wraps the long-running `upscaler.py --serve` subprocess.
-// One stdin lock + one stdout reader goroutine routes results back
-// to per-job-ID channels.
-type helperProc struct {
-	cmd     *exec.Cmd
-	stdin   io.WriteCloser
-	stdout  io.ReadCloser
-	stdLock sync.Mutex
-
-	pendingMu sync.Mutex
-	pending   map[string]chan helperEvent
-
-	closed atomic.Bool
+// artefact is the model file the python backend loads.
+type artefact struct {
+	path   string
+	sha256 string        // from the manifest, or hashed for --model-path
+	spec   *models.Model // its manifest entry; nil for --model-path
 }
 
-type helperEvent struct {
-	Event  string `json:"event"`
-	ID     string `json:"id,omitempty"`
-	Output string `json:"output,omitempty"`
-	Msg    string `json:"msg,omitempty"`
-}
+// openBackend starts the --backend that runs the jobs. Only python
+// needs the runtime and a model artefact; it is started before the
+// listener opens, so the first request never pays the warmup cost.
+// The fake and remote backends get the stock input limits: the model
+// they stand in for is not ours to look up.
+func openBackend(ctx context.Context, o *opts, stats *backend.Stats) (backend.Backend, artefact, error) {
+	switch o.backend {
+	case backend.NameFake:
+		return backend.NewFake(stats), artefact{}, nil
+	case backend.NameRemote:
+		if o.endpoint == "" {
+			return nil, artefact{}, errs.New(errs.User, "--backend remote needs --endpoint")
+		}
+		b, err := backend.NewRemote(o.endpoint, backend.RemoteOptions{APIKey: backend.APIKey(o.apiKey, o.endpoint), Stats: stats})
+		return b, artefact{}, err
+	}
 
-func startHelper(r *rrt.Resolved, model string, gpuID int) (*helperProc, error) {
-	cmd := exec.Command(r.Python,
-		r.Script,
-		"--serve",
-		"--model", model,
-		"--gpu-id", strconv.Itoa(gpuID),
-	)
-	cmd.Stderr = os.Stderr // helper logs to our stderr
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
+	loc := &rrt.Locator{
+		PythonOverride: o.pythonBin,
+		ScriptOverride: o.runtimeScript,
 	}
-	stdout, err := cmd.StdoutPipe()
+	resolved, err := loc.Locate()
 	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("start helper: %w", err)
+		return nil, artefact{}, err
 	}
 
-	hp := &helperProc{
-		cmd:     cmd,
-		stdin:   stdin,
-		stdout:  stdout,
-		pending: make(map[string]chan helperEvent),
+	model, err := resolveModel(ctx, o)
+	if err != nil {
+		return nil, artefact{}, err
 	}
 
-	// Reader: dispatches every JSONL frame to the matching pending channel
-	go hp.readLoop()
-
-	// Wait for the helper's "ready" event before declaring success.
-	// This is what makes `serve` startup feel synchronous from the
-	// outside — caller can rely on first request having warm session.
-	readyCh := make(chan helperEvent, 1)
-	hp.subscribe("__ready__", readyCh)
-	select {
-	case ev := <-readyCh:
-		if ev.Event != "ready" {
-			return nil, fmt.Errorf("helper sent %s before ready: %s", ev.Event, ev.Msg)
-		}
-	case <-time.After(120 * time.Second):
-		_ = cmd.Process.Kill()
-		return nil, errors.New("helper did not signal ready within 120s")
+	probeCtx, probeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer probeCancel()
+	if err := resolved.Probe(probeCtx); err != nil {
+		return nil, artefact{}, err
 	}
-	hp.unsubscribe("__ready__")
-
-	return hp, nil
-}
 
-func (h *helperProc) readLoop() {
-	scanner := bufio.NewScanner(h.stdout)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
-	for scanner.Scan() {
-		var ev helperEvent
-		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
-			fmt.Fprintf(os.Stderr, "[helper] non-json line: %s\n", scanner.Text())
-			continue
-		}
-		// Bootstrap: route the one-time "ready" event to a synthetic ID
-		if ev.Event == "ready" {
-			h.dispatch("__ready__", ev)
-			continue
-		}
-		if ev.ID != "" {
-			h.dispatch(ev.ID, ev)
-		}
-	}
-	// EOF or scan error — helper died. Close all pending channels so
-	// in-flight requests fail fast rather than hang forever.
-	h.closed.Store(true)
-	h.pendingMu.Lock()
-	for _, ch := range h.pending {
-		close(ch)
+	helper, err := backend.StartHelper(resolved, model.path, o.gpuID, o.provider, stats)
+	if err != nil {
+		return nil, artefact{}, errs.Wrap(errs.Runtime, err)
 	}
-	h.pending = nil
-	h.pendingMu.Unlock()
-}
-
-func (h *helperProc) subscribe(id string, ch chan helperEvent) {
-	h.pendingMu.Lock()
-	defer h.pendingMu.Unlock()
-	h.pending[id] = ch
+	return helper, model, nil
 }
 
-func (h *helperProc) unsubscribe(id string) {
-	h.pendingMu.Lock()
-	defer h.pendingMu.Unlock()
-	delete(h.pending, id)
-}
-
-func (h *helperProc) dispatch(id string, ev helperEvent) {
-	h.pendingMu.Lock()
-	ch, ok := h.pending[id]
-	h.pendingMu.Unlock()
-	if !ok {
-		return
-	}
-	select {
-	case ch <- ev:
-	default:
-		// channel full — caller already got their answer
+// resolveModel picks the artefact to load: the cached one the
+// resolver settles on or, with --auto-fetch, the one it downloads.
+// Its manifest entry bounds request sizing; a model this build can't
+// drive is refused here, before anything is fetched or the helper
+// starts. --model-path has no entry, keeps the stock limits, and is
+// hashed for /health.
+func resolveModel(ctx context.Context, o *opts) (artefact, error) {
+	if o.modelPath != "" {
+		sum, err := models.HashFile(o.modelPath)
+		if err != nil {
+			return artefact{}, errs.New(errs.User, "--model-path %s: %w", o.modelPath, err)
+		}
+		return artefact{path: o.modelPath, sha256: sum}, nil
 	}
-}
-
-func (h *helperProc) Close() error {
-	if h.closed.Swap(true) {
-		return nil
-	}
-	_ = h.stdin.Close()
-	if h.cmd.Process != nil {
-		// Give it a chance to exit cleanly, then kill.
-		done := make(chan error, 1)
-		go func() { done <- h.cmd.Wait() }()
-		select {
-		case <-done:
-		case <-time.After(5 * time.Second):
-			_ = h.cmd.Process.Kill()
-			<-done
+	mf, _, err := modelfetch.LoadManifest("", o.allowUnsigned)
+	if err != nil {
+		return artefact{}, errs.Wrap(errs.Environment, fmt.Errorf("manifest: %w", err))
+	}
+	hw := models.HardwareFor(ctx, o.gpuID, o.smArch, o.variant)
+	res, err := models.Resolve(mf, models.Query{
+		Name:     o.model,
+		Variant:  o.variant,
+		Provider: o.provider,
+		Hardware: hw,
+	})
+	variant := models.FetchVariant(o.variant, o.provider)
+	switch {
+	case err == nil:
+		if err := res.Model.Runnable(0); err != nil {
+			return artefact{}, errs.New(errs.User, "--model: %w", err)
 		}
+		fmt.Fprintf(os.Stderr, "model: %s [%s]\n", filepath.Base(res.Path), res.Reason)
+		return artefact{path: res.Path, sha256: res.Model.SHA256, spec: &res.Model}, nil
+	case !errors.Is(err, models.ErrNotCached):
+		return artefact{}, err
+	case !o.autoFetch:
+		return artefact{}, errs.New(errs.Environment,
+			"%v. Run: real-esrgan-serve fetch-model --name %s --variant %s (or start with --auto-fetch)",
+			err, o.model, variant,
+		)
+	}
+	spec, err := mf.Find(models.Filter{Name: o.model, Variant: variant, Hardware: hw})
+	if err == nil {
+		err = spec.Runnable(0)
 	}
-	return nil
-}
-
-// upscale sends one job to the helper and waits for the result.
-func (h *helperProc) upscale(ctx context.Context, jobID, in, out string) (helperEvent, error) {
-	if h.closed.Load() {
-		return helperEvent{}, errors.New("helper is dead — restart the server")
+	if err != nil {
+		return artefact{}, errs.New(errs.User, "--model: %w", err)
 	}
-
-	ch := make(chan helperEvent, 4)
-	h.subscribe(jobID, ch)
-	defer h.unsubscribe(jobID)
-
-	frame, _ := json.Marshal(map[string]string{
-		"id": jobID, "input": in, "output": out,
+	// Progress goes to stderr with the rest of the server's logs;
+	// stdout stays quiet for whatever supervises us.
+	fr, err := modelfetch.Fetch(ctx, modelfetch.Request{
+		Name: o.model, Variant: spec.Variant, SMArch: spec.SMArch, GPUClass: spec.GPUClass, AllowUnsigned: o.allowUnsigned,
 	})
-	frame = append(frame, '\n')
-
-	h.stdLock.Lock()
-	_, err := h.stdin.Write(frame)
-	h.stdLock.Unlock()
 	if err != nil {
-		return helperEvent{}, fmt.Errorf("helper stdin: %w", err)
-	}
-
-	for {
-		select {
-		case ev, ok := <-ch:
-			if !ok {
-				return helperEvent{}, errors.New("helper died mid-job")
-			}
-			switch ev.Event {
-			case "done":
-				return ev, nil
-			case "error":
-				return ev, fmt.Errorf("helper error: %s", ev.Msg)
-			default:
-				// progress / preprocessing / inferring — keep listening
-			}
-		case <-ctx.Done():
-			return helperEvent{}, ctx.Err()
-		}
+		return artefact{}, fmt.Errorf("--auto-fetch: %w", err)
 	}
+	return artefact{path: fr.Path, sha256: fr.Model.SHA256, spec: &fr.Model}, nil
 }
 
 // ─────────────────────────────────────────────────────────────────────
 // HTTP server
 // ─────────────────────────────────────────────────────────────────────
 
-// Server holds the helper + a semaphore limiting in-flight jobs.
+// Server holds the backend + a semaphore limiting in-flight jobs.
 type Server struct {
-	helper *helperProc
-	gates  chan struct{}
-}
-
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if s.helper.closed.Load() {
-		http.Error(w, "helper dead", http.StatusServiceUnavailable)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"status":"ok"}`))
+	backend backend.Backend
+	stats   *backend.Stats
+	gates   *gate
+	limits  sizing.Limits // the model's input bounds, from its manifest entry
+	probe   *probe        // nil unless --probe-interval
+
+	// For /health.
+	started  time.Time
+	version  string
+	gpuID    int
+	modelSHA string
+	draining atomic.Bool  // shutting down: /readyz fails
+	lastJob  atomic.Int64 // UnixNano of the last request answered
 }
 
 func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
@@ -433,17 +408,40 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 		outExt = "." + outExt
 	}
 
-	select {
-	case s.gates <- struct{}{}:
-	case <-r.Context().Done():
+	scale := imageinfo.NativeScale
+	if v := r.URL.Query().Get("scale"); v != "" {
+		scale, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("scale: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	sreq := sizing.Request{Scale: scale, Limits: s.limits}
+	resample := r.URL.Query().Get("resample")
+	if err := validateSizing(sreq, resample); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec, err := planJob(in, outExt, sreq, resample)
+	if err == nil {
+		err = s.supports(spec)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	leave, ok := s.gates.enter(r.Context())
+	if !ok {
 		return
 	}
-	out, _, err := s.runOnePathBased(r.Context(), in, outExt)
-	<-s.gates
+	out, _, err := s.runOnePathBased(r.Context(), in, spec)
+	leave()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.lastJob.Store(time.Now().UnixNano())
 
 	switch outExt {
 	case ".png":
@@ -461,8 +459,6 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var jobSeq uint64
-
 // handleRunSync — JSON-envelope alias of /upscale matching the
 // iosuite-serve / RunPod-worker wire contract. iosuite's
 // LocalProvider posts here unchanged from what it would post to a
@@ -474,9 +470,18 @@ var jobSeq uint64
 //	{"input": {
 //	    "images": [{"image_base64": "..."}, ...],
 //	    "output_format": "jpg" | "png" | "webp",
-//	    "tile": false                  // not yet supported here; rejected if true
+//	    "scale": 4,                    // optional; non-4 resamples, >4 chains passes
+//	    "resample": "lanczos",         // optional; filter used when scale != 4
+//	    "target_width": 3840,          // optional; output at least this wide
+//	    "target_height": 2160,         // optional; output at least this tall
+//	    "max_dimension": 4096,         // optional; fit output within NxN
+//	    "allow_downscale": false,      // optional; shrink inputs past the target
+//	    "tile": false                  // optional; force the slice/blend path
 //	}}
 //
+// scale and the target fields are mutually exclusive; see
+// internal/sizing for how passes are planned.
+//
 // Response:
 //
 //	{"status": "COMPLETED",
@@ -484,7 +489,8 @@ var jobSeq uint64
 //	                          "output_format": "..."}]}}
 //
 // Errors return non-2xx so iosuite can branch on status code +
-// JSON error body. tile:true returns 400 explaining the limitation.
+// JSON error body. Tiling, asked for or needed by the input size,
+// returns 400 when the backend doesn't advertise the tile capability.
 func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
@@ -493,31 +499,9 @@ func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 	const maxBody = 25 * 1024 * 1024
 	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 
-	type imageInput struct {
-		ImageBase64 string `json:"image_base64,omitempty"`
-	}
-	type runSyncInput struct {
-		Images        []imageInput `json:"images"`
-		OutputFormat  string       `json:"output_format,omitempty"`
-		Tile          bool         `json:"tile,omitempty"`
-		DiscardOutput bool         `json:"discard_output,omitempty"`
-	}
-	type runSyncReq struct {
-		Input runSyncInput `json:"input"`
-	}
-	type imageOutput struct {
-		ImageBase64  string `json:"image_base64,omitempty"`
-		ExecMS       int    `json:"exec_ms"`
-		OutputFormat string `json:"output_format,omitempty"`
-	}
-	type runSyncResp struct {
-		Status string `json:"status"`
-		Output struct {
-			Outputs []imageOutput `json:"outputs"`
-		} `json:"output"`
-	}
-
-	var req runSyncReq
+	// The envelope's types are pkg/client's, so the client can't drift
+	// from what is accepted here.
+	var req client.Request
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
@@ -528,14 +512,6 @@ func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "input.images is required (non-empty array)", http.StatusBadRequest)
 		return
 	}
-	if req.Input.Tile {
-		// Tile mode lives in the RunPod worker (providers/runpod/handler.py
-		// + runtime/tiling.py). The local Go server currently shells a
-		// per-image helper that doesn't honour the flag. Failing loud
-		// here keeps the caller from silently getting un-tiled output.
-		http.Error(w, "tile=true is not supported by `real-esrgan-serve serve` — use the RunPod worker for inputs >1280²", http.StatusBadRequest)
-		return
-	}
 
 	outFormat := req.Input.OutputFormat
 	if outFormat == "" {
@@ -543,8 +519,21 @@ func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 	}
 	outExt := "." + outFormat
 
-	resp := runSyncResp{Status: "COMPLETED"}
-	resp.Output.Outputs = make([]imageOutput, 0, len(req.Input.Images))
+	sreq := sizing.Request{
+		Scale:          req.Input.Scale,
+		TargetWidth:    req.Input.TargetWidth,
+		TargetHeight:   req.Input.TargetHeight,
+		MaxDimension:   req.Input.MaxDimension,
+		AllowDownscale: req.Input.AllowDownscale,
+		Limits:         s.limits,
+	}
+	if err := validateSizing(sreq, req.Input.Resample); err != nil {
+		http.Error(w, fmt.Sprintf("input: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp := client.Response{Status: client.StatusCompleted}
+	resp.Output.Outputs = make([]client.ImageOutput, 0, len(req.Input.Images))
 
 	for i, img := range req.Input.Images {
 		if img.ImageBase64 == "" {
@@ -557,28 +546,37 @@ func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		spec, err := planJob(raw, outExt, sreq, req.Input.Resample)
+		if err == nil {
+			spec.plan.Tile = spec.plan.Tile || req.Input.Tile
+			err = s.supports(spec)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("input.images[%d]: %v", i, err), http.StatusBadRequest)
+			return
+		}
+
 		// Backpressure gate per image — same semantics as
 		// handleUpscale's single-image path.
-		select {
-		case s.gates <- struct{}{}:
-		case <-r.Context().Done():
+		leave, ok := s.gates.enter(r.Context())
+		if !ok {
 			return
 		}
-
-		out, execMS, err := s.runOnePathBased(r.Context(), raw, outExt)
-		<-s.gates
+		out, execMS, err := s.runOnePathBased(r.Context(), raw, spec)
+		leave()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("upscale image %d: %v", i, err), http.StatusInternalServerError)
 			return
 		}
+		s.lastJob.Store(time.Now().UnixNano())
 
 		var b64Out string
 		if !req.Input.DiscardOutput {
 			b64Out = base64.StdEncoding.EncodeToString(out)
 		}
-		resp.Output.Outputs = append(resp.Output.Outputs, imageOutput{
+		resp.Output.Outputs = append(resp.Output.Outputs, client.ImageOutput{
 			ImageBase64:  b64Out,
-			ExecMS:       execMS,
+			ExecMS:       int64(execMS),
 			OutputFormat: outFormat,
 		})
 	}
@@ -589,11 +587,76 @@ func (s *Server) handleRunSync(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validateSizing checks the optional sizing/resample request fields.
+// An empty resample means "helper default" and is always accepted.
+// Any scale the model was accepted for at startup can be served: a
+// 4x model chains passes up and resamples down, on every backend.
+func validateSizing(r sizing.Request, resample string) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if resample != "" {
+		if err := imageinfo.ValidateResample(resample); err != nil {
+			return fmt.Errorf("resample: %w", err)
+		}
+	}
+	return nil
+}
+
+// jobSpec is everything runOnePathBased needs beyond the input bytes:
+// the output encoding and the pass plan worked out from the header.
+type jobSpec struct {
+	outExt   string
+	resample string
+	plan     sizing.Plan
+	in       imageinfo.Info
+	known    bool // in came from a readable header; plan has real dims
+}
+
+// planJob reads the input header and plans its passes. Scale-only
+// requests tolerate an unreadable header (the helper decodes more
+// formats than package image); target modes can't plan without dims.
+func planJob(raw []byte, outExt string, r sizing.Request, resample string) (jobSpec, error) {
+	spec := jobSpec{outExt: outExt, resample: resample}
+	info, err := imageinfo.ProbeBytes(raw)
+	switch {
+	case err == nil:
+		plan, err := sizing.Compute(info.Width, info.Height, r)
+		if err != nil {
+			return jobSpec{}, err
+		}
+		spec.plan, spec.in, spec.known = plan, info, true
+	case r.HasTarget():
+		return jobSpec{}, fmt.Errorf("read dimensions for target sizing: %w", err)
+	default:
+		spec.plan = sizing.ForScale(r.Scale)
+	}
+	return spec, nil
+}
+
+// supports refuses a job the backend can't run, as told by its
+// capabilities (for the helper, those of its ready event). Failing
+// loud here keeps the caller from silently getting un-tiled output.
+func (s *Server) supports(spec jobSpec) error {
+	if caps := s.backend.Capabilities(); spec.plan.Tile && !caps.Has(protocol.CapTile) {
+		return fmt.Errorf("this input needs tiled inference, which the %s does not support; "+
+			"upgrade runtime/upscaler.py or use the RunPod worker", caps)
+	}
+	return nil
+}
+
 // runOnePathBased stages the input bytes to a tmp dir, calls the
-// helper, reads the output, and returns it. Shared by /upscale's
+// backend, reads the output, and returns it. Shared by /upscale's
 // multipart path and /runsync's JSON path so both produce
 // byte-identical results.
-func (s *Server) runOnePathBased(ctx context.Context, in []byte, outExt string) ([]byte, int, error) {
+func (s *Server) runOnePathBased(ctx context.Context, in []byte, spec jobSpec) ([]byte, int, error) {
+	plan := spec.plan
+	if plan.Passthrough && sameFormat(spec.in.Format, spec.outExt) {
+		// Already at the target and already in the requested
+		// encoding: hand the bytes straight back, no helper round-trip.
+		return in, 0, nil
+	}
+
 	tmpDir, err := os.MkdirTemp("", "res-job-")
 	if err != nil {
 		return nil, 0, fmt.Errorf("tmpdir: %w", err)
@@ -601,23 +664,42 @@ func (s *Server) runOnePathBased(ctx context.Context, in []byte, outExt string)
 	defer os.RemoveAll(tmpDir)
 
 	inPath := filepath.Join(tmpDir, "input.bin")
-	outPath := filepath.Join(tmpDir, "output"+outExt)
+	outPath := filepath.Join(tmpDir, "output"+spec.outExt)
 
 	if err := os.WriteFile(inPath, in, 0o644); err != nil {
 		return nil, 0, fmt.Errorf("write input: %w", err)
 	}
 
-	jobID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&jobSeq, 1))
 	jobCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
 
 	t0 := time.Now()
-	if _, err := s.helper.upscale(jobCtx, jobID, inPath, outPath); err != nil {
+	job := backend.Job{Input: inPath, Output: outPath, Plan: plan, Resample: spec.resample}
+	if _, err := s.backend.Upscale(jobCtx, job); err != nil {
 		return nil, 0, err
 	}
 	out, err := os.ReadFile(outPath)
 	if err != nil {
 		return nil, 0, fmt.Errorf("read output: %w", err)
 	}
+	if spec.known {
+		if err := imageinfo.CheckScaledBytes(spec.in, out, plan.Scale); err != nil {
+			return nil, 0, err
+		}
+	}
 	return out, int(time.Since(t0).Milliseconds()), nil
 }
+
+// sameFormat reports whether a package-image format name ("jpeg",
+// "png") is the encoding an output extension asks for.
+func sameFormat(format, outExt string) bool {
+	switch outExt {
+	case ".jpg", ".jpeg":
+		return format == "jpeg"
+	case ".png":
+		return format == "png"
+	case ".gif":
+		return format == "gif"
+	}
+	return false
+}
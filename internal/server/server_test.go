@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/backend"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
+	"github.com/ls-ads/real-esrgan-serve/internal/protocol"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+	"github.com/ls-ads/real-esrgan-serve/pkg/client"
+)
+
+// capsBackend is the fake backend advertising only features, to stand
+// in for helpers of different capabilities.
+type capsBackend struct {
+	*backend.Fake
+	features []string
+}
+
+func (c capsBackend) Capabilities() backend.Capabilities {
+	caps := c.Fake.Capabilities()
+	caps.Features = c.features
+	return caps
+}
+
+func pngBase64(t *testing.T, w, h int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// TestRunSync_capabilities: tiling, asked for or needed by the input
+// size, runs only on a backend that advertises it.
+func TestRunSync_capabilities(t *testing.T) {
+	cases := []struct {
+		name   string
+		caps   []string
+		w, h   int
+		input  string
+		status int
+	}{
+		{"plain", nil, 80, 64, `"output_format": "png"`, http.StatusOK},
+		{"tile asked, not advertised", nil, 80, 64, `"tile": true`, http.StatusBadRequest},
+		// The second 4× pass sees 1600x1200, past the single-shot cap.
+		{"tile needed, not advertised", nil, 400, 300, `"scale": 16`, http.StatusBadRequest},
+		{"tile asked, advertised", []string{protocol.CapTile}, 80, 64, `"tile": true, "output_format": "png"`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{backend: capsBackend{backend.NewFake(nil), tc.caps}, gates: newGate(1)}
+
+			body := fmt.Sprintf(`{"input": {"images": [{"image_base64": %q}], %s}}`, pngBase64(t, tc.w, tc.h), tc.input)
+			rec, cfg := runSync(t, s.handleRunSync, body)
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), "tiled inference") {
+					t.Errorf("body: %s", rec.Body)
+				}
+				return
+			}
+			if cfg.Width != 320 || cfg.Height != 256 {
+				t.Errorf("output %dx%d", cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
+
+// runSync posts body to handler h and decodes the one output of a
+// 200 response.
+func runSync(t *testing.T, h http.HandlerFunc, body string) (*httptest.ResponseRecorder, image.Config) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/runsync", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		return rec, image.Config{}
+	}
+	var resp client.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Output.Outputs) != 1 {
+		t.Fatalf("response: %s, %v", rec.Body, err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(resp.Output.Outputs[0].ImageBase64)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("output: %v", err)
+	}
+	return rec, cfg
+}
+
+// TestRunSync_remote: a server on the remote backend forwards to
+// another server (here on the fake backend) and answers with its
+// output; both count the job.
+func TestRunSync_remote(t *testing.T) {
+	farStats := &backend.Stats{}
+	far := &Server{backend: backend.NewFake(farStats), stats: farStats, gates: newGate(1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runsync", far.handleRunSync)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	stats := &backend.Stats{}
+	remote, err := backend.NewRemote(ts.URL, backend.RemoteOptions{Stats: stats})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	near := &Server{backend: remote, stats: stats, gates: newGate(1)}
+
+	body := fmt.Sprintf(`{"input": {"images": [{"image_base64": %q}], "scale": 2, "output_format": "png"}}`, pngBase64(t, 80, 64))
+	rec, cfg := runSync(t, near.handleRunSync, body)
+	if rec.Code != http.StatusOK || cfg.Width != 160 || cfg.Height != 128 {
+		t.Fatalf("status %d, output %dx%d: %s", rec.Code, cfg.Width, cfg.Height, rec.Body)
+	}
+	if farStats.Done.Load() != 1 || stats.Done.Load() != 1 {
+		t.Errorf("done: far %d, near %d", farStats.Done.Load(), stats.Done.Load())
+	}
+
+	rec = httptest.NewRecorder()
+	near.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `real_esrgan_jobs_total{outcome="done"} 1`) {
+		t.Errorf("metrics:\n%s", rec.Body)
+	}
+}
+
+// TestRunSync_subNative: on the python backend the server runs with
+// the resolved 4x entry's limits; factors below 4 are still served, as
+// a resample of one pass, exactly as on the fake and remote backends.
+func TestRunSync_subNative(t *testing.T) {
+	spec := &models.Model{Name: "realesrgan-x4plus", Variant: models.VariantFP16, Scale: 4,
+		Input: models.InputLimits{MinDim: sizing.MinDim, MaxUntiledDim: sizing.MaxUntiledDim, MaxTiledDim: sizing.MaxTiledDim}}
+	if err := spec.Runnable(0); err != nil {
+		t.Fatalf("startup check: %v", err)
+	}
+	s := &Server{backend: backend.NewFake(nil), gates: newGate(1), limits: spec.Limits()}
+	cases := []struct {
+		scale string
+		w, h  int
+	}{
+		{"2", 160, 128},
+		{"3", 240, 192},
+		{"1.5", 120, 96},
+	}
+	for _, tc := range cases {
+		t.Run(tc.scale, func(t *testing.T) {
+			body := fmt.Sprintf(`{"input": {"images": [{"image_base64": %q}], "scale": %s, "output_format": "png"}}`, pngBase64(t, 80, 64), tc.scale)
+			rec, cfg := runSync(t, s.handleRunSync, body)
+			if rec.Code != http.StatusOK || cfg.Width != tc.w || cfg.Height != tc.h {
+				t.Fatalf("status %d, output %dx%d, want %dx%d: %s", rec.Code, cfg.Width, cfg.Height, tc.w, tc.h, rec.Body)
+			}
+		})
+	}
+}
+
+// TestProbeImage: the probe runs the image the benchmarks do.
+func TestProbeImage(t *testing.T) {
+	want, err := os.ReadFile("../../deploy/bench/64x64-rgb.png.b64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probeImage != strings.TrimSpace(string(want)) {
+		t.Error("probeImage differs from deploy/bench/64x64-rgb.png.b64")
+	}
+}
+
+// probeBackend is the fake backend made slow, failing or dead.
+type probeBackend struct {
+	*backend.Fake
+	delay time.Duration
+	err   error
+	dead  bool
+}
+
+func (p probeBackend) Upscale(ctx context.Context, job backend.Job) (backend.Result, error) {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return backend.Result{}, ctx.Err()
+	}
+	if p.err != nil {
+		return backend.Result{}, p.err
+	}
+	return p.Fake.Upscale(ctx, job)
+}
+
+func (p probeBackend) Alive() bool { return !p.dead }
+
+func TestProbeOnce(t *testing.T) {
+	cases := []struct {
+		name  string
+		b     probeBackend
+		limit time.Duration
+		want  string // in the error; "" = passes
+	}{
+		{"ok", probeBackend{}, 10 * time.Second, ""},
+		{"failing", probeBackend{err: errors.New("CUDA out of memory")}, 10 * time.Second, "CUDA out of memory"},
+		{"slow", probeBackend{delay: 10 * time.Second}, 50 * time.Millisecond, "no answer within 50ms"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.b.Fake = backend.NewFake(nil)
+			s := &Server{backend: tc.b, gates: newGate(1), probe: &probe{interval: time.Hour, maxLatency: tc.limit}}
+			r := s.probeOnce(context.Background())
+			if tc.want == "" && r.Error != "" || !strings.Contains(r.Error, tc.want) {
+				t.Errorf("error %q, want %q", r.Error, tc.want)
+			}
+		})
+	}
+}
+
+// TestProbes: /livez, /readyz and /health across the states a server
+// passes through.
+func TestProbes(t *testing.T) {
+	failed := &probe{last: &probeResult{Error: "no answer within 10s"}}
+	passed := &probe{last: &probeResult{LatencyMS: 12}}
+	cases := []struct {
+		name     string
+		dead     bool
+		draining bool
+		probe    *probe
+		livez    int
+		readyz   string // "" = 200
+	}{
+		{"serving", false, false, nil, 200, ""},
+		{"probe passing", false, false, passed, 200, ""},
+		{"first probe pending", false, false, &probe{}, 200, "waiting for the first probe"},
+		{"probe failing", false, false, failed, 200, "probe failed: no answer within 10s"},
+		{"draining", false, true, passed, 200, "shutting down"},
+		{"helper dead", true, false, passed, 503, "helper dead"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{backend: probeBackend{Fake: backend.NewFake(nil), dead: tc.dead}, gates: newGate(1), probe: tc.probe}
+			s.draining.Store(tc.draining)
+
+			if rec := get(s.handleLivez, "/livez"); rec.Code != tc.livez {
+				t.Errorf("/livez %d, want %d", rec.Code, tc.livez)
+			}
+			rec := get(s.handleReadyz, "/readyz")
+			if want := map[bool]int{true: 200, false: 503}[tc.readyz == ""]; rec.Code != want {
+				t.Errorf("/readyz %d, want %d", rec.Code, want)
+			}
+			if !strings.Contains(rec.Body.String(), tc.readyz) {
+				t.Errorf("/readyz: %s, want %q", rec.Body, tc.readyz)
+			}
+			if rec := get(s.handleHealth, "/health"); rec.Code != tc.livez {
+				t.Errorf("/health %d, want %d", rec.Code, tc.livez)
+			}
+		})
+	}
+}
+
+// TestHealth_verbose: ?verbose=1 adds the detail, and only then.
+func TestHealth_verbose(t *testing.T) {
+	s := &Server{
+		backend:  backend.NewFake(nil),
+		gates:    newGate(2),
+		started:  time.Now().Add(-time.Minute),
+		version:  "v1.2.3",
+		gpuID:    1,
+		modelSHA: "abc123",
+	}
+	s.lastJob.Store(time.Now().UnixNano())
+	leave, _ := s.gates.enter(context.Background())
+	defer leave()
+
+	var h map[string]any
+	if err := json.Unmarshal(get(s.handleHealth, "/health").Body.Bytes(), &h); err != nil || h["version"] != nil {
+		t.Fatalf("plain /health: %v, %v", h, err)
+	}
+	if err := json.Unmarshal(get(s.handleHealth, "/health?verbose=1").Body.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"status":      "ok",
+		"ready":       true,
+		"version":     "v1.2.3",
+		"uptime_s":    60.0,
+		"backend":     backend.NameFake,
+		"gpu_id":      1.0,
+		"queue_depth": 0.0,
+		"in_flight":   1.0,
+	}
+	for k, v := range want {
+		if h[k] != v {
+			t.Errorf("%s = %v, want %v", k, h[k], v)
+		}
+	}
+	if m, _ := h["model"].(map[string]any); m["sha256"] != "abc123" {
+		t.Errorf("model = %v", h["model"])
+	}
+	if h["last_success"] == nil {
+		t.Error("no last_success")
+	}
+}
+
+func get(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
@@ -0,0 +1,220 @@
+// Package sizing turns "what size do I want" into "how many model
+// passes and what final resample", for `super-resolution` and the
+// `/runsync` envelope alike.
+//
+// Real-ESRGAN only ever runs forward at 4×. Every other output size is
+// built from that:
+//
+//	scale ≤ 4           one pass, resample the 4× output down
+//	4 < scale ≤ 16      two passes (16× = 4× then 4×), and so on
+//	target ≤ input      zero passes: pass through, or resample down
+//
+// The plan is computed Go-side (where we can read headers cheaply) and
+// handed to runtime/upscaler.py as --passes/--outscale. The helper
+// doesn't second-guess it; it only knows how to execute one.
+package sizing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+)
+
+// MinDim is the engine profile's smallest per-axis input (docs/IMAGE-IO.md
+// § 4.2). Below it the model pass would be rejected by the runtime.
+const MinDim = 64
+
+// MaxTiledDim is the largest per-axis input a single model pass will
+// accept, tiled. Same ceiling as docs/IMAGE-IO.md § 6 — set by the
+// float32 stitch canvas, not the engine profile.
+const MaxTiledDim = 4096
+
+// MaxUntiledDim is the engine's single-shot profile max. Pass inputs
+// above it go through runtime/tiling.py.
+const MaxUntiledDim = 1280
+
+// Limits are one model's per-axis input bounds (the manifest's
+// `input`). Zero fields fall back to the constants above, which are
+// the stock Real-ESRGAN export's.
+type Limits struct {
+	MinDim        int
+	MaxUntiledDim int
+	MaxTiledDim   int
+}
+
+func (l Limits) orDefaults() Limits {
+	if l.MinDim == 0 {
+		l.MinDim = MinDim
+	}
+	if l.MaxUntiledDim == 0 {
+		l.MaxUntiledDim = MaxUntiledDim
+	}
+	if l.MaxTiledDim == 0 {
+		l.MaxTiledDim = MaxTiledDim
+	}
+	return l
+}
+
+// Request is the caller's sizing intent. Exactly one of Scale or the
+// target fields drives the plan; Scale is ignored when any target is set.
+type Request struct {
+	Scale          float64 // explicit factor; 0 = unset
+	TargetWidth    int     // scale until output width ≥ this
+	TargetHeight   int     // scale until output height ≥ this
+	MaxDimension   int     // cap: neither output axis exceeds this
+	AllowDownscale bool    // inputs already past the target are shrunk rather than passed through
+	Limits         Limits  // the model's input bounds; zero = defaults
+}
+
+// HasTarget reports whether any target-size field is set.
+func (r Request) HasTarget() bool {
+	return r.TargetWidth > 0 || r.TargetHeight > 0 || r.MaxDimension > 0
+}
+
+// Validate checks the request in isolation (no input dims needed).
+func (r Request) Validate() error {
+	if r.TargetWidth < 0 || r.TargetHeight < 0 || r.MaxDimension < 0 {
+		return errors.New("target-width, target-height and max-dimension must be >= 0")
+	}
+	if r.HasTarget() && r.Scale != 0 {
+		return errors.New("scale cannot be combined with target-width / target-height / max-dimension")
+	}
+	if !r.HasTarget() && r.Scale != 0 {
+		return imageinfo.ValidateScale(r.Scale)
+	}
+	return nil
+}
+
+// Plan is how a single input gets to its requested size. JSON tags are
+// the `plan` event payload in --json-events mode.
+type Plan struct {
+	InputWidth   int     `json:"input_width"`
+	InputHeight  int     `json:"input_height"`
+	Scale        float64 `json:"scale"`
+	Passes       int     `json:"passes"`
+	Tile         bool    `json:"tile"`
+	Passthrough  bool    `json:"passthrough"`
+	OutputWidth  int     `json:"output_width"`
+	OutputHeight int     `json:"output_height"`
+	Reason       string  `json:"reason,omitempty"`
+}
+
+// Compute plans the passes for an input of w×h.
+func Compute(w, h int, r Request) (Plan, error) {
+	if w <= 0 || h <= 0 {
+		return Plan{}, fmt.Errorf("input dimensions %dx%d are invalid", w, h)
+	}
+	if err := r.Validate(); err != nil {
+		return Plan{}, err
+	}
+	p := Plan{InputWidth: w, InputHeight: h}
+
+	scale := r.Scale
+	switch {
+	case r.HasTarget():
+		scale = targetScale(w, h, r)
+		if scale <= 1 {
+			if !r.AllowDownscale || scale == 1 {
+				p.Scale = 1
+				p.Passthrough = true
+				p.OutputWidth, p.OutputHeight = w, h
+				p.Reason = "input already meets the target"
+				return p, nil
+			}
+			p.Scale = scale
+			p.OutputWidth, p.OutputHeight = imageinfo.ScaledDims(w, h, scale)
+			p.Reason = "input larger than the target; resample down, no model pass"
+			return p, nil
+		}
+	case scale == 0:
+		scale = imageinfo.NativeScale
+	}
+
+	lim := r.Limits.orDefaults()
+	if w < lim.MinDim || h < lim.MinDim {
+		return Plan{}, fmt.Errorf("input %dx%d is below the %dx%d minimum", w, h, lim.MinDim, lim.MinDim)
+	}
+	p.Scale = scale
+	p.Passes = passesFor(scale)
+	p.OutputWidth, p.OutputHeight = imageinfo.ScaledDims(w, h, scale)
+
+	// Walk the passes to find the largest pass input. Only the last
+	// pass of a multi-pass plan sees a resampled intermediate (see
+	// runtime/upscaler.py:_run_passes); every earlier one sees the
+	// previous native 4× output.
+	pw, ph := w, h
+	for i := 0; i < p.Passes; i++ {
+		if i > 0 && i == p.Passes-1 {
+			pw, ph = imageinfo.ScaledDims(w, h, scale/imageinfo.NativeScale)
+		}
+		if pw > lim.MaxTiledDim || ph > lim.MaxTiledDim {
+			return Plan{}, fmt.Errorf(
+				"pass %d of %d would run on %dx%d, above the %dx%d tiled limit — request a smaller output",
+				i+1, p.Passes, pw, ph, lim.MaxTiledDim, lim.MaxTiledDim)
+		}
+		if pw > lim.MaxUntiledDim || ph > lim.MaxUntiledDim {
+			p.Tile = true
+		}
+		pw, ph = pw*int(imageinfo.NativeScale), ph*int(imageinfo.NativeScale)
+	}
+	if p.Passes > 1 {
+		p.Reason = fmt.Sprintf("%d chained 4x passes", p.Passes)
+	} else if scale != imageinfo.NativeScale {
+		p.Reason = "one 4x pass, resampled to the requested scale"
+	}
+	if p.Tile {
+		if p.Reason != "" {
+			p.Reason += ", "
+		}
+		p.Reason += fmt.Sprintf("tiled (a pass input exceeds %d px)", lim.MaxUntiledDim)
+	}
+	return p, nil
+}
+
+// ForScale plans an explicit scale when the input's dimensions are
+// unknown (unreadable header). Passes are still right; output dims and
+// the tiling decision can't be, so tiling is left to the helper's own
+// per-pass short-circuit for multi-pass plans.
+func ForScale(scale float64) Plan {
+	if scale == 0 {
+		scale = imageinfo.NativeScale
+	}
+	p := Plan{Scale: scale, Passes: passesFor(scale)}
+	p.Tile = p.Passes > 1
+	return p
+}
+
+// targetScale is the factor that satisfies every target at once:
+// large enough to reach each "at least" target, small enough to stay
+// inside max-dimension. When they conflict the cap wins — "fit within"
+// is a hard limit, "at least" is a wish.
+func targetScale(w, h int, r Request) float64 {
+	scale := 0.0
+	if r.TargetWidth > 0 {
+		scale = math.Max(scale, float64(r.TargetWidth)/float64(w))
+	}
+	if r.TargetHeight > 0 {
+		scale = math.Max(scale, float64(r.TargetHeight)/float64(h))
+	}
+	if r.MaxDimension > 0 {
+		limit := float64(r.MaxDimension) / float64(max(w, h))
+		if scale == 0 || scale > limit {
+			scale = limit
+		}
+	}
+	return scale
+}
+
+// passesFor is the number of native passes needed to reach scale:
+// ceil(log4(scale)), never less than one. Computed by repeated
+// multiplication rather than math.Log so 16 is exactly two passes.
+func passesFor(scale float64) int {
+	n, reach := 1, imageinfo.NativeScale
+	for reach < scale {
+		reach *= imageinfo.NativeScale
+		n++
+	}
+	return n
+}
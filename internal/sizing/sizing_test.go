@@ -0,0 +1,135 @@
+package sizing
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCompute walks the planner through each mode. The interesting
+// branches are the pass count (16× must be exactly two passes, not
+// three from float log rounding), the tile decision on the second
+// pass, and what happens when the input is already past the target.
+func TestCompute(t *testing.T) {
+	cases := []struct {
+		name        string
+		w, h        int
+		req         Request
+		passes      int
+		scale       float64
+		outW, outH  int
+		tile        bool
+		passthrough bool
+	}{
+		{
+			name: "default is one native pass",
+			w:    640, h: 480,
+			passes: 1, scale: 4, outW: 2560, outH: 1920,
+		},
+		{
+			name: "scale 2 is one pass resampled down",
+			w:    640, h: 480, req: Request{Scale: 2},
+			passes: 1, scale: 2, outW: 1280, outH: 960,
+		},
+		{
+			name: "scale 16 is exactly two passes",
+			w:    100, h: 100, req: Request{Scale: 16},
+			passes: 2, scale: 16, outW: 1600, outH: 1600,
+		},
+		{
+			name: "second pass above 1280 turns tiling on",
+			w:    400, h: 300, req: Request{Scale: 16},
+			passes: 2, scale: 16, outW: 6400, outH: 4800, tile: true,
+		},
+		{
+			name: "target width rounds to the exact width",
+			w:    1000, h: 750, req: Request{TargetWidth: 3840},
+			passes: 1, scale: 3.84, outW: 3840, outH: 2880,
+		},
+		{
+			name: "target width past 4x needs two passes",
+			w:    500, h: 500, req: Request{TargetWidth: 3000},
+			passes: 2, scale: 6, outW: 3000, outH: 3000, tile: false,
+		},
+		{
+			name: "both targets: at least both",
+			w:    1000, h: 1000, req: Request{TargetWidth: 2000, TargetHeight: 3000},
+			passes: 1, scale: 3, outW: 3000, outH: 3000,
+		},
+		{
+			name: "max-dimension fits the long edge",
+			w:    1024, h: 512, req: Request{MaxDimension: 4096},
+			passes: 1, scale: 4, outW: 4096, outH: 2048,
+		},
+		{
+			name: "max-dimension caps a target",
+			w:    1000, h: 500, req: Request{TargetWidth: 8000, MaxDimension: 2000},
+			passes: 1, scale: 2, outW: 2000, outH: 1000,
+		},
+		{
+			name: "input past target passes through by default",
+			w:    5000, h: 3000, req: Request{TargetWidth: 3840},
+			passes: 0, scale: 1, outW: 5000, outH: 3000, passthrough: true,
+		},
+		{
+			name: "tiny input past the target still passes through",
+			w:    40, h: 40, req: Request{MaxDimension: 32},
+			passes: 0, scale: 1, outW: 40, outH: 40, passthrough: true,
+		},
+		{
+			name: "input past target downscales when allowed",
+			w:    7680, h: 4320, req: Request{TargetWidth: 3840, AllowDownscale: true},
+			passes: 0, scale: 0.5, outW: 3840, outH: 2160,
+		},
+		{
+			name: "a model's own untiled limit decides tiling",
+			w:    800, h: 600, req: Request{Limits: Limits{MaxUntiledDim: 512}},
+			passes: 1, scale: 4, outW: 3200, outH: 2400, tile: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Compute(tc.w, tc.h, tc.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Passes != tc.passes || p.Scale != tc.scale {
+				t.Errorf("passes=%d scale=%v, want passes=%d scale=%v", p.Passes, p.Scale, tc.passes, tc.scale)
+			}
+			if p.OutputWidth != tc.outW || p.OutputHeight != tc.outH {
+				t.Errorf("output %dx%d, want %dx%d", p.OutputWidth, p.OutputHeight, tc.outW, tc.outH)
+			}
+			if p.Tile != tc.tile {
+				t.Errorf("tile=%v, want %v", p.Tile, tc.tile)
+			}
+			if p.Passthrough != tc.passthrough {
+				t.Errorf("passthrough=%v, want %v", p.Passthrough, tc.passthrough)
+			}
+		})
+	}
+}
+
+func TestCompute_errors(t *testing.T) {
+	cases := []struct {
+		name    string
+		w, h    int
+		req     Request
+		wantErr string
+	}{
+		{"scale with target", 100, 100, Request{Scale: 2, TargetWidth: 800}, "cannot be combined"},
+		{"negative target", 100, 100, Request{TargetWidth: -1}, ">= 0"},
+		{"zero input", 0, 100, Request{}, "invalid"},
+		{"below minimum", 32, 200, Request{}, "below the 64x64 minimum"},
+		// 2000² at 16×: second pass runs on 2000·4 = 8000², past the tiled cap.
+		{"pass beyond tiled cap", 2000, 2000, Request{Scale: 16}, "tiled limit"},
+		{"below a model's minimum", 100, 100, Request{Limits: Limits{MinDim: 128}}, "below the 128x128 minimum"},
+		{"beyond a model's tiled cap", 3000, 3000, Request{Limits: Limits{MaxTiledDim: 2048}}, "2048x2048 tiled limit"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compute(tc.w, tc.h, tc.req)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error %v does not contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
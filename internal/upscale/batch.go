@@ -0,0 +1,92 @@
+package upscale
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/backend"
+)
+
+// backendJob is j as the backend takes it.
+func (j job) backendJob(o *opts) backend.Job {
+	return backend.Job{Input: j.in, Output: j.out, Plan: j.plan, Resample: o.resample}
+}
+
+// batches groups a directory's jobs the way the backend sends them:
+// one request per group for the remote backend, one job per group
+// otherwise, so the helper's own progress stays in step with ours.
+func batches(b backend.Backend, jobs []job, o *opts) [][]job {
+	bb, ok := b.(backend.Batcher)
+	if !ok {
+		groups := make([][]job, len(jobs))
+		for i, j := range jobs {
+			groups[i] = []job{j}
+		}
+		return groups
+	}
+	bjobs := make([]backend.Job, len(jobs))
+	for i, j := range jobs {
+		bjobs[i] = j.backendJob(o)
+	}
+	var groups [][]job
+	for _, g := range bb.Batches(bjobs) {
+		groups = append(groups, jobs[:len(g)])
+		jobs = jobs[len(g):]
+	}
+	return groups
+}
+
+// invokeBatch runs one group of jobs and returns each one's error,
+// nil for the ones that succeeded. Plans are reported up front and
+// results as the group comes back. When a batch fails with
+// --continue-on-error its jobs are retried one by one, so the
+// failure lands on the file that caused it and the rest still run.
+func invokeBatch(ctx context.Context, b backend.Backend, group []job, o *opts) []error {
+	if len(group) == 1 {
+		return []error{invokeOne(ctx, b, group[0], o)}
+	}
+	failed := make([]error, len(group))
+	var todo []int
+	for i, j := range group {
+		done, err := o.announce(j)
+		failed[i] = err
+		if !done {
+			todo = append(todo, i)
+		}
+	}
+	if len(todo) == 0 {
+		return failed
+	}
+	bjobs := make([]backend.Job, len(todo))
+	for k, i := range todo {
+		bjobs[k] = group[i].backendJob(o)
+	}
+	caps := b.Capabilities()
+	emit(o.events, o.jsonEvents, "batch", map[string]any{"files": len(bjobs), "backend": caps.Backend, "endpoint": caps.Model})
+	if !o.jsonEvents {
+		fmt.Fprintf(os.Stderr, "  sending %d file(s) to %s\n", len(bjobs), caps.Model)
+	}
+
+	results, err := b.UpscaleBatch(ctx, bjobs)
+	if err != nil && o.continueOnErr && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "warn: batch failed (%v); retrying its files one by one\n", err)
+		for _, i := range todo {
+			res, err := b.Upscale(ctx, group[i].backendJob(o))
+			if err == nil {
+				err = o.finish(b, group[i], res)
+			}
+			failed[i] = err
+		}
+		return failed
+	}
+	for k, i := range todo {
+		switch {
+		case k < len(results):
+			failed[i] = o.finish(b, group[i], results[k])
+		default:
+			failed[i] = err
+		}
+	}
+	return failed
+}
@@ -0,0 +1,129 @@
+package upscale
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
+)
+
+// job is one input that has been through preflight: header read,
+// limits checked, passes planned. err is set when the input must not
+// reach the helper at all.
+type job struct {
+	in, out string
+	info    imageinfo.Info
+	known   bool // info came from a readable header; plan has real dims
+	plan    sizing.Plan
+	err     error
+}
+
+// preflight validates one input in pure Go before anything pays for
+// interpreter start-up or a model load. The header tells us corrupt
+// files and sizes outside docs/IMAGE-IO.md § 4 (64 px minimum; above
+// 1280 px the plan turns tiling on; above 4096 px per pass it fails)
+// — the same failures the helper would report, seconds earlier.
+//
+// A format Go has no decoder for is not refused: PIL reads more than
+// we do (docs/IMAGE-IO.md § 1), so in --scale mode the job goes ahead
+// on sizing.ForScale and the helper decodes it, as in serve's planJob.
+// Target sizing needs the real dimensions, so there it is refused.
+func (o *opts) preflight(in, out string) job {
+	j := job{in: in, out: out}
+	r := o.sizingRequest()
+	info, err := imageinfo.Probe(in)
+	switch {
+	case err == nil:
+	case errors.Is(err, image.ErrFormat) && !r.HasTarget():
+		j.plan = sizing.ForScale(r.Scale)
+		return j
+	case errors.Is(err, image.ErrFormat):
+		j.err = errs.New(errs.User, "%s: format not readable without the helper, and target sizing needs its dimensions (use --scale)", o.display(in))
+		return j
+	default:
+		j.err = errs.New(errs.User, "%s: not a readable image (corrupt header): %w", o.display(in), err)
+		return j
+	}
+	j.info, j.known = info, true
+	plan, err := sizing.Compute(info.Width, info.Height, r)
+	if err != nil {
+		j.err = errs.New(errs.User, "%s: %w", o.display(in), err)
+		return j
+	}
+	j.plan = plan
+	return j
+}
+
+// preflightFile is one entry of the `preflight` JSON event.
+type preflightFile struct {
+	Input  string       `json:"input"`
+	Output string       `json:"output"`
+	OK     bool         `json:"ok"`
+	Width  int          `json:"width,omitempty"`
+	Height int          `json:"height,omitempty"`
+	Format string       `json:"format,omitempty"`
+	Plan   *sizing.Plan `json:"plan,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// report prints the preflight result for every job — a `preflight`
+// event in --json-events mode, a ✓/✗ table on stderr otherwise — and
+// returns how many were rejected.
+func (o *opts) report(jobs []job) int {
+	rejected := 0
+	files := make([]preflightFile, 0, len(jobs))
+	for i := range jobs {
+		j := &jobs[i]
+		f := preflightFile{Input: o.display(j.in), Output: o.display(j.out), OK: j.err == nil}
+		if j.err != nil {
+			rejected++
+			f.Error = j.err.Error()
+		} else {
+			f.Width, f.Height, f.Format = j.info.Width, j.info.Height, j.info.Format
+			f.Plan = &j.plan
+			if !j.known {
+				f.Format = "unknown"
+			}
+		}
+		files = append(files, f)
+	}
+	emit(o.events, o.jsonEvents, "preflight", map[string]any{
+		"files":    files,
+		"ok":       len(jobs) - rejected,
+		"rejected": rejected,
+		"dry_run":  o.dryRun,
+	})
+	if o.jsonEvents {
+		return rejected
+	}
+
+	fmt.Fprintf(os.Stderr, "preflight: %d file(s), %d ok, %d rejected\n",
+		len(jobs), len(jobs)-rejected, rejected)
+	for _, j := range jobs {
+		if j.err != nil {
+			fmt.Fprintf(os.Stderr, "  ✗ %v\n", j.err)
+			continue
+		}
+		p := j.plan
+		if !j.known {
+			fmt.Fprintf(os.Stderr, "  ✓ %s  format unknown here, left to the helper → %sx, %d pass(es)\n",
+				o.display(j.in), imageinfo.FormatScale(p.Scale), p.Passes)
+			continue
+		}
+		line := fmt.Sprintf("%s  %dx%d %s → %dx%d, %d pass(es)",
+			o.display(j.in), j.info.Width, j.info.Height, j.info.Format,
+			p.OutputWidth, p.OutputHeight, p.Passes)
+		switch {
+		case p.Passthrough:
+			line += ", unchanged"
+		case p.Tile:
+			line += ", tiled"
+		}
+		fmt.Fprintf(os.Stderr, "  ✓ %s\n", line)
+	}
+	return rejected
+}
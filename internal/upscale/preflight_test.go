@@ -0,0 +1,71 @@
+package upscale
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+)
+
+// TestPreflight: a corrupt header is refused; a format only the
+// helper reads goes through on --scale and is refused for target
+// sizing; a readable image is planned on its real dimensions.
+func TestPreflight(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 80, 64))); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	cases := []struct {
+		name    string
+		data    []byte
+		target  int // --target-width; 0 = --scale 4
+		known   bool
+		wantErr string // "" = accepted
+		outW    int    // with known
+	}{
+		{"valid", valid, 0, true, "", 320},
+		{"valid, target", valid, 160, true, "", 160},
+		{"corrupt", valid[:20], 0, false, "corrupt header", 0},
+		{"unknown format", []byte("\x00\x00\x00\x18ftypheic: nothing Go decodes"), 0, false, "", 0},
+		{"unknown format, target", []byte("nothing Go decodes"), 160, false, "target sizing needs its dimensions", 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "in.img")
+			if err := os.WriteFile(in, tc.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			o := &opts{scale: 4, targetWidth: tc.target}
+			j := o.preflight(in, in+".png")
+
+			if tc.wantErr != "" {
+				if j.err == nil || !strings.Contains(j.err.Error(), tc.wantErr) || !errs.Is(j.err, errs.User) {
+					t.Fatalf("err = %v, want a user error containing %q", j.err, tc.wantErr)
+				}
+				return
+			}
+			if j.err != nil {
+				t.Fatalf("err = %v", j.err)
+			}
+			if j.known != tc.known {
+				t.Errorf("known = %v, want %v", j.known, tc.known)
+			}
+			if !tc.known {
+				if j.plan.Scale != 4 || j.plan.Passes != 1 {
+					t.Errorf("plan = %+v, want ForScale(4)", j.plan)
+				}
+				return
+			}
+			if j.plan.OutputWidth != tc.outW {
+				t.Errorf("output width %d, want %d", j.plan.OutputWidth, tc.outW)
+			}
+		})
+	}
+}
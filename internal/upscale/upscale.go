@@ -4,13 +4,22 @@
 // the user-facing command is `super-resolution`.
 //
 // Flow:
-//  1. Validate flags + resolve input/output paths
-//  2. Resolve runtime (helper script + python interpreter)
-//  3. Resolve model path (--model is a name; we look it up in the
-//     manifest cache, fetch on miss)
-//  4. Spawn `python3 runtime/upscaler.py --image ... --out ...`
-//  5. Pipe stdout (JSON events when --json-events) and stderr through
-//  6. Exit with the helper's exit code
+//  1. Validate flags + resolve input/output paths, then preflight
+//     every input in pure Go (preflight.go) — header, size limits,
+//     pass plan — before anything below runs
+//  2. Open the --backend. For the default python backend: resolve
+//     the runtime (helper script + python interpreter) and the model
+//     path (--model is a name; models.Resolve picks the best verified
+//     artefact in the cache, and we fetch on a miss only with
+//     --auto-fetch)
+//  3. Hand each job to the backend — for python, spawn
+//     `python3 runtime/upscaler.py --input ... --output ...` and pipe
+//     its stdout (JSON events when --json-events) and stderr through
+//  4. Check the output's geometry against the plan; exit with the
+//     helper's exit code on failure
+//
+// --backend remote sends the images to a serve or RunPod endpoint
+// instead, and --backend fake resizes them in Go (see internal/backend).
 //
 // The subprocess boundary is deliberate. The previous version went
 // through CGO to a C++ engine, coupling the Go release to a specific
@@ -22,19 +31,26 @@
 package upscale
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/ls-ads/real-esrgan-serve/internal/backend"
+	"github.com/ls-ads/real-esrgan-serve/internal/config"
+	"github.com/ls-ads/real-esrgan-serve/internal/errs"
+	"github.com/ls-ads/real-esrgan-serve/internal/imageinfo"
+	"github.com/ls-ads/real-esrgan-serve/internal/modelfetch"
+	"github.com/ls-ads/real-esrgan-serve/internal/models"
 	rrt "github.com/ls-ads/real-esrgan-serve/internal/runtime"
+	"github.com/ls-ads/real-esrgan-serve/internal/sizing"
 	"github.com/spf13/cobra"
 )
 
@@ -43,14 +59,53 @@ type opts struct {
 	output        string
 	model         string
 	gpuID         int
-	scale         int
+	scale         float64
+	scaleSet      bool // --scale given explicitly (conflicts with target modes)
+	resample      string
+	targetWidth   int
+	targetHeight  int
+	maxDimension  int
+	allowDown     bool
+	outputFormat  string // jpg | png | webp; required with --output -
 	jsonEvents    bool
 	continueOnErr bool
+	dryRun        bool
 	pythonBin     string
 	runtimeScript string
 	modelPath     string // override the manifest lookup; absolute path to .onnx
+	autoFetch     bool   // fetch-model on a cache miss instead of failing
+	variant       string // auto | engine | fp16 | fp32 (models.Resolve preference)
+	provider      string // auto | cuda | tensorrt | cpu
+	smArch        string // GPU SM arch, e.g. sm89; lets the resolver pick an engine
+	allowUnsigned bool   // --allow-unsigned-manifest
+	backend       string // --backend: python | remote | fake
+	endpoint      string // /runsync URL or runpod://<id>; implies --backend remote
+	timeout       time.Duration
+	retries       int
+	maxPayloadMB  int
+	apiKey        string // the api-key setting; see internal/config
+
+	// events is where JSON events and helper stdout go: os.Stdout
+	// normally, os.Stderr when --output - claims stdout for image bytes.
+	events io.Writer
+	// stdinPath / stdoutPath are the temp files standing in for `-`,
+	// so progress lines can print "<stdin>" instead of a tmp path.
+	stdinPath  string
+	stdoutPath string
+
+	// spec and resolved are settled once by loadSpec, before
+	// preflight. spec is the manifest entry of the artefact the run
+	// loads, for its native scale and input limits; resolved is the
+	// resolver's answer, with no Path when --auto-fetch is to
+	// download spec. Both nil with --model-path and for backends
+	// that don't load the model here.
+	spec     *models.Model
+	resolved *models.Resolution
 }
 
+// stdio is the --input / --output value meaning stdin / stdout.
+const stdio = "-"
+
 // Command returns the Cobra command tree for `super-resolution`.
 // The legacy `upscale` name is kept as an alias so existing scripts
 // keep working — but the canonical name is `super-resolution`,
@@ -70,47 +125,177 @@ engine warm across many requests.
 This is the AI super-resolution path — the model reconstructs detail
 rather than just resampling pixels. For fast classical upscaling
 (lanczos, bicubic, etc), use 'iosuite resize' (which dispatches to
-ffmpeg-serve).`,
+ffmpeg-serve).
+
+--backend picks what runs the model: python (the default, above),
+remote (another 'serve' or a RunPod endpoint, given by --endpoint) or
+fake (a pure-Go nearest-neighbour resize for tests and UI work on
+machines without onnxruntime — not super-resolution).`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(o)
+			o.scaleSet = cmd.Flags().Changed("scale")
+			o.apiKey, _ = config.FromContext(cmd.Context()).Value(config.CommandName(cmd), config.APIKey)
+			if o.endpoint != "" && !cmd.Flags().Changed("backend") {
+				o.backend = backend.NameRemote
+			}
+			return errs.Wrap(errs.Runtime, run(o))
 		},
 	}
 
 	f := cmd.Flags()
-	f.StringVarP(&o.input, "input", "i", "", "Input image file or directory (required)")
-	f.StringVarP(&o.output, "output", "o", "", "Output path (auto-derived if omitted: <name>_4x.<ext>)")
-	f.StringVar(&o.model, "model", "realesrgan-x4plus", "Model name (looked up in cache, fetched if missing)")
-	f.StringVar(&o.modelPath, "model-path", "", "Absolute path to .onnx (skips manifest lookup)")
+	f.StringVarP(&o.input, "input", "i", "", "Input image file or directory, or - for stdin (required)")
+	f.StringVarP(&o.output, "output", "o", "", "Output path, or - for stdout (auto-derived if omitted: <name>_<scale>x.<ext>)")
+	f.StringVar(&o.outputFormat, "output-format", "", "Output encoding: jpg | png | webp. Required with --output -; otherwise sets the extension of derived output paths")
+	f.StringVar(&o.model, "model", "realesrgan-x4plus", "Model name (looked up in cache; see --auto-fetch)")
+	f.StringVar(&o.modelPath, "model-path", "", "Absolute path to .onnx or .engine (skips manifest lookup)")
+	f.BoolVar(&o.autoFetch, "auto-fetch", false, "Download + verify the model (as fetch-model would) when it isn't cached")
+	f.StringVar(&o.variant, "variant", models.VariantAuto, "Cached artefact to load: auto (engine > fp16 > fp32) | engine | fp16 | fp32")
+	f.StringVar(&o.smArch, "sm-arch", "", "GPU SM arch (e.g. sm89); detected with nvidia-smi when unset")
+	f.BoolVar(&o.allowUnsigned, "allow-unsigned-manifest", false, "Accept a model manifest without a valid signature — dev only")
 	f.IntVarP(&o.gpuID, "gpu-id", "g", 0, "GPU device index (0 = first NVIDIA GPU; -1 = CPU)")
-	f.IntVar(&o.scale, "scale", 4, "Upscale factor (model-native is 4)")
+	f.StringVar(&o.provider, "provider", models.ProviderAuto, "Execution provider: auto (TensorRT > CUDA > CPU) | cuda | tensorrt (or trt) | cpu; a named one fails rather than fall back")
+	f.Float64Var(&o.scale, "scale", imageinfo.NativeScale, "Upscale factor, e.g. 2, 3, 1.5 (model-native is 4; others resample the 4x output)")
+	f.StringVar(&o.resample, "resample", "lanczos", "Resampling filter when --scale != 4: lanczos | bicubic | bilinear | nearest")
+	f.IntVar(&o.targetWidth, "target-width", 0, "Upscale until the output is at least this wide (px); replaces --scale")
+	f.IntVar(&o.targetHeight, "target-height", 0, "Upscale until the output is at least this tall (px); replaces --scale")
+	f.IntVar(&o.maxDimension, "max-dimension", 0, "Fit the output within NxN (px); caps --target-width/--target-height")
+	f.BoolVar(&o.allowDown, "allow-downscale", false, "Shrink inputs already larger than the target (default: pass them through untouched)")
 	f.BoolVar(&o.jsonEvents, "json-events", false, "Emit JSON progress events to stdout (for tooling)")
-	f.BoolVarP(&o.continueOnErr, "continue-on-error", "c", false, "When input is a directory, keep going on per-file failures")
+	f.BoolVarP(&o.continueOnErr, "continue-on-error", "c", false, "When input is a directory, keep going on per-file failures (including files rejected by preflight)")
+	f.BoolVar(&o.dryRun, "dry-run", false, "Validate inputs and print the plan, then stop before starting the helper")
 	f.StringVar(&o.pythonBin, "python", "", "Python interpreter (default: --python > $PYTHON > python3)")
 	f.StringVar(&o.runtimeScript, "runtime", "", "Override path to runtime/upscaler.py (default: alongside the binary)")
+	f.StringVar(&o.backend, "backend", backend.NamePython, "What runs the model: python (upscaler.py per image) | remote (a serve or RunPod endpoint, see --endpoint) | fake (pure-Go nearest neighbour, for tests)")
+	f.StringVar(&o.endpoint, "endpoint", "", "Send images to a serve (http://host:8311) or RunPod endpoint (runpod://<endpoint-id>); implies --backend remote. API key: $REAL_ESRGAN_API_KEY or api-key in the config file, else $RUNPOD_API_KEY for runpod://")
+	f.DurationVar(&o.timeout, "timeout", 5*time.Minute, "With --endpoint: give up on one request (a batch of files) after this long")
+	f.IntVar(&o.retries, "retries", 3, "With --endpoint: retries after a network error, 429 or 5xx, with backoff")
+	f.IntVar(&o.maxPayloadMB, "max-payload-mb", backend.DefaultMaxBody>>20, "With --endpoint: largest request body; directory files are batched up to it")
 
 	_ = cmd.MarkFlagRequired("input")
 
 	return cmd
 }
 
-func run(o *opts) error {
-	loc := &rrt.Locator{
-		PythonOverride: o.pythonBin,
-		ScriptOverride: o.runtimeScript,
+// sizingRequest maps the flags onto a sizing.Request. --scale only
+// counts alongside a target flag when the user typed it — the default
+// 4 must not trip the "scale + target" conflict check.
+func (o *opts) sizingRequest() sizing.Request {
+	r := sizing.Request{
+		TargetWidth:    o.targetWidth,
+		TargetHeight:   o.targetHeight,
+		MaxDimension:   o.maxDimension,
+		AllowDownscale: o.allowDown,
 	}
-	resolved, err := loc.Locate()
-	if err != nil {
-		return err
+	if !r.HasTarget() || o.scaleSet {
+		r.Scale = o.scale
 	}
+	if o.spec != nil {
+		r.Limits = o.spec.Limits()
+	}
+	return r
+}
 
-	model, err := resolveModel(o)
+// loadSpec settles which artefact the python backend will load before
+// any input is preflighted against its limits: the cached one the
+// resolver picks or, on a miss with --auto-fetch, the one that will be
+// downloaded (not yet: a bad input never waits on the network). Its
+// entry must be able to serve --scale. --model-path has no manifest
+// entry, and the other backends don't load the model here; they get
+// the stock limits, as in serve.
+func (o *opts) loadSpec(ctx context.Context) error {
+	if o.modelPath != "" || o.backend != backend.NamePython {
+		return nil
+	}
+	mf, _, err := modelfetch.LoadManifest("", o.allowUnsigned)
 	if err != nil {
+		return errs.Wrap(errs.Environment, fmt.Errorf("manifest: %w", err))
+	}
+	hw := models.HardwareFor(ctx, o.gpuID, o.smArch, o.variant)
+	res, err := models.Resolve(mf, models.Query{
+		Name:     o.model,
+		Variant:  o.variant,
+		Provider: o.provider,
+		Hardware: hw,
+	})
+	fetch := models.FetchVariant(o.variant, o.provider)
+	switch {
+	case err == nil:
+		o.spec = &res.Model
+	case !errors.Is(err, models.ErrNotCached):
 		return err
+	case !o.autoFetch:
+		return errs.New(errs.Environment,
+			"%v. Run:\n"+
+				"  real-esrgan-serve fetch-model --name %s --variant %s\n"+
+				"  or pass --auto-fetch to download it now.",
+			err, o.model, fetch,
+		)
+	default:
+		if o.spec, err = mf.Find(models.Filter{Name: o.model, Variant: fetch, Hardware: hw}); err != nil {
+			return errs.New(errs.User, "--auto-fetch: %w", err)
+		}
+	}
+	if err := o.spec.Runnable(o.sizingRequest().Scale); err != nil {
+		return errs.New(errs.User, "--model: %w", err)
 	}
+	o.resolved = res
+	return nil
+}
 
-	info, err := os.Stat(o.input)
-	if err != nil {
-		return fmt.Errorf("input: %w", err)
+// outputSuffix is the auto-derived filename tag: "_2x" for a scale,
+// "_w3840", "_max4096", "_w3840-max4096" for target modes.
+func (o *opts) outputSuffix() string {
+	r := o.sizingRequest()
+	if !r.HasTarget() {
+		return "_" + imageinfo.FormatScale(o.scale) + "x"
+	}
+	var parts []string
+	if r.TargetWidth > 0 {
+		parts = append(parts, fmt.Sprintf("w%d", r.TargetWidth))
+	}
+	if r.TargetHeight > 0 {
+		parts = append(parts, fmt.Sprintf("h%d", r.TargetHeight))
+	}
+	if r.MaxDimension > 0 {
+		parts = append(parts, fmt.Sprintf("max%d", r.MaxDimension))
+	}
+	return "_" + strings.Join(parts, "-")
+}
+
+func run(o *opts) error {
+	if err := backend.Validate(o.backend); err != nil {
+		return err
+	}
+	if err := models.ValidateProvider(o.provider); err != nil {
+		return err
+	}
+	o.provider = models.CanonicalProvider(o.provider)
+	if o.backend != backend.NamePython && o.provider != models.ProviderAuto {
+		return errs.New(errs.User, "--provider applies to --backend python, not %s", o.backend)
+	}
+	if o.endpoint != "" && o.backend != backend.NameRemote {
+		return errs.New(errs.User, "--endpoint sends images to a remote backend; it can't be used with --backend %s", o.backend)
+	}
+	if err := o.sizingRequest().Validate(); err != nil {
+		return errs.Wrap(errs.User, err)
+	}
+	if err := imageinfo.ValidateResample(o.resample); err != nil {
+		return errs.New(errs.User, "--resample: %w", err)
+	}
+	switch o.outputFormat {
+	case "", "jpg", "jpeg", "png", "webp":
+	default:
+		return errs.New(errs.User, "--output-format %q: want jpg | png | webp", o.outputFormat)
+	}
+	o.events = os.Stdout
+	if o.output == stdio {
+		if o.outputFormat == "" {
+			return errs.New(errs.User, "--output-format is required with --output - (the encoding can't be inferred from a filename)")
+		}
+		// Image bytes own stdout; everything else moves to stderr.
+		o.events = os.Stderr
+	}
+	if o.input == stdio && o.output == "" {
+		return errs.New(errs.User, "--output is required with --input - (use --output - for stdout)")
 	}
 
 	// Trap signals so a Ctrl-C kills the helper subprocess too.
@@ -118,28 +303,43 @@ func run(o *opts) error {
 		syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	if err := o.loadSpec(ctx); err != nil {
+		return err
+	}
+
+	if o.input == stdio || o.output == stdio {
+		return runStdio(ctx, o)
+	}
+
+	info, err := os.Stat(o.input)
+	if err != nil {
+		return errs.New(errs.User, "input: %w", err)
+	}
+
 	if !info.IsDir() {
 		out := o.output
 		if out == "" {
-			out = derivedOutput(o.input)
+			out = derivedOutput(o.input, o.outputSuffix())
+			if o.outputFormat != "" {
+				out = withExt(out, o.outputFormat)
+			}
 		}
-		return invokeOne(ctx, resolved, model, o.input, out, o)
+		return runOne(ctx, o.preflight(o.input, out), o)
 	}
 
-	// Directory mode
+	// Directory mode: preflight every file before the helper starts,
+	// so a bad file 40 images in fails the run in milliseconds rather
+	// than after 39 inferences.
 	outDir := o.output
 	if outDir == "" {
-		outDir = strings.TrimRight(o.input, "/\\") + "_4x"
-	}
-	if err := os.MkdirAll(outDir, 0o755); err != nil {
-		return fmt.Errorf("mkdir %s: %w", outDir, err)
+		outDir = strings.TrimRight(o.input, "/\\") + o.outputSuffix()
 	}
 
 	entries, err := os.ReadDir(o.input)
 	if err != nil {
 		return fmt.Errorf("readdir %s: %w", o.input, err)
 	}
-	var anyErr error
+	var jobs []job
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
@@ -152,8 +352,46 @@ func run(o *opts) error {
 		}
 		in := filepath.Join(o.input, e.Name())
 		out := filepath.Join(outDir, e.Name())
-		if err := invokeOne(ctx, resolved, model, in, out, o); err != nil {
-			fmt.Fprintf(os.Stderr, "  ✗ %s: %v\n", e.Name(), err)
+		if o.outputFormat != "" {
+			out = withExt(out, o.outputFormat)
+		}
+		jobs = append(jobs, o.preflight(in, out))
+	}
+
+	rejected := o.report(jobs)
+	switch {
+	case rejected > 0 && o.dryRun:
+		return errs.New(errs.User, "%d of %d file(s) failed preflight", rejected, len(jobs))
+	case rejected > 0 && !o.continueOnErr:
+		return errs.New(errs.User, "%d of %d file(s) failed preflight; nothing was processed "+
+			"(--continue-on-error skips them instead)", rejected, len(jobs))
+	case o.dryRun:
+		return nil
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return errs.New(errs.Environment, "mkdir %s: %w", outDir, err)
+	}
+	b, err := o.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	var anyErr error
+	var ready []job
+	for _, j := range jobs {
+		if j.err != nil {
+			anyErr = j.err // already reported by preflight
+			continue
+		}
+		ready = append(ready, j)
+	}
+	for _, group := range batches(b, ready, o) {
+		for i, err := range invokeBatch(ctx, b, group, o) {
+			if err == nil {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "  ✗ %s: %v\n", filepath.Base(group[i].in), err)
 			anyErr = err
 			if !o.continueOnErr {
 				return err
@@ -161,14 +399,158 @@ func run(o *opts) error {
 		}
 	}
 	if anyErr != nil && o.continueOnErr {
-		// Surface that some files failed without halting the run.
-		return fmt.Errorf("one or more files failed; see stderr above")
+		// Surface that some files failed without halting the run. The
+		// last failure's category decides the exit code.
+		return fmt.Errorf("one or more files failed; see stderr above (last: %w)", anyErr)
 	}
 	return nil
 }
 
-// derivedOutput returns "<name>_4x.<ext>" alongside the input.
-func derivedOutput(input string) string {
+// runStdio handles `--input -` and/or `--output -`. Both ends are
+// staged through a temp dir: the helper (and PIL) want real paths, and
+// a seekable file is what package image needs for the header probe
+// anyway. Stdin is read to EOF before the helper starts; stdout gets
+// the encoded result only after the helper exits 0 and the output
+// passes validation, so a consumer never sees half an image.
+func runStdio(ctx context.Context, o *opts) error {
+	tmpDir, err := os.MkdirTemp("", "res-stdio-")
+	if err != nil {
+		return fmt.Errorf("tmpdir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	in := o.input
+	if in == stdio {
+		in = filepath.Join(tmpDir, "stdin.bin")
+		f, err := os.Create(in)
+		if err != nil {
+			return fmt.Errorf("stage stdin: %w", err)
+		}
+		n, err := io.Copy(f, os.Stdin)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return errs.New(errs.User, "read stdin: %w", err)
+		}
+		if n == 0 {
+			return errs.New(errs.User, "--input -: stdin was empty")
+		}
+		o.stdinPath = in
+	} else if info, err := os.Stat(in); err != nil {
+		return errs.New(errs.User, "input: %w", err)
+	} else if info.IsDir() {
+		return errs.New(errs.User, "--output - needs a single input file, not a directory")
+	}
+
+	out := o.output
+	if out == stdio {
+		out = filepath.Join(tmpDir, "stdout."+o.outputFormat)
+		o.stdoutPath = out
+	} else if o.outputFormat != "" {
+		out = withExt(out, o.outputFormat)
+	}
+
+	if err := runOne(ctx, o.preflight(in, out), o); err != nil {
+		return err
+	}
+	if o.stdoutPath == "" || o.dryRun {
+		return nil
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return fmt.Errorf("read output: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return fmt.Errorf("write stdout: %w", err)
+	}
+	return nil
+}
+
+// runOne is the single-input path shared by file and stdio modes:
+// fail on a preflight rejection, stop after the report for --dry-run,
+// otherwise start the backend.
+func runOne(ctx context.Context, j job, o *opts) error {
+	if j.err != nil {
+		return j.err
+	}
+	if o.dryRun {
+		o.report([]job{j})
+		return nil
+	}
+	b, err := o.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	return invokeOne(ctx, b, j, o)
+}
+
+// open sets up the --backend — deferred until preflight has passed,
+// so a bad input never waits on the helper, the model or the network.
+// Only python resolves a runtime and a model artefact.
+func (o *opts) open(ctx context.Context) (backend.Backend, error) {
+	switch o.backend {
+	case backend.NameFake:
+		return backend.NewFake(nil), nil
+	case backend.NameRemote:
+		if o.endpoint == "" {
+			return nil, errs.New(errs.User, "--backend remote needs --endpoint")
+		}
+		return backend.NewRemote(o.endpoint, backend.RemoteOptions{
+			APIKey:  backend.APIKey(o.apiKey, o.endpoint),
+			Timeout: o.timeout,
+			Retries: o.retries,
+			MaxBody: int64(o.maxPayloadMB) << 20,
+		})
+	}
+	loc := &rrt.Locator{
+		PythonOverride: o.pythonBin,
+		ScriptOverride: o.runtimeScript,
+	}
+	resolved, err := loc.Locate()
+	if err != nil {
+		return nil, err
+	}
+	model, err := resolveModel(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := models.HelperProvider(model, o.provider); err != nil {
+		return nil, err
+	}
+	return &backend.Subprocess{
+		Runtime:    resolved,
+		Model:      model,
+		GPUID:      o.gpuID,
+		Provider:   o.provider,
+		Events:     o.events,
+		JSONEvents: o.jsonEvents,
+	}, nil
+}
+
+// display maps the staged temp paths back to what the user typed.
+func (o *opts) display(path string) string {
+	switch path {
+	case "":
+		return path
+	case o.stdinPath:
+		return "<stdin>"
+	case o.stdoutPath:
+		return "<stdout>"
+	}
+	return path
+}
+
+// withExt swaps path's extension for the given --output-format.
+func withExt(path, format string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+}
+
+// derivedOutput returns "<name><suffix>.<ext>" alongside the input,
+// e.g. "photo_4x.jpg".
+func derivedOutput(input, suffix string) string {
 	dir := filepath.Dir(input)
 	base := filepath.Base(input)
 	ext := filepath.Ext(base)
@@ -176,97 +558,146 @@ func derivedOutput(input string) string {
 	if ext == "" {
 		ext = ".png"
 	}
-	return filepath.Join(dir, stem+"_4x"+ext)
+	return filepath.Join(dir, stem+suffix+ext)
 }
 
-func resolveModel(o *opts) (string, error) {
+func resolveModel(ctx context.Context, o *opts) (string, error) {
 	if o.modelPath != "" {
 		if _, err := os.Stat(o.modelPath); err != nil {
-			return "", fmt.Errorf("--model-path %s: %w", o.modelPath, err)
+			return "", errs.New(errs.User, "--model-path %s: %w", o.modelPath, err)
 		}
+		emit(o.events, o.jsonEvents, "model", map[string]any{"path": o.modelPath, "reason": "--model-path"})
 		return o.modelPath, nil
 	}
-	// Look in the standard cache paths. We do NOT auto-fetch here —
-	// fetch is an explicit `fetch-model` action so users see when
-	// network I/O happens. Helpful error if not found.
-	candidates := modelCacheCandidates(o.model)
-	for _, p := range candidates {
-		if _, err := os.Stat(p); err == nil {
-			return p, nil
-		}
+	if res := o.resolved; res.Path != "" {
+		o.explainModel(res)
+		return res.Path, nil
+	}
+	// loadSpec only leaves the path empty with --auto-fetch: network
+	// I/O only happens when asked for, so users see when it does.
+	fr, err := modelfetch.Fetch(ctx, modelfetch.Request{
+		Name:          o.model,
+		Variant:       o.spec.Variant,
+		SMArch:        o.spec.SMArch,
+		GPUClass:      o.spec.GPUClass,
+		AllowUnsigned: o.allowUnsigned,
+		JSONEvents:    o.jsonEvents,
+		Events:        o.events,
+	})
+	if err != nil {
+		return "", fmt.Errorf("--auto-fetch: %w", err)
+	}
+	emit(o.events, o.jsonEvents, "model", map[string]any{
+		"path": fr.Path, "variant": fr.Model.Variant, "reason": "fetched (--auto-fetch)", "considered": o.resolved.Considered,
+	})
+	return fr.Path, nil
+}
+
+// explainModel reports which artefact the resolver picked and why:
+// a `model` event with --json-events, one stderr line otherwise.
+func (o *opts) explainModel(res *models.Resolution) {
+	emit(o.events, o.jsonEvents, "model", map[string]any{
+		"path":       res.Path,
+		"variant":    res.Variant,
+		"reason":     res.Reason,
+		"considered": res.Considered,
+	})
+	if !o.jsonEvents {
+		fmt.Fprintf(os.Stderr, "model: %s [%s]\n", filepath.Base(res.Path), res.Reason)
 	}
-	return "", fmt.Errorf(
-		"model %q not found in cache. Run:\n"+
-			"  real-esrgan-serve fetch-model --name %s --variant fp16\n"+
-			"  (looked in: %s)",
-		o.model, o.model, strings.Join(candidates, ", "),
-	)
 }
 
-// modelCacheCandidates lists where to look for a cached `.onnx` for
-// the given model name. Mirrors modelfetch's resolveDest order.
-func modelCacheCandidates(name string) []string {
-	out := []string{}
-	for _, variant := range []string{"_fp16", "_fp32"} {
-		filename := name + variant + ".onnx"
-		if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
-			out = append(out, filepath.Join(x, "real-esrgan-serve", "models", filename))
+// invokeOne runs the backend on one preflighted job.
+func invokeOne(ctx context.Context, b backend.Backend, j job, o *opts) error {
+	if done, err := o.announce(j); done || err != nil {
+		return err
+	}
+	res, err := b.Upscale(ctx, j.backendJob(o))
+	if err != nil {
+		return err
+	}
+	return o.finish(b, j, res)
+}
+
+// announce reports the job's plan, and carries out a passthrough that
+// is a plain copy; done says nothing is left for the backend.
+func (o *opts) announce(j job) (done bool, err error) {
+	in, out, plan := j.in, j.out, j.plan
+
+	emit(o.events, o.jsonEvents, "plan", map[string]any{"input": o.display(in), "output": o.display(out), "plan": plan})
+	if !o.jsonEvents {
+		fmt.Fprintf(os.Stderr, "→ %s\n", o.display(in))
+		if plan.Reason != "" {
+			fmt.Fprintf(os.Stderr, "  plan: %s (%d pass(es) → %dx%d)\n",
+				plan.Reason, plan.Passes, plan.OutputWidth, plan.OutputHeight)
 		}
-		if home, err := os.UserHomeDir(); err == nil {
-			out = append(out, filepath.Join(home, ".cache", "real-esrgan-serve", "models", filename))
+	}
+
+	if plan.Passthrough && sameFormat(in, out) {
+		if err := copyFile(in, out); err != nil {
+			return true, fmt.Errorf("pass through %s: %w", in, err)
+		}
+		emit(o.events, o.jsonEvents, "done", map[string]any{"output": o.display(out), "passthrough": true})
+		if !o.jsonEvents {
+			fmt.Fprintf(os.Stderr, "  ✓ %s (unchanged)\n", o.display(out))
 		}
-		out = append(out, filepath.Join("/var/cache/real-esrgan-serve/models", filename))
+		return true, nil
 	}
-	return out
+	return false, nil
 }
 
-func invokeOne(ctx context.Context, r *rrt.Resolved, model, in, out string, o *opts) error {
-	args := []string{
-		r.Script,
-		"--input", in,
-		"--output", out,
-		"--model", model,
-		"--gpu-id", fmt.Sprintf("%d", o.gpuID),
+// finish checks what the backend wrote for j and reports it.
+func (o *opts) finish(b backend.Backend, j job, res backend.Result) error {
+	// The backend owns the resample; we only confirm it landed on the
+	// geometry the plan promised.
+	// Nothing to confirm against when preflight couldn't read the input.
+	if j.known {
+		if err := imageinfo.CheckScaled(j.info, j.out, j.plan.Scale); err != nil {
+			return errs.Wrap(errs.Integrity, err)
+		}
 	}
-	if o.jsonEvents {
-		args = append(args, "--json-events")
+	if !b.Capabilities().Events {
+		// The python helper reports its own done event; the other
+		// backends are silent, so the stream gets one from us.
+		emit(o.events, o.jsonEvents, "done", map[string]any{"output": o.display(j.out), "elapsed_ms": res.ElapsedMS})
 	}
-
 	if !o.jsonEvents {
-		fmt.Fprintf(os.Stderr, "→ %s\n", in)
+		fmt.Fprintf(os.Stderr, "  ✓ %s\n", o.display(j.out))
+	}
+	return nil
+}
+
+// sameFormat reports whether a passthrough can be a byte copy: the
+// output extension names the same encoding as the input's.
+func sameFormat(in, out string) bool {
+	norm := func(p string) string {
+		ext := strings.ToLower(filepath.Ext(p))
+		if ext == ".jpeg" {
+			return ".jpg"
+		}
+		return ext
 	}
+	return norm(in) == norm(out)
+}
 
-	cmd := exec.CommandContext(ctx, r.Python, args...)
-	// Stderr goes straight to ours so users see Python tracebacks
-	// in real time.
-	cmd.Stderr = os.Stderr
-	stdout, err := cmd.StdoutPipe()
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	if err := cmd.Start(); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return err
 	}
+	return os.WriteFile(dst, b, 0o644)
+}
 
-	// Stream the helper's stdout. In JSON-events mode this is JSONL
-	// the caller (iosuite CLI / web UI) parses; in human mode it's
-	// already-friendly progress text we just pass through.
-	stream := bufio.NewScanner(stdout)
-	stream.Buffer(make([]byte, 0, 64*1024), 1<<20) // helper events stay small
-	for stream.Scan() {
-		fmt.Println(stream.Text())
-	}
-	if err := stream.Err(); err != nil && !errors.Is(err, io.EOF) {
-		// non-fatal: we still wait for the process and let its exit
-		// status be the truth
-		fmt.Fprintf(os.Stderr, "warn: stdout scan: %v\n", err)
-	}
-
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("upscaler failed: %w", err)
-	}
-	if !o.jsonEvents {
-		fmt.Fprintf(os.Stderr, "  ✓ %s\n", out)
+// emit writes one JSON event line to w when --json-events is on.
+// Same shape as the helper's own events so callers parse one stream.
+func emit(w io.Writer, on bool, event string, fields map[string]any) {
+	if !on {
+		return
 	}
-	return nil
+	fields["event"] = event
+	b, _ := json.Marshal(fields)
+	fmt.Fprintln(w, string(b))
 }
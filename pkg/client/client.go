@@ -0,0 +1,357 @@
+// Package client speaks the /runsync wire contract of real-esrgan-serve:
+// to a local `serve` (http://host:8311) or a RunPod-style endpoint
+// (runpod://<endpoint-id>), which take the same envelope.
+//
+// The types in wire.go are the contract itself; `serve` decodes its
+// requests into them, so a client built from this package can't drift
+// from the server. The package follows the module's semantic version:
+// fields are only ever added, and a field the far end doesn't know is
+// left off the wire while it holds its zero value.
+//
+// RunSync is the one call most callers need: it splits a batch into
+// requests under the body cap, retries what may go better next time,
+// waits out a RunPod job still queued when runsync returns, and
+// merges the answers. Submit, Status and Wait are the asynchronous
+// halves for RunPod-style endpoints; `serve` answers /runsync only.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxBody is RunPod's ~20 MB request-body cap.
+	DefaultMaxBody = 20 << 20
+	// RunPodAPI is where runpod://<endpoint-id> points by default.
+	RunPodAPI = "https://api.runpod.ai/v2"
+
+	defaultBackoff      = time.Second
+	defaultPollInterval = 2 * time.Second
+	// EnvelopeOverhead is what a request adds to its images, which
+	// ImageSize measures.
+	EnvelopeOverhead = 512
+
+	imageOverhead = len(`{"image_base64":""},`)
+)
+
+// Options tune a Client. Zero values take the defaults.
+type Options struct {
+	// APIKey goes out as `Authorization: Bearer`.
+	APIKey string
+	// HTTPClient sends the requests; default a fresh http.Client. Bound
+	// a call with its ctx rather than HTTPClient.Timeout, which would
+	// also cut off status polling.
+	HTTPClient *http.Client
+	// Retries is how many more times a request is tried after a
+	// network error, a 429 or a 5xx. Refusals (other 4xx, failed
+	// jobs) are never retried.
+	Retries int
+	// Backoff is the first retry's delay, doubled for each after up
+	// to 32x, unless the server sends Retry-After. Default 1s.
+	Backoff time.Duration
+	// OnRetry, if set, hears about each retry before its wait.
+	OnRetry func(attempt int, err error, wait time.Duration)
+	// MaxBody caps one request body; RunSync and Submit split a batch
+	// to fit. Default DefaultMaxBody.
+	MaxBody int64
+	// PollInterval is the wait between status checks. Default 2s.
+	PollInterval time.Duration
+	// RunPodAPI replaces RunPodAPI for runpod:// endpoints, for a
+	// proxy or a test server.
+	RunPodAPI string
+}
+
+// Client talks to one endpoint. It is safe for concurrent use.
+type Client struct {
+	base   string // endpoint URL without /runsync
+	runpod bool
+	opts   Options
+	apiKey atomic.Pointer[string]
+}
+
+// New returns a client for endpoint: a server root (/runsync is
+// added), a full /runsync URL, or runpod://<endpoint-id>.
+func New(endpoint string, opts Options) (*Client, error) {
+	c := &Client{opts: opts}
+	c.apiKey.Store(&opts.APIKey)
+	if c.opts.RunPodAPI == "" {
+		c.opts.RunPodAPI = RunPodAPI
+	}
+	if id, ok := strings.CutPrefix(endpoint, "runpod://"); ok {
+		id = strings.Trim(id, "/")
+		if id == "" || strings.ContainsAny(id, "/?#") {
+			return nil, fmt.Errorf("endpoint %q: want runpod://<endpoint-id>", endpoint)
+		}
+		c.base, c.runpod = strings.TrimSuffix(c.opts.RunPodAPI, "/")+"/"+id, true
+	} else {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("endpoint %q: want an http:// or https:// URL, or runpod://<endpoint-id>", endpoint)
+		}
+		u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/runsync")
+		c.base = u.String()
+	}
+	if c.opts.Retries < 0 {
+		return nil, fmt.Errorf("retries must be >= 0, got %d", c.opts.Retries)
+	}
+	if c.opts.HTTPClient == nil {
+		c.opts.HTTPClient = &http.Client{}
+	}
+	if c.opts.Backoff <= 0 {
+		c.opts.Backoff = defaultBackoff
+	}
+	if c.opts.MaxBody <= 0 {
+		c.opts.MaxBody = DefaultMaxBody
+	}
+	if c.opts.PollInterval <= 0 {
+		c.opts.PollInterval = defaultPollInterval
+	}
+	return c, nil
+}
+
+// Endpoint is the /runsync URL the client posts to.
+func (c *Client) Endpoint() string { return c.base + "/runsync" }
+
+// RunPod reports whether the endpoint came from runpod://.
+func (c *Client) RunPod() bool { return c.runpod }
+
+// SetAPIKey replaces Options.APIKey for requests from now on, for a
+// key rotated under a long-running caller.
+func (c *Client) SetAPIKey(key string) { c.apiKey.Store(&key) }
+
+// CloseIdleConnections closes the HTTP client's idle connections.
+func (c *Client) CloseIdleConnections() { c.opts.HTTPClient.CloseIdleConnections() }
+
+// ImageSize is what an image of n raw bytes adds to a request body.
+func ImageSize(n int64) int64 {
+	return int64(base64.StdEncoding.EncodedLen(int(n)) + imageOverhead)
+}
+
+// Chunk splits in into the requests RunSync and Submit send: runs of
+// its images whose bodies fit maxBody, every other field shared. An
+// image too big to go alone is a *TooLargeError.
+func Chunk(in Input, maxBody int64) ([]Input, error) {
+	if len(in.Images) == 0 {
+		return nil, errors.New("input has no images")
+	}
+	var chunks []Input
+	var size int64
+	for i, img := range in.Images {
+		n := int64(len(img.ImageBase64) + imageOverhead)
+		if EnvelopeOverhead+n > maxBody {
+			return nil, &TooLargeError{Index: i, Size: EnvelopeOverhead + n, MaxBody: maxBody}
+		}
+		if len(chunks) > 0 && size+n <= maxBody {
+			last := &chunks[len(chunks)-1]
+			last.Images = append(last.Images, img)
+			size += n
+			continue
+		}
+		chunk := in
+		chunk.Images = []Image{img}
+		chunks = append(chunks, chunk)
+		size = EnvelopeOverhead + n
+	}
+	return chunks, nil
+}
+
+// RunSync runs in to completion and returns the merged answer, its
+// outputs in input order. Images the worker failed are an ItemErrors
+// returned alongside the Response holding the rest; any other error
+// returns no Response.
+func (c *Client) RunSync(ctx context.Context, in Input) (*Response, error) {
+	chunks, err := Chunk(in, c.opts.MaxBody)
+	if err != nil {
+		return nil, err
+	}
+	merged := &Response{Status: StatusCompleted}
+	var failed ItemErrors
+	offset := 0
+	for _, chunk := range chunks {
+		resp, err := c.call(ctx, http.MethodPost, c.base+"/runsync", &Request{Input: chunk})
+		if err == nil && resp.Pending() {
+			resp, err = c.Wait(ctx, resp.ID)
+		}
+		if err == nil {
+			err = finished(resp)
+		}
+		if err != nil {
+			return nil, err
+		}
+		merged.Output.Outputs = append(merged.Output.Outputs, resp.Output.Outputs...)
+		if d := resp.Output.Diagnostics; d != nil {
+			if merged.Output.Diagnostics == nil {
+				merged.Output.Diagnostics = &Diagnostics{}
+			}
+			md := merged.Output.Diagnostics
+			md.Providers, md.RequestedProvider, md.Model = d.Providers, d.RequestedProvider, d.Model
+			md.BatchSize += d.BatchSize
+			md.BatchTotalMS += d.BatchTotalMS
+			for _, e := range d.PerItemErrors {
+				e.Index += offset
+				md.PerItemErrors = append(md.PerItemErrors, e)
+				failed = append(failed, e)
+			}
+		}
+		if resp.Output.Model != "" {
+			merged.Output.Model = resp.Output.Model
+		}
+		merged.ID = resp.ID
+		offset += len(chunk.Images)
+	}
+	if len(failed) > 0 {
+		return merged, failed
+	}
+	return merged, nil
+}
+
+// Submit queues in on a RunPod-style endpoint's /run and returns the
+// job ids, one per chunk in input order, for Status or Wait.
+func (c *Client) Submit(ctx context.Context, in Input) ([]string, error) {
+	chunks, err := Chunk(in, c.opts.MaxBody)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, chunk := range chunks {
+		resp, err := c.call(ctx, http.MethodPost, c.base+"/run", &Request{Input: chunk})
+		if err != nil {
+			return ids, err
+		}
+		if resp.ID == "" {
+			return ids, fmt.Errorf("%s/run: answer has no job id", c.base)
+		}
+		ids = append(ids, resp.ID)
+	}
+	return ids, nil
+}
+
+// Status returns job id's current state; Output is set once it has
+// completed.
+func (c *Client) Status(ctx context.Context, id string) (*Response, error) {
+	return c.call(ctx, http.MethodGet, c.base+"/status/"+url.PathEscape(id), nil)
+}
+
+// Wait polls job id until it leaves the queue, and returns its final
+// state. A job that didn't complete is a *JobError.
+func (c *Client) Wait(ctx context.Context, id string) (*Response, error) {
+	if id == "" {
+		return nil, errors.New("wait: job has no id to poll")
+	}
+	for {
+		select {
+		case <-time.After(c.opts.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		resp, err := c.Status(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Pending() {
+			return resp, finished(resp)
+		}
+	}
+}
+
+// finished is the error for a final response that isn't a success.
+func finished(resp *Response) error {
+	msg := resp.Error
+	if msg == "" {
+		msg = resp.Output.Error
+	}
+	if resp.Status != StatusCompleted || msg != "" {
+		return &JobError{ID: resp.ID, Status: resp.Status, Message: msg}
+	}
+	return nil
+}
+
+// call sends one request, retrying network errors, 429 and 5xx.
+func (c *Client) call(ctx context.Context, method, target string, req *Request) (*Response, error) {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.once(ctx, method, target, body)
+		if err == nil || attempt == c.opts.Retries || !retryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		wait := c.opts.Backoff << min(attempt, 5)
+		var he *HTTPError
+		if errors.As(err, &he) && he.RetryAfter > 0 {
+			wait = he.RetryAfter
+		}
+		if c.opts.OnRetry != nil {
+			c.opts.OnRetry(attempt+1, err, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable: an HTTP error the server calls temporary, or no answer
+// at all. A response that didn't decode counts as the latter.
+func retryable(err error) bool {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.Temporary()
+	}
+	return true
+}
+
+func (c *Client) once(ctx context.Context, method, target string, body []byte) (*Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key := *c.apiKey.Load(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, &HTTPError{
+			URL:        target,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(msg)),
+			RetryAfter: time.Duration(secs) * time.Second,
+		}
+	}
+	var out Response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: decode response: %w", target, err)
+	}
+	return &out, nil
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runpod is a RunPod-style endpoint. Each image's base64 is its text,
+// and the output echoes it upper-cased; an image reading "bad" fails
+// on its own. /runsync answers straight away unless queue is set;
+// /run always queues, and a queued job completes on its second status
+// check.
+type runpod struct {
+	mu       sync.Mutex
+	bodies   []int // size of each /runsync or /run body
+	failures int   // first posts answered 429
+	queue    bool
+	jobs     map[string]*Response
+	polls    map[string]int
+}
+
+func (rp *runpod) serve(t *testing.T) *Client {
+	t.Helper()
+	rp.jobs, rp.polls = map[string]*Response{}, map[string]int{}
+	mux := http.NewServeMux()
+	post := func(async bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer k" {
+				http.Error(w, "no key", http.StatusUnauthorized)
+				return
+			}
+			var req Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rp.mu.Lock()
+			defer rp.mu.Unlock()
+			rp.bodies = append(rp.bodies, int(r.ContentLength))
+			if rp.failures > 0 {
+				rp.failures--
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "throttled", http.StatusTooManyRequests)
+				return
+			}
+			done := &Response{Status: StatusCompleted, Output: Output{Diagnostics: &Diagnostics{BatchSize: len(req.Input.Images)}}}
+			for i, img := range req.Input.Images {
+				if img.ImageBase64 == "bad" {
+					done.Output.Diagnostics.PerItemErrors = append(done.Output.Diagnostics.PerItemErrors, ItemError{Index: i, Error: "cannot identify image file"})
+					continue
+				}
+				done.Output.Outputs = append(done.Output.Outputs, ImageOutput{ImageBase64: strings.ToUpper(img.ImageBase64), ExecMS: 5})
+			}
+			done.ID = fmt.Sprintf("job-%d", len(rp.jobs))
+			rp.jobs[done.ID] = done
+			if async || rp.queue {
+				json.NewEncoder(w).Encode(Response{ID: done.ID, Status: StatusInQueue})
+				return
+			}
+			json.NewEncoder(w).Encode(done)
+		}
+	}
+	mux.HandleFunc("POST /v2/ep/runsync", post(false))
+	mux.HandleFunc("POST /v2/ep/run", post(true))
+	mux.HandleFunc("GET /v2/ep/status/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rp.mu.Lock()
+		defer rp.mu.Unlock()
+		id := r.PathValue("id")
+		done, ok := rp.jobs[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if rp.polls[id]++; rp.polls[id] < 2 {
+			json.NewEncoder(w).Encode(Response{ID: id, Status: StatusInProgress})
+			return
+		}
+		json.NewEncoder(w).Encode(done)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	c, err := New("runpod://ep", Options{
+		APIKey:       "k",
+		Retries:      2,
+		Backoff:      time.Millisecond,
+		PollInterval: time.Millisecond,
+		// Two 100-byte images to a request.
+		MaxBody:   EnvelopeOverhead + 2*int64(100+imageOverhead),
+		RunPodAPI: ts.URL + "/v2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// images returns n inputs of 100 base64 bytes, "bad" at the indices
+// in bad.
+func images(n int, bad ...int) Input {
+	var in Input
+	for i := range n {
+		in.Images = append(in.Images, Image{ImageBase64: fmt.Sprintf("%-100d", i)})
+	}
+	for _, i := range bad {
+		in.Images[i].ImageBase64 = "bad"
+	}
+	return in
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		want     string // Endpoint(); "" = refused
+	}{
+		{"http://gpu-box:8311", "http://gpu-box:8311/runsync"},
+		{"http://gpu-box:8311/", "http://gpu-box:8311/runsync"},
+		{"https://gpu-box/api/runsync", "https://gpu-box/api/runsync"},
+		{"runpod://abc123", "https://api.runpod.ai/v2/abc123/runsync"},
+		{"runpod://", ""},
+		{"runpod://a/b", ""},
+		{"gpu-box:8311", ""},
+		{"ftp://gpu-box", ""},
+	}
+	for _, tc := range cases {
+		c, err := New(tc.endpoint, Options{})
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: accepted as %s", tc.endpoint, c.Endpoint())
+		case tc.want != "" && (err != nil || c.Endpoint() != tc.want):
+			t.Errorf("%s: got %v, %v; want %s", tc.endpoint, c, err, tc.want)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	one := int64(100 + imageOverhead)
+	cases := []struct {
+		name    string
+		in      Input
+		maxBody int64
+		want    []int // images per chunk; nil = error
+	}{
+		{"fits one", images(3), EnvelopeOverhead + 3*one, []int{3}},
+		{"split", images(5), EnvelopeOverhead + 2*one, []int{2, 2, 1}},
+		{"one each", images(3), EnvelopeOverhead + one, []int{1, 1, 1}},
+		{"too large", images(3), EnvelopeOverhead + one - 1, nil},
+		{"empty", Input{}, DefaultMaxBody, nil},
+	}
+	for _, tc := range cases {
+		tc.in.OutputFormat = "png"
+		chunks, err := Chunk(tc.in, tc.maxBody)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: chunked into %d", tc.name, len(chunks))
+			}
+			continue
+		}
+		var got []int
+		for _, c := range chunks {
+			got = append(got, len(c.Images))
+			if c.OutputFormat != "png" {
+				t.Errorf("%s: chunk lost output_format", tc.name)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	var tl *TooLargeError
+	if _, err := Chunk(images(2), EnvelopeOverhead); !errors.As(err, &tl) || tl.Index != 0 {
+		t.Errorf("too large: %v", err)
+	}
+}
+
+// TestRunSync: a batch past the body cap goes as several requests,
+// throttling is retried, a queued job is waited out, and the answers
+// come back as one, failed items indexed in the whole batch.
+func TestRunSync(t *testing.T) {
+	cases := []struct {
+		name     string
+		rp       *runpod
+		bad      []int
+		requests int
+	}{
+		{"chunked", &runpod{}, nil, 3},
+		{"throttled", &runpod{failures: 2}, nil, 5},
+		{"queued", &runpod{queue: true}, nil, 3},
+		{"item errors", &runpod{}, []int{3}, 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.rp.serve(t)
+			resp, err := c.RunSync(context.Background(), images(5, tc.bad...))
+			var items ItemErrors
+			switch {
+			case tc.bad == nil && err != nil:
+				t.Fatal(err)
+			case tc.bad != nil && (!errors.As(err, &items) || len(items) != 1 || items[0].Index != 3):
+				t.Fatalf("got %v, want image 3 failed", err)
+			}
+			if len(tc.rp.bodies) != tc.requests {
+				t.Errorf("requests: %d, want %d", len(tc.rp.bodies), tc.requests)
+			}
+			for _, n := range tc.rp.bodies {
+				if int64(n) > c.opts.MaxBody {
+					t.Errorf("body of %d bytes, cap %d", n, c.opts.MaxBody)
+				}
+			}
+			for i, o := range resp.Output.ByIndex(5) {
+				if (o == nil) != (tc.bad != nil && i == 3) {
+					t.Fatalf("output %d: %v", i, o)
+				}
+				if o != nil && strings.TrimSpace(o.ImageBase64) != fmt.Sprint(i) {
+					t.Errorf("output %d: %q", i, o.ImageBase64)
+				}
+			}
+		})
+	}
+}
+
+// TestSubmit: /run hands back one job per chunk, and Wait follows
+// each to completion; an unknown job is the endpoint's 404.
+func TestSubmit(t *testing.T) {
+	rp := &runpod{}
+	c := rp.serve(t)
+	ctx := context.Background()
+	ids, err := c.Submit(ctx, images(3))
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("ids %v, %v", ids, err)
+	}
+	if st, err := c.Status(ctx, ids[0]); err != nil || !st.Pending() {
+		t.Fatalf("status: %+v, %v", st, err)
+	}
+	for _, id := range ids {
+		resp, err := c.Wait(ctx, id)
+		if err != nil || resp.Status != StatusCompleted || len(resp.Output.Outputs) == 0 {
+			t.Fatalf("%s: %+v, %v", id, resp, err)
+		}
+	}
+	var he *HTTPError
+	if _, err := c.Status(ctx, "nope"); !errors.As(err, &he) || he.StatusCode != http.StatusNotFound || he.Temporary() {
+		t.Fatalf("unknown job: %v", err)
+	}
+}
+
+// TestErrors: each way a call fails has its own type, and only the
+// temporary ones are retried.
+func TestErrors(t *testing.T) {
+	var calls atomic.Int32
+	var answer atomic.Value // http.HandlerFunc
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		answer.Load().(http.HandlerFunc)(w, r)
+	}))
+	defer ts.Close()
+	c, err := New(ts.URL, Options{Retries: 2, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		answer http.HandlerFunc
+		check  func(err error) bool
+		calls  int32
+	}{
+		{"refused", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "input.images[0]: scale must be at most 16", http.StatusBadRequest)
+		}, func(err error) bool {
+			var he *HTTPError
+			return errors.As(err, &he) && !he.Temporary() && strings.Contains(he.Body, "at most 16")
+		}, 1},
+		{"unauthorized", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "", http.StatusUnauthorized)
+		}, func(err error) bool {
+			var he *HTTPError
+			return errors.As(err, &he) && he.Unauthorized()
+		}, 1},
+		{"unavailable", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "", http.StatusServiceUnavailable)
+		}, func(err error) bool {
+			var he *HTTPError
+			return errors.As(err, &he) && he.Temporary()
+		}, 3},
+		{"job failed", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(Response{ID: "j", Status: StatusFailed, Error: "CUDA out of memory"})
+		}, func(err error) bool {
+			var je *JobError
+			return errors.As(err, &je) && je.Message == "CUDA out of memory"
+		}, 1},
+		{"worker error", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(Response{Status: StatusCompleted, Output: Output{Error: "model not loaded"}})
+		}, func(err error) bool {
+			var je *JobError
+			return errors.As(err, &je) && je.Message == "model not loaded"
+		}, 1},
+		{"garbled", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>"))
+		}, func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), "decode response")
+		}, 3},
+	}
+	for _, tc := range cases {
+		calls.Store(0)
+		answer.Store(tc.answer)
+		_, err := c.RunSync(context.Background(), images(1))
+		if !tc.check(err) || calls.Load() != tc.calls {
+			t.Errorf("%s: %v after %d call(s), want %d", tc.name, err, calls.Load(), tc.calls)
+		}
+	}
+}
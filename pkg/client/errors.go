@@ -0,0 +1,83 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HTTPError is a non-2xx answer. `serve` explains a refused request
+// in Body as plain text.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string // e.g. "400 Bad Request"
+	Body       string // the first 4 KiB, trimmed
+	// RetryAfter is the wait the server asked for, 0 for none.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s: %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.URL, e.Status, e.Body)
+}
+
+// Temporary reports whether the same request may succeed later: 429
+// and 5xx. Any other 4xx is the far end refusing the request itself.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// Unauthorized reports a missing or rejected API key.
+func (e *HTTPError) Unauthorized() bool {
+	return e.StatusCode == 401 || e.StatusCode == 403
+}
+
+// JobError is a job the endpoint took but did not complete: RunPod
+// reports it FAILED, CANCELLED or TIMED_OUT, or the worker answered
+// with an error instead of outputs.
+type JobError struct {
+	ID      string // the RunPod job id, if any
+	Status  string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	var b strings.Builder
+	b.WriteString("job")
+	if e.ID != "" {
+		b.WriteString(" " + e.ID)
+	}
+	b.WriteString(" " + strings.ToLower(e.Status))
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	return b.String()
+}
+
+// ItemErrors are the inputs of a completed job that the worker
+// couldn't upscale; the others are in the Response that comes with it.
+type ItemErrors []ItemError
+
+func (e ItemErrors) Error() string {
+	msg := fmt.Sprintf("image %d: %s", e[0].Index, e[0].Error)
+	if len(e) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e)-1)
+	}
+	return msg
+}
+
+// TooLargeError is an image that on its own makes a request bigger
+// than the body cap, so no batching can send it.
+type TooLargeError struct {
+	Index   int
+	Size    int64 // the request with this image alone
+	MaxBody int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("image %d needs a %.1f MB request, past the %.1f MB cap",
+		e.Index, float64(e.Size)/(1<<20), float64(e.MaxBody)/(1<<20))
+}
@@ -0,0 +1,125 @@
+package client
+
+// The /runsync envelope. A request is {"input": Input}; the answer is
+// a Response whose Output carries one ImageOutput per image. `serve`
+// decodes requests into these same types, and the RunPod worker
+// (providers/runpod/handler.py) answers in the same shape, wrapped in
+// RunPod's job id and status.
+
+// Request is the body of a /runsync or /run post.
+type Request struct {
+	Input Input `json:"input"`
+}
+
+// Input is one batch of images and the settings they share. Zero
+// values are left off the wire and mean the far end's default.
+type Input struct {
+	Images []Image `json:"images"`
+	// OutputFormat is jpg (the default), png or webp. The RunPod
+	// worker writes jpg or png.
+	OutputFormat string `json:"output_format,omitempty"`
+
+	// Sizing, as super-resolution's flags of the same names. `serve`
+	// only: the RunPod worker upscales at the native 4x and rejects
+	// nothing, so leave these zero for it.
+	Scale          float64 `json:"scale,omitempty"`
+	Resample       string  `json:"resample,omitempty"`
+	TargetWidth    int     `json:"target_width,omitempty"`
+	TargetHeight   int     `json:"target_height,omitempty"`
+	MaxDimension   int     `json:"max_dimension,omitempty"`
+	AllowDownscale bool    `json:"allow_downscale,omitempty"`
+
+	// Tile forces tiled inference; large inputs are tiled anyway.
+	Tile bool `json:"tile,omitempty"`
+	// DiscardOutput runs the images but sends back only timings, for
+	// benchmarks.
+	DiscardOutput bool `json:"discard_output,omitempty"`
+}
+
+// Image is one input, its encoded bytes in standard base64.
+type Image struct {
+	ImageBase64 string `json:"image_base64"`
+}
+
+// Job states, as RunPod reports them. `serve` answers only
+// StatusCompleted, or an HTTP error.
+const (
+	StatusInQueue    = "IN_QUEUE"
+	StatusInProgress = "IN_PROGRESS"
+	StatusCompleted  = "COMPLETED"
+	StatusFailed     = "FAILED"
+	StatusCancelled  = "CANCELLED"
+	StatusTimedOut   = "TIMED_OUT"
+)
+
+// Response is the answer to a /runsync post or a status check.
+type Response struct {
+	// ID is the RunPod job id; empty from `serve`.
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Error is RunPod's account of a failed job.
+	Error  string `json:"error,omitempty"`
+	Output Output `json:"output"`
+}
+
+// Pending reports whether the job has yet to finish.
+func (r *Response) Pending() bool {
+	return r.Status == StatusInQueue || r.Status == StatusInProgress
+}
+
+// Output is what the worker returned. A failed image is missing from
+// Outputs and listed in Diagnostics.PerItemErrors instead; ByIndex
+// lines the two back up with the inputs.
+type Output struct {
+	Outputs []ImageOutput `json:"outputs"`
+	Model   string        `json:"model,omitempty"`
+	// Error is the worker's account of a batch it couldn't run.
+	Error       string       `json:"error,omitempty"`
+	Diagnostics *Diagnostics `json:"_diagnostics,omitempty"`
+}
+
+// ImageOutput is one upscaled image.
+type ImageOutput struct {
+	// ImageBase64 is empty when the request set DiscardOutput.
+	ImageBase64  string `json:"image_base64,omitempty"`
+	ExecMS       int64  `json:"exec_ms"`
+	OutputFormat string `json:"output_format,omitempty"`
+}
+
+// Diagnostics is the RunPod worker's report on how it ran the batch.
+type Diagnostics struct {
+	Providers         []string    `json:"providers,omitempty"`
+	RequestedProvider string      `json:"requested_provider,omitempty"`
+	Model             string      `json:"model,omitempty"`
+	BatchSize         int         `json:"batch_size,omitempty"`
+	BatchTotalMS      int64       `json:"batch_total_ms,omitempty"`
+	PerItemErrors     []ItemError `json:"per_item_errors,omitempty"`
+}
+
+// ItemError is one input the worker couldn't upscale. Index counts
+// from 0 in the request's images.
+type ItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ByIndex returns the output for each of n inputs, nil where the
+// input failed.
+func (o *Output) ByIndex(n int) []*ImageOutput {
+	failed := make(map[int]bool)
+	if o.Diagnostics != nil {
+		for _, e := range o.Diagnostics.PerItemErrors {
+			failed[e.Index] = true
+		}
+	}
+	slots := make([]*ImageOutput, n)
+	next := 0
+	for i := range slots {
+		if failed[i] || next == len(o.Outputs) {
+			continue
+		}
+		slots[i] = &o.Outputs[next]
+		next++
+	}
+	return slots
+}
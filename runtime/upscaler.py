@@ -24,18 +24,45 @@ I/O contract:
     --tile             tile-based inference for inputs > 1280² (the engine's
                        single-shot cap). Safe to leave on always — small
                        inputs skip the slice/stitch overhead.
+    --outscale FLOAT   final scale relative to the input (default 4, the
+                       model-native factor). Other values resample the
+                       4× output with --resample; output dims are
+                       round(W·outscale) × round(H·outscale).
+    --resample NAME    filter for --outscale: lanczos | bicubic |
+                       bilinear | nearest (default lanczos)
+    --passes INT       native 4× model passes (default 1). 0 skips the
+                       model entirely (resample only); 2+ chains passes
+                       for outscale > 4, e.g. 16× = two passes.
     --json-events      emit progress as one JSON object per line on stdout
 
   Stdin (serve mode):
     one JSON object per line, e.g.
     {"id": "abc", "input": "/tmp/in.jpg", "output": "/tmp/out.jpg"}
     Optional `"tile": true` enables the tile-based path for that frame.
+    Optional `"outscale": 2.0` / `"resample": "bicubic"` / `"passes": 2`
+    override the process-wide --outscale / --resample / --passes for
+    that frame. `"inputs"` / `"outputs"` lists make a batched frame;
+    `"input_b64"` + `"output_format"` carry the image inline, answered
+    with `"output_b64"` instead of a path. `{"cancel": "abc"}` is a
+    control frame: a queued job is dropped, a running one stops at its
+    next tile / pass / batch item.
 
   Stdout (json-events / serve mode):
-    {"event": "ready"}                                 once after model load
+    {"event": "ready", "protocol_version": 1,
+     "capabilities": ["tile", ...], ...}               once after model load
     {"event": "progress", "id": "abc", "frac": 0.42}   periodic, 0.0..1.0
     {"event": "done", "id": "abc", "output": "..."}    on success
     {"event": "error", "id": "abc", "msg": "..."}      on failure (does NOT exit serve mode)
+    {"event": "cancelled", "id": "abc"}                after a cancel (serve mode)
+    Serve-mode done / cancelled events also carry "elapsed_ms", the
+    time spent on the job — for a cancelled job, wasted GPU time.
+
+  Serve mode is versioned: PROTOCOL_VERSION changes only when a frame
+  or event changes incompatibly, and the Go side refuses a helper
+  whose version differs from its own. Optional features are listed in
+  CAPABILITIES instead, so adding one needs no version bump. The
+  contract is pinned by the Go conformance suite in
+  internal/protocol/protocoltest.
 
   Exit codes (one-shot mode):
     0  success
@@ -48,7 +75,9 @@ from __future__ import annotations
 
 import argparse
 import json
+import queue
 import sys
+import threading
 import time
 from pathlib import Path
 
@@ -57,12 +86,17 @@ from pathlib import Path
 # the package is provisioned).
 
 
+_EMIT_LOCK = threading.Lock()
+
+
 def _emit(json_events: bool, **payload) -> None:
-    """Write one JSON event to stdout if json-events is on, flush."""
+    """Write one JSON event to stdout if json-events is on, flush.
+    Serve mode emits from two threads; the lock keeps lines whole."""
     if not json_events:
         return
-    sys.stdout.write(json.dumps(payload, separators=(",", ":")) + "\n")
-    sys.stdout.flush()
+    with _EMIT_LOCK:
+        sys.stdout.write(json.dumps(payload, separators=(",", ":")) + "\n")
+        sys.stdout.flush()
 
 
 def _die(code: int, msg: str, json_events: bool) -> None:
@@ -76,6 +110,22 @@ def _die(code: int, msg: str, json_events: bool) -> None:
 
 _PROVIDER_CHOICES = ("auto", "cpu", "cuda", "trt")
 
+# Serve-mode wire contract; keep in lockstep with internal/protocol.
+PROTOCOL_VERSION = 1
+
+# Optional serve-mode features, advertised in the ready event:
+#   tile       "tile": true frames take the slice/blend path
+#   batched    "inputs" / "outputs" frames
+#   inline_io  "input_b64" frames, answered with "output_b64"
+#   cancel     {"cancel": "<id>"} control frames
+CAPABILITIES = ("tile", "batched", "inline_io", "cancel")
+
+# Real-ESRGAN's native factor. --outscale values other than this are
+# produced by resampling the model output, not by a different model.
+NATIVE_SCALE = 4
+
+_RESAMPLE_CHOICES = ("lanczos", "bicubic", "bilinear", "nearest")
+
 
 def _build_providers(provider: str, gpu_id: int, available: list[str],
                      trt_cache: Path) -> list:
@@ -423,6 +473,13 @@ def _load_session(model_path: Path, gpu_id: int, json_events: bool,
             f"Provider=auto will degrade silently; provider=cuda is "
             f"strict by design."
         )
+    if provider == "auto" and gpu_id >= 0 and actual == ["CPUExecutionProvider"]:
+        # auto is allowed to land here, but on a GPU run it is rarely
+        # what the operator meant; the Go side warns on the ready event
+        # too, this covers one-shot runs.
+        print(f"[ort] warning: provider=auto fell back to CPU on GPU "
+              f"{gpu_id} (available: {available}); pass --provider cuda "
+              f"to fail instead", file=sys.stderr, flush=True)
     return sess
 
 
@@ -462,7 +519,44 @@ def _run_inference(session, chw):
     return session.run(None, {inp_meta.name: chw})
 
 
-def _postprocess_and_save(out_tensor, output_path: Path) -> None:
+def _outscale_dims(in_w: int, in_h: int, outscale: float) -> tuple[int, int]:
+    """Target (W, H) for `outscale`. Round-half-up, floored at 1 px —
+    the Go side computes the same numbers to validate the output, so
+    keep the two in lockstep (internal/imageinfo.ScaledDims)."""
+    return (max(1, int(in_w * outscale + 0.5)),
+            max(1, int(in_h * outscale + 0.5)))
+
+
+def _resample_filter(resample: str):
+    """PIL filter constant for a --resample name."""
+    from PIL import Image  # type: ignore[import-not-found]
+
+    filters = {
+        "lanczos": Image.LANCZOS,
+        "bicubic": Image.BICUBIC,
+        "bilinear": Image.BILINEAR,
+        "nearest": Image.NEAREST,
+    }
+    if resample not in filters:
+        raise ValueError(f"unknown resample filter {resample!r}; "
+                         f"choose from {list(filters)}")
+    return filters[resample]
+
+
+def _resample(img, outscale: float, resample: str = "lanczos"):
+    """Resize a native-4× PIL image to `outscale` × the original input.
+    No-op at the native factor so the default path stays byte-identical
+    to what it produced before --outscale existed."""
+    if outscale == NATIVE_SCALE:
+        return img
+    w, h = img.size
+    target = _outscale_dims(w // NATIVE_SCALE, h // NATIVE_SCALE, outscale)
+    return img.resize(target, _resample_filter(resample))
+
+
+def _postprocess_and_save(out_tensor, output_path: Path,
+                          outscale: float = NATIVE_SCALE,
+                          resample: str = "lanczos") -> None:
     """NCHW float [0..1] (float16 or float32) → PIL image → file."""
     import numpy as np  # type: ignore[import-not-found]
     from PIL import Image  # type: ignore[import-not-found]
@@ -471,17 +565,20 @@ def _postprocess_and_save(out_tensor, output_path: Path) -> None:
     # CHW -> HWC
     arr = arr.transpose(1, 2, 0)
     output_path.parent.mkdir(parents=True, exist_ok=True)
-    img = Image.fromarray(arr)
+    img = _resample(Image.fromarray(arr), outscale, resample)
     # PIL infers format from suffix; .jpg encodes ~5x faster than .png
     # on a 5K-class output — see ARCHITECTURE.md performance notes.
     img.save(output_path)
 
 
-def _run_tiled(session, input_path: Path, output_path: Path) -> None:
+def _run_tiled(session, input_path: Path, output_path: Path,
+               outscale: float = NATIVE_SCALE, resample: str = "lanczos",
+               check=None) -> None:
     """Tile-based one-shot for inputs that exceed the engine's
     single-shot cap (1280² profile max). Slices into ≤1024² tiles with
     32-px overlap, runs the inference path per tile, blends into a
     single output canvas. See runtime/tiling.py for the algorithm.
+    `check`, when given, runs before each tile (serve-mode cancel).
 
     Lazy-imports tiling.py so the helper still loads (and `--help`
     still works) on systems without numpy/Pillow installed; the inner
@@ -501,15 +598,63 @@ def _run_tiled(session, input_path: Path, output_path: Path) -> None:
     img = Image.open(input_path)
 
     def infer(chw):
+        if check:
+            check()
         # `_run_inference` returns a list (matches ORT.run shape); take
         # the first entry. Tiling.py expects (1, 3, 4·t_h, 4·t_w).
         return _run_inference(session, chw)[0]
 
-    out_img = tiling.upscale_tiled(img, infer)
+    out_img = _resample(tiling.upscale_tiled(img, infer), outscale, resample)
     output_path.parent.mkdir(parents=True, exist_ok=True)
     out_img.save(output_path)
 
 
+def _run_passes(session, input_path: Path, output_path: Path, passes: int,
+                outscale: float, resample: str = "lanczos",
+                check=None) -> None:
+    """Multi-pass / resample-only path, planned Go-side (see
+    internal/sizing). `passes` native 4× model runs, then a final
+    resample so the output is exactly round(W·outscale) × round(H·outscale).
+
+      passes == 0  no inference; pure resample (downscale or
+                   re-encode of an input already past the target).
+                   `session` may be None.
+      passes >= 2  before the LAST pass the intermediate is resampled
+                   to outscale/4 × the input, so that pass lands on the
+                   target directly instead of overshooting to 4^N and
+                   throwing most of the pixels away.
+
+    Every pass goes through tiling.upscale_tiled, which short-circuits
+    to one inference call when the pass input fits a single tile — the
+    second pass of a 16× request is almost always above the 1280²
+    single-shot cap. `check` runs before each tile, as in _run_tiled."""
+    from PIL import Image  # type: ignore[import-not-found]
+
+    import os
+    sys.path.insert(0, os.path.dirname(os.path.abspath(__file__)))
+    import tiling  # type: ignore[import-not-found]
+
+    filt = _resample_filter(resample)
+    img = Image.open(input_path).convert("RGB")
+    in_w, in_h = img.size
+
+    def infer(chw):
+        if check:
+            check()
+        return _run_inference(session, chw)[0]
+
+    for i in range(passes):
+        if i > 0 and i == passes - 1:
+            img = img.resize(_outscale_dims(in_w, in_h, outscale / NATIVE_SCALE), filt)
+        img = tiling.upscale_tiled(img, infer)
+
+    target = _outscale_dims(in_w, in_h, outscale)
+    if img.size != target:
+        img = img.resize(target, filt)
+    output_path.parent.mkdir(parents=True, exist_ok=True)
+    img.save(output_path)
+
+
 def run_one_shot(args: argparse.Namespace) -> int:
     je = args.json_events
     model = Path(args.model)
@@ -518,17 +663,41 @@ def run_one_shot(args: argparse.Namespace) -> int:
 
     if not inp.exists():
         _die(1, f"input does not exist: {inp}", je)
+    if args.outscale <= 0:
+        _die(1, f"--outscale must be > 0, got {args.outscale}", je)
+    if args.passes < 0:
+        _die(1, f"--passes must be >= 0, got {args.passes}", je)
+
+    if args.passes == 0:
+        # Resample-only: the input is already at/past the target. No
+        # model load — this path must work even without onnxruntime.
+        _emit(je, event="resampling", input=str(inp), outscale=args.outscale)
+        try:
+            _run_passes(None, inp, out, 0, args.outscale, args.resample)
+        except ValueError as e:
+            _die(1, str(e), je)
+        _emit(je, event="done", output=str(out))
+        return 0
 
     _emit(je, event="loading_model", path=str(model))
     t0 = time.monotonic()
     session = _load_session(model, args.gpu_id, je, provider=args.provider)
-    _emit(je, event="model_loaded", elapsed_ms=int((time.monotonic() - t0) * 1000))
+    _emit(je, event="model_loaded", elapsed_ms=int((time.monotonic() - t0) * 1000),
+          providers=session.get_providers(), requested_provider=args.provider)
 
-    if args.tile:
+    if args.passes > 1:
+        _emit(je, event="inferring_passes", input=str(inp), passes=args.passes)
+        t0 = time.monotonic()
+        try:
+            _run_passes(session, inp, out, args.passes, args.outscale, args.resample)
+        except Exception as e:  # noqa: BLE001
+            _die(2, f"multi-pass inference failed: {e}", je)
+        _emit(je, event="inferred", elapsed_ms=int((time.monotonic() - t0) * 1000))
+    elif args.tile:
         _emit(je, event="inferring_tiled", input=str(inp))
         t0 = time.monotonic()
         try:
-            _run_tiled(session, inp, out)
+            _run_tiled(session, inp, out, args.outscale, args.resample)
         except Exception as e:  # noqa: BLE001
             _die(2, f"tiled inference failed: {e}", je)
         _emit(je, event="inferred", elapsed_ms=int((time.monotonic() - t0) * 1000))
@@ -545,7 +714,10 @@ def run_one_shot(args: argparse.Namespace) -> int:
         _emit(je, event="inferred", elapsed_ms=int((time.monotonic() - t0) * 1000))
 
         _emit(je, event="postprocessing", output=str(out))
-        _postprocess_and_save(result[0], out)
+        try:
+            _postprocess_and_save(result[0], out, args.outscale, args.resample)
+        except ValueError as e:
+            _die(1, str(e), je)
 
     _emit(je, event="done", output=str(out))
     return 0
@@ -581,6 +753,11 @@ def run_serve(args: argparse.Namespace) -> int:
                  "results": [{"output": "..."}, ...],
                  "batched": true,
                  "engine": "primary" | "batched"}
+
+    Frames are read on a separate thread (_Inbox) so that
+    `{"cancel": "<id>"}` takes effect mid-job: the job stops at its
+    next tile / pass / batch item and answers
+    {"event": "cancelled", "id": "...", "elapsed_ms": ...}.
     """
     import numpy as np  # type: ignore[import-not-found]
 
@@ -605,52 +782,154 @@ def run_serve(args: argparse.Namespace) -> int:
     # them back to callers — `engine_secondary` is null when no batched
     # engine was loaded. Helps benchmark callers attribute timings.
     _emit(True, event="ready",
+          protocol_version=PROTOCOL_VERSION,
+          capabilities=list(CAPABILITIES),
           providers=session.get_providers(),
           requested_provider=args.provider,
           model=model.name,
           batched_model=(Path(batched_model_path).name if batched_session else None))
 
-    for line in sys.stdin:
-        line = line.strip()
-        if not line:
-            continue
-        try:
-            job = json.loads(line)
-        except json.JSONDecodeError as e:
-            _emit(True, event="error", msg=f"bad jsonl frame: {e}")
-            continue
-
+    inbox = _Inbox(sys.stdin)
+    for job in inbox:
         job_id = job.get("id", "")
+        outscale = float(job.get("outscale", args.outscale))
+        resample = job.get("resample", args.resample)
+        passes = int(job.get("passes", args.passes))
+        t0 = time.monotonic()
 
-        # Branch on shape: `inputs` (plural) → batched; `input` → single.
-        if "inputs" in job and "outputs" in job:
-            try:
-                _serve_one_batch(session, batched_session, job, job_id, np)
-            except Exception as e:  # noqa: BLE001
-                _emit(True, event="error", id=job_id, msg=str(e))
-            continue
+        def check(job_id=job_id):
+            inbox.check(job_id)
 
         try:
-            if job.get("tile"):
-                # Tile-based path for inputs above the engine's 1280²
-                # profile max. Slices, infers per tile on the warm
-                # session, blends. See runtime/tiling.py.
-                _run_tiled(session, Path(job["input"]), Path(job["output"]))
+            check()  # cancelled while queued: no work at all
+            # Branch on shape: `inputs` (plural) → batched; `input` → single.
+            if "inputs" in job and "outputs" in job:
+                done = _serve_one_batch(session, batched_session, job, np,
+                                        outscale, resample, check)
+            elif "input_b64" in job:
+                done = {"output_b64": _serve_inline(session, job, passes,
+                                                    outscale, resample, check)}
             else:
-                chw, w, h = _preprocess(Path(job["input"]))
-                result = _run_inference(session, chw)
-                _postprocess_and_save(result[0], Path(job["output"]))
-            _emit(True, event="done", id=job_id, output=job["output"])
+                _serve_one(session, Path(job["input"]), Path(job["output"]),
+                           bool(job.get("tile")), passes, outscale, resample,
+                           check)
+                done = {"output": job["output"]}
+            _emit(True, event="done", id=job_id, elapsed_ms=_ms_since(t0), **done)
+        except _Cancelled:
+            _emit(True, event="cancelled", id=job_id, elapsed_ms=_ms_since(t0))
         except Exception as e:  # noqa: BLE001
             _emit(True, event="error", id=job_id, msg=str(e))
+        finally:
+            inbox.finish(job_id)
     return 0
 
 
+def _ms_since(t0: float) -> int:
+    return int((time.monotonic() - t0) * 1000)
+
+
+class _Cancelled(Exception):
+    """Raised at a serve-mode checkpoint once the job is cancelled."""
+
+
+class _Inbox:
+    """Serve-mode frames from stdin, read on a thread of their own so a
+    `{"cancel": id}` frame is seen while a job is running. Jobs come
+    out in order by iterating; cancels only mark their id, which the
+    job notices at its next check(). A cancel for an id that isn't
+    queued or running (already answered, never sent) is dropped."""
+
+    def __init__(self, stream) -> None:
+        self._jobs: queue.Queue = queue.Queue()
+        self._lock = threading.Lock()
+        self._live: set[str] = set()
+        self._cancelled: set[str] = set()
+        threading.Thread(target=self._read, args=(stream,), daemon=True).start()
+
+    def _read(self, stream) -> None:
+        for line in stream:
+            line = line.strip()
+            if not line:
+                continue
+            try:
+                job = json.loads(line)
+                if not isinstance(job, dict):
+                    raise ValueError("not an object")
+            except ValueError as e:  # JSONDecodeError is a ValueError
+                _emit(True, event="error", msg=f"bad jsonl frame: {e}")
+                continue
+            with self._lock:
+                if "cancel" in job:
+                    if str(job["cancel"]) in self._live:
+                        self._cancelled.add(str(job["cancel"]))
+                    continue
+                self._live.add(str(job.get("id", "")))
+            self._jobs.put(job)
+        self._jobs.put(None)  # EOF
+
+    def __iter__(self):
+        while (job := self._jobs.get()) is not None:
+            yield job
+
+    def check(self, job_id: str) -> None:
+        with self._lock:
+            if job_id in self._cancelled:
+                raise _Cancelled(job_id)
+
+    def finish(self, job_id: str) -> None:
+        with self._lock:
+            self._live.discard(job_id)
+            self._cancelled.discard(job_id)
+
+
+def _serve_one(session, inp: Path, out: Path, tile: bool, passes: int,
+               outscale: float, resample: str, check=None) -> None:
+    """One single-image serve-mode frame, path to path."""
+    if passes != 1:
+        # Planned multi-pass or resample-only frame (target-size
+        # modes). See _run_passes.
+        _run_passes(session, inp, out, passes, outscale, resample, check)
+    elif tile:
+        # Tile-based path for inputs above the engine's 1280²
+        # profile max. Slices, infers per tile on the warm
+        # session, blends. See runtime/tiling.py.
+        _run_tiled(session, inp, out, outscale, resample, check)
+    else:
+        chw, w, h = _preprocess(inp)
+        if check:
+            check()
+        result = _run_inference(session, chw)
+        _postprocess_and_save(result[0], out, outscale, resample)
+
+
+def _serve_inline(session, job: dict, passes: int, outscale: float,
+                  resample: str, check=None) -> str:
+    """The inline_io capability: the frame carries the image as
+    base64 and the result goes back the same way, for callers that
+    share no filesystem with the helper. Staged through a private
+    temp dir so every path-based mode works unchanged."""
+    import base64
+    import tempfile
+
+    fmt = str(job.get("output_format") or "png").lower().lstrip(".")
+    with tempfile.TemporaryDirectory(prefix="upscaler-") as tmp:
+        inp = Path(tmp) / "input.bin"
+        out = Path(tmp) / f"output.{fmt}"
+        inp.write_bytes(base64.b64decode(job["input_b64"], validate=True))
+        _serve_one(session, inp, out, bool(job.get("tile")), passes,
+                   outscale, resample, check)
+        return base64.b64encode(out.read_bytes()).decode("ascii")
+
+
 def _serve_one_batch(primary_session, batched_session,
-                     job: dict, job_id: str, np) -> None:
+                     job: dict, np,
+                     outscale: float = NATIVE_SCALE,
+                     resample: str = "lanczos",
+                     check=None) -> dict:
     """Batched JSONL handler. Routes to the batched session if one
     is loaded AND the request fits its profile; otherwise falls back
-    to iterating per-image on the primary session.
+    to iterating per-image on the primary session. Returns the done
+    event's fields; `check` runs before each inference call.
 
     All inputs must already share the same (H, W) — the handler-side
     router (handler.py:_process_batch) groups by shape. We
@@ -691,11 +970,14 @@ def _serve_one_batch(primary_session, batched_session,
     if use_batched:
         # Stack and run a single forward pass on the batched engine.
         batched_chw = np.concatenate(chws, axis=0)
+        if check:
+            check()
         result = _run_inference(batched_session, batched_chw)
         out_tensor = result[0]  # (N, 3, 4H, 4W)
         per_item = []
         for i, out_path in enumerate(outputs):
-            _postprocess_and_save(out_tensor[i:i + 1], out_path)
+            _postprocess_and_save(out_tensor[i:i + 1], out_path,
+                                  outscale, resample)
             per_item.append({"output": str(out_path)})
         engine_used = "batched"
     else:
@@ -704,13 +986,15 @@ def _serve_one_batch(primary_session, batched_session,
         # batching benefit but always produces correct output.
         per_item = []
         for chw, out_path in zip(chws, outputs):
+            if check:
+                check()
             result = _run_inference(primary_session, chw)
-            _postprocess_and_save(result[0], out_path)
+            _postprocess_and_save(result[0], out_path, outscale, resample)
             per_item.append({"output": str(out_path)})
         engine_used = "primary"
 
-    _emit(True, event="done", id=job_id, batched=True,
-          results=per_item, batch_size=n, engine=engine_used)
+    return {"batched": True, "results": per_item, "batch_size": n,
+            "engine": engine_used}
 
 
 def _shape_fits_session(sess: "TrtSession", n: int, h: int, w: int) -> bool:
@@ -743,6 +1027,16 @@ def main() -> int:
                         "ORT wheel was built with. cpu/cuda/trt are STRICT "
                         "— a missing system lib raises rather than falling "
                         "back silently.")
+    p.add_argument("--outscale", type=float, default=float(NATIVE_SCALE),
+                   help="final scale relative to the input (default 4, "
+                        "model-native). Other values resample the 4× "
+                        "output with --resample.")
+    p.add_argument("--resample", choices=_RESAMPLE_CHOICES, default="lanczos",
+                   help="resampling filter used when --outscale != 4")
+    p.add_argument("--passes", type=int, default=1,
+                   help="native 4× model passes (default 1). 0 = resample "
+                        "only, no model load; >=2 chains passes for "
+                        "outscale > 4. Planned by the Go side.")
     p.add_argument("--json-events", action="store_true", help="emit progress as JSONL on stdout")
     p.add_argument("--serve", action="store_true", help="daemon mode: read JSONL jobs from stdin")
     p.add_argument("--tile", action="store_true",
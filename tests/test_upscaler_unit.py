@@ -9,6 +9,9 @@ Covered:
   - _preprocess + _postprocess_and_save: image I/O round-trip.
     Ensures the float-clip + uint8 cast doesn't silently mangle pixels
     that came in valid.
+  - run_serve: the ready event's protocol_version / capabilities
+    handshake, an inline_io round trip and a mid-job cancel, on a
+    stand-in session.
 
 Not covered (intentional):
   - TrtSession: requires a real GPU and a built engine to exercise
@@ -161,3 +164,104 @@ def test_run_inference_dispatches_to_trt_session_when_present(monkeypatch):
     # _postprocess_and_save consumes result[0] — must be subscriptable
     assert hasattr(result, "__getitem__")
     assert result[0].shape == (1, 3, 4, 4)
+
+
+# ────────────────────────────────────────────────────────────────────
+# run_serve — the handshake and inline_io (Go: internal/protocol)
+# ────────────────────────────────────────────────────────────────────
+
+class _NearestSession:
+    """ORT-shaped stand-in: 4× nearest-neighbour, no model."""
+    class _Input:
+        name = "input"
+        type = "tensor(float)"
+
+    def get_inputs(self):
+        return [self._Input()]
+
+    def get_providers(self):
+        return ["CPUExecutionProvider"]
+
+    def run(self, _outputs, feeds):
+        chw = feeds["input"]
+        return [chw.repeat(4, axis=2).repeat(4, axis=3)]
+
+
+def _serve(monkeypatch, capsys, frames, session=None):
+    """Run serve mode over `frames` (dicts, or an iterator of JSONL
+    lines when the test needs to pace stdin) and return the events."""
+    import argparse
+    import json
+    import sys
+
+    session = session or _NearestSession()
+    if isinstance(frames, list):
+        frames = [json.dumps(f) + "\n" for f in frames]
+    monkeypatch.setattr(upscaler, "_load_session", lambda *a, **k: session)
+    monkeypatch.setattr(sys, "stdin", frames)
+    args = argparse.Namespace(model="x4.onnx", batched_model=None, gpu_id=-1,
+                              provider="cpu", outscale=4.0, resample="lanczos",
+                              passes=1)
+    assert upscaler.run_serve(args) == 0
+    return [json.loads(line) for line in capsys.readouterr().out.splitlines()]
+
+
+def test_serve_ready_carries_protocol_version_and_capabilities(monkeypatch, capsys):
+    """The Go side refuses a helper whose protocol_version differs
+    from its own, and gates tiling / batching / inline I/O on the
+    capability list — both must be in the ready event."""
+    ready = _serve(monkeypatch, capsys, [])[0]
+    assert ready["event"] == "ready"
+    assert ready["protocol_version"] == upscaler.PROTOCOL_VERSION == 1
+    assert set(ready["capabilities"]) == {"tile", "batched", "inline_io", "cancel"}
+
+
+def test_serve_inline_io_round_trip(monkeypatch, capsys, tmp_path):
+    import base64
+    import io
+
+    buf = io.BytesIO()
+    Image.new("RGB", (6, 5), (10, 20, 30)).save(buf, format="PNG")
+    events = _serve(monkeypatch, capsys, [
+        {"id": "a", "input_b64": base64.b64encode(buf.getvalue()).decode(),
+         "output_format": "png"},
+        {"id": "b", "input_b64": "not base64!"},
+    ])
+    done, err = events[1], events[2]
+    assert done["event"] == "done" and done["id"] == "a"
+    out = Image.open(io.BytesIO(base64.b64decode(done["output_b64"])))
+    assert out.size == (24, 20)
+    # A bad frame answers with an error and the loop keeps going.
+    assert err["event"] == "error" and err["id"] == "b"
+
+
+def test_serve_cancel_stops_a_running_job(monkeypatch, capsys, tmp_path):
+    """A cancel frame read mid-job stops it at the next checkpoint
+    (here: the second of two passes) with a `cancelled` event, and the
+    helper goes on to the next job."""
+    import json
+    import threading
+
+    inp = tmp_path / "in.png"
+    Image.new("RGB", (6, 5)).save(inp)
+    started, cancel_read = threading.Event(), threading.Event()
+
+    class Slow(_NearestSession):
+        def run(self, outputs, feeds):
+            started.set()
+            cancel_read.wait(5)
+            return super().run(outputs, feeds)
+
+    def stdin():
+        yield json.dumps({"id": "a", "input": str(inp), "output": str(tmp_path / "a.png"),
+                          "passes": 2, "outscale": 16}) + "\n"
+        started.wait(5)
+        yield json.dumps({"cancel": "a"}) + "\n"
+        # Resumed only once the reader has taken the cancel frame.
+        cancel_read.set()
+        yield json.dumps({"id": "b", "input": str(inp), "output": str(tmp_path / "b.png")}) + "\n"
+
+    events = _serve(monkeypatch, capsys, stdin(), session=Slow())[1:]
+    assert [(e["event"], e["id"]) for e in events] == [("cancelled", "a"), ("done", "b")]
+    assert events[0]["elapsed_ms"] >= 0
+    assert not (tmp_path / "a.png").exists()